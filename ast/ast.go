@@ -6,12 +6,15 @@ package ast
 import (
 	"bytes"
 	"monkey/token"
+	"sort"
 	"strings"
 )
 
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // ノードの先頭の位置
+	End() token.Position // ノードの直後の位置
 }
 
 type Statement interface {
@@ -28,6 +31,7 @@ type Expression interface {
 // 全ての有効なmonekyプログラムは、ひと続きの文の集まり
 type Program struct {
 	Statements []Statement
+	Comments   []*Comment // 最後の文より後ろのコメント
 }
 
 // インターフェースで定義されている関数の1つ
@@ -39,6 +43,20 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if n := len(p.Statements); n > 0 {
+		return p.Statements[n-1].End()
+	}
+	return token.Position{}
+}
+
 // インターフェースで定義されている関数の1つ
 // 文字列表示してデバッグしやすいようにする
 func (p *Program) String() string {
@@ -55,10 +73,21 @@ type LetStatement struct {
 	Token token.Token // token.LET トークン
 	Name  *Identifier // 束縛の識別子
 	Value Expression  // 値を生成する式を保持する
+	Doc   []*Comment  // 直前のコメント
 }
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos() }
+func (ls *LetStatement) End() token.Position {
+	if !isNilNode(ls.Value) {
+		return ls.Value.End()
+	}
+	if ls.Name != nil {
+		return ls.Name.End()
+	}
+	return tokenEnd(ls.Token)
+}
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
@@ -82,22 +111,32 @@ type Identifier struct {
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos() }
+func (i *Identifier) End() token.Position  { return tokenEnd(i.Token) }
 func (i *Identifier) String() string       { return i.Value }
 
 type ReturnStatement struct {
 	Token       token.Token // 'return'トークン
 	ReturnValue Expression
+	Doc         []*Comment // 直前のコメント
 }
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos() }
+func (rs *ReturnStatement) End() token.Position {
+	if !isNilNode(rs.ReturnValue) {
+		return rs.ReturnValue.End()
+	}
+	return tokenEnd(rs.Token)
+}
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
-	out.WriteString(rs.TokenLiteral() + " ")
+	out.WriteString(rs.TokenLiteral())
 
 	if rs.ReturnValue != nil {
-		out.WriteString(rs.ReturnValue.String())
+		out.WriteString(" " + rs.ReturnValue.String())
 	}
 
 	out.WriteString(";")
@@ -105,13 +144,49 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// 囲んでいる関数から戻るときに評価する式。関数の外ではプログラムの最後に評価する
+type DeferStatement struct {
+	Token      token.Token // 'defer'トークン
+	Expression Expression
+	Doc        []*Comment // 直前のコメント
+}
+
+func (ds *DeferStatement) statementNode()       {}
+func (ds *DeferStatement) TokenLiteral() string { return ds.Token.Literal }
+func (ds *DeferStatement) Pos() token.Position  { return ds.Token.Pos() }
+func (ds *DeferStatement) End() token.Position {
+	if !isNilNode(ds.Expression) {
+		return ds.Expression.End()
+	}
+	return tokenEnd(ds.Token)
+}
+func (ds *DeferStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ds.TokenLiteral())
+	if ds.Expression != nil {
+		out.WriteString(" " + ds.Expression.String())
+	}
+	out.WriteString(";")
+
+	return out.String()
+}
+
 type ExpressionStatement struct {
 	Token      token.Token // 式の最初のトークン
 	Expression Expression  // 式を保持
+	Doc        []*Comment  // 直前のコメント
 }
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos() }
+func (es *ExpressionStatement) End() token.Position {
+	if !isNilNode(es.Expression) {
+		return es.Expression.End()
+	}
+	return tokenEnd(es.Token)
+}
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -129,6 +204,8 @@ type IntegerLiteral struct {
 // 式
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos() }
+func (il *IntegerLiteral) End() token.Position  { return tokenEnd(il.Token) }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
 type PrefixExpression struct {
@@ -139,6 +216,13 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos() }
+func (pe *PrefixExpression) End() token.Position {
+	if !isNilNode(pe.Right) {
+		return pe.Right.End()
+	}
+	return tokenEnd(pe.Token)
+}
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -158,6 +242,18 @@ type InfixExpression struct {
 
 func (oe *InfixExpression) expressionNode()      {}
 func (oe *InfixExpression) TokenLiteral() string { return oe.Token.Literal }
+func (oe *InfixExpression) Pos() token.Position {
+	if !isNilNode(oe.Left) {
+		return oe.Left.Pos()
+	}
+	return oe.Token.Pos()
+}
+func (oe *InfixExpression) End() token.Position {
+	if !isNilNode(oe.Right) {
+		return oe.Right.End()
+	}
+	return tokenEnd(oe.Token)
+}
 func (oe *InfixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -176,6 +272,8 @@ type Boolean struct {
 
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos() }
+func (b *Boolean) End() token.Position  { return tokenEnd(b.Token) }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
 type IfExpression struct {
@@ -187,6 +285,16 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos() }
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	if ie.Consequence != nil {
+		return ie.Consequence.End()
+	}
+	return tokenEnd(ie.Token)
+}
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -204,12 +312,22 @@ func (ie *IfExpression) String() string {
 }
 
 type BlockStatement struct {
-	Token      token.Token
+	Token      token.Token // '{'トークン
 	Statements []Statement
+	Comments   []*Comment     // 最後の文より後ろのコメント
+	Rbrace     token.Position // '}'の位置
 }
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos() }
+func (bs *BlockStatement) End() token.Position {
+	// アロー関数の本体のように}を持たないブロックは、最後の文で終わる
+	if !bs.Rbrace.IsValid() && len(bs.Statements) > 0 {
+		return bs.Statements[len(bs.Statements)-1].End()
+	}
+	return after(bs.Rbrace)
+}
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -228,6 +346,13 @@ type FunctionLiteral struct {
 
 func (fl *FunctionLiteral) expressionNode()      {} // fnの結果をほかの変数に代入できたりするため。代入式の一部として扱うためには、式でないといけない
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos() }
+func (fl *FunctionLiteral) End() token.Position {
+	if fl.Body != nil {
+		return fl.Body.End()
+	}
+	return tokenEnd(fl.Token)
+}
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -249,10 +374,18 @@ type CallExpression struct {
 	Token     token.Token // '('トークン
 	Function  Expression
 	Arguments []Expression
+	Rparen    token.Position // ')'の位置
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position {
+	if !isNilNode(ce.Function) {
+		return ce.Function.Pos()
+	}
+	return ce.Token.Pos()
+}
+func (ce *CallExpression) End() token.Position { return after(ce.Rparen) }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -276,15 +409,31 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos() }
+func (sl *StringLiteral) End() token.Position  { return tokenEnd(sl.Token) }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 
+type BytesLiteral struct {
+	Token token.Token
+	Value string
+}
+
+func (bl *BytesLiteral) expressionNode()      {}
+func (bl *BytesLiteral) TokenLiteral() string { return bl.Token.Literal }
+func (bl *BytesLiteral) Pos() token.Position  { return bl.Token.Pos() }
+func (bl *BytesLiteral) End() token.Position  { return tokenEnd(bl.Token) }
+func (bl *BytesLiteral) String() string       { return "b\"" + bl.Token.Literal + "\"" }
+
 type ArrayLiteral struct {
 	Token    token.Token // '['トークン
 	Elements []Expression
+	Rbracket token.Position // ']'の位置
 }
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos() }
+func (al *ArrayLiteral) End() token.Position  { return after(al.Rbracket) }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -301,13 +450,21 @@ func (al *ArrayLiteral) String() string {
 }
 
 type IndexExpression struct {
-	Token token.Token // '['トークン
-	Left  Expression
-	Index Expression
+	Token    token.Token // '['トークン
+	Left     Expression
+	Index    Expression
+	Rbracket token.Position // ']'の位置
 }
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position {
+	if !isNilNode(ie.Left) {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos()
+}
+func (ie *IndexExpression) End() token.Position { return after(ie.Rbracket) }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -321,17 +478,20 @@ func (ie *IndexExpression) String() string {
 }
 
 type HashLiteral struct {
-	Token token.Token // '{'トークン
-	Pairs map[Expression]Expression
+	Token  token.Token // '{'トークン
+	Pairs  map[Expression]Expression
+	Rbrace token.Position // '}'の位置
 }
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos() }
+func (hl *HashLiteral) End() token.Position  { return after(hl.Rbrace) }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, key := range hl.Keys() {
+		pairs = append(pairs, key.String()+":"+hl.Pairs[key].String())
 	}
 
 	out.WriteString("{")
@@ -341,6 +501,26 @@ func (hl *HashLiteral) String() string {
 	return out.String()
 }
 
+// キーをソース上の順に並べて返す
+// Pairsはmapで順序を持たないので、位置で並べ直す。位置のないノードは文字列表現で比べる
+func (hl *HashLiteral) Keys() []Expression {
+	keys := make([]Expression, 0, len(hl.Pairs))
+	for key := range hl.Pairs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i].Pos(), keys[j].Pos()
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.Column != b.Column {
+			return a.Column < b.Column
+		}
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
 type MacroLiteral struct {
 	Token      token.Token // 'macro'トークン
 	Parameters []*Identifier
@@ -349,6 +529,13 @@ type MacroLiteral struct {
 
 func (ml *MacroLiteral) expressionNode()      {}
 func (ml *MacroLiteral) TokenLiteral() string { return ml.Token.Literal }
+func (ml *MacroLiteral) Pos() token.Position  { return ml.Token.Pos() }
+func (ml *MacroLiteral) End() token.Position {
+	if ml.Body != nil {
+		return ml.Body.End()
+	}
+	return tokenEnd(ml.Token)
+}
 func (ml *MacroLiteral) String() string {
 	var out bytes.Buffer
 
@@ -365,3 +552,23 @@ func (ml *MacroLiteral) String() string {
 
 	return out.String()
 }
+
+// トークンの直後の位置を返す
+func tokenEnd(tok token.Token) token.Position {
+	width := len(tok.Literal)
+	switch tok.Type {
+	case token.STRING:
+		width += 2 // 引用符
+	case token.BYTES:
+		width += 3 // bと引用符
+	}
+	return token.Position{Line: tok.Line, Column: tok.Column + width}
+}
+
+// 1文字のトークンの位置から、その直後の位置を返す
+func after(pos token.Position) token.Position {
+	if !pos.IsValid() {
+		return pos
+	}
+	return token.Position{Line: pos.Line, Column: pos.Column + 1}
+}
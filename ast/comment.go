@@ -0,0 +1,37 @@
+package ast
+
+import (
+	"monkey/token"
+	"strings"
+)
+
+// 行コメント。構文解析器が近くの文に付ける
+// 評価には影響せず、フォーマッタやドキュメント生成でソースを復元するために使う
+type Comment struct {
+	Token token.Token // token.COMMENT トークン。リテラルは//を含む
+}
+
+func (c *Comment) TokenLiteral() string { return c.Token.Literal }
+func (c *Comment) String() string       { return c.Token.Literal }
+func (c *Comment) Pos() token.Position  { return c.Token.Pos() }
+func (c *Comment) End() token.Position  { return tokenEnd(c.Token) }
+
+// //と前後の空白を除いた本文を返す
+func (c *Comment) Text() string {
+	return strings.TrimSpace(strings.TrimPrefix(c.Token.Literal, "//"))
+}
+
+// 文の直前に付いているコメントを返す
+func DocComments(stmt Statement) []*Comment {
+	switch stmt := stmt.(type) {
+	case *LetStatement:
+		return stmt.Doc
+	case *ReturnStatement:
+		return stmt.Doc
+	case *DeferStatement:
+		return stmt.Doc
+	case *ExpressionStatement:
+		return stmt.Doc
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ASTをGraphvizのDOT形式に変換する。ノードのラベルはトークンのリテラルと型名
+//
+//	monkey run --dot script.mky | dot -Tpng -o ast.png
+func Dot(node Node) string {
+	var out bytes.Buffer
+
+	out.WriteString("digraph AST {\n")
+	out.WriteString("  node [shape=box, fontname=\"monospace\"];\n")
+
+	id := 0
+	var visit func(node Node) int
+	visit = func(node Node) int {
+		self := id
+		id++
+
+		if node == nil || isNilNode(node) {
+			fmt.Fprintf(&out, "  n%d [label=\"<nil>\", style=dashed];\n", self)
+			return self
+		}
+
+		fmt.Fprintf(&out, "  n%d [label=%q];\n", self, dotLabel(node))
+		for _, child := range nodeChildren(node) {
+			fmt.Fprintf(&out, "  n%d -> n%d;\n", self, visit(child))
+		}
+		return self
+	}
+	visit(node)
+
+	out.WriteString("}\n")
+	return out.String()
+}
+
+// トークンのリテラルを1行目、型名を2行目にしたラベルを返す
+func dotLabel(node Node) string {
+	// Programのリテラルは最初の文のものなので使わない
+	if _, ok := node.(*Program); ok {
+		return nodeName(node)
+	}
+
+	literal := node.TokenLiteral()
+	if literal == "" {
+		literal = nodeDetail(node)
+	}
+	if literal == "" {
+		return nodeName(node)
+	}
+	return literal + "\n" + nodeName(node)
+}
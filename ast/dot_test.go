@@ -0,0 +1,40 @@
+package ast
+
+import (
+	"monkey/token"
+	"testing"
+)
+
+func TestDot(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&ExpressionStatement{
+				Token: token.Token{Type: token.INT, Literal: "1"},
+				Expression: &InfixExpression{
+					Token:    token.Token{Type: token.PLUS, Literal: "+"},
+					Left:     &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "1"}, Value: 1},
+					Operator: "+",
+					Right:    &Identifier{Token: token.Token{Type: token.IDENT, Literal: "x"}, Value: "x"},
+				},
+			},
+		},
+	}
+
+	expected := `digraph AST {
+  node [shape=box, fontname="monospace"];
+  n0 [label="Program"];
+  n1 [label="1\nExpressionStatement"];
+  n2 [label="+\nInfixExpression"];
+  n3 [label="1\nIntegerLiteral"];
+  n2 -> n3;
+  n4 [label="x\nIdentifier"];
+  n2 -> n4;
+  n1 -> n2;
+  n0 -> n1;
+}
+`
+
+	if got := Dot(program); got != expected {
+		t.Errorf("Dot() wrong.\nwant=\n%s\ngot=\n%s", expected, got)
+	}
+}
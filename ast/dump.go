@@ -0,0 +1,144 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ASTを1行に1ノードずつ、深さに応じてインデントした木として文字列化する
+func Dump(node Node) string {
+	var out bytes.Buffer
+	dump(&out, node, 0)
+	return out.String()
+}
+
+func dump(out *bytes.Buffer, node Node, depth int) {
+	out.WriteString(strings.Repeat("  ", depth))
+
+	// インターフェースに包まれたnilも含めて判定する
+	if node == nil || isNilNode(node) {
+		out.WriteString("<nil>\n")
+		return
+	}
+
+	out.WriteString(nodeName(node))
+	if detail := nodeDetail(node); detail != "" {
+		out.WriteString(" " + detail)
+	}
+	out.WriteString("\n")
+
+	// ハッシュはキーと値の組ごとにまとめて表示する
+	if hash, ok := node.(*HashLiteral); ok {
+		for _, key := range hash.Keys() {
+			out.WriteString(strings.Repeat("  ", depth+1) + "HashPair\n")
+			dump(out, key, depth+2)
+			dump(out, hash.Pairs[key], depth+2)
+		}
+		return
+	}
+
+	for _, child := range nodeChildren(node) {
+		dump(out, child, depth+1)
+	}
+}
+
+// ノードの型名を返す
+func nodeName(node Node) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+}
+
+// リテラルの値や演算子など、ノードを区別するための情報を返す
+func nodeDetail(node Node) string {
+	switch node := node.(type) {
+	case *Identifier:
+		return node.Value
+	case *IntegerLiteral:
+		return fmt.Sprintf("%d", node.Value)
+	case *StringLiteral:
+		return fmt.Sprintf("%q", node.Value)
+	case *BytesLiteral:
+		return fmt.Sprintf("b%q", node.Value)
+	case *Boolean:
+		return fmt.Sprintf("%t", node.Value)
+	case *PrefixExpression:
+		return node.Operator
+	case *InfixExpression:
+		return node.Operator
+	default:
+		return ""
+	}
+}
+
+// 子ノードをソースコード上の順に返す。ハッシュはキーと値を交互に返す
+func nodeChildren(node Node) []Node {
+	children := []Node{}
+
+	switch node := node.(type) {
+	case *Program:
+		for _, s := range node.Statements {
+			children = append(children, s)
+		}
+	case *LetStatement:
+		children = append(children, node.Name, node.Value)
+	case *ReturnStatement:
+		// 値のないreturnは子を持たない
+		if node.ReturnValue != nil {
+			children = append(children, node.ReturnValue)
+		}
+	case *DeferStatement:
+		children = append(children, node.Expression)
+	case *ExpressionStatement:
+		children = append(children, node.Expression)
+	case *BlockStatement:
+		for _, s := range node.Statements {
+			children = append(children, s)
+		}
+	case *PrefixExpression:
+		children = append(children, node.Right)
+	case *InfixExpression:
+		children = append(children, node.Left, node.Right)
+	case *IfExpression:
+		children = append(children, node.Condition, node.Consequence)
+		if node.Alternative != nil {
+			children = append(children, node.Alternative)
+		}
+	case *FunctionLiteral:
+		for _, p := range node.Parameters {
+			children = append(children, p)
+		}
+		children = append(children, node.Body)
+	case *MacroLiteral:
+		for _, p := range node.Parameters {
+			children = append(children, p)
+		}
+		children = append(children, node.Body)
+	case *CallExpression:
+		children = append(children, node.Function)
+		for _, a := range node.Arguments {
+			children = append(children, a)
+		}
+	case *ArrayLiteral:
+		for _, e := range node.Elements {
+			children = append(children, e)
+		}
+	case *IndexExpression:
+		children = append(children, node.Left, node.Index)
+	case *HashLiteral:
+		for _, key := range node.Keys() {
+			children = append(children, key, node.Pairs[key])
+		}
+	}
+
+	return children
+}
+
+// nilか、型付きのnilポインタを保持しているか判定する
+func isNilNode(node Node) bool {
+	if node == nil {
+		return true
+	}
+	v := reflect.ValueOf(node)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
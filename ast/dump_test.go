@@ -0,0 +1,61 @@
+package ast
+
+import (
+	"monkey/token"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let"},
+				Name:  &Identifier{Value: "x"},
+				Value: &InfixExpression{
+					Left:     &IntegerLiteral{Value: 1},
+					Operator: "+",
+					Right: &CallExpression{
+						Function:  &Identifier{Value: "f"},
+						Arguments: []Expression{&StringLiteral{Value: "a"}},
+					},
+				},
+			},
+			&ExpressionStatement{
+				Expression: &HashLiteral{
+					Pairs: map[Expression]Expression{
+						&Boolean{Value: true}: &IntegerLiteral{Value: 2},
+					},
+				},
+			},
+		},
+	}
+
+	expected := `Program
+  LetStatement
+    Identifier x
+    InfixExpression +
+      IntegerLiteral 1
+      CallExpression
+        Identifier f
+        StringLiteral "a"
+  ExpressionStatement
+    HashLiteral
+      HashPair
+        Boolean true
+        IntegerLiteral 2
+`
+
+	if got := Dump(program); got != expected {
+		t.Errorf("Dump() wrong.\nwant=\n%s\ngot=\n%s", expected, got)
+	}
+}
+
+func TestDumpNil(t *testing.T) {
+	var value *InfixExpression
+	stmt := &LetStatement{Name: &Identifier{Value: "x"}, Value: value}
+
+	expected := "LetStatement\n  Identifier x\n  <nil>\n"
+	if got := Dump(stmt); got != expected {
+		t.Errorf("Dump() wrong. want=%q, got=%q", expected, got)
+	}
+}
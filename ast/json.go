@@ -0,0 +1,419 @@
+package ast
+
+import (
+	"encoding/json"
+	"fmt"
+	"monkey/token"
+)
+
+// ASTのJSON表現
+// 各ノードは"node"に型名を持つオブジェクトになる。キーはencoding/jsonによってソートされ、
+// ハッシュの組はHashLiteral.Keysの順(ソース上の位置順)に並ぶので、同じASTからは常に同じJSONができる
+
+type jsonToken struct {
+	Type    token.TokenType `json:"type"`
+	Literal string          `json:"literal"`
+	Line    int             `json:"line"`
+	Column  int             `json:"column"`
+}
+
+type jsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// ノードをJSONにする
+func MarshalJSON(node Node) ([]byte, error) {
+	return json.Marshal(encodeNode(node))
+}
+
+// MarshalJSONで作ったJSONからノードを復元する
+func UnmarshalJSON(data []byte) (Node, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	return decodeNode(obj)
+}
+
+func encodeToken(tok token.Token) jsonToken {
+	return jsonToken{Type: tok.Type, Literal: tok.Literal, Line: tok.Line, Column: tok.Column}
+}
+
+func encodePosition(pos token.Position) jsonPosition {
+	return jsonPosition{Line: pos.Line, Column: pos.Column}
+}
+
+func encodeNode(node Node) interface{} {
+	if isNilNode(node) {
+		return nil
+	}
+
+	obj := map[string]interface{}{"node": nodeName(node)}
+
+	switch node := node.(type) {
+	case *Program:
+		obj["statements"] = encodeStatements(node.Statements)
+		obj["comments"] = encodeComments(node.Comments)
+	case *Comment:
+		obj["token"] = encodeToken(node.Token)
+	case *LetStatement:
+		obj["token"] = encodeToken(node.Token)
+		obj["name"] = encodeNode(node.Name)
+		obj["value"] = encodeNode(node.Value)
+		obj["doc"] = encodeComments(node.Doc)
+	case *ReturnStatement:
+		obj["token"] = encodeToken(node.Token)
+		obj["returnValue"] = encodeNode(node.ReturnValue)
+		obj["doc"] = encodeComments(node.Doc)
+	case *DeferStatement:
+		obj["token"] = encodeToken(node.Token)
+		obj["expression"] = encodeNode(node.Expression)
+		obj["doc"] = encodeComments(node.Doc)
+	case *ExpressionStatement:
+		obj["token"] = encodeToken(node.Token)
+		obj["expression"] = encodeNode(node.Expression)
+		obj["doc"] = encodeComments(node.Doc)
+	case *BlockStatement:
+		obj["token"] = encodeToken(node.Token)
+		obj["statements"] = encodeStatements(node.Statements)
+		obj["comments"] = encodeComments(node.Comments)
+		obj["rbrace"] = encodePosition(node.Rbrace)
+	case *Identifier:
+		obj["token"] = encodeToken(node.Token)
+		obj["value"] = node.Value
+	case *IntegerLiteral:
+		obj["token"] = encodeToken(node.Token)
+		obj["value"] = node.Value
+	case *StringLiteral:
+		obj["token"] = encodeToken(node.Token)
+		obj["value"] = node.Value
+	case *BytesLiteral:
+		obj["token"] = encodeToken(node.Token)
+		obj["value"] = node.Value
+	case *Boolean:
+		obj["token"] = encodeToken(node.Token)
+		obj["value"] = node.Value
+	case *PrefixExpression:
+		obj["token"] = encodeToken(node.Token)
+		obj["operator"] = node.Operator
+		obj["right"] = encodeNode(node.Right)
+	case *InfixExpression:
+		obj["token"] = encodeToken(node.Token)
+		obj["left"] = encodeNode(node.Left)
+		obj["operator"] = node.Operator
+		obj["right"] = encodeNode(node.Right)
+	case *IfExpression:
+		obj["token"] = encodeToken(node.Token)
+		obj["condition"] = encodeNode(node.Condition)
+		obj["consequence"] = encodeNode(node.Consequence)
+		obj["alternative"] = encodeNode(node.Alternative)
+	case *FunctionLiteral:
+		obj["token"] = encodeToken(node.Token)
+		obj["parameters"] = encodeIdentifiers(node.Parameters)
+		obj["body"] = encodeNode(node.Body)
+	case *MacroLiteral:
+		obj["token"] = encodeToken(node.Token)
+		obj["parameters"] = encodeIdentifiers(node.Parameters)
+		obj["body"] = encodeNode(node.Body)
+	case *CallExpression:
+		obj["token"] = encodeToken(node.Token)
+		obj["function"] = encodeNode(node.Function)
+		obj["arguments"] = encodeExpressions(node.Arguments)
+		obj["rparen"] = encodePosition(node.Rparen)
+	case *ArrayLiteral:
+		obj["token"] = encodeToken(node.Token)
+		obj["elements"] = encodeExpressions(node.Elements)
+		obj["rbracket"] = encodePosition(node.Rbracket)
+	case *IndexExpression:
+		obj["token"] = encodeToken(node.Token)
+		obj["left"] = encodeNode(node.Left)
+		obj["index"] = encodeNode(node.Index)
+		obj["rbracket"] = encodePosition(node.Rbracket)
+	case *HashLiteral:
+		pairs := []interface{}{}
+		for _, key := range node.Keys() {
+			pairs = append(pairs, map[string]interface{}{
+				"key":   encodeNode(key),
+				"value": encodeNode(node.Pairs[key]),
+			})
+		}
+		obj["token"] = encodeToken(node.Token)
+		obj["pairs"] = pairs
+		obj["rbrace"] = encodePosition(node.Rbrace)
+	}
+
+	return obj
+}
+
+func encodeStatements(stmts []Statement) []interface{} {
+	list := make([]interface{}, len(stmts))
+	for i, s := range stmts {
+		list[i] = encodeNode(s)
+	}
+	return list
+}
+
+func encodeExpressions(exps []Expression) []interface{} {
+	list := make([]interface{}, len(exps))
+	for i, e := range exps {
+		list[i] = encodeNode(e)
+	}
+	return list
+}
+
+func encodeIdentifiers(idents []*Identifier) []interface{} {
+	list := make([]interface{}, len(idents))
+	for i, ident := range idents {
+		list[i] = encodeNode(ident)
+	}
+	return list
+}
+
+func encodeComments(comments []*Comment) []interface{} {
+	list := make([]interface{}, len(comments))
+	for i, c := range comments {
+		list[i] = encodeNode(c)
+	}
+	return list
+}
+
+// JSONのオブジェクトからノードを復元する。キーがなければゼロ値のままにする
+type decoder struct {
+	obj map[string]json.RawMessage
+	err error
+}
+
+func decodeNode(obj map[string]json.RawMessage) (Node, error) {
+	if obj == nil {
+		return nil, nil
+	}
+
+	d := &decoder{obj: obj}
+	var name string
+	d.value("node", &name)
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	var node Node
+	switch name {
+	case "Program":
+		n := &Program{}
+		n.Statements = d.statements("statements")
+		n.Comments = d.comments("comments")
+		node = n
+	case "Comment":
+		node = &Comment{Token: d.token()}
+	case "LetStatement":
+		n := &LetStatement{Token: d.token()}
+		n.Name, _ = d.node("name").(*Identifier)
+		n.Value = d.expression("value")
+		n.Doc = d.comments("doc")
+		node = n
+	case "ReturnStatement":
+		n := &ReturnStatement{Token: d.token()}
+		n.ReturnValue = d.expression("returnValue")
+		n.Doc = d.comments("doc")
+		node = n
+	case "DeferStatement":
+		n := &DeferStatement{Token: d.token()}
+		n.Expression = d.expression("expression")
+		n.Doc = d.comments("doc")
+		node = n
+	case "ExpressionStatement":
+		n := &ExpressionStatement{Token: d.token()}
+		n.Expression = d.expression("expression")
+		n.Doc = d.comments("doc")
+		node = n
+	case "BlockStatement":
+		n := &BlockStatement{Token: d.token()}
+		n.Statements = d.statements("statements")
+		n.Comments = d.comments("comments")
+		n.Rbrace = d.position("rbrace")
+		node = n
+	case "Identifier":
+		n := &Identifier{Token: d.token()}
+		d.value("value", &n.Value)
+		node = n
+	case "IntegerLiteral":
+		n := &IntegerLiteral{Token: d.token()}
+		d.value("value", &n.Value)
+		node = n
+	case "StringLiteral":
+		n := &StringLiteral{Token: d.token()}
+		d.value("value", &n.Value)
+		node = n
+	case "BytesLiteral":
+		n := &BytesLiteral{Token: d.token()}
+		d.value("value", &n.Value)
+		node = n
+	case "Boolean":
+		n := &Boolean{Token: d.token()}
+		d.value("value", &n.Value)
+		node = n
+	case "PrefixExpression":
+		n := &PrefixExpression{Token: d.token()}
+		d.value("operator", &n.Operator)
+		n.Right = d.expression("right")
+		node = n
+	case "InfixExpression":
+		n := &InfixExpression{Token: d.token()}
+		n.Left = d.expression("left")
+		d.value("operator", &n.Operator)
+		n.Right = d.expression("right")
+		node = n
+	case "IfExpression":
+		n := &IfExpression{Token: d.token()}
+		n.Condition = d.expression("condition")
+		n.Consequence, _ = d.node("consequence").(*BlockStatement)
+		n.Alternative, _ = d.node("alternative").(*BlockStatement)
+		node = n
+	case "FunctionLiteral":
+		n := &FunctionLiteral{Token: d.token()}
+		n.Parameters = d.identifiers("parameters")
+		n.Body, _ = d.node("body").(*BlockStatement)
+		node = n
+	case "MacroLiteral":
+		n := &MacroLiteral{Token: d.token()}
+		n.Parameters = d.identifiers("parameters")
+		n.Body, _ = d.node("body").(*BlockStatement)
+		node = n
+	case "CallExpression":
+		n := &CallExpression{Token: d.token()}
+		n.Function = d.expression("function")
+		n.Arguments = d.expressions("arguments")
+		n.Rparen = d.position("rparen")
+		node = n
+	case "ArrayLiteral":
+		n := &ArrayLiteral{Token: d.token()}
+		n.Elements = d.expressions("elements")
+		n.Rbracket = d.position("rbracket")
+		node = n
+	case "IndexExpression":
+		n := &IndexExpression{Token: d.token()}
+		n.Left = d.expression("left")
+		n.Index = d.expression("index")
+		n.Rbracket = d.position("rbracket")
+		node = n
+	case "HashLiteral":
+		n := &HashLiteral{Token: d.token(), Pairs: map[Expression]Expression{}}
+		var pairs []map[string]map[string]json.RawMessage
+		d.value("pairs", &pairs)
+		for _, pair := range pairs {
+			key, _ := d.decode(pair["key"]).(Expression)
+			value, _ := d.decode(pair["value"]).(Expression)
+			if key != nil {
+				n.Pairs[key] = value
+			}
+		}
+		n.Rbrace = d.position("rbrace")
+		node = n
+	default:
+		return nil, fmt.Errorf("unknown node type: %q", name)
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	return node, nil
+}
+
+// キーの値をvに読み込む。キーがない場合は何もしない
+func (d *decoder) value(key string, v interface{}) {
+	raw, ok := d.obj[key]
+	if !ok || d.err != nil {
+		return
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		d.err = fmt.Errorf("%s: %w", key, err)
+	}
+}
+
+func (d *decoder) decode(obj map[string]json.RawMessage) Node {
+	if d.err != nil {
+		return nil
+	}
+	node, err := decodeNode(obj)
+	if err != nil {
+		d.err = err
+		return nil
+	}
+	return node
+}
+
+func (d *decoder) token() token.Token {
+	var tok jsonToken
+	d.value("token", &tok)
+	return token.Token{Type: tok.Type, Literal: tok.Literal, Line: tok.Line, Column: tok.Column}
+}
+
+func (d *decoder) position(key string) token.Position {
+	var pos jsonPosition
+	d.value(key, &pos)
+	return token.Position{Line: pos.Line, Column: pos.Column}
+}
+
+func (d *decoder) node(key string) Node {
+	var obj map[string]json.RawMessage
+	d.value(key, &obj)
+	return d.decode(obj)
+}
+
+func (d *decoder) nodes(key string) []Node {
+	var objs []map[string]json.RawMessage
+	d.value(key, &objs)
+
+	nodes := make([]Node, 0, len(objs))
+	for _, obj := range objs {
+		if node := d.decode(obj); node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+func (d *decoder) expression(key string) Expression {
+	exp, _ := d.node(key).(Expression)
+	return exp
+}
+
+func (d *decoder) statements(key string) []Statement {
+	stmts := []Statement{}
+	for _, node := range d.nodes(key) {
+		if stmt, ok := node.(Statement); ok {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
+
+func (d *decoder) expressions(key string) []Expression {
+	exps := []Expression{}
+	for _, node := range d.nodes(key) {
+		if exp, ok := node.(Expression); ok {
+			exps = append(exps, exp)
+		}
+	}
+	return exps
+}
+
+func (d *decoder) identifiers(key string) []*Identifier {
+	idents := []*Identifier{}
+	for _, node := range d.nodes(key) {
+		if ident, ok := node.(*Identifier); ok {
+			idents = append(idents, ident)
+		}
+	}
+	return idents
+}
+
+func (d *decoder) comments(key string) []*Comment {
+	var comments []*Comment
+	for _, node := range d.nodes(key) {
+		if c, ok := node.(*Comment); ok {
+			comments = append(comments, c)
+		}
+	}
+	return comments
+}
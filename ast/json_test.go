@@ -0,0 +1,49 @@
+package ast
+
+import (
+	"monkey/token"
+	"testing"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	node := &InfixExpression{
+		Token:    token.Token{Type: token.PLUS, Literal: "+", Line: 1, Column: 3},
+		Left:     &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "1", Line: 1, Column: 1}, Value: 1},
+		Operator: "+",
+		Right:    &Identifier{Token: token.Token{Type: token.IDENT, Literal: "x", Line: 1, Column: 5}, Value: "x"},
+	}
+
+	expected := `{"left":{"node":"IntegerLiteral","token":{"type":"INT","literal":"1","line":1,"column":1},"value":1},` +
+		`"node":"InfixExpression","operator":"+",` +
+		`"right":{"node":"Identifier","token":{"type":"IDENT","literal":"x","line":1,"column":5},"value":"x"},` +
+		`"token":{"type":"+","literal":"+","line":1,"column":3}}`
+
+	data, err := MarshalJSON(node)
+	if err != nil {
+		t.Fatalf("MarshalJSON returned error: %s", err)
+	}
+	if string(data) != expected {
+		t.Errorf("json wrong.\nwant=%s\ngot=%s", expected, data)
+	}
+}
+
+func TestUnmarshalJSONErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"node":"Unknown"}`, `unknown node type: "Unknown"`},
+		{`{"node":"IntegerLiteral","value":"one"}`, "value: json: cannot unmarshal string into Go value of type int64"},
+	}
+
+	for _, tt := range tests {
+		_, err := UnmarshalJSON([]byte(tt.input))
+		if err == nil {
+			t.Errorf("expected error for %s", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("error wrong for %s.\nwant=%q\ngot=%q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package ast
+
+// Rewriteに渡す変換関数。受け取ったノードか、それに代わるノードを返す
+// 文のリストの中でnilを返すと、その文を取り除く
+type RewriteFunc func(Node) Node
+
+// 子から順にfnを適用して、新しい木を作る(帰りがけ順)
+// Modifyと違い、全てのノードをコピーするので元の木は変更しない
+// 変換結果の型が親のフィールドに合わない場合、そのフィールドはnilになる
+func Rewrite(node Node, fn RewriteFunc) Node {
+	if isNilNode(node) {
+		return node
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		c := *n
+		c.Statements = rewriteStatements(n.Statements, fn)
+		node = &c
+	case *LetStatement:
+		c := *n
+		c.Name, _ = Rewrite(n.Name, fn).(*Identifier)
+		c.Value, _ = Rewrite(n.Value, fn).(Expression)
+		node = &c
+	case *ReturnStatement:
+		c := *n
+		c.ReturnValue, _ = Rewrite(n.ReturnValue, fn).(Expression)
+		node = &c
+	case *DeferStatement:
+		c := *n
+		c.Expression, _ = Rewrite(n.Expression, fn).(Expression)
+		node = &c
+	case *ExpressionStatement:
+		c := *n
+		c.Expression, _ = Rewrite(n.Expression, fn).(Expression)
+		node = &c
+	case *BlockStatement:
+		c := *n
+		c.Statements = rewriteStatements(n.Statements, fn)
+		node = &c
+	case *PrefixExpression:
+		c := *n
+		c.Right, _ = Rewrite(n.Right, fn).(Expression)
+		node = &c
+	case *InfixExpression:
+		c := *n
+		c.Left, _ = Rewrite(n.Left, fn).(Expression)
+		c.Right, _ = Rewrite(n.Right, fn).(Expression)
+		node = &c
+	case *IfExpression:
+		c := *n
+		c.Condition, _ = Rewrite(n.Condition, fn).(Expression)
+		c.Consequence, _ = Rewrite(n.Consequence, fn).(*BlockStatement)
+		if n.Alternative != nil {
+			c.Alternative, _ = Rewrite(n.Alternative, fn).(*BlockStatement)
+		}
+		node = &c
+	case *FunctionLiteral:
+		c := *n
+		c.Parameters = rewriteIdentifiers(n.Parameters, fn)
+		c.Body, _ = Rewrite(n.Body, fn).(*BlockStatement)
+		node = &c
+	case *MacroLiteral:
+		c := *n
+		c.Parameters = rewriteIdentifiers(n.Parameters, fn)
+		c.Body, _ = Rewrite(n.Body, fn).(*BlockStatement)
+		node = &c
+	case *CallExpression:
+		c := *n
+		c.Function, _ = Rewrite(n.Function, fn).(Expression)
+		c.Arguments = rewriteExpressions(n.Arguments, fn)
+		node = &c
+	case *ArrayLiteral:
+		c := *n
+		c.Elements = rewriteExpressions(n.Elements, fn)
+		node = &c
+	case *IndexExpression:
+		c := *n
+		c.Left, _ = Rewrite(n.Left, fn).(Expression)
+		c.Index, _ = Rewrite(n.Index, fn).(Expression)
+		node = &c
+	case *HashLiteral:
+		c := *n
+		c.Pairs = make(map[Expression]Expression, len(n.Pairs))
+		for _, key := range n.Keys() {
+			newKey, _ := Rewrite(key, fn).(Expression)
+			newValue, _ := Rewrite(n.Pairs[key], fn).(Expression)
+			if newKey != nil {
+				c.Pairs[newKey] = newValue
+			}
+		}
+		node = &c
+	case *Identifier:
+		c := *n
+		node = &c
+	case *IntegerLiteral:
+		c := *n
+		node = &c
+	case *StringLiteral:
+		c := *n
+		node = &c
+	case *BytesLiteral:
+		c := *n
+		node = &c
+	case *Boolean:
+		c := *n
+		node = &c
+	}
+
+	return fn(node)
+}
+
+func rewriteStatements(stmts []Statement, fn RewriteFunc) []Statement {
+	result := make([]Statement, 0, len(stmts))
+	for _, s := range stmts {
+		if stmt, ok := Rewrite(s, fn).(Statement); ok && !isNilNode(stmt) {
+			result = append(result, stmt)
+		}
+	}
+	return result
+}
+
+func rewriteExpressions(exps []Expression, fn RewriteFunc) []Expression {
+	if exps == nil {
+		return nil
+	}
+	result := make([]Expression, len(exps))
+	for i, e := range exps {
+		result[i], _ = Rewrite(e, fn).(Expression)
+	}
+	return result
+}
+
+func rewriteIdentifiers(idents []*Identifier, fn RewriteFunc) []*Identifier {
+	if idents == nil {
+		return nil
+	}
+	result := make([]*Identifier, len(idents))
+	for i, ident := range idents {
+		result[i], _ = Rewrite(ident, fn).(*Identifier)
+	}
+	return result
+}
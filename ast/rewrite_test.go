@@ -0,0 +1,99 @@
+package ast
+
+import "testing"
+
+func TestRewrite(t *testing.T) {
+	one := func() Expression { return &IntegerLiteral{Value: 1} }
+	two := func() Expression { return &IntegerLiteral{Value: 2} }
+
+	// 1を2に置き換える
+	turnOneIntoTwo := func(node Node) Node {
+		integer, ok := node.(*IntegerLiteral)
+		if !ok || integer.Value != 1 {
+			return node
+		}
+		return &IntegerLiteral{Value: 2}
+	}
+
+	tests := []struct {
+		input    Node
+		expected Node
+	}{
+		{one(), two()},
+		{
+			&CallExpression{Function: &Identifier{Value: "f"}, Arguments: []Expression{one(), two()}},
+			&CallExpression{Function: &Identifier{Value: "f"}, Arguments: []Expression{two(), two()}},
+		},
+		{
+			&MacroLiteral{Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}}},
+			&MacroLiteral{Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}}},
+		},
+		{
+			&IfExpression{
+				Condition:   &InfixExpression{Left: one(), Operator: "<", Right: two()},
+				Consequence: &BlockStatement{Statements: []Statement{&ReturnStatement{ReturnValue: one()}}},
+				Alternative: &BlockStatement{Statements: []Statement{&ReturnStatement{}}},
+			},
+			&IfExpression{
+				Condition:   &InfixExpression{Left: two(), Operator: "<", Right: two()},
+				Consequence: &BlockStatement{Statements: []Statement{&ReturnStatement{ReturnValue: two()}}},
+				Alternative: &BlockStatement{Statements: []Statement{&ReturnStatement{}}},
+			},
+		},
+		{
+			&HashLiteral{Pairs: map[Expression]Expression{one(): &IndexExpression{Left: &ArrayLiteral{Elements: []Expression{one()}}, Index: one()}}},
+			&HashLiteral{Pairs: map[Expression]Expression{two(): &IndexExpression{Left: &ArrayLiteral{Elements: []Expression{two()}}, Index: two()}}},
+		},
+	}
+
+	for _, tt := range tests {
+		before := Dump(tt.input)
+		rewritten := Rewrite(tt.input, turnOneIntoTwo)
+
+		if Dump(rewritten) != Dump(tt.expected) {
+			t.Errorf("rewrite wrong.\nwant=%s\ngot=%s", Dump(tt.expected), Dump(rewritten))
+		}
+		// 元の木は変更しない
+		if Dump(tt.input) != before {
+			t.Errorf("input was modified.\nbefore=%s\nafter=%s", before, Dump(tt.input))
+		}
+	}
+}
+
+func TestRewriteRemovesStatements(t *testing.T) {
+	program := &Program{Statements: []Statement{
+		&LetStatement{Name: &Identifier{Value: "x"}, Value: &IntegerLiteral{Value: 1}},
+		&ExpressionStatement{Expression: &Identifier{Value: "x"}},
+		&ExpressionStatement{Expression: &FunctionLiteral{Body: &BlockStatement{Statements: []Statement{
+			&ExpressionStatement{Expression: &Identifier{Value: "y"}},
+			&ReturnStatement{ReturnValue: &Identifier{Value: "y"}},
+		}}}},
+	}}
+
+	// 値を捨てる識別子だけの式文を取り除く
+	rewritten := Rewrite(program, func(node Node) Node {
+		if stmt, ok := node.(*ExpressionStatement); ok {
+			if _, ok := stmt.Expression.(*Identifier); ok {
+				return nil
+			}
+		}
+		return node
+	})
+
+	expected := `Program
+  LetStatement
+    Identifier x
+    IntegerLiteral 1
+  ExpressionStatement
+    FunctionLiteral
+      BlockStatement
+        ReturnStatement
+          Identifier y
+`
+	if Dump(rewritten) != expected {
+		t.Errorf("rewrite wrong.\nwant=%s\ngot=%s", expected, Dump(rewritten))
+	}
+	if len(program.Statements) != 3 {
+		t.Errorf("input was modified. got=%d statements", len(program.Statements))
+	}
+}
@@ -0,0 +1,41 @@
+package ast
+
+// Walkが各ノードで呼び出す
+// Visitが返したVisitorでそのノードの子をたどる。nilを返すと子はたどらない
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// ASTを深さ優先でたどる。まずv.Visit(node)を呼び、返ったVisitorが
+// nilでなければ子ノードをソースコード上の順にたどり、最後にw.Visit(nil)を呼ぶ
+// nilの子は飛ばす。コメントはたどらないので、必要ならDocやCommentsを参照する
+func Walk(v Visitor, node Node) {
+	if isNilNode(node) {
+		return
+	}
+
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	for _, child := range nodeChildren(node) {
+		Walk(v, child)
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// ASTを深さ優先でたどり、各ノードでfを呼ぶ。fがfalseを返すとそのノードの子はたどらない
+// 子をたどり終えるとf(nil)を呼ぶ
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
@@ -0,0 +1,145 @@
+package ast
+
+import (
+	"monkey/token"
+	"strings"
+	"testing"
+)
+
+// 訪れたノードの型名を記録する。nilの呼び出しは")"で表す
+type recorder struct {
+	visited []string
+	skip    string // この型の子はたどらない
+}
+
+func (r *recorder) Visit(node Node) Visitor {
+	if node == nil {
+		r.visited = append(r.visited, ")")
+		return nil
+	}
+
+	name := nodeName(node)
+	r.visited = append(r.visited, name)
+	if name == r.skip {
+		return nil
+	}
+	return r
+}
+
+func TestWalk(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Name: &Identifier{Value: "f"},
+				Value: &FunctionLiteral{
+					Parameters: []*Identifier{{Value: "x"}},
+					Body: &BlockStatement{
+						Statements: []Statement{
+							&ReturnStatement{ReturnValue: &PrefixExpression{
+								Operator: "-",
+								Right:    &Identifier{Value: "x"},
+							}},
+						},
+					},
+				},
+			},
+			&ExpressionStatement{
+				Expression: &IndexExpression{
+					Left:  &ArrayLiteral{Elements: []Expression{&IntegerLiteral{Value: 1}}},
+					Index: &IntegerLiteral{Value: 0},
+				},
+			},
+			&ReturnStatement{},
+		},
+	}
+
+	tests := []struct {
+		skip     string
+		expected string
+	}{
+		{
+			"",
+			"Program LetStatement Identifier ) FunctionLiteral Identifier ) BlockStatement ReturnStatement " +
+				"PrefixExpression Identifier ) ) ) ) ) ) ExpressionStatement IndexExpression ArrayLiteral " +
+				"IntegerLiteral ) ) IntegerLiteral ) ) ) ReturnStatement ) )",
+		},
+		{
+			"FunctionLiteral",
+			"Program LetStatement Identifier ) FunctionLiteral ) ExpressionStatement IndexExpression ArrayLiteral " +
+				"IntegerLiteral ) ) IntegerLiteral ) ) ) ReturnStatement ) )",
+		},
+	}
+
+	for _, tt := range tests {
+		r := &recorder{skip: tt.skip}
+		Walk(r, program)
+
+		got := strings.Join(r.visited, " ")
+		if got != tt.expected {
+			t.Errorf("visit order wrong (skip=%q).\nwant=%q\ngot=%q", tt.skip, tt.expected, got)
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&ExpressionStatement{
+				Expression: &CallExpression{
+					Function: &Identifier{Value: "f"},
+					Arguments: []Expression{
+						&Identifier{Value: "a"},
+						&FunctionLiteral{
+							Parameters: []*Identifier{{Value: "b"}},
+							Body: &BlockStatement{Statements: []Statement{
+								&ExpressionStatement{Expression: &Identifier{Value: "c"}},
+							}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// 関数リテラルの中には入らずに識別子を集める
+	names := []string{}
+	Inspect(program, func(node Node) bool {
+		switch node := node.(type) {
+		case *Identifier:
+			names = append(names, node.Value)
+		case *FunctionLiteral:
+			return false
+		}
+		return true
+	})
+
+	if got := strings.Join(names, ","); got != "f,a" {
+		t.Errorf("identifiers wrong. want=%q, got=%q", "f,a", got)
+	}
+}
+
+func TestWalkHashLiteral(t *testing.T) {
+	// {b: 1, a: 2}。キーの文字列表現の順ではなく、ソースコード上の順にたどる
+	hash := &HashLiteral{Pairs: map[Expression]Expression{
+		&Identifier{Token: token.Token{Line: 1, Column: 2}, Value: "b"}: &IntegerLiteral{Token: token.Token{Literal: "1", Line: 1, Column: 5}, Value: 1},
+		&Identifier{Token: token.Token{Line: 1, Column: 8}, Value: "a"}: &IntegerLiteral{Token: token.Token{Literal: "2", Line: 1, Column: 11}, Value: 2},
+	}}
+
+	values := []string{}
+	Inspect(hash, func(node Node) bool {
+		switch node := node.(type) {
+		case *Identifier:
+			values = append(values, node.Value)
+		case *IntegerLiteral:
+			values = append(values, node.String())
+		}
+		return true
+	})
+
+	if got := strings.Join(values, ","); got != "b,1,a,2" {
+		t.Errorf("visit order wrong. want=%q, got=%q", "b,1,a,2", got)
+	}
+	if got := hash.String(); got != "{b:1, a:2}" {
+		t.Errorf("hash.String() wrong. want=%q, got=%q", "{b:1, a:2}", got)
+	}
+}
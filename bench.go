@@ -0,0 +1,167 @@
+// ベンチマークモード。monkey bench script.mky -n 100
+// 構文解析とマクロ展開は1回だけ行い、評価だけをN回繰り返して計測する。VMではコンパイルも計測に含める
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/diag"
+	"monkey/evaluator"
+	"monkey/object"
+	"os"
+	"runtime"
+	"time"
+)
+
+// 計測結果
+type benchResult struct {
+	runs    int
+	min     time.Duration
+	max     time.Duration
+	total   time.Duration
+	mallocs uint64 // 全ての評価でのアロケーション回数の合計
+	bytes   uint64 // 全ての評価でアロケートしたバイト数の合計
+	lastErr *object.Error
+}
+
+// benchサブコマンドの設定
+type benchOptions struct {
+	runs     int    // 評価する回数
+	optimize bool   // 評価の前に定数式を計算しておく
+	noColor  bool   // エラー表示に色を付けない
+	engine   string // 実行エンジン。repl.EngineEvalかrepl.EngineVM
+}
+
+// benchサブコマンドの引数を処理し、終了コードを返す
+func benchCommand(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: monkey bench <file> [-n runs]")
+		fs.PrintDefaults()
+	}
+	runs := fs.Int("n", 10, "number of evaluations")
+	optimize := fs.Bool("optimize", false, "fold constant expressions before evaluating")
+	noColor := fs.Bool("no-color", false, "disable colored output")
+	engine := engineFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return exitRuntimeError
+	}
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return exitRuntimeError
+	}
+	filename := fs.Arg(0)
+
+	// ファイル名の後ろに書かれたフラグも受け付ける
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return exitRuntimeError
+	}
+	if *runs < 1 {
+		fmt.Fprintln(os.Stderr, "-n must be at least 1")
+		return exitRuntimeError
+	}
+	if err := checkEngine(*engine); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitRuntimeError
+	}
+
+	return benchFile(filename, benchOptions{
+		runs:     *runs,
+		optimize: *optimize,
+		noColor:  *noColor,
+		engine:   *engine,
+	}, os.Stdout, os.Stderr)
+}
+
+// ファイルを構文解析してマクロを展開し、評価を繰り返して結果をoutに表示する
+func benchFile(filename string, opts benchOptions, out, errOut io.Writer) int {
+	f, err := os.Open(filename)
+	if err != nil {
+		fmt.Fprintln(errOut, err)
+		return exitRuntimeError
+	}
+	defer f.Close()
+
+	printer := diag.NewPrinter(errOut, diag.ColorEnabled(!opts.noColor, errOut))
+	program := parseSource(filename, f, printer, 0)
+	if program == nil {
+		return exitParseError
+	}
+
+	macroEnv := object.NewEnvironment()
+	evaluator.DefineMacros(program, macroEnv)
+	expanded, macroErrors := evaluator.ExpandMacros(program, macroEnv)
+	if len(macroErrors) != 0 {
+		for _, err := range macroErrors {
+			printer.Print(diag.MacroError, filename+":"+err.Error())
+		}
+		return exitRuntimeError
+	}
+	if opts.optimize {
+		expanded = evaluator.Optimize(expanded)
+	}
+
+	result, err := benchmark(opts.engine, expanded, opts.runs)
+	if err != nil {
+		printer.Print(diag.CompileError, filename+":"+err.Error())
+		return exitRuntimeError
+	}
+	if result.lastErr != nil {
+		printer.Print(diag.RuntimeError, result.lastErr.Located(filename)+result.lastErr.StackTrace())
+		return exitRuntimeError
+	}
+
+	printBenchResult(out, result)
+	return exitOK
+}
+
+// プログラムをruns回評価して計測する。評価のたびに新しい環境を使う
+// VMでコンパイルできない場合はerrorを返す
+func benchmark(engine string, program ast.Node, runs int) (benchResult, error) {
+	result := benchResult{runs: runs}
+	var before, after runtime.MemStats
+
+	for i := 0; i < runs; i++ {
+		env := object.NewEnvironment()
+		files := evaluator.NewFiles()
+
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		evaluated, err := execute(engine, program, env, evaluator.Options{Files: files})
+		elapsed := time.Since(start)
+		runtime.ReadMemStats(&after)
+		// 閉じ忘れたファイルが実行のたびに溜まらないようにする
+		files.Close()
+
+		if err != nil {
+			return result, err
+		}
+		if errObj, ok := evaluated.(*object.Error); ok {
+			result.lastErr = errObj
+			return result, nil
+		}
+
+		if i == 0 || elapsed < result.min {
+			result.min = elapsed
+		}
+		if elapsed > result.max {
+			result.max = elapsed
+		}
+		result.total += elapsed
+		result.mallocs += after.Mallocs - before.Mallocs
+		result.bytes += after.TotalAlloc - before.TotalAlloc
+	}
+
+	return result, nil
+}
+
+func printBenchResult(out io.Writer, r benchResult) {
+	n := uint64(r.runs)
+	fmt.Fprintf(out, "runs: %d\n", r.runs)
+	fmt.Fprintf(out, "min: %s  avg: %s  max: %s\n", r.min, r.total/time.Duration(r.runs), r.max)
+	fmt.Fprintf(out, "allocs/run: %d  bytes/run: %d\n", r.mallocs/n, r.bytes/n)
+}
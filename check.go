@@ -0,0 +1,55 @@
+// 構文チェックモード。monkey check a.mky b.mky ...
+// 評価はせずに、字句解析と構文解析のエラーだけを報告する
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"monkey/diag"
+	"os"
+)
+
+// checkサブコマンドの引数を処理し、終了コードを返す
+func checkCommand(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: monkey check [flags] <file>...")
+		fs.PrintDefaults()
+	}
+	noColor := fs.Bool("no-color", false, "disable colored output")
+	contextLines := fs.Int("context", 0, "number of source lines to show around parse errors")
+	if err := fs.Parse(args); err != nil {
+		return exitRuntimeError
+	}
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return exitRuntimeError
+	}
+
+	return checkFiles(fs.Args(), diag.ColorEnabled(!*noColor, os.Stderr), *contextLines, os.Stderr)
+}
+
+// 全てのファイルを構文解析する。構文エラーが1つでもあればexitParseErrorを、読めないファイルがあればexitRuntimeErrorを返す
+func checkFiles(filenames []string, color bool, contextLines int, errOut io.Writer) int {
+	printer := diag.NewPrinter(errOut, color)
+	code := exitOK
+
+	for _, filename := range filenames {
+		f, err := os.Open(filename)
+		if err != nil {
+			fmt.Fprintln(errOut, err)
+			code = exitRuntimeError
+			continue
+		}
+
+		if parseSource(filename, f, printer, contextLines) == nil && code == exitOK {
+			code = exitParseError
+		}
+		f.Close()
+	}
+
+	return code
+}
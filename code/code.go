@@ -0,0 +1,272 @@
+// バイトコードの命令を定義する。1つの命令は1バイトのオペコードと、0個以上のオペランドからなる
+// オペランドはビッグエンディアンで、幅はオペコードごとに決まっている
+
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// 命令の並び。複数の命令を1つのバイト列につなげて持つ
+type Instructions []byte
+
+// 1行に1命令ずつ、オフセットとオペコード名とオペランドを並べる
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			// 続きは命令の区切りがわからないので読まない
+			break
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n",
+			len(operands), operandCount)
+	}
+
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
+
+type Opcode byte
+
+const (
+	// 定数プールのオペランド番目の値を積む
+	OpConstant Opcode = iota
+	// スタックの一番上の値を捨てる。式文の後に置く
+	OpPop
+
+	// 中置演算子。2つの値を降ろして、結果を積む
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpLessThan
+	OpRange
+
+	// 整数に特化した中置演算子。コンパイル時に整数とわかるオペランドを含む演算に使う
+	// 実行時に両方が整数なら型で分岐せずに計算し、そうでなければ対応する汎用の演算と同じ結果になる
+	OpAddInt
+	OpSubInt
+	OpMulInt
+	OpEqualInt
+	OpNotEqualInt
+	OpGreaterThanInt
+	OpLessThanInt
+
+	// 前置演算子
+	OpMinus
+	OpBang
+
+	OpTrue
+	OpFalse
+	OpNull
+
+	// オペランドの位置へ飛ぶ
+	OpJump
+	// 降ろした値が真でなければ、オペランドの位置へ飛ぶ
+	OpJumpNotTruthy
+
+	// オペランドの数の要素を降ろして配列にする
+	OpArray
+	// オペランドの数の値(キーと値が交互に並ぶ)を降ろしてハッシュにする
+	OpHash
+	// 添字とコレクションを降ろして、要素を積む
+	OpIndex
+
+	// オペランドの数の引数と関数を降ろして呼び出す
+	OpCall
+	// OpCallと同じく呼び出すが、結果をそのまま返す位置にあるので、実行中のフレームを呼ぶ関数のものに置き換えてよい
+	OpTailCall
+	// スタックの一番上の値を返して関数を抜ける
+	OpReturnValue
+	// 値を返さずに関数を抜ける。結果はnullになる
+	OpReturn
+	// 定数プールの1つ目のオペランド番目の関数から、実行時の関数の値を作って積む
+	// 2つ目のオペランドの数のセルを降ろして、関数の自由変数にする
+	OpClosure
+
+	// グローバル変数を読み書きする。オペランドは変数の番号
+	OpGetGlobal
+	OpSetGlobal
+	// 関数のローカル変数を読み書きする。オペランドはフレームの中での番号
+	OpGetLocal
+	OpSetLocal
+	// 入れ子の関数から参照されるローカル変数は、スロットにセルを置いてその中身を読み書きする
+	OpMakeCell
+	OpGetCell
+	OpSetCell
+	// ローカル変数のセルそのものを積む。OpClosureの前に置く
+	OpLoadCell
+	// 実行中の関数の自由変数を読む
+	OpGetFree
+	// 実行中の関数の自由変数のセルそのものを積む。OpClosureの前に置く
+	OpLoadFree
+	// 組み込み関数を積む。オペランドはevaluator.BuiltinNamesの順の番号
+	OpGetBuiltin
+
+	// 関数の値を降ろして、実行中の関数から戻るときに呼び出すよう積んでおく。deferの式は引数のない関数にする
+	OpDefer
+
+	// quote式。1つ目のオペランドは定数プールにあるquoteの引数、2つ目はunquoteの引数にする関数の数
+	// 積んである関数を降ろして、unquoteの順に呼び出した値で置き換えたquoteを積む
+	OpQuote
+)
+
+// オペコードの名前とオペランドの幅
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+
+	OpAdd:         {"OpAdd", []int{}},
+	OpSub:         {"OpSub", []int{}},
+	OpMul:         {"OpMul", []int{}},
+	OpDiv:         {"OpDiv", []int{}},
+	OpEqual:       {"OpEqual", []int{}},
+	OpNotEqual:    {"OpNotEqual", []int{}},
+	OpGreaterThan: {"OpGreaterThan", []int{}},
+	OpLessThan:    {"OpLessThan", []int{}},
+	OpRange:       {"OpRange", []int{}},
+
+	OpAddInt:         {"OpAddInt", []int{}},
+	OpSubInt:         {"OpSubInt", []int{}},
+	OpMulInt:         {"OpMulInt", []int{}},
+	OpEqualInt:       {"OpEqualInt", []int{}},
+	OpNotEqualInt:    {"OpNotEqualInt", []int{}},
+	OpGreaterThanInt: {"OpGreaterThanInt", []int{}},
+	OpLessThanInt:    {"OpLessThanInt", []int{}},
+
+	OpMinus: {"OpMinus", []int{}},
+	OpBang:  {"OpBang", []int{}},
+
+	OpTrue:  {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+	OpNull:  {"OpNull", []int{}},
+
+	OpJump:          {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+
+	OpArray: {"OpArray", []int{2}},
+	OpHash:  {"OpHash", []int{2}},
+	OpIndex: {"OpIndex", []int{}},
+
+	OpCall:        {"OpCall", []int{1}},
+	OpTailCall:    {"OpTailCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+	OpClosure:     {"OpClosure", []int{2, 1}},
+
+	OpGetGlobal:  {"OpGetGlobal", []int{2}},
+	OpSetGlobal:  {"OpSetGlobal", []int{2}},
+	OpGetLocal:   {"OpGetLocal", []int{1}},
+	OpSetLocal:   {"OpSetLocal", []int{1}},
+	OpMakeCell:   {"OpMakeCell", []int{1}},
+	OpGetCell:    {"OpGetCell", []int{1}},
+	OpSetCell:    {"OpSetCell", []int{1}},
+	OpLoadCell:   {"OpLoadCell", []int{1}},
+	OpGetFree:    {"OpGetFree", []int{1}},
+	OpLoadFree:   {"OpLoadFree", []int{1}},
+	OpGetBuiltin: {"OpGetBuiltin", []int{1}},
+
+	OpDefer: {"OpDefer", []int{}},
+	OpQuote: {"OpQuote", []int{2, 1}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+// オペコードとオペランドから命令を組み立てる。未定義のオペコードの場合は空の命令を返す
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// Makeの逆。オペランドを読み取って、読んだバイト数と一緒に返す
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+
+		offset += width
+	}
+
+	return operands, offset
+}
+
+// VMの実行中はDefinitionを引かずに直接読む
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 { return uint8(ins[0]) }
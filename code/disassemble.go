@@ -0,0 +1,109 @@
+package code
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 定数プールの値。object.Objectが満たす
+type Constant interface {
+	Inspect() string
+}
+
+// 命令を持つ定数。object.CompiledFunctionが満たす
+type Function interface {
+	Constant
+	Code() (Instructions, PositionTable)
+	Signature() string // fn(a, b)のような見出し
+}
+
+// コンパイルしたプログラムを人が読める形で書き出す
+// トップレベルの命令の後に、定数プールの関数の命令を続ける。定数を参照する命令には、その値を添える
+// 各命令の前には行番号を、前の命令と同じ行なら|を置く
+func Disassemble(w io.Writer, ins Instructions, positions PositionTable, constants []Constant) error {
+	d := disassembler{w: w, constants: constants}
+
+	d.section("main", ins, positions)
+	for i, c := range constants {
+		if fn, ok := c.(Function); ok {
+			fnIns, fnPositions := fn.Code()
+			d.section(fmt.Sprintf("function #%d %s", i, fn.Signature()), fnIns, fnPositions)
+		}
+	}
+
+	return d.err
+}
+
+type disassembler struct {
+	w         io.Writer
+	constants []Constant
+	err       error
+}
+
+func (d *disassembler) printf(format string, a ...interface{}) {
+	if d.err != nil {
+		return
+	}
+	_, d.err = fmt.Fprintf(d.w, format, a...)
+}
+
+func (d *disassembler) section(title string, ins Instructions, positions PositionTable) {
+	d.printf("== %s ==\n", title)
+
+	prevLine := -1
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			d.printf("%04d ERROR: %s\n", i, err)
+			// 続きは命令の区切りがわからないので読まない
+			break
+		}
+		operands, read := ReadOperands(def, ins[i+1:])
+
+		lineCol := "   |"
+		if line := positions.Line(i); line != prevLine {
+			lineCol = "   -"
+			if line > 0 {
+				lineCol = fmt.Sprintf("%4d", line)
+			}
+			prevLine = line
+		}
+
+		text := ins.fmtInstruction(def, operands)
+		if comment := d.constantComment(Opcode(ins[i]), operands); comment != "" {
+			text = fmt.Sprintf("%-24s ; %s", text, comment)
+		}
+		d.printf("%04d %s %s\n", i, lineCol, text)
+
+		i += 1 + read
+	}
+}
+
+// 定数を参照する命令に添える説明
+func (d *disassembler) constantComment(op Opcode, operands []int) string {
+	if op != OpConstant && op != OpClosure {
+		return ""
+	}
+
+	index := operands[0]
+	if index >= len(d.constants) {
+		return "<invalid constant>"
+	}
+	if _, ok := d.constants[index].(Function); ok {
+		return fmt.Sprintf("function #%d", index)
+	}
+	return oneLine(d.constants[index].Inspect())
+}
+
+// 長い値や複数行の値を1行に収める
+func oneLine(s string) string {
+	const maxWidth = 40
+
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if runes := []rune(s); len(runes) > maxWidth {
+		s = string(runes[:maxWidth-3]) + "..."
+	}
+	return s
+}
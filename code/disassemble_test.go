@@ -0,0 +1,112 @@
+package code
+
+import (
+	"bytes"
+	"monkey/token"
+	"testing"
+)
+
+type testConstant string
+
+func (c testConstant) Inspect() string { return string(c) }
+
+type testFunction struct {
+	ins       Instructions
+	positions PositionTable
+}
+
+func (f testFunction) Inspect() string                     { return "fn" }
+func (f testFunction) Code() (Instructions, PositionTable) { return f.ins, f.positions }
+func (f testFunction) Signature() string                   { return "fn(x)" }
+
+func TestPositionTable(t *testing.T) {
+	pos := func(line, column int) token.Position { return token.Position{Line: line, Column: column} }
+
+	var positions PositionTable
+	positions = positions.Add(0, pos(1, 1))
+	positions = positions.Add(3, pos(1, 1)) // 同じ位置は記録しない
+	positions = positions.Add(6, pos(1, 5))
+	positions = positions.Add(9, token.Position{}) // 位置の分からない命令は前の位置のまま
+	positions = positions.Add(12, pos(2, 1))
+
+	expected := PositionTable{{0, pos(1, 1)}, {6, pos(1, 5)}, {12, pos(2, 1)}}
+	if len(positions) != len(expected) {
+		t.Fatalf("wrong table. got=%v", positions)
+	}
+	for i, want := range expected {
+		if positions[i] != want {
+			t.Errorf("wrong entry %d. want=%v, got=%v", i, want, positions[i])
+		}
+	}
+
+	for offset, want := range map[int]token.Position{0: pos(1, 1), 5: pos(1, 1), 6: pos(1, 5), 11: pos(1, 5), 12: pos(2, 1), 100: pos(2, 1)} {
+		if got := positions.Pos(offset); got != want {
+			t.Errorf("wrong position for offset %d. want=%s, got=%s", offset, want, got)
+		}
+	}
+	if got := positions.Line(7); got != 1 {
+		t.Errorf("wrong line for offset 7. want=1, got=%d", got)
+	}
+	if got := (PositionTable{{4, pos(3, 1)}}).Pos(0); got.IsValid() {
+		t.Errorf("expected an invalid position before the first entry. got=%s", got)
+	}
+
+	if truncated := positions.Truncate(6); len(truncated) != 1 || truncated[0] != (Position{0, pos(1, 1)}) {
+		t.Errorf("wrong truncated table. got=%v", truncated)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	fnIns := concat(Make(OpGetLocal, 0), Make(OpReturnValue))
+	fn := testFunction{ins: fnIns, positions: PositionTable{{0, token.Position{Line: 2, Column: 1}}}}
+
+	ins := concat(
+		Make(OpConstant, 0),
+		Make(OpConstant, 1),
+		Make(OpAdd),
+		Make(OpClosure, 2, 0),
+		Make(OpPop),
+	)
+	positions := PositionTable{{0, token.Position{Line: 1, Column: 1}}, {6, token.Position{Line: 1, Column: 3}}, {7, token.Position{Line: 3, Column: 1}}}
+	constants := []Constant{testConstant("1"), testConstant("multi\nline"), fn}
+
+	var out bytes.Buffer
+	if err := Disassemble(&out, ins, positions, constants); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := `== main ==
+0000    1 OpConstant 0             ; 1
+0003    | OpConstant 1             ; multi\nline
+0006    | OpAdd
+0007    3 OpClosure 2 0            ; function #2
+0011    | OpPop
+== function #2 fn(x) ==
+0000    2 OpGetLocal 0
+0002    | OpReturnValue
+`
+	if out.String() != expected {
+		t.Errorf("wrong output.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestDisassembleWithoutLines(t *testing.T) {
+	var out bytes.Buffer
+	Disassemble(&out, concat(Make(OpTrue), Make(OpPop)), nil, nil)
+
+	expected := `== main ==
+0000    - OpTrue
+0001    | OpPop
+`
+	if out.String() != expected {
+		t.Errorf("wrong output.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func concat(ins ...[]byte) Instructions {
+	out := Instructions{}
+	for _, i := range ins {
+		out = append(out, i...)
+	}
+	return out
+}
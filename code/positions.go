@@ -0,0 +1,55 @@
+package code
+
+import (
+	"monkey/token"
+	"sort"
+)
+
+// 命令の位置とソースの位置の対応。Offsetの命令から次の要素の手前の命令までがPosから作られた
+type Position struct {
+	Offset int
+	Pos    token.Position
+}
+
+// 命令ごとのソースの位置。位置が変わる命令だけを、Offsetの順に並べる
+// 実行時のエラーやスタックトレースに、元のソースの行と列を示すのに使う
+type PositionTable []Position
+
+// offsetの命令が作られた位置を返す。分からない場合は無効な位置
+func (t PositionTable) Pos(offset int) token.Position {
+	// offsetより後ろから始まる最初の要素の1つ前が、offsetを含む要素
+	i := sort.Search(len(t), func(i int) bool { return t[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}
+	}
+	return t[i-1].Pos
+}
+
+// offsetの命令が作られた行を返す。分からない場合は0
+func (t PositionTable) Line(offset int) int {
+	return t.Pos(offset).Line
+}
+
+// offsetから始まる命令の位置を記録する。直前の命令と同じ位置なら何もしない
+func (t PositionTable) Add(offset int, pos token.Position) PositionTable {
+	if !pos.IsValid() {
+		return t
+	}
+	if len(t) > 0 {
+		last := t[len(t)-1]
+		if last.Pos == pos {
+			return t
+		}
+		if last.Offset == offset {
+			t[len(t)-1].Pos = pos
+			return t
+		}
+	}
+	return append(t, Position{Offset: offset, Pos: pos})
+}
+
+// offset以降の命令の記録を取り除く。命令を切り詰めたときに使う
+func (t PositionTable) Truncate(offset int) PositionTable {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Offset >= offset })
+	return t[:i]
+}
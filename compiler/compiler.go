@@ -0,0 +1,703 @@
+// ASTをバイトコードに変換する。命令の列と、命令から番号で参照する定数プールを作る
+
+package compiler
+
+import (
+	"fmt"
+	"math"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/evaluator"
+	"monkey/object"
+	"monkey/token"
+)
+
+// コンパイルの失敗。対応していない構文や、命令に収まらない大きさのプログラムで起きる
+type Error struct {
+	Pos     token.Position
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+func newError(at ast.Node, format string, a ...interface{}) *Error {
+	return &Error{Pos: at.Pos(), Message: fmt.Sprintf(format, a...)}
+}
+
+// 直前に出力した命令。ブロックの最後のOpPopを取り除くときに使う
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// 関数ごとの命令の出力先
+type CompilationScope struct {
+	instructions        code.Instructions
+	positions           code.PositionTable
+	callNames           map[int]string // 識別子で関数を呼び出す命令の位置と、その名前
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+}
+
+type Compiler struct {
+	constants     []object.Object
+	constantIndex map[constantKey]int // 定数プールに追加済みの値と、その番号
+
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	pos token.Position // コンパイル中のノードの位置。出力する命令に記録する
+}
+
+// コンパイルの結果。VMに渡す
+type Bytecode struct {
+	Instructions code.Instructions
+	Positions    code.PositionTable // トップレベルの命令とソースの位置の対応
+	CallNames    map[int]string     // トップレベルで識別子で関数を呼び出す命令の位置と、その名前
+	Constants    []object.Object
+	GlobalNames  []string // グローバル変数の名前。番号の順に並ぶ
+}
+
+func New() *Compiler {
+	return &Compiler{
+		constants:     []object.Object{},
+		constantIndex: make(map[constantKey]int),
+		symbolTable:   NewGlobalSymbolTable(),
+		scopes:        []CompilationScope{{instructions: code.Instructions{}}},
+	}
+}
+
+// 前回のコンパイルで定義したグローバル変数と定数を引き継ぐ。REPLで入力ごとにコンパイルするときに使う
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	for i, obj := range constants {
+		if key, ok := keyOf(obj); ok {
+			if _, exists := compiler.constantIndex[key]; !exists {
+				compiler.constantIndex[key] = i
+			}
+		}
+	}
+	return compiler
+}
+
+// グローバル変数の表。NewWithStateで次のコンパイラに渡す
+func (c *Compiler) SymbolTable() *SymbolTable {
+	return c.symbolTable
+}
+
+func (c *Compiler) Compile(node ast.Node) error {
+	// 子のノードをコンパイルし終えたら、このノードの位置に戻す
+	if pos := nodePos(node); pos.IsValid() && pos != c.pos {
+		defer func(prev token.Position) { c.pos = prev }(c.pos)
+		c.pos = pos
+	}
+
+	switch node := node.(type) {
+
+	// 文
+	case *ast.Program:
+		// 関数から後で定義するグローバル変数を参照できるように、先にすべてのletの名前を定義しておく
+		for _, name := range letNames(node) {
+			c.symbolTable.Define(name)
+		}
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+		// 最後の式文の値はプログラムの結果としてスタックに残す
+		if len(node.Statements) > 0 && c.lastInstructionIs(code.OpPop) {
+			if _, ok := node.Statements[len(node.Statements)-1].(*ast.ExpressionStatement); ok {
+				c.removeLastPop()
+			}
+		}
+		return c.checkLimits(node)
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+	case *ast.BlockStatement:
+		return c.compileBlock(node)
+	case *ast.ReturnStatement:
+		// 値を省略したreturnはnullを返す
+		if node.ReturnValue == nil {
+			c.emit(code.OpReturn)
+			return nil
+		}
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+	case *ast.LetStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.storeSymbol(c.symbolTable.Define(node.Name.Value))
+	case *ast.DeferStatement:
+		return c.compileDefer(node)
+
+	// 式
+	case *ast.IntegerLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+	case *ast.BytesLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Bytes{Value: []byte(node.Value)}))
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return newError(node, "unknown operator %s", node.Operator)
+		}
+	case *ast.InfixExpression:
+		return c.compileInfix(node)
+	case *ast.IfExpression:
+		return c.compileIf(node)
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+	case *ast.HashLiteral:
+		// 評価器と同じく、ソース上の順にキーと値を評価する
+		keys := node.Keys()
+		for _, k := range keys {
+			if err := c.Compile(k); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Pairs[k]); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(keys)*2)
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+	case *ast.FunctionLiteral:
+		return c.compileFunction(node)
+	case *ast.CallExpression:
+		return c.compileCall(node)
+
+	case *ast.Identifier:
+		c.loadSymbol(c.resolve(node.Value))
+	default:
+		return newError(node, "%s is not supported by the compiler", describe(node))
+	}
+
+	return nil
+}
+
+// ノードから作る命令の位置。実行時のエラーが評価器と同じ位置を指すようにする
+// 中置演算子は、評価器と同じく左端ではなく演算子の位置にする。左に長く続く式でPosを辿ると時間がかかることもない
+func nodePos(node ast.Node) token.Position {
+	switch node := node.(type) {
+	case *ast.InfixExpression:
+		return node.Token.Pos()
+	case *ast.Program:
+		return token.Position{}
+	default:
+		return node.Pos()
+	}
+}
+
+// 対応していないノードをエラーメッセージで示す
+func describe(node ast.Node) string {
+	switch node.(type) {
+	case *ast.MacroLiteral:
+		return "macro"
+	default:
+		return fmt.Sprintf("%T", node)
+	}
+}
+
+func (c *Compiler) compileInfix(node *ast.InfixExpression) error {
+	// 左から順に評価する。< を > に入れ替えるとオペランドの評価順が変わってしまうので、それぞれ命令を持つ
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+
+	if op, ok := intOperators[node.Operator]; ok && c.isInteger(node.Left) && c.isInteger(node.Right) {
+		c.emit(op)
+		return nil
+	}
+
+	switch node.Operator {
+	case "+":
+		c.emit(code.OpAdd)
+	case "-":
+		c.emit(code.OpSub)
+	case "*":
+		c.emit(code.OpMul)
+	case "/":
+		c.emit(code.OpDiv)
+	case "==":
+		c.emit(code.OpEqual)
+	case "!=":
+		c.emit(code.OpNotEqual)
+	case ">":
+		c.emit(code.OpGreaterThan)
+	case "<":
+		c.emit(code.OpLessThan)
+	case "..":
+		c.emit(code.OpRange)
+	default:
+		return newError(node, "unknown operator %s", node.Operator)
+	}
+	return nil
+}
+
+// 整数に特化した命令がある演算子
+var intOperators = map[string]code.Opcode{
+	"+":  code.OpAddInt,
+	"-":  code.OpSubInt,
+	"*":  code.OpMulInt,
+	"==": code.OpEqualInt,
+	"!=": code.OpNotEqualInt,
+	">":  code.OpGreaterThanInt,
+	"<":  code.OpLessThanInt,
+}
+
+// 式の値が必ず整数になるか判定する。整数のリテラルとその符号を反転した式、組み込み関数lenの呼び出しが当たる
+// 整数どうしの演算は桁あふれでBigIntになることがあるので含めない
+func (c *Compiler) isInteger(node ast.Expression) bool {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return true
+	case *ast.PrefixExpression:
+		_, ok := node.Right.(*ast.IntegerLiteral)
+		return node.Operator == "-" && ok
+	case *ast.CallExpression:
+		// 同じ名前の変数がなければ組み込み関数を呼ぶ。lenは整数かエラーを返す
+		ident, ok := node.Function.(*ast.Identifier)
+		if !ok || ident.Value != "len" {
+			return false
+		}
+		sym, ok := c.symbolTable.Resolve(ident.Value)
+		return ok && sym.Scope == BuiltinScope
+	}
+	return false
+}
+
+func (c *Compiler) compileIf(node *ast.IfExpression) error {
+	// 条件が定数なら、選ばれる分岐だけをコンパイルする
+	if truthy, ok := constantTruthiness(node.Condition); ok {
+		switch {
+		case truthy:
+			return c.Compile(node.Consequence)
+		case node.Alternative != nil:
+			return c.Compile(node.Alternative)
+		default:
+			c.emit(code.OpNull)
+			return nil
+		}
+	}
+
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+
+	// 飛び先は後で書き換える
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.Compile(node.Consequence); err != nil {
+		return err
+	}
+
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	// elseがない場合、条件が偽ならnullになる
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else {
+		if err := c.Compile(node.Alternative); err != nil {
+			return err
+		}
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// ブロックは最後の式文の値をスタックに残す。値を残す文がなければnullを積む
+func (c *Compiler) compileBlock(block *ast.BlockStatement) error {
+	for _, s := range block.Statements {
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+	}
+
+	if len(block.Statements) == 0 {
+		c.emit(code.OpNull)
+		return nil
+	}
+	switch {
+	case endsWithReturn(block):
+		// 関数を抜けるので、ブロックの値は使われない
+	case c.lastInstructionIs(code.OpPop):
+		c.removeLastPop()
+	default:
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+// 名前を解決する。どこにも定義がない名前は、後で定義されるかもしれないグローバル変数として扱う
+// 評価器も名前を使う時点で探すので、まだ定義されていなければ実行時にエラーになる
+func (c *Compiler) resolve(name string) Symbol {
+	if sym, ok := c.symbolTable.Resolve(name); ok {
+		return sym
+	}
+	c.symbolTable.global().Define(name)
+	sym, _ := c.symbolTable.Resolve(name)
+	return sym
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case CellScope:
+		c.emit(code.OpGetCell, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	}
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case CellScope:
+		c.emit(code.OpSetCell, s.Index)
+	}
+}
+
+// 関数の自由変数にするため、変数のセルを積む
+func (c *Compiler) loadCell(s Symbol) {
+	switch s.Scope {
+	case CellScope:
+		c.emit(code.OpLoadCell, s.Index)
+	case FreeScope:
+		c.emit(code.OpLoadFree, s.Index)
+	}
+}
+
+func (c *Compiler) compileFunction(node *ast.FunctionLiteral) error {
+	if len(node.Parameters) > math.MaxUint8 {
+		return newError(node, "too many parameters (max %d)", math.MaxUint8)
+	}
+
+	c.enterScope()
+
+	// 仮引数とletで定義する名前に、先にスロットを割り当てる
+	// 入れ子の関数から参照される変数は、後で束縛し直しても関数から見えるようにセルに入れる
+	captured := nestedNames(node.Body)
+	cells := []Symbol{}
+	for _, p := range node.Parameters {
+		if captured[p.Value] {
+			cells = append(cells, c.symbolTable.DefineCell(p.Value))
+		} else {
+			c.symbolTable.Define(p.Value)
+		}
+	}
+	for _, name := range letNames(node.Body) {
+		// 仮引数と同じ名前のletは、仮引数の変数に代入する
+		if _, ok := c.symbolTable.store[name]; ok {
+			continue
+		}
+		scope := LocalScope
+		if captured[name] {
+			scope = CellScope
+		}
+		if sym := c.symbolTable.Hoist(name, scope); sym.Scope == CellScope {
+			cells = append(cells, sym)
+		}
+	}
+	if c.symbolTable.NumDefinitions() > math.MaxUint8+1 {
+		c.leaveScope()
+		return newError(node, "too many local variables (max %d)", math.MaxUint8+1)
+	}
+	for _, sym := range cells {
+		c.emit(code.OpMakeCell, sym.Index)
+	}
+
+	if err := c.Compile(node.Body); err != nil {
+		c.leaveScope()
+		return err
+	}
+	// ブロックの値を関数の戻り値にする
+	// 最後の命令ではなく文で判断する。if式の最後の分岐がreturnで終わっていても、他の分岐はここに来る
+	if !endsWithReturn(node.Body) {
+		c.emit(code.OpReturnValue)
+	}
+	if err := c.checkSize(node, "function"); err != nil {
+		c.leaveScope()
+		return err
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.NumDefinitions()
+	localNames := c.symbolTable.Names()
+	scope := eliminateDeadCode(c.leaveScope())
+	markTailCalls(scope.instructions)
+
+	if len(freeSymbols) > math.MaxUint8 {
+		return newError(node, "too many free variables (max %d)", math.MaxUint8)
+	}
+	freeNames := make([]string, len(freeSymbols))
+	for i, s := range freeSymbols {
+		c.loadCell(s)
+		freeNames[i] = s.Name
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:  scope.instructions,
+		Positions:     scope.positions,
+		CallNames:     scope.callNames,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+		LocalNames:    localNames,
+		FreeNames:     freeNames,
+		Parameters:    node.Parameters,
+		Body:          node.Body,
+	}
+	c.emit(code.OpClosure, c.addConstant(compiledFn), len(freeSymbols))
+	return nil
+}
+
+// deferの式を引数のない関数にして、実行中の関数から戻るときに呼び出すよう積む
+// 評価器と同じく、式は戻るときの変数の値で評価される
+func (c *Compiler) compileDefer(node *ast.DeferStatement) error {
+	if err := c.compileFunction(thunk(node.Token, node.Expression)); err != nil {
+		return err
+	}
+	c.emit(code.OpDefer)
+	return nil
+}
+
+// quoteの引数はASTのまま定数にする。unquoteとunquote_spliceの引数は、評価器が評価する順に
+// 引数のない関数にして積んでおき、実行時にquoteを作るときに呼び出す
+func (c *Compiler) compileQuote(node *ast.CallExpression) error {
+	if len(node.Arguments) != 1 {
+		return newError(node, "quote takes exactly one argument")
+	}
+	exps, ok := evaluator.UnquotedExpressions(node.Arguments[0])
+	if !ok {
+		return newError(node, "nested unquote is not supported by the compiler")
+	}
+	if len(exps) > math.MaxUint8 {
+		return newError(node, "too many unquotes (max %d)", math.MaxUint8)
+	}
+
+	for _, exp := range exps {
+		if err := c.compileFunction(thunk(node.Token, exp)); err != nil {
+			return err
+		}
+	}
+	c.emit(code.OpQuote, c.addConstant(&object.Quote{Node: node.Arguments[0]}), len(exps))
+	return nil
+}
+
+// 式を評価して返す、引数のない関数を作る。後で呼び出すために式をコンパイルするときに使う
+func thunk(tok token.Token, exp ast.Expression) *ast.FunctionLiteral {
+	return &ast.FunctionLiteral{
+		Token: tok,
+		Body: &ast.BlockStatement{
+			Token:      tok,
+			Statements: []ast.Statement{&ast.ExpressionStatement{Token: tok, Expression: exp}},
+		},
+	}
+}
+
+func endsWithReturn(block *ast.BlockStatement) bool {
+	if len(block.Statements) == 0 {
+		return false
+	}
+	_, ok := block.Statements[len(block.Statements)-1].(*ast.ReturnStatement)
+	return ok
+}
+
+func (c *Compiler) compileCall(node *ast.CallExpression) error {
+	// 評価器と同じく、quoteは名前を束縛し直していても引数を評価しない
+	if evaluator.IsQuoteCall(node) {
+		return c.compileQuote(node)
+	}
+	if len(node.Arguments) > math.MaxUint8 {
+		return newError(node, "too many arguments (max %d)", math.MaxUint8)
+	}
+
+	if err := c.Compile(node.Function); err != nil {
+		return err
+	}
+	for _, a := range node.Arguments {
+		if err := c.Compile(a); err != nil {
+			return err
+		}
+	}
+
+	pos := c.emit(code.OpCall, len(node.Arguments))
+	// スタックトレースには、評価器と同じく呼び出しに使った識別子を表示する
+	if ident, ok := node.Function.(*ast.Identifier); ok {
+		scope := &c.scopes[c.scopeIndex]
+		if scope.callNames == nil {
+			scope.callNames = make(map[int]string)
+		}
+		scope.callNames[pos] = ident.Value
+	}
+	return nil
+}
+
+// オペランドは2バイトなので、定数の数と命令の長さはその範囲に収まらないといけない
+func (c *Compiler) checkLimits(program *ast.Program) error {
+	if c.symbolTable.NumDefinitions() > math.MaxUint16+1 {
+		return newError(program, "too many global variables (max %d)", math.MaxUint16+1)
+	}
+	if len(c.constants) > math.MaxUint16+1 {
+		return newError(program, "too many constants (max %d)", math.MaxUint16+1)
+	}
+	return c.checkSize(program, "program")
+}
+
+// 出力中の命令の長さを確かめる。ジャンプ命令のオペランドは2バイトなので、それより後ろの位置には飛べない
+// 命令はスコープごとに位置を数えるので、関数の本体もそれぞれ確かめる
+func (c *Compiler) checkSize(node ast.Node, what string) error {
+	if len(c.currentInstructions()) > math.MaxUint16 {
+		return newError(node, "%s too large (max %d bytes of instructions)", what, math.MaxUint16)
+	}
+	return nil
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	// 続けてコンパイルできるように、出力中の命令は書き換えずに取り除いたものを返す
+	main := eliminateDeadCode(c.scopes[c.scopeIndex])
+	return &Bytecode{
+		Instructions: main.instructions,
+		Positions:    main.positions,
+		CallNames:    main.callNames,
+		Constants:    c.constants,
+		GlobalNames:  c.symbolTable.global().Names(),
+	}
+}
+
+// 定数プールに追加して、その番号を返す。同じ値が既にあれば、その番号を返す
+func (c *Compiler) addConstant(obj object.Object) int {
+	key, ok := keyOf(obj)
+	if ok {
+		if i, exists := c.constantIndex[key]; exists {
+			return i
+		}
+	}
+
+	c.constants = append(c.constants, obj)
+	if ok {
+		c.constantIndex[key] = len(c.constants) - 1
+	}
+	return len(c.constants) - 1
+}
+
+// 命令を出力して、その位置を返す
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	c.setLastInstruction(op, pos)
+
+	return pos
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	scope := &c.scopes[c.scopeIndex]
+	scope.instructions = append(scope.instructions, ins...)
+	scope.positions = scope.positions.Add(posNewInstruction, c.pos)
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	c.scopes[c.scopeIndex].instructions = c.currentInstructions()[:last.Position]
+	c.scopes[c.scopeIndex].positions = c.scopes[c.scopeIndex].positions.Truncate(last.Position)
+	c.scopes[c.scopeIndex].lastInstruction = previous
+}
+
+// 出力済みの命令のオペランドを書き換える。オペランドの幅は変わらないので、同じ長さの命令で置き換える
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	newInstruction := code.Make(op, operand)
+
+	copy(c.currentInstructions()[opPos:], newInstruction)
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, CompilationScope{instructions: code.Instructions{}})
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+// 関数のスコープを抜けて、出力した命令などを返す
+func (c *Compiler) leaveScope() CompilationScope {
+	scope := c.scopes[c.scopeIndex]
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+
+	return scope
+}
@@ -0,0 +1,1014 @@
+package compiler
+
+import (
+	"monkey/ast"
+	"monkey/code"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type compilerTestCase struct {
+	input                string
+	expectedConstants    []interface{}
+	expectedInstructions []code.Instructions
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAddInt),
+			},
+		},
+		{
+			input:             "1; 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+			},
+		},
+		{
+			input:             "2 / 1 - 3 * 4",
+			expectedConstants: []interface{}{2, 1, 3, 4},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpMulInt),
+				code.Make(code.OpSub),
+			},
+		},
+		{
+			input:             "-1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+			},
+		},
+		{
+			input:             "1..3",
+			expectedConstants: []interface{}{1, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpRange),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "true",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+			},
+		},
+		{
+			// オペランドの順番を入れ替えずに、左から評価する
+			input:             "1 < 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessThanInt),
+			},
+		},
+		{
+			input:             "true != !false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpFalse),
+				code.Make(code.OpBang),
+				code.Make(code.OpNotEqual),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestIntegerOperations(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// どちらのオペランドも必ず整数になる場合だけ、整数に特化した命令にする
+			input: "fn(a) { [len(a) < -1, 1 + 2] }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpGetBuiltin, builtinIndex("len")),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpMinus),
+					code.Make(code.OpLessThanInt),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAddInt),
+					code.Make(code.OpArray, 2),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+			},
+		},
+		{
+			// 片方が整数のリテラルでも、もう片方が整数とわからない演算は汎用の命令のまま
+			// 整数どうしの演算の結果も、桁あふれでBigIntになることがあるので整数とはみなさない
+			input: "fn(a, b) { [a + b, a - 1, a / 2, a..3, (1 * 2) == 2] }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				3,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpDiv),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpRange),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpMulInt),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpEqual),
+					code.Make(code.OpArray, 5),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+			},
+		},
+		{
+			// lenという名前の変数は組み込み関数とは限らない
+			input: "fn(len) { len(1) + 1 }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (c) { 10 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpGetGlobal, 0),
+				// 0003
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0006
+				code.Make(code.OpConstant, 0),
+				// 0009
+				code.Make(code.OpJump, 13),
+				// 0012
+				code.Make(code.OpNull),
+				// 0013
+				code.Make(code.OpPop),
+				// 0014
+				code.Make(code.OpConstant, 1),
+			},
+		},
+		{
+			input:             "if (c) { 10 } else { 20 }",
+			expectedConstants: []interface{}{10, 20},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpGetGlobal, 0),
+				// 0003
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0006
+				code.Make(code.OpConstant, 0),
+				// 0009
+				code.Make(code.OpJump, 15),
+				// 0012
+				code.Make(code.OpConstant, 1),
+			},
+		},
+		{
+			// 空のブロックはnullになる
+			input:             "if (c) { }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpGetGlobal, 0),
+				// 0003
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0006
+				code.Make(code.OpNull),
+				// 0007
+				code.Make(code.OpJump, 11),
+				// 0010
+				code.Make(code.OpNull),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestCollections(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `["a", b"b"]`,
+			expectedConstants: []interface{}{"a", []byte("b")},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 2),
+			},
+		},
+		{
+			// キーと値はソース上の順に並ぶ
+			input:             "{3: 4, 1: 2}",
+			expectedConstants: []interface{}{3, 4, 1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpHash, 4),
+			},
+		},
+		{
+			input:             "[1][0]",
+			expectedConstants: []interface{}{1, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpIndex),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn() { return 5 + 10 }",
+			expectedConstants: []interface{}{
+				5,
+				10,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAddInt),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+			},
+		},
+		{
+			// 最後の式の値を返す
+			input: "fn() { 1; 2 }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+			},
+		},
+		{
+			input: "fn() { }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpNull),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+			},
+		},
+		{
+			// 片方の分岐がreturnで終わっていても、もう片方の値を返す
+			input: "fn(c) { if (c) { 1 } else { return 2 } }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpJumpNotTruthy, 11),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpJump, 15),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpReturnValue),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+			},
+		},
+		{
+			input: "fn() { return }()",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpCall, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLetStatementScopes(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let one = 1; let two = one; two",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+			},
+		},
+		{
+			// 後で定義するグローバル変数も参照できる
+			input: "let f = fn() { g }; let g = 1;",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+		{
+			input: "fn(a) { let b = a; b }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// 内側の関数から参照される仮引数はセルに入れる
+			input: "fn(a) { fn(b) { a + b } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpMakeCell, 0),
+					code.Make(code.OpLoadCell, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+			},
+		},
+		{
+			// 自由変数はさらに内側の関数へ受け渡す
+			input: "fn(a) { fn() { fn() { a } } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpLoadFree, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpMakeCell, 0),
+					code.Make(code.OpLoadCell, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+			},
+		},
+		{
+			// 再帰する関数は、自分自身が入るセルを捕まえる
+			input: "fn() { let f = fn() { f() }; f }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpTailCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpMakeCell, 0),
+					code.Make(code.OpLoadCell, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpSetCell, 0),
+					code.Make(code.OpGetCell, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConstantDeduplication(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `1 + 1; "a" + "a"; 1`,
+			expectedConstants: []interface{}{1, "a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpAddInt),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+			},
+		},
+		{
+			// 同じ本体の関数は1つにまとめる
+			input: "[fn() { 1 }, fn() { 1 }]",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpArray, 2),
+			},
+		},
+		{
+			// 命令が同じでも、仮引数の名前が違えば表示が変わるのでまとめない
+			input: "[fn(a) { a }, fn(b) { b }]",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpArray, 2),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	// 前回のコンパイルの定数も使い回す
+	first := New()
+	if err := first.Compile(parse(`1; "a"`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	second := NewWithState(first.SymbolTable(), first.Bytecode().Constants)
+	if err := second.Compile(parse(`"a"; 2; 1`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	if err := testConstants([]interface{}{1, "a", 2}, second.Bytecode().Constants); err != "" {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	input := `1;
+let f = fn() {
+  g(2)
+};
+3 +
+4`
+
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	// 命令の位置とソースの位置。演算は演算子の位置になる
+	expected := map[int]string{
+		0:  "1:1", // OpConstant 1
+		3:  "1:1", // OpPop
+		4:  "2:9", // OpClosure
+		8:  "2:1", // OpSetGlobal
+		11: "5:1", // OpConstant 3
+		14: "6:1", // OpConstant 4
+		17: "5:3", // OpAddInt
+	}
+	for offset, pos := range expected {
+		if got := bytecode.Positions.Pos(offset).String(); got != pos {
+			t.Errorf("wrong position for offset %d. want=%s, got=%s", offset, pos, got)
+		}
+	}
+
+	// 呼び出しは呼び出す式の位置になり、識別子の名前を記録する
+	fn := bytecode.Constants[2].(*object.CompiledFunction)
+	if got := fn.Positions.Pos(0).String(); got != "3:3" {
+		t.Errorf("wrong position in function. want=3:3, got=%s", got)
+	}
+	if got := fn.Positions.Pos(6).String(); got != "3:3" {
+		t.Errorf("wrong position of the call. want=3:3, got=%s", got)
+	}
+	if name := fn.CallNames[6]; name != "g" {
+		t.Errorf("wrong call name. want=g, got=%q", name)
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "len([]); push([], 1)",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, builtinIndex("len")),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetBuiltin, builtinIndex("push")),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall, 2),
+			},
+		},
+		{
+			// 関数の中でも自由変数にはならない
+			input: "fn() { len([]) }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetBuiltin, builtinIndex("len")),
+					code.Make(code.OpArray, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+			},
+		},
+		{
+			// 同じ名前のグローバル変数が優先される
+			input:             "let len = 1; len",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+// 組み込み関数の表での番号
+func builtinIndex(name string) int {
+	return sort.SearchStrings(evaluator.BuiltinNames(), name)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// 結果をそのまま返す呼び出しだけを置き換える
+			input: "fn(f) { f(f() + 1); return f() }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpTailCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+			},
+		},
+		{
+			// if式の分岐の最後の呼び出しは、ジャンプの先で返される
+			input: "fn(c, f) { if (c) { f() } else { f(1) } }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpJumpNotTruthy, 12),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpTailCall, 0),
+					code.Make(code.OpJump, 19),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+			},
+		},
+		{
+			// 結果を使う場合や、トップレベルの呼び出しは置き換えない
+			input: "fn(c, f) { let x = if (c) { f() }; x }; fn() { 1 }()",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpJumpNotTruthy, 12),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpJump, 13),
+					code.Make(code.OpNull),
+					code.Make(code.OpSetLocal, 2),
+					code.Make(code.OpGetLocal, 2),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpCall, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestDeadCodeElimination(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// 条件が定数なら、選ばれる分岐だけが残る
+			input:             "if (true) { 10 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+			},
+		},
+		{
+			input:             "if (!1) { 10 } else { 20 }",
+			expectedConstants: []interface{}{20},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+			},
+		},
+		{
+			input:             "if (false) { 10 }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+			},
+		},
+		{
+			// returnの後ろの命令は取り除く
+			input: "fn() { return 1; 2 }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+			},
+		},
+		{
+			// returnで終わる分岐の後ろのジャンプを取り除き、飛び先を付け直す
+			input: "fn(c) { if (c) { return 1 }; 2 }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpGetLocal, 0),
+					// 0002
+					code.Make(code.OpJumpNotTruthy, 9),
+					// 0005
+					code.Make(code.OpConstant, 0),
+					// 0008
+					code.Make(code.OpReturnValue),
+					// 0009
+					code.Make(code.OpNull),
+					// 0010
+					code.Make(code.OpPop),
+					// 0011
+					code.Make(code.OpConstant, 1),
+					// 0014
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	// 取り除いた分だけ、後ろの命令の位置と呼び出しの名前をずらす
+	compiler := New()
+	if err := compiler.Compile(parse("fn(c, f) { if (c) { return 1 }; f() }")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	fn := compiler.Bytecode().Constants[1].(*object.CompiledFunction)
+	if op := code.Opcode(fn.Instructions[13]); op != code.OpTailCall {
+		t.Fatalf("expected OpTailCall at 13. got=%d", op)
+	}
+	if got := fn.Positions.Pos(13).String(); got != "1:33" {
+		t.Errorf("wrong position of the call. want=1:33, got=%s", got)
+	}
+	if name := fn.CallNames[13]; name != "f" || len(fn.CallNames) != 1 {
+		t.Errorf("wrong call names. got=%v", fn.CallNames)
+	}
+}
+
+func TestDefer(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// deferの式は引数のない関数にする。参照するローカル変数はセルに入れる
+			input: "fn(a) { defer a; a }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpMakeCell, 0),
+					code.Make(code.OpLoadCell, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpDefer),
+					code.Make(code.OpGetCell, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestQuote(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// 引数は定数にして、unquoteの引数を関数にする
+			input: "quote(1 + unquote(2 * 3))",
+			expectedConstants: []interface{}{
+				2,
+				3,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpMulInt),
+					code.Make(code.OpReturnValue),
+				},
+				quoteConstant("QUOTE((1 + unquote((2 * 3))))"),
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpQuote, 3, 1),
+			},
+		},
+		{
+			input: "quote(x)",
+			expectedConstants: []interface{}{
+				quoteConstant("QUOTE(x)"),
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpQuote, 0, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestCompilerErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"macro(x) { x }", "1:1: macro is not supported by the compiler"},
+		{"quote(1, 2)", "1:1: quote takes exactly one argument"},
+		{"quote(unquote(unquote(1)))", "1:1: nested unquote is not supported by the compiler"},
+		// ジャンプ先は2バイトで表すので、命令の長さはプログラムと関数の本体それぞれで制限する
+		{strings.Repeat("1;", 20000), "1:1: program too large (max 65535 bytes of instructions)"},
+		{"let f = fn(a) {" + strings.Repeat("a;", 30000) + "}", "1:9: function too large (max 65535 bytes of instructions)"},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		if err == nil {
+			t.Errorf("expected an error for %q", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
+
+func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		compiler := New()
+		err := compiler.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+
+		if err := testInstructions(tt.expectedInstructions, bytecode.Instructions); err != "" {
+			t.Fatalf("%s: %s", tt.input, err)
+		}
+		if err := testConstants(tt.expectedConstants, bytecode.Constants); err != "" {
+			t.Fatalf("%s: %s", tt.input, err)
+		}
+	}
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func concatInstructions(s []code.Instructions) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+	return out
+}
+
+func testInstructions(expected []code.Instructions, actual code.Instructions) string {
+	concatted := concatInstructions(expected)
+	if actual.String() != concatted.String() {
+		return "wrong instructions.\nwant=\n" + concatted.String() + "got=\n" + actual.String()
+	}
+	return ""
+}
+
+// 定数プールのquote。表示で比べる
+type quoteConstant string
+
+func testConstants(expected []interface{}, actual []object.Object) string {
+	if len(expected) != len(actual) {
+		return "wrong number of constants. got=" + inspectAll(actual)
+	}
+
+	for i, constant := range expected {
+		switch constant := constant.(type) {
+		case int:
+			integer, ok := actual[i].(*object.Integer)
+			if !ok || integer.Value != int64(constant) {
+				return "constant " + actual[i].Inspect() + " is not the expected integer"
+			}
+		case string:
+			str, ok := actual[i].(*object.String)
+			if !ok || str.Value != constant {
+				return "constant " + actual[i].Inspect() + " is not the expected string"
+			}
+		case []byte:
+			b, ok := actual[i].(*object.Bytes)
+			if !ok || string(b.Value) != string(constant) {
+				return "constant " + actual[i].Inspect() + " is not the expected bytes"
+			}
+		case quoteConstant:
+			quote, ok := actual[i].(*object.Quote)
+			if !ok || quote.Inspect() != string(constant) {
+				return "constant " + actual[i].Inspect() + " is not the expected quote"
+			}
+		case []code.Instructions:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				return "constant " + actual[i].Inspect() + " is not a function"
+			}
+			if err := testInstructions(constant, fn.Instructions); err != "" {
+				return "function constant: " + err
+			}
+		}
+	}
+
+	return ""
+}
+
+func inspectAll(objs []object.Object) string {
+	s := make([]string, len(objs))
+	for i, obj := range objs {
+		s[i] = obj.Inspect()
+	}
+	return "[" + strings.Join(s, ", ") + "]"
+}
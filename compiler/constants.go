@@ -0,0 +1,48 @@
+package compiler
+
+import (
+	"monkey/object"
+	"strconv"
+	"strings"
+)
+
+// 定数プールの中で同じ値を探すためのキー
+// 生成されたプログラムは同じリテラルや関数を何度も含むので、1つにまとめて定数プールを小さくする
+type constantKey struct {
+	kind  object.ObjectType
+	value string
+}
+
+// 値が同じなら同じキーを返す。まとめられない値はokが偽になる
+func keyOf(obj object.Object) (key constantKey, ok bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return constantKey{kind: obj.Type(), value: strconv.FormatInt(obj.Value, 10)}, true
+	case *object.String:
+		return constantKey{kind: obj.Type(), value: obj.Value}, true
+	case *object.CompiledFunction:
+		// 命令が同じでも、表示する仮引数や本体が違う関数はまとめない
+		var b strings.Builder
+		b.Write(obj.Instructions)
+		b.WriteByte(0)
+		b.WriteString(strconv.Itoa(obj.NumLocals))
+		b.WriteByte(0)
+		b.WriteString(strconv.Itoa(obj.NumParameters))
+		b.WriteByte(0)
+		b.WriteString(strings.Join(obj.LocalNames, ","))
+		b.WriteByte(0)
+		b.WriteString(strings.Join(obj.FreeNames, ","))
+		b.WriteByte(0)
+		if obj.Body != nil {
+			for _, p := range obj.Parameters {
+				b.WriteString(p.Value)
+				b.WriteByte(',')
+			}
+			b.WriteByte(0)
+			b.WriteString(obj.Body.String())
+		}
+		return constantKey{kind: obj.Type(), value: b.String()}, true
+	default:
+		return constantKey{}, false
+	}
+}
@@ -0,0 +1,117 @@
+package compiler
+
+import (
+	"monkey/ast"
+	"monkey/code"
+)
+
+// 到達できない命令を取り除く
+// 無条件のジャンプやreturnの後ろの命令は、どこかのジャンプの飛び先にならない限り実行されない
+// return 1; 2のように書いたコードや、returnで終わる分岐の後ろのジャンプがこれに当たる
+// 命令の位置が変わるので、ジャンプの飛び先、命令ごとのソースの位置、呼び出しの名前も付け直す
+func eliminateDeadCode(scope CompilationScope) CompilationScope {
+	ins := scope.instructions
+	reachable, ok := reachableInstructions(ins)
+	if !ok {
+		return scope
+	}
+
+	// 古い位置から新しい位置への対応。命令の末尾へのジャンプもあるので、末尾の分も用意する
+	newOffsets := make([]int, len(ins)+1)
+	size := 0
+	for i := 0; i < len(ins); i = nextInstruction(ins, i) {
+		newOffsets[i] = size
+		if reachable[i] {
+			size += nextInstruction(ins, i) - i
+		}
+	}
+	newOffsets[len(ins)] = size
+	if size == len(ins) {
+		return scope
+	}
+
+	result := CompilationScope{instructions: make(code.Instructions, 0, size)}
+	if scope.callNames != nil {
+		result.callNames = map[int]string{}
+	}
+	for i := 0; i < len(ins); i = nextInstruction(ins, i) {
+		if !reachable[i] {
+			continue
+		}
+		offset := newOffsets[i]
+		switch op := code.Opcode(ins[i]); op {
+		case code.OpJump, code.OpJumpNotTruthy:
+			target := int(code.ReadUint16(ins[i+1:]))
+			result.instructions = append(result.instructions, code.Make(op, newOffsets[target])...)
+		default:
+			result.instructions = append(result.instructions, ins[i:nextInstruction(ins, i)]...)
+		}
+		result.positions = result.positions.Add(offset, scope.positions.Pos(i))
+		if name, ok := scope.callNames[i]; ok {
+			result.callNames[offset] = name
+		}
+	}
+	return result
+}
+
+// 先頭から実行を辿って、到達できる命令の位置に印を付ける
+// 知らない命令があれば、命令の区切りが分からないのでokが偽になる
+func reachableInstructions(ins code.Instructions) (reachable []bool, ok bool) {
+	for i := 0; i < len(ins); i = nextInstruction(ins, i) {
+		if _, err := code.Lookup(ins[i]); err != nil {
+			return nil, false
+		}
+	}
+
+	reachable = make([]bool, len(ins))
+	pending := []int{0}
+	for len(pending) > 0 {
+		pos := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		for pos < len(ins) && !reachable[pos] {
+			reachable[pos] = true
+
+			switch code.Opcode(ins[pos]) {
+			case code.OpJump:
+				pos = int(code.ReadUint16(ins[pos+1:]))
+			case code.OpJumpNotTruthy:
+				pending = append(pending, int(code.ReadUint16(ins[pos+1:])))
+				pos = nextInstruction(ins, pos)
+			case code.OpReturnValue, code.OpReturn:
+				pos = len(ins)
+			default:
+				pos = nextInstruction(ins, pos)
+			}
+		}
+	}
+	return reachable, true
+}
+
+// posの命令の次の命令の位置
+func nextInstruction(ins code.Instructions, pos int) int {
+	def, err := code.Lookup(ins[pos])
+	if err != nil {
+		return len(ins)
+	}
+	_, read := code.ReadOperands(def, ins[pos+1:])
+	return pos + 1 + read
+}
+
+// 条件の式がコンパイル時に真偽の決まる定数なら、その真偽を返す
+// リテラルとその否定だけを調べる。if (true) { ... }のように、マクロで作ったコードによく現れる
+func constantTruthiness(node ast.Expression) (truthy bool, ok bool) {
+	switch node := node.(type) {
+	case *ast.Boolean:
+		return node.Value, true
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.BytesLiteral:
+		// 評価器ではnullとfalse以外の値はすべて真になる
+		return true, true
+	case *ast.PrefixExpression:
+		if node.Operator == "!" {
+			truthy, ok := constantTruthiness(node.Right)
+			return !truthy, ok
+		}
+	}
+	return false, false
+}
@@ -0,0 +1,56 @@
+package compiler
+
+import (
+	"monkey/ast"
+	"monkey/evaluator"
+)
+
+// nodeの中でletが束縛する名前を、ソース上の順に重複なく返す。入れ子の関数の中は含めない
+// 評価器ではブロックが新しい環境を作らないので、if式のブロックの中のletも関数全体の変数になる
+func letNames(node ast.Node) []string {
+	seen := make(map[string]bool)
+	names := []string{}
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.CallExpression:
+			// quoteの中のletは実行されない
+			return !evaluator.IsQuoteCall(n)
+		case *ast.LetStatement:
+			if !seen[n.Name.Value] {
+				seen[n.Name.Value] = true
+				names = append(names, n.Name.Value)
+			}
+		}
+		return true
+	})
+	return names
+}
+
+// bodyの中の入れ子の関数に出てくる名前を集める。deferの式とquoteの中のunquoteの引数も関数にして後で呼び出すので含める
+// 入れ子の関数の中で定義し直した名前も含むので、実際に参照されるものより多いことがある
+// 多めに見積もっても、不要なセルができるだけで結果は変わらない
+func nestedNames(body *ast.BlockStatement) map[string]bool {
+	names := make(map[string]bool)
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral, *ast.DeferStatement:
+		case *ast.CallExpression:
+			// quoteの中のunquoteの引数も関数にする
+			if !evaluator.IsQuoteCall(n) {
+				return true
+			}
+		default:
+			return true
+		}
+		ast.Inspect(n, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Identifier); ok {
+				names[ident.Value] = true
+			}
+			return true
+		})
+		return false
+	})
+	return names
+}
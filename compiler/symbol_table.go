@@ -0,0 +1,169 @@
+package compiler
+
+import "monkey/evaluator"
+
+type SymbolScope string
+
+const (
+	GlobalScope  SymbolScope = "GLOBAL"
+	LocalScope   SymbolScope = "LOCAL"
+	CellScope    SymbolScope = "CELL" // 入れ子の関数から参照されるローカル変数。スロットにセルを置く
+	FreeScope    SymbolScope = "FREE" // 外側の関数のローカル変数
+	BuiltinScope SymbolScope = "BUILTIN"
+)
+
+// 名前を解決した結果。Indexはスコープごとの番号
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// 名前と番号の対応。関数ごとに1つ作り、外側の関数の表をOuterで辿る
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store          map[string]Symbol
+	numDefinitions int
+
+	// Hoistでスロットを確保したが、まだletをコンパイルしていない変数
+	// この関数の中からは外側の同じ名前が見え、入れ子の関数からはこの変数が見える
+	pending map[string]Symbol
+
+	// この関数が参照する外側の変数。外側の表で解決した結果を、自由変数の番号の順に並べる
+	FreeSymbols []Symbol
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: make(map[string]Symbol), pending: make(map[string]Symbol)}
+}
+
+// 組み込み関数を定義した、一番外側の表を作る
+// 組み込み関数の番号はevaluator.BuiltinNamesの順で、VMの組み込み関数の表と対応する
+func NewGlobalSymbolTable() *SymbolTable {
+	s := NewSymbolTable()
+	for i, name := range evaluator.BuiltinNames() {
+		s.DefineBuiltin(i, name)
+	}
+	return s
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// 変数を定義する。一番外側の表ではグローバル変数、それ以外ではローカル変数になる
+// 同じ表で定義済みの名前は、同じシンボルを使い回す。評価器のletも同じ環境では上書きになるため
+func (s *SymbolTable) Define(name string) Symbol {
+	if s.Outer == nil {
+		return s.define(name, GlobalScope)
+	}
+	return s.define(name, LocalScope)
+}
+
+// 入れ子の関数から参照されるローカル変数を定義する
+func (s *SymbolTable) DefineCell(name string) Symbol {
+	return s.define(name, CellScope)
+}
+
+// letで定義する変数のスロットを先に確保する。scopeはLocalScopeかCellScope
+// 評価器では関数の環境が呼び出しごとに1つなので、後のletで定義する変数も入れ子の関数から参照できる
+// 一方、let x = x + 1の右辺のようにletより前では外側の変数を参照するので、letをコンパイルするまで名前は定義しない
+func (s *SymbolTable) Hoist(name string, scope SymbolScope) Symbol {
+	if sym, ok := s.pending[name]; ok {
+		return sym
+	}
+	symbol := Symbol{Name: name, Scope: scope, Index: s.numDefinitions}
+	s.pending[name] = symbol
+	s.numDefinitions++
+	return symbol
+}
+
+func (s *SymbolTable) define(name string, scope SymbolScope) Symbol {
+	if sym, ok := s.pending[name]; ok {
+		delete(s.pending, name)
+		s.store[name] = sym
+		return sym
+	}
+	// 先にDefineCellで定義した名前は、letで定義し直してもセルのまま
+	if sym, ok := s.store[name]; ok && sym.Scope != BuiltinScope && sym.Scope != FreeScope {
+		return sym
+	}
+
+	symbol := Symbol{Name: name, Scope: scope, Index: s.numDefinitions}
+	s.store[name] = symbol
+	s.numDefinitions++
+	return symbol
+}
+
+// 組み込み関数を定義する。グローバル変数と同じ名前のものは、グローバル変数が優先される
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	if sym, ok := s.store[name]; ok && sym.Scope == GlobalScope {
+		return sym
+	}
+	symbol := Symbol{Name: name, Scope: BuiltinScope, Index: index}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Scope: FreeScope, Index: len(s.FreeSymbols) - 1}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+// 名前を内側の表から順に探す。外側の関数のローカル変数は、この関数の自由変数になる
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	return s.resolve(name, false)
+}
+
+// innerは入れ子の関数から探している場合。Hoistで確保した変数も見つける
+func (s *SymbolTable) resolve(name string, inner bool) (Symbol, bool) {
+	if sym, ok := s.pending[name]; ok && inner {
+		return sym, true
+	}
+	sym, ok := s.store[name]
+	if ok || s.Outer == nil {
+		return sym, ok
+	}
+
+	sym, ok = s.Outer.resolve(name, true)
+	if !ok {
+		return sym, ok
+	}
+	if sym.Scope == GlobalScope || sym.Scope == BuiltinScope {
+		return sym, ok
+	}
+	return s.defineFree(sym), true
+}
+
+// 定義した変数の数。グローバル変数の数か、関数のローカル変数のスロットの数になる
+func (s *SymbolTable) NumDefinitions() int {
+	return s.numDefinitions
+}
+
+// 番号の順に並べた変数の名前
+func (s *SymbolTable) Names() []string {
+	names := make([]string, s.numDefinitions)
+	for _, sym := range s.store {
+		if sym.Scope == GlobalScope || sym.Scope == LocalScope || sym.Scope == CellScope {
+			names[sym.Index] = sym.Name
+		}
+	}
+	for _, sym := range s.pending {
+		names[sym.Index] = sym.Name
+	}
+	return names
+}
+
+// 一番外側の表
+func (s *SymbolTable) global() *SymbolTable {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s
+}
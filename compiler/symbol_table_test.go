@@ -0,0 +1,137 @@
+package compiler
+
+import "testing"
+
+func TestDefine(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.Define("a")
+	b := global.Define("b")
+	if a != (Symbol{Name: "a", Scope: GlobalScope, Index: 0}) {
+		t.Errorf("wrong symbol for a. got=%+v", a)
+	}
+	if b != (Symbol{Name: "b", Scope: GlobalScope, Index: 1}) {
+		t.Errorf("wrong symbol for b. got=%+v", b)
+	}
+
+	// 同じ名前を定義し直しても番号は変わらない
+	if again := global.Define("a"); again != a {
+		t.Errorf("redefinition changed the symbol. got=%+v", again)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	c := local.Define("c")
+	d := local.DefineCell("d")
+	if c != (Symbol{Name: "c", Scope: LocalScope, Index: 0}) {
+		t.Errorf("wrong symbol for c. got=%+v", c)
+	}
+	if d != (Symbol{Name: "d", Scope: CellScope, Index: 1}) {
+		t.Errorf("wrong symbol for d. got=%+v", d)
+	}
+	if local.NumDefinitions() != 2 {
+		t.Errorf("wrong number of definitions. got=%d", local.NumDefinitions())
+	}
+}
+
+func TestResolve(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.DefineBuiltin(0, "len")
+
+	first := NewEnclosedSymbolTable(global)
+	first.Define("b")
+	first.DefineCell("c")
+
+	second := NewEnclosedSymbolTable(first)
+	second.Define("d")
+
+	third := NewEnclosedSymbolTable(second)
+
+	tests := []struct {
+		table    *SymbolTable
+		name     string
+		expected Symbol
+	}{
+		{second, "a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{second, "len", Symbol{Name: "len", Scope: BuiltinScope, Index: 0}},
+		{second, "d", Symbol{Name: "d", Scope: LocalScope, Index: 0}},
+		{second, "c", Symbol{Name: "c", Scope: FreeScope, Index: 0}},
+		// 外側の関数の自由変数は、さらに内側の関数の自由変数になる
+		{third, "c", Symbol{Name: "c", Scope: FreeScope, Index: 0}},
+		{first, "c", Symbol{Name: "c", Scope: CellScope, Index: 1}},
+	}
+
+	for _, tt := range tests {
+		sym, ok := tt.table.Resolve(tt.name)
+		if !ok {
+			t.Errorf("name %s not resolvable", tt.name)
+			continue
+		}
+		if sym != tt.expected {
+			t.Errorf("wrong symbol for %s. want=%+v, got=%+v", tt.name, tt.expected, sym)
+		}
+	}
+
+	if _, ok := third.Resolve("x"); ok {
+		t.Errorf("undefined name x was resolved")
+	}
+
+	expectedFree := []Symbol{{Name: "c", Scope: CellScope, Index: 1}}
+	if len(second.FreeSymbols) != 1 || second.FreeSymbols[0] != expectedFree[0] {
+		t.Errorf("wrong free symbols. got=%+v", second.FreeSymbols)
+	}
+	if len(third.FreeSymbols) != 1 || third.FreeSymbols[0] != (Symbol{Name: "c", Scope: FreeScope, Index: 0}) {
+		t.Errorf("wrong free symbols. got=%+v", third.FreeSymbols)
+	}
+}
+
+func TestShadowingBuiltin(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+
+	// グローバル変数が組み込み関数より優先される
+	sym := global.Define("len")
+	if sym.Scope != GlobalScope {
+		t.Errorf("global did not shadow the builtin. got=%+v", sym)
+	}
+	if again := global.DefineBuiltin(0, "len"); again != sym {
+		t.Errorf("builtin replaced the global. got=%+v", again)
+	}
+	if names := global.Names(); len(names) != 1 || names[0] != "len" {
+		t.Errorf("wrong names. got=%v", names)
+	}
+}
+
+func TestHoist(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("x")
+
+	local := NewEnclosedSymbolTable(global)
+	hoisted := local.Hoist("x", CellScope)
+	if hoisted != (Symbol{Name: "x", Scope: CellScope, Index: 0}) {
+		t.Errorf("wrong symbol for x. got=%+v", hoisted)
+	}
+	if names := local.Names(); len(names) != 1 || names[0] != "x" {
+		t.Errorf("wrong names. got=%v", names)
+	}
+
+	// letをコンパイルするまでは、この関数の中からは外側の変数が見える
+	if sym, _ := local.Resolve("x"); sym.Scope != GlobalScope {
+		t.Errorf("expected the global x before let. got=%+v", sym)
+	}
+	// 入れ子の関数からは確保した変数が見える
+	inner := NewEnclosedSymbolTable(local)
+	if sym, _ := inner.Resolve("x"); sym != (Symbol{Name: "x", Scope: FreeScope, Index: 0}) || inner.FreeSymbols[0] != hoisted {
+		t.Errorf("expected the hoisted x from the inner function. got=%+v", sym)
+	}
+
+	// letで定義すると、確保したスロットを使う
+	if sym := local.Define("x"); sym != hoisted {
+		t.Errorf("let did not use the hoisted slot. got=%+v", sym)
+	}
+	if sym, _ := local.Resolve("x"); sym != hoisted {
+		t.Errorf("expected the hoisted x after let. got=%+v", sym)
+	}
+	if local.NumDefinitions() != 1 {
+		t.Errorf("wrong number of definitions. got=%d", local.NumDefinitions())
+	}
+}
@@ -0,0 +1,39 @@
+package compiler
+
+import "monkey/code"
+
+// 関数の命令のうち、結果をそのまま返す呼び出しをOpTailCallに書き換える
+// 呼び出しの後に無条件のジャンプだけを挟んでOpReturnValueに着くなら、呼び出しの結果が関数の戻り値になる
+// return f(x)、最後の式文、最後のif式の分岐の呼び出しがこれに当たる
+func markTailCalls(ins code.Instructions) {
+	for i := 0; i < len(ins); {
+		op := code.Opcode(ins[i])
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			return
+		}
+		_, read := code.ReadOperands(def, ins[i+1:])
+		next := i + 1 + read
+
+		if op == code.OpCall && returnsAt(ins, next) {
+			ins[i] = byte(code.OpTailCall)
+		}
+		i = next
+	}
+}
+
+// posから無条件のジャンプを辿って、OpReturnValueに着くか判定する
+func returnsAt(ins code.Instructions, pos int) bool {
+	// ジャンプが輪になっていても止まるように、辿る回数を命令の長さまでにする
+	for n := 0; n < len(ins) && pos < len(ins); n++ {
+		switch code.Opcode(ins[pos]) {
+		case code.OpReturnValue:
+			return true
+		case code.OpJump:
+			pos = int(code.ReadUint16(ins[pos+1:]))
+		default:
+			return false
+		}
+	}
+	return false
+}
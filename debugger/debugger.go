@@ -0,0 +1,369 @@
+// 対話的なデバッガ。評価のフックを使って文の手前で止まり、環境を調べたり1文ずつ進めたりする
+
+package debugger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const PROMPT = "(debug) "
+
+// プロンプトを表示して1行読み込む。REPLの行エディタをそのまま使える
+type LineReader interface {
+	ReadLine(prompt string) (string, error)
+}
+
+// どこで次に止まるか
+type mode int
+
+const (
+	modeContinue mode = iota // ブレークポイントまで止まらない
+	modeStep                 // 次の文で止まる。呼び出した関数の中にも入る
+	modeNext                 // 同じか浅い呼び出しの深さの、次の文で止まる
+)
+
+type Debugger struct {
+	in  LineReader
+	out io.Writer
+
+	breakpoints map[int]bool // ブレークポイントを置いた行
+
+	mode      mode
+	nextDepth int // modeNextで止まる呼び出しの深さ
+	lastLine  int // 直前に評価を始めた文の行。同じ行で何度も止まらないようにする
+
+	calls []*ast.CallExpression // 評価中の関数呼び出し。最後が最も内側
+
+	cancel   context.CancelFunc
+	quitting bool
+
+	// Runで使う評価の設定。Hooksはこのデバッガで上書きする
+	Options evaluator.Options
+}
+
+func New(in LineReader, out io.Writer) *Debugger {
+	return &Debugger{in: in, out: out, breakpoints: make(map[int]bool)}
+}
+
+// ブレークポイントを置く
+func (d *Debugger) SetBreakpoint(line int) {
+	d.breakpoints[line] = true
+}
+
+// ブレークポイントを取り除く
+func (d *Debugger) ClearBreakpoint(line int) {
+	delete(d.breakpoints, line)
+}
+
+// ブレークポイントを置いた行を昇順で返す
+func (d *Debugger) Breakpoints() []int {
+	lines := make([]int, 0, len(d.breakpoints))
+	for line := range d.breakpoints {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// 最初の文で止まった状態からnodeを評価する。quitで中断した場合はnilを返す
+// ブレークポイントは次の実行にも引き継ぐ
+func (d *Debugger) Run(node ast.Node, env *object.Environment) object.Object {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d.cancel = cancel
+	d.quitting = false
+	d.mode = modeStep
+	d.lastLine = 0
+	d.calls = nil
+
+	opts := d.Options
+	opts.Hooks = d
+	result := evaluator.EvalWithOptions(ctx, node, env, opts)
+	if d.quitting {
+		return nil
+	}
+	return result
+}
+
+func (d *Debugger) OnEnterNode(node ast.Node, env *object.Environment) {
+	if d.quitting {
+		return
+	}
+
+	if call, ok := node.(*ast.CallExpression); ok {
+		d.calls = append(d.calls, call)
+		return
+	}
+
+	// 文の手前でだけ止まる。ブロックは中の文で止まる
+	stmt, ok := node.(ast.Statement)
+	if !ok || !stmt.Pos().IsValid() {
+		return
+	}
+	if _, ok := stmt.(*ast.BlockStatement); ok {
+		return
+	}
+
+	line := stmt.Pos().Line
+	stop := false
+	switch {
+	case d.mode == modeStep:
+		stop = true
+	case d.mode == modeNext && env.CallDepth() <= d.nextDepth:
+		stop = true
+	case d.breakpoints[line] && line != d.lastLine:
+		stop = true
+	}
+	d.lastLine = line
+
+	if stop {
+		d.pause(stmt, env)
+	}
+}
+
+func (d *Debugger) OnExitNode(node ast.Node, env *object.Environment, result object.Object) {
+	if _, ok := node.(*ast.CallExpression); ok && len(d.calls) > 0 {
+		d.calls = d.calls[:len(d.calls)-1]
+	}
+}
+
+// 評価を止めて、再開するコマンドが来るまで入力を処理する
+func (d *Debugger) pause(stmt ast.Statement, env *object.Environment) {
+	fmt.Fprintf(d.out, "stopped at %s: %s\n", stmt.Pos(), stmt.String())
+
+	for {
+		line, err := d.in.ReadLine(PROMPT)
+		if err != nil {
+			d.quit()
+			return
+		}
+
+		name, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+		if name == "" {
+			continue
+		}
+
+		cmd := lookupCommand(name)
+		if cmd == nil {
+			fmt.Fprintf(d.out, "unknown command: %s (type help for a list of commands)\n", name)
+			continue
+		}
+
+		if cmd.run(d, strings.TrimSpace(arg), env) {
+			return
+		}
+	}
+}
+
+// 評価を中断する
+func (d *Debugger) quit() {
+	d.quitting = true
+	d.mode = modeContinue
+	d.cancel()
+}
+
+type command struct {
+	names []string // 先頭が正式な名前で、残りは省略形
+	usage string
+	help  string
+	// 真を返すと評価を再開する
+	run func(d *Debugger, arg string, env *object.Environment) bool
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			names: []string{"step", "s"},
+			usage: "step",
+			help:  "run to the next statement, entering function calls",
+			run: func(d *Debugger, arg string, env *object.Environment) bool {
+				d.mode = modeStep
+				return true
+			},
+		},
+		{
+			names: []string{"next", "n"},
+			usage: "next",
+			help:  "run to the next statement, stepping over function calls",
+			run: func(d *Debugger, arg string, env *object.Environment) bool {
+				d.mode = modeNext
+				d.nextDepth = env.CallDepth()
+				return true
+			},
+		},
+		{
+			names: []string{"continue", "c"},
+			usage: "continue",
+			help:  "run until the next breakpoint",
+			run: func(d *Debugger, arg string, env *object.Environment) bool {
+				d.mode = modeContinue
+				return true
+			},
+		},
+		{
+			names: []string{"break", "b"},
+			usage: "break [line]",
+			help:  "set a breakpoint, or list them all",
+			run:   commandBreak,
+		},
+		{
+			names: []string{"delete", "d"},
+			usage: "delete <line>",
+			help:  "remove a breakpoint",
+			run:   commandDelete,
+		},
+		{
+			names: []string{"env", "e"},
+			usage: "env",
+			help:  "list the bindings visible from the current statement, innermost scope first",
+			run:   commandEnv,
+		},
+		{
+			names: []string{"print", "p"},
+			usage: "print <expr>",
+			help:  "evaluate an expression in the current environment",
+			run:   commandPrint,
+		},
+		{
+			names: []string{"where", "w"},
+			usage: "where",
+			help:  "show the function calls being evaluated",
+			run:   commandWhere,
+		},
+		{
+			names: []string{"quit", "q"},
+			usage: "quit",
+			help:  "stop evaluating",
+			run: func(d *Debugger, arg string, env *object.Environment) bool {
+				d.quit()
+				return true
+			},
+		},
+		{
+			names: []string{"help", "h"},
+			usage: "help",
+			help:  "show this help",
+			run:   commandHelp,
+		},
+	}
+}
+
+func lookupCommand(name string) *command {
+	for _, cmd := range commands {
+		for _, n := range cmd.names {
+			if n == name {
+				return cmd
+			}
+		}
+	}
+	return nil
+}
+
+func commandBreak(d *Debugger, arg string, env *object.Environment) bool {
+	if arg == "" {
+		for _, line := range d.Breakpoints() {
+			fmt.Fprintf(d.out, "breakpoint at line %d\n", line)
+		}
+		return false
+	}
+
+	line, err := strconv.Atoi(arg)
+	if err != nil || line < 1 {
+		io.WriteString(d.out, "usage: break [line]\n")
+		return false
+	}
+
+	d.SetBreakpoint(line)
+	fmt.Fprintf(d.out, "breakpoint at line %d\n", line)
+	return false
+}
+
+func commandDelete(d *Debugger, arg string, env *object.Environment) bool {
+	line, err := strconv.Atoi(arg)
+	if err != nil {
+		io.WriteString(d.out, "usage: delete <line>\n")
+		return false
+	}
+
+	d.ClearBreakpoint(line)
+	return false
+}
+
+// 内側の環境から順に、環境ごとに束縛を表示する。内側の束縛に隠された名前には印を付ける
+func commandEnv(d *Debugger, arg string, env *object.Environment) bool {
+	seen := make(map[string]bool)
+	for scope := env; scope != nil; scope = scope.Outer() {
+		bindings := scope.All()
+		names := make([]string, 0, len(bindings))
+		for name := range bindings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		switch {
+		case scope.Outer() == nil:
+			io.WriteString(d.out, "global:\n")
+		case scope == env:
+			io.WriteString(d.out, "local:\n")
+		default:
+			io.WriteString(d.out, "enclosing:\n")
+		}
+		for _, name := range names {
+			shadowed := ""
+			if seen[name] {
+				shadowed = " (shadowed)"
+			}
+			seen[name] = true
+			fmt.Fprintf(d.out, "  %s = %s%s\n", name, bindings[name].Inspect(), shadowed)
+		}
+	}
+	return false
+}
+
+func commandPrint(d *Debugger, arg string, env *object.Environment) bool {
+	p := parser.New(lexer.New(arg))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			io.WriteString(d.out, msg+"\n")
+		}
+		return false
+	}
+
+	// 止まっている文の環境で評価する。フックは付けないので、ここでは止まらない
+	opts := d.Options
+	opts.Hooks = nil
+	evaluated := evaluator.EvalWithOptions(context.Background(), program, env, opts)
+	if evaluated != nil {
+		io.WriteString(d.out, evaluated.Inspect()+"\n")
+	}
+	return false
+}
+
+func commandWhere(d *Debugger, arg string, env *object.Environment) bool {
+	for i := len(d.calls) - 1; i >= 0; i-- {
+		call := d.calls[i]
+		fmt.Fprintf(d.out, "  %s (%s)\n", call.Function.String(), call.Pos())
+	}
+	return false
+}
+
+func commandHelp(d *Debugger, arg string, env *object.Environment) bool {
+	for _, cmd := range commands {
+		fmt.Fprintf(d.out, "  %-16s %-4s %s\n", cmd.usage, strings.Join(cmd.names[1:], ","), cmd.help)
+	}
+	return false
+}
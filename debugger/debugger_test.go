@@ -0,0 +1,154 @@
+package debugger
+
+import (
+	"bytes"
+	"io"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+// 用意したコマンドを順に返す。尽きたらio.EOFを返す
+type scriptReader struct {
+	lines []string
+}
+
+func (r *scriptReader) ReadLine(prompt string) (string, error) {
+	if len(r.lines) == 0 {
+		return "", io.EOF
+	}
+	line := r.lines[0]
+	r.lines = r.lines[1:]
+	return line, nil
+}
+
+const testProgram = `let double = fn(x) {
+  let y = x * 2;
+  y
+};
+let a = double(1);
+let b = double(a);
+b + 1`
+
+func runScript(t *testing.T, commands ...string) (string, object.Object) {
+	t.Helper()
+
+	p := parser.New(lexer.New(testProgram))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	var out bytes.Buffer
+	d := New(&scriptReader{lines: commands}, &out)
+	result := d.Run(program, object.NewEnvironment())
+	return out.String(), result
+}
+
+func stops(out string) []string {
+	result := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "stopped at ") {
+			result = append(result, strings.TrimPrefix(line, "stopped at "))
+		}
+	}
+	return result
+}
+
+func TestStepping(t *testing.T) {
+	tests := []struct {
+		commands []string
+		expected []string
+	}{
+		{
+			[]string{"continue"},
+			[]string{"1:1: let double = fn(x) let y = (x * 2);y;"},
+		},
+		{
+			[]string{"next", "next", "next", "c"},
+			[]string{
+				"1:1: let double = fn(x) let y = (x * 2);y;",
+				"5:1: let a = double(1);",
+				"6:1: let b = double(a);",
+				"7:1: (b + 1)",
+			},
+		},
+		{
+			[]string{"n", "step", "s", "s", "c"},
+			[]string{
+				"1:1: let double = fn(x) let y = (x * 2);y;",
+				"5:1: let a = double(1);",
+				"2:3: let y = (x * 2);",
+				"3:3: y",
+				"6:1: let b = double(a);",
+			},
+		},
+		{
+			[]string{"break 3", "c", "c", "c"},
+			[]string{
+				"1:1: let double = fn(x) let y = (x * 2);y;",
+				"3:3: y",
+				"3:3: y",
+			},
+		},
+		{
+			[]string{"b 3", "b 7", "d 3", "c", "c"},
+			[]string{
+				"1:1: let double = fn(x) let y = (x * 2);y;",
+				"7:1: (b + 1)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		out, result := runScript(t, tt.commands...)
+		got := stops(out)
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("wrong stops for %v.\nwant=%q\ngot=%q", tt.commands, tt.expected, got)
+		}
+
+		integer, ok := result.(*object.Integer)
+		if !ok || integer.Value != 5 {
+			t.Errorf("wrong result for %v. got=%v", tt.commands, result)
+		}
+	}
+}
+
+func TestInspectPausedFrame(t *testing.T) {
+	out, _ := runScript(t, "b 3", "c", "print x + y", "env", "where", "quit")
+
+	for _, want := range []string{
+		"\n3\n",
+		"local:\n  x = 1\n  y = 2\nglobal:\n  double = ",
+		"  double (5:9)\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q. got=%q", want, out)
+		}
+	}
+}
+
+func TestQuit(t *testing.T) {
+	out, result := runScript(t, "quit")
+	if result != nil {
+		t.Errorf("result must be nil after quit. got=%v", result)
+	}
+	if len(stops(out)) != 1 {
+		t.Errorf("wrong number of stops. got=%q", out)
+	}
+
+	// 入力が尽きた場合も中断する
+	_, result = runScript(t)
+	if result != nil {
+		t.Errorf("result must be nil at end of input. got=%v", result)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	out, _ := runScript(t, "jump", "c")
+	if !strings.Contains(out, "unknown command: jump") {
+		t.Errorf("unknown command was not reported. got=%q", out)
+	}
+}
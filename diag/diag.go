@@ -0,0 +1,84 @@
+// エラーや警告を種類ごとに色と接頭辞を付けて表示する。REPLとCLIで共通して使う
+
+package diag
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// 重大度
+type Severity int
+
+const (
+	Warning Severity = iota
+	ParseError
+	MacroError
+	CompileError
+	RuntimeError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "warning"
+	case ParseError:
+		return "parse error"
+	case MacroError:
+		return "macro error"
+	case CompileError:
+		return "compile error"
+	case RuntimeError:
+		return "runtime error"
+	default:
+		return "error"
+	}
+}
+
+// 重大度ごとの色
+func (s Severity) color() string {
+	switch s {
+	case Warning:
+		return "\x1b[1;33m"
+	default:
+		return "\x1b[1;31m"
+	}
+}
+
+const colorReset = "\x1b[0m"
+
+// outへの出力に色を使うか判定する。NO_COLOR環境変数が設定されている場合は常に使わない
+// https://no-color.org/
+// パイプやファイルへの出力にエスケープシーケンスが混ざらないように、outが端末の場合だけ使う
+func ColorEnabled(want bool, out io.Writer) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	f, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+	return want && isTerminal(f)
+}
+
+// 診断メッセージを出力する
+type Printer struct {
+	Out   io.Writer
+	Color bool
+}
+
+func NewPrinter(out io.Writer, color bool) *Printer {
+	return &Printer{Out: out, Color: color}
+}
+
+// 接頭辞を付けてメッセージを出力する。複数行のメッセージは2行目以降をそのまま出力する
+func (p *Printer) Print(sev Severity, msg string) {
+	prefix := sev.String() + ":"
+	if p.Color {
+		prefix = sev.color() + prefix + colorReset
+	}
+
+	fmt.Fprintf(p.Out, "%s %s\n", prefix, strings.TrimRight(msg, "\n"))
+}
@@ -0,0 +1,68 @@
+package diag
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrint(t *testing.T) {
+	tests := []struct {
+		severity Severity
+		color    bool
+		msg      string
+		expected string
+	}{
+		{ParseError, false, "1:5: unexpected )", "parse error: 1:5: unexpected )\n"},
+		{RuntimeError, false, "type mismatch\n", "runtime error: type mismatch\n"},
+		{Warning, false, "unused", "warning: unused\n"},
+		{RuntimeError, true, "boom", "\x1b[1;31mruntime error:\x1b[0m boom\n"},
+		{Warning, true, "hmm", "\x1b[1;33mwarning:\x1b[0m hmm\n"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		NewPrinter(&out, tt.color).Print(tt.severity, tt.msg)
+
+		if out.String() != tt.expected {
+			t.Errorf("output wrong. want=%q, got=%q", tt.expected, out.String())
+		}
+	}
+}
+
+func TestColorEnabledRespectsNoColor(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	if ColorEnabled(true, os.Stdout) {
+		t.Errorf("ColorEnabled(true) should be false when NO_COLOR is set")
+	}
+}
+
+func TestColorEnabledRequiresTerminal(t *testing.T) {
+	// NO_COLORによらず、端末でなければ色を使わないことを確かめる。Setenvで終了時に元に戻す
+	t.Setenv("NO_COLOR", "")
+	os.Unsetenv("NO_COLOR")
+
+	if ColorEnabled(true, &bytes.Buffer{}) {
+		t.Errorf("ColorEnabled(true) should be false for a buffer")
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if ColorEnabled(true, f) {
+		t.Errorf("ColorEnabled(true) should be false for a regular file")
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if ColorEnabled(true, w) {
+		t.Errorf("ColorEnabled(true) should be false for a pipe")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build linux
+
+package diag
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// fが端末かどうかを判定する
+func isTerminal(f *os.File) bool {
+	var t syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL,
+		f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&t)))
+	return errno == 0
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package diag
+
+import "os"
+
+// fが端末かどうかを判定する。linux以外ではキャラクタデバイスを端末とみなす
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,87 @@
+// 逆アセンブルモード。monkey disasm script.mky
+// 実行はせずに、コンパイルしたバイトコードを表示する
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/diag"
+	"monkey/evaluator"
+	"monkey/object"
+	"os"
+)
+
+// disasmサブコマンドの引数を処理し、終了コードを返す
+func disasmCommand(args []string) int {
+	fs := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: monkey disasm [flags] <file>")
+		fs.PrintDefaults()
+	}
+	noColor := fs.Bool("no-color", false, "disable colored output")
+	if err := fs.Parse(args); err != nil {
+		return exitRuntimeError
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitRuntimeError
+	}
+
+	return disasmFile(fs.Arg(0), diag.ColorEnabled(!*noColor, os.Stderr), os.Stdout, os.Stderr)
+}
+
+// ファイルを構文解析してコンパイルし、バイトコードを表示する。ファイル名が-の場合は標準入力から読み込む
+func disasmFile(filename string, color bool, out, errOut io.Writer) int {
+	src := io.Reader(os.Stdin)
+	if filename == "-" {
+		filename = STDIN_NAME
+	} else {
+		f, err := os.Open(filename)
+		if err != nil {
+			fmt.Fprintln(errOut, err)
+			return exitRuntimeError
+		}
+		defer f.Close()
+		src = f
+	}
+
+	printer := diag.NewPrinter(errOut, color)
+
+	program := parseSource(filename, src, printer, 0)
+	if program == nil {
+		return exitParseError
+	}
+
+	macroEnv := object.NewEnvironment()
+	evaluator.DefineMacros(program, macroEnv)
+	expanded, macroErrors := evaluator.ExpandMacros(program, macroEnv)
+	if len(macroErrors) != 0 {
+		for _, err := range macroErrors {
+			printer.Print(diag.MacroError, filename+":"+err.Error())
+		}
+		return exitRuntimeError
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(expanded); err != nil {
+		printer.Print(diag.CompileError, filename+":"+err.Error())
+		return exitRuntimeError
+	}
+	bytecode := comp.Bytecode()
+
+	constants := make([]code.Constant, len(bytecode.Constants))
+	for i, c := range bytecode.Constants {
+		constants[i] = c
+	}
+	if err := code.Disassemble(out, bytecode.Instructions, bytecode.Positions, constants); err != nil {
+		fmt.Fprintln(errOut, err)
+		return exitRuntimeError
+	}
+
+	return exitOK
+}
@@ -0,0 +1,42 @@
+// 実行エンジンの選択。--engine=eval|vm
+// どちらも同じ結果になるので、VMで問題が起きたときに評価器へ切り替えて確かめられる
+// ただしVMは末尾呼び出しでフレームを置き換えるので、深い末尾再帰でも深さの上限を超えず、スタックトレースでは置き換えた呼び出しを数だけ示す
+// デフォルトはVMにする。VMは実行の打ち切りやステップ数、確保量の上限にまだ対応していないが、run、bench、REPLはいずれも上限を設定しない
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/object"
+	"monkey/repl"
+	"monkey/vm"
+)
+
+// -engineフラグを追加する
+func engineFlag(fs *flag.FlagSet) *string {
+	return fs.String("engine", repl.EngineVM, `execution engine: "vm" (bytecode) or "eval" (tree-walking evaluator)`)
+}
+
+func checkEngine(engine string) error {
+	if engine != repl.EngineEval && engine != repl.EngineVM {
+		return fmt.Errorf(`unknown engine %q, want "eval" or "vm"`, engine)
+	}
+	return nil
+}
+
+// プログラムを選んだエンジンで実行する。VMでコンパイルできない場合はerrorを返す
+// optsのうち、VMはファイルと出力先の設定だけを使う
+func execute(engine string, program ast.Node, env *object.Environment, opts evaluator.Options) (object.Object, error) {
+	if engine == repl.EngineEval {
+		return evaluator.EvalWithOptions(context.Background(), program, env, opts), nil
+	}
+	session := vm.NewSession()
+	session.DisableFiles = opts.DisableFiles
+	session.Files = opts.Files
+	session.Stdout = opts.Stdout
+	return session.Run(program, env)
+}
@@ -0,0 +1,86 @@
+package evaluator
+
+import "monkey/object"
+
+// オブジェクトの大きさの見積もりに使う値。Goの実際のメモリ配置とは一致しないが、増え方の目安になる
+const (
+	objectHeaderSize = 16 // オブジェクト1つあたりの固定の大きさ
+	interfaceSize    = 16 // 配列の要素などのobject.Object1つ
+	hashPairSize     = 64 // ハッシュの1組。キー、HashKey、値
+	bindingSize      = 32 // 環境の1つの束縛
+	wordSize         = 8  // 任意精度の整数の1桁
+)
+
+// 評価で作ったオブジェクトのおおよその大きさを加算する。上限を超えた場合はエラーを返す
+// 上限が設定されていない場合は何もしない
+func (ev *evaluation) charge(obj object.Object) object.Object {
+	if ev.opts.MaxAllocBytes <= 0 || obj == nil || isError(obj) {
+		return obj
+	}
+	if err := ev.chargeBytes(approxSize(obj)); err != nil {
+		return err
+	}
+	return obj
+}
+
+// nバイトを加算する。上限を超えた場合はエラーを返す
+func (ev *evaluation) chargeBytes(n int) *object.Error {
+	if ev.opts.MaxAllocBytes <= 0 {
+		return nil
+	}
+	ev.allocated += n
+	if ev.allocated > ev.opts.MaxAllocBytes {
+		return newError(object.LIMIT_ERROR, "memory limit exceeded (max %d bytes)", ev.opts.MaxAllocBytes)
+	}
+	return nil
+}
+
+// array()で配列を作る。範囲のように大きな配列になりうるものも、上限を超えた時点で打ち切る
+// 範囲や文字列の要素は走査するときに作るので要素の大きさも加算し、配列やハッシュの要素は既にあるので配列の枠だけを加算する
+func (ev *evaluation) array(args []object.Object) object.Object {
+	if ev.opts.MaxAllocBytes <= 0 {
+		return collectArray(args[0], nil)
+	}
+
+	_, existing := args[0].(*object.Array)
+	if _, ok := args[0].(*object.Hash); ok {
+		existing = true
+	}
+	if err := ev.chargeBytes(objectHeaderSize); err != nil {
+		return err
+	}
+	return collectArray(args[0], func(el object.Object) *object.Error {
+		size := interfaceSize
+		if !existing {
+			size += approxSize(el)
+		}
+		return ev.chargeBytes(size)
+	})
+}
+
+// オブジェクトのおおよその大きさを返す。要素が指すオブジェクトは含めない
+// 要素はそれぞれ作られたときに加算されているので、二重に数えないようにする
+func approxSize(obj object.Object) int {
+	switch obj := obj.(type) {
+	case *object.Boolean, *object.Null:
+		// TRUE、FALSE、NULLは使い回すので新しく確保しない
+		return 0
+	case *object.Integer:
+		if minCachedInteger <= obj.Value && obj.Value <= maxCachedInteger {
+			return 0
+		}
+		return objectHeaderSize
+	case *object.BigInt:
+		return objectHeaderSize + wordSize*len(obj.Value.Bits())
+	case *object.String:
+		return objectHeaderSize + len(obj.Value)
+	case *object.Bytes:
+		return objectHeaderSize + len(obj.Value)
+	case *object.Array:
+		return objectHeaderSize + interfaceSize*len(obj.Elements)
+	case *object.Hash:
+		return objectHeaderSize + hashPairSize*len(obj.Pairs)
+	default:
+		return objectHeaderSize
+	}
+}
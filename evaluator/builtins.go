@@ -2,36 +2,46 @@ package evaluator
 
 import (
 	"fmt"
+	"io"
+	"math/big"
 	"monkey/object"
+	"os"
+	"sort"
+	"strings"
 )
 
 var builtins = map[string]*object.Builtin{
 	"len": &object.Builtin{
+		Name:    "len",
+		Usage:   "len(arg)",
+		Doc:     "returns the number of characters in a string, bytes in a byte string or elements in an array or range",
+		MinArgs: 1,
+		MaxArgs: 1,
 		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
-
 			switch arg := args[0].(type) {
 			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value))}
+				return newInteger(int64(len(arg.Value)))
+			case *object.Bytes:
+				return newInteger(int64(len(arg.Value)))
 			case *object.Array:
-				return &object.Integer{Value: int64(len(arg.Elements))}
+				return newInteger(int64(len(arg.Elements)))
+			case *object.Range:
+				return newInteger(arg.Len())
 			default:
-				return newError("argument to `len` not supported, got %s",
+				return newError(object.TYPE_ERROR, "argument to `len` not supported, got %s",
 					args[0].Type())
 			}
 		},
 	},
 	"first": &object.Builtin{
+		Name:    "first",
+		Usage:   "first(array)",
+		Doc:     "returns the first element of an array, or null if it is empty",
+		MinArgs: 1,
+		MaxArgs: 1,
 		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
 			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `first` must be ARRAY, got %s",
+				return newError(object.TYPE_ERROR, "argument to `first` must be ARRAY, got %s",
 					args[0].Type())
 			}
 
@@ -44,13 +54,14 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 	"last": &object.Builtin{
+		Name:    "last",
+		Usage:   "last(array)",
+		Doc:     "returns the last element of an array, or null if it is empty",
+		MinArgs: 1,
+		MaxArgs: 1,
 		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
 			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `last` must be ARRAY, got %s",
+				return newError(object.TYPE_ERROR, "argument to `last` must be ARRAY, got %s",
 					args[0].Type())
 			}
 
@@ -64,13 +75,14 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 	"rest": &object.Builtin{
+		Name:    "rest",
+		Usage:   "rest(array)",
+		Doc:     "returns a new array containing all elements but the first",
+		MinArgs: 1,
+		MaxArgs: 1,
 		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
 			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `rest` must be ARRAY, got %s",
+				return newError(object.TYPE_ERROR, "argument to `rest` must be ARRAY, got %s",
 					args[0].Type())
 			}
 
@@ -80,19 +92,21 @@ var builtins = map[string]*object.Builtin{
 				// 新しく割り当てられた配列を返す
 				newElements := make([]object.Object, length-1, length-1)
 				copy(newElements, arr.Elements[1:length])
+				return &object.Array{Elements: newElements}
 			}
 
 			return NULL
 		},
 	},
 	"push": &object.Builtin{
+		Name:    "push",
+		Usage:   "push(array, value)",
+		Doc:     "returns a new array with value appended",
+		MinArgs: 2,
+		MaxArgs: 2,
 		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 2 {
-				return newError("wrong number of arguments. got=%d, want=2",
-					len(args))
-			}
 			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `push` must be ARRAY, got %s",
+				return newError(object.TYPE_ERROR, "argument to `push` must be ARRAY, got %s",
 					args[0].Type())
 			}
 
@@ -106,13 +120,374 @@ var builtins = map[string]*object.Builtin{
 			return &object.Array{Elements: newElements}
 		},
 	},
-	"puts": &object.Builtin{
+	"keys": &object.Builtin{
+		Name:    "keys",
+		Usage:   "keys(hash)",
+		Doc:     "returns an array of the keys of a hash in insertion order",
+		MinArgs: 1,
+		MaxArgs: 1,
 		Fn: func(args ...object.Object) object.Object {
-			for _, arg := range args {
-				fmt.Println(arg.Inspect())
+			if args[0].Type() != object.HASH_OBJ {
+				return newError(object.TYPE_ERROR, "argument to `keys` must be HASH, got %s",
+					args[0].Type())
 			}
 
-			return NULL
+			pairs := args[0].(*object.Hash).Ordered()
+			keys := make([]object.Object, len(pairs))
+			for i, pair := range pairs {
+				keys[i] = pair.Key
+			}
+
+			return &object.Array{Elements: keys}
+		},
+	},
+	"slice": &object.Builtin{
+		Name:    "slice",
+		Usage:   "slice(x, start, end)",
+		Doc:     "returns the elements of an array, string or byte string from start up to but not including end",
+		MinArgs: 3,
+		MaxArgs: 3,
+		Fn: func(args ...object.Object) object.Object {
+			start, ok := args[1].(*object.Integer)
+			if !ok {
+				return newError(object.TYPE_ERROR, "start of `slice` must be INTEGER, got %s",
+					args[1].Type())
+			}
+			end, ok := args[2].(*object.Integer)
+			if !ok {
+				return newError(object.TYPE_ERROR, "end of `slice` must be INTEGER, got %s",
+					args[2].Type())
+			}
+
+			var length int
+			switch arg := args[0].(type) {
+			case *object.Array:
+				length = len(arg.Elements)
+			case *object.String:
+				length = len(arg.Value)
+			case *object.Bytes:
+				length = len(arg.Value)
+			default:
+				return newError(object.TYPE_ERROR, "argument to `slice` not supported, got %s",
+					args[0].Type())
+			}
+			if start.Value < 0 || end.Value > int64(length) || start.Value > end.Value {
+				return newError(object.INDEX_ERROR, "slice bounds out of range [%d:%d] with length %d",
+					start.Value, end.Value, length)
+			}
+
+			// 元の値と領域を共有しないようにコピーする
+			switch arg := args[0].(type) {
+			case *object.Array:
+				elements := make([]object.Object, end.Value-start.Value)
+				copy(elements, arg.Elements[start.Value:end.Value])
+				return &object.Array{Elements: elements}
+			case *object.String:
+				return &object.String{Value: arg.Value[start.Value:end.Value]}
+			default:
+				value := make([]byte, end.Value-start.Value)
+				copy(value, args[0].(*object.Bytes).Value[start.Value:end.Value])
+				return &object.Bytes{Value: value}
+			}
+		},
+	},
+	"range": &object.Builtin{
+		Name:    "range",
+		Usage:   "range([start, ]end[, step])",
+		Doc:     "returns the integers from start (default 0) up to but not including end, counting by step (default 1)",
+		MinArgs: 1,
+		MaxArgs: 3,
+		Fn: func(args ...object.Object) object.Object {
+			values := make([]int64, len(args))
+			for i, arg := range args {
+				n, ok := arg.(*object.Integer)
+				if !ok {
+					return newError(object.TYPE_ERROR, "arguments to `range` must be INTEGER, got %s",
+						arg.Type())
+				}
+				values[i] = n.Value
+			}
+
+			r := &object.Range{End: values[0], Step: 1}
+			if len(values) > 1 {
+				r.Start, r.End = values[0], values[1]
+			}
+			if len(values) > 2 {
+				r.Step = values[2]
+			}
+			if r.Step == 0 {
+				return newError(object.TYPE_ERROR, "step of `range` must not be zero")
+			}
+			return r
+		},
+	},
+	"contains": &object.Builtin{
+		Name:    "contains",
+		Usage:   "contains(collection, value)",
+		Doc:     "reports whether an array or range has an element, a hash has a key, or a string has a substring equal to value",
+		MinArgs: 2,
+		MaxArgs: 2,
+		Fn: func(args ...object.Object) object.Object {
+			switch collection := args[0].(type) {
+			case *object.Range:
+				n, ok := args[1].(*object.Integer)
+				return nativeBoolToBooleanObject(ok && collection.Contains(n.Value))
+			case *object.Array:
+				for _, el := range collection.Elements {
+					if object.Equal(el, args[1]) {
+						return TRUE
+					}
+				}
+				return FALSE
+			case *object.Hash:
+				key, ok := args[1].(object.Hashable)
+				if !ok {
+					return newError(object.TYPE_ERROR, "unusable as hash key: %s", args[1].Type())
+				}
+				_, ok = collection.Pairs[key.HashKey()]
+				return nativeBoolToBooleanObject(ok)
+			case *object.String:
+				sub, ok := args[1].(*object.String)
+				if !ok {
+					return newError(object.TYPE_ERROR, "second argument to `contains` must be STRING, got %s",
+						args[1].Type())
+				}
+				return nativeBoolToBooleanObject(strings.Contains(collection.Value, sub.Value))
+			default:
+				return newError(object.TYPE_ERROR, "argument to `contains` not supported, got %s",
+					args[0].Type())
+			}
+		},
+	},
+	"bytes": &object.Builtin{
+		Name:    "bytes",
+		Usage:   "bytes(x)",
+		Doc:     "converts a string, or an array of integers from 0 to 255, to a byte string",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: func(args ...object.Object) object.Object {
+			switch arg := args[0].(type) {
+			case *object.Bytes:
+				return arg
+			case *object.String:
+				return &object.Bytes{Value: []byte(arg.Value)}
+			case *object.Array:
+				value := make([]byte, len(arg.Elements))
+				for i, el := range arg.Elements {
+					n, ok := el.(*object.Integer)
+					if !ok || n.Value < 0 || n.Value > 255 {
+						return newError(object.TYPE_ERROR, "element %d of argument to `bytes` is not a byte: %s",
+							i, el.Inspect())
+					}
+					value[i] = byte(n.Value)
+				}
+				return &object.Bytes{Value: value}
+			default:
+				return newError(object.TYPE_ERROR, "argument to `bytes` not supported, got %s",
+					args[0].Type())
+			}
 		},
 	},
+	"bigint": &object.Builtin{
+		Name:    "bigint",
+		Usage:   "bigint(x)",
+		Doc:     "converts an integer or a string of digits to an integer; values that do not fit in 64 bits become arbitrary-precision",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: func(args ...object.Object) object.Object {
+			switch arg := args[0].(type) {
+			case *object.BigInt, *object.Integer:
+				return arg
+			case *object.String:
+				value, ok := new(big.Int).SetString(arg.Value, 0)
+				if !ok {
+					return newError(object.TYPE_ERROR, "cannot convert %q to BIGINT", arg.Value)
+				}
+				return newBigInteger(value)
+			default:
+				return newError(object.TYPE_ERROR, "argument to `bigint` not supported, got %s",
+					args[0].Type())
+			}
+		},
+	},
+	"string": &object.Builtin{
+		Name:    "string",
+		Usage:   "string(bytes)",
+		Doc:     "converts a byte string to a string",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: func(args ...object.Object) object.Object {
+			switch arg := args[0].(type) {
+			case *object.String:
+				return arg
+			case *object.Bytes:
+				return &object.String{Value: string(arg.Value)}
+			default:
+				return newError(object.TYPE_ERROR, "argument to `string` not supported, got %s",
+					args[0].Type())
+			}
+		},
+	},
+	"array": arrayBuiltin,
+	"freeze": &object.Builtin{
+		Name:    "freeze",
+		Usage:   "freeze(value)",
+		Doc:     "makes an array or hash, and the arrays and hashes inside it, immutable and returns it",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: func(args ...object.Object) object.Object {
+			object.Freeze(args[0])
+			return args[0]
+		},
+	},
+	"frozen": &object.Builtin{
+		Name:    "frozen",
+		Usage:   "frozen(value)",
+		Doc:     "reports whether a value is immutable; only arrays and hashes that were not frozen are mutable",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: func(args ...object.Object) object.Object {
+			return nativeBoolToBooleanObject(object.IsFrozen(args[0]))
+		},
+	},
+	// 展開の段階でquoteに置き換わるので、ここに来るのは引数がquote(...)でない場合だけ
+	"macroexpand": &object.Builtin{
+		Name:    "macroexpand",
+		Usage:   "macroexpand(quote(expr))",
+		Doc:     "returns expr with all macro calls expanded, as a quote",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: func(args ...object.Object) object.Object {
+			return newError(object.TYPE_ERROR, "argument to `macroexpand` must be a quote(...) expression")
+		},
+	},
+	"macroexpand_1": &object.Builtin{
+		Name:    "macroexpand_1",
+		Usage:   "macroexpand_1(quote(expr))",
+		Doc:     "returns expr with its outermost macro call expanded once, as a quote",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: func(args ...object.Object) object.Object {
+			return newError(object.TYPE_ERROR, "argument to `macroexpand_1` must be a quote(...) expression")
+		},
+	},
+}
+
+// putsとdocは評価の設定で出力先を変えられるように、評価器から出力先を渡して呼び出す
+// 直接Fnを呼んだ場合は標準出力に書く
+var putsBuiltin = &object.Builtin{
+	Name:    "puts",
+	Usage:   "puts(args...)",
+	Doc:     "prints each argument on its own line and returns null",
+	MinArgs: 0,
+	MaxArgs: object.VARIADIC,
+	Fn: func(args ...object.Object) object.Object {
+		return puts(os.Stdout, args)
+	},
+}
+
+func puts(out io.Writer, args []object.Object) object.Object {
+	for _, arg := range args {
+		fmt.Fprintln(out, arg.Inspect())
+	}
+
+	return NULL
+}
+
+// 評価器では確保量の上限を超えたところで打ち切れるように、要素ごとに大きさを加算して呼び出す
+var arrayBuiltin = &object.Builtin{
+	Name:    "array",
+	Usage:   "array(iterable)",
+	Doc:     "returns an array of the elements of an array, range or string, or of the keys of a hash",
+	MinArgs: 1,
+	MaxArgs: 1,
+	Fn: func(args ...object.Object) object.Object {
+		return collectArray(args[0], nil)
+	},
+}
+
+// iterableの要素を配列に集める。chargeがnilでなければ要素を追加するたびに呼び、エラーを返せばそこで打ち切る
+func collectArray(arg object.Object, charge func(el object.Object) *object.Error) object.Object {
+	iterable, ok := arg.(object.Iterable)
+	if !ok {
+		return newError(object.TYPE_ERROR, "argument to `array` must be iterable, got %s", arg.Type())
+	}
+
+	elements := []object.Object{}
+	it := iterable.Iter()
+	for el, ok := it.Next(); ok; el, ok = it.Next() {
+		if charge != nil {
+			if err := charge(el); err != nil {
+				return err
+			}
+		}
+		elements = append(elements, el)
+	}
+	return &object.Array{Elements: elements}
+}
+
+// 組み込み関数の名前の一覧をソートして返す
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	builtins["puts"] = putsBuiltin
+	// docはbuiltinsを参照するので、初期化の循環を避けるためにここで登録する
+	builtins["doc"] = docBuiltin
+}
+
+var docBuiltin = &object.Builtin{
+	Name:    "doc",
+	Usage:   "doc(name)",
+	Doc:     "prints the usage and description of a builtin function",
+	MinArgs: 1,
+	MaxArgs: 1,
+	Fn: func(args ...object.Object) object.Object {
+		return doc(os.Stdout, args)
+	},
+}
+
+func doc(out io.Writer, args []object.Object) object.Object {
+	var builtin *object.Builtin
+	switch arg := args[0].(type) {
+	case *object.String:
+		b, ok := builtins[arg.Value]
+		if !ok {
+			return newError(object.NAME_ERROR, "no builtin function named %q", arg.Value)
+		}
+		builtin = b
+	case *object.Builtin:
+		builtin = arg
+	default:
+		return newError(object.TYPE_ERROR, "argument to `doc` must be STRING or BUILTIN, got %s",
+			args[0].Type())
+	}
+
+	fmt.Fprintln(out, formatBuiltinDoc(builtin))
+	return NULL
+}
+
+// 名前から組み込み関数を探す
+func LookupBuiltin(name string) (*object.Builtin, bool) {
+	builtin, ok := builtins[name]
+	return builtin, ok
+}
+
+// 組み込み関数の使い方と説明を返す
+func BuiltinDoc(name string) (string, bool) {
+	builtin, ok := builtins[name]
+	if !ok {
+		return "", false
+	}
+	return formatBuiltinDoc(builtin), true
+}
+
+func formatBuiltinDoc(builtin *object.Builtin) string {
+	return builtin.Usage + "\n    " + builtin.Doc
 }
@@ -0,0 +1,62 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+)
+
+// 実行中の関数呼び出し
+type callFrame struct {
+	env      *object.Environment // 呼び出しの環境
+	closures []*object.Function  // この呼び出しの中で作った関数
+	defers   []deferred          // 呼び出しから戻るときに評価するdeferの式
+}
+
+// 関数を作ったことを、実行中の呼び出しに記録する
+func (ev *evaluation) recordClosure(fn *object.Function) {
+	if len(ev.calls) == 0 {
+		return
+	}
+	frame := &ev.calls[len(ev.calls)-1]
+	if frame.env == fn.Env {
+		frame.closures = append(frame.closures, fn)
+	}
+}
+
+// 呼び出しが終わった後に、その中で作った関数が捕まえている環境を、使う名前だけに絞る
+// 呼び出しが終わった環境には新しい名前が増えないので、絞っても名前の解決結果は変わらない
+// 使わない値を持ち続けないようにして、関数が生き残ってもメモリを解放できるようにする
+func (ev *evaluation) pruneClosures(frame callFrame) {
+	for _, fn := range frame.closures {
+		fn.Env = frame.env.Prune(ev.freeVariables(fn))
+	}
+}
+
+// 関数の本体が参照する名前のうち、仮引数以外のものを返す。結果は本体ごとに覚えておく
+// 本体の中のletで束縛する名前も含むので、実際に必要なものより多いことがある
+func (ev *evaluation) freeVariables(fn *object.Function) []string {
+	if names, ok := ev.freeVars[fn.Body]; ok {
+		return names
+	}
+
+	params := make(map[string]bool, len(fn.Parameters))
+	for _, p := range fn.Parameters {
+		params[p.Value] = true
+	}
+
+	seen := make(map[string]bool)
+	names := []string{}
+	ast.Inspect(fn.Body, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Identifier); ok && !params[ident.Value] && !seen[ident.Value] {
+			seen[ident.Value] = true
+			names = append(names, ident.Value)
+		}
+		return true
+	})
+
+	if ev.freeVars == nil {
+		ev.freeVars = make(map[*ast.BlockStatement][]string)
+	}
+	ev.freeVars[fn.Body] = names
+	return names
+}
@@ -0,0 +1,45 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+)
+
+// deferで後から評価する式
+type deferred struct {
+	exp ast.Expression
+	env *object.Environment
+}
+
+// 式を実行中の関数呼び出しに積む。関数の外ではプログラムの最後に評価する
+func (ev *evaluation) pushDeferred(exp ast.Expression, env *object.Environment) {
+	d := deferred{exp: exp, env: env}
+	if len(ev.calls) == 0 {
+		ev.defers = append(ev.defers, d)
+		return
+	}
+	frame := &ev.calls[len(ev.calls)-1]
+	frame.defers = append(frame.defers, d)
+}
+
+// 積まれた式を後に積んだものから順に評価する。resultは関数やプログラムの評価結果
+// 式がエラーになった場合、resultがエラーでなければそのエラーを返す。resultがエラーなら元のエラーを優先する
+// frameは式を積んだ呼び出しの位置で、負の場合は関数の外で積んだものを評価する
+func (ev *evaluation) runDeferred(result object.Object, frame int) object.Object {
+	for {
+		// 評価中に呼び出しが増減してスライスが作り直されることがあるので、毎回取り直す
+		defers := &ev.defers
+		if frame >= 0 {
+			defers = &ev.calls[frame].defers
+		}
+		if len(*defers) == 0 {
+			return result
+		}
+		d := (*defers)[len(*defers)-1]
+		*defers = (*defers)[:len(*defers)-1]
+
+		if evaluated := ev.eval(d.exp, d.env); isError(evaluated) && !isError(result) {
+			result = evaluated
+		}
+	}
+}
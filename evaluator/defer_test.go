@@ -0,0 +1,59 @@
+package evaluator
+
+import (
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+func TestDefer(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+		log      string
+	}{
+		// 後に積んだものから順に、関数の本体の後で評価する
+		{`let f = fn() { defer record(1); defer record(2); record(3); 10 }; f()`, 10, "3 2 1"},
+		// エラーで抜ける場合も評価する
+		{`let f = fn() { defer record("cleanup"); 1 / 0 }; f()`, "division by zero", "cleanup"},
+		{`let f = fn(x) { defer record(x); if (x > 0) { return 1; } record("after"); 2 }; f(5)`, 1, "5"},
+		// 式は積んだときではなく、戻るときに評価する
+		{`let f = fn() { let x = 1; defer record(x); let x = 2; x }; f()`, 2, "2"},
+		// deferの式のエラーは結果を置き換える。ただし本体のエラーを優先する
+		{`let f = fn() { defer -true; 1 }; f()`, "unknown operator: -BOOLEAN", ""},
+		{`let f = fn() { defer 1 / 0; -true }; f()`, "unknown operator: -BOOLEAN", ""},
+		// 関数の外ではプログラムの最後に評価する
+		{`defer record("end"); record("start"); 5`, 5, "start end"},
+		{`let f = fn() { defer record("f"); 1 }; defer record("top"); f() + f()`, 2, "f f top"},
+	}
+
+	for _, tt := range tests {
+		log := []string{}
+		env := object.NewEnvironment()
+		env.Set("record", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+			log = append(log, strings.Trim(args[0].Inspect(), `"`))
+			return NULL
+		}})
+
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := Eval(program, env)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+			} else if errObj.Message != expected {
+				t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, expected, errObj.Message)
+			}
+		}
+
+		if got := strings.Join(log, " "); got != tt.log {
+			t.Errorf("wrong evaluation order for %q. want=%q, got=%q", tt.input, tt.log, got)
+		}
+	}
+}
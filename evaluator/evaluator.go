@@ -1,35 +1,193 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
 	"monkey/ast"
 	"monkey/object"
+	"monkey/token"
+	"os"
 )
 
+// 関数呼び出しの深さの上限。再帰が深すぎてGoのスタックを使い切る前にエラーにする
+// 0以下の場合は制限しない
+var MaxCallDepth = 10000
+
 var (
 	NULL  = &object.Null{}
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
 )
 
+// あらかじめ作っておく整数の範囲。ループのカウンタや添字のような小さい整数は、毎回確保せずに使い回す
+const (
+	minCachedInteger = -128
+	maxCachedInteger = 255
+)
+
+var cachedIntegers = func() []*object.Integer {
+	ints := make([]*object.Integer, maxCachedInteger-minCachedInteger+1)
+	for i := range ints {
+		ints[i] = &object.Integer{Value: int64(i + minCachedInteger)}
+	}
+	return ints
+}()
+
+// 整数オブジェクトを返す。小さい整数の場合は作っておいたものを返す
+// 使い回すので、返したオブジェクトのValueを書き換えてはいけない
+func newInteger(value int64) *object.Integer {
+	if minCachedInteger <= value && value <= maxCachedInteger {
+		return cachedIntegers[value-minCachedInteger]
+	}
+	return &object.Integer{Value: value}
+}
+
+// 評価の設定。ゼロ値は制限なし
+type Options struct {
+	// 評価するノードの数の上限。信頼できないコードを実行するときに、終わらない処理を打ち切る
+	// 0以下の場合は制限しない
+	MaxSteps int
+
+	// 評価中に確保するオブジェクトの大きさの合計の上限(おおよそのバイト数)。巨大な配列や文字列を作り続ける処理を打ち切る
+	// 解放された分は差し引かないので、長く動く処理ほど上限に近づく。0以下の場合は制限しない
+	MaxAllocBytes int
+
+	// 各ノードの評価の前後に呼ぶフック。nilの場合は呼ばない
+	Hooks Hooks
+
+	// open()でファイルを開けないようにする。信頼できないコードを実行するときに使う
+	DisableFiles bool
+
+	// open()で開いたファイルを記録する。評価を終えたらCloseで閉じ忘れたファイルを閉じる
+	// nilの場合は記録しないので、閉じ忘れたファイルはプロセスが終わるまで開いたままになる
+	Files *Files
+
+	// puts()とdoc()の出力先。nilの場合は標準出力に書く
+	Stdout io.Writer
+}
+
+func (opts Options) stdout() io.Writer {
+	if opts.Stdout == nil {
+		return os.Stdout
+	}
+	return opts.Stdout
+}
+
+// 評価の様子を観察するためのフック。プロファイラやデバッガ、教材用の可視化に使う
+// フックから評価の結果を変えることはできない
+type Hooks interface {
+	// nodeをenvで評価する直前に呼ぶ
+	OnEnterNode(node ast.Node, env *object.Environment)
+	// nodeを評価した直後に呼ぶ。resultは評価結果で、エラーやnilのこともある
+	OnExitNode(node ast.Node, env *object.Environment, result object.Object)
+}
+
+// 1回の評価で共有する状態
+type evaluation struct {
+	ctx  context.Context
+	done <-chan struct{} // ctx.Done()。取り消せないctxの場合はnil
+	opts Options
+
+	steps     int // 評価したノードの数
+	allocated int // 確保したオブジェクトのおおよそのバイト数。MaxAllocBytesが設定されている場合だけ数える
+
+	calls    []callFrame                      // 実行中の関数呼び出し。最後が最も内側
+	freeVars map[*ast.BlockStatement][]string // 関数の本体ごとの自由変数
+
+	defers []deferred // 関数の外で積んだdeferの式。評価の最後に評価する
+
+	expanding bool // マクロの本体を評価している。quoteが束縛する名前を付け替える
+
+	strings object.StringPool // 文字列リテラルの値を共有する
+}
+
+// ノードを評価する
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	return EvalContext(context.Background(), node, env)
+}
+
+// ctxを確認しながらノードを評価する。ctxが取り消されるか期限を過ぎると、評価を打ち切ってエラーを返す
+// 確認は文の区切りと関数呼び出しのたびに行う。組み込み関数の実行中は打ち切れない
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment) object.Object {
+	return EvalWithOptions(ctx, node, env, Options{})
+}
+
+// optsの制限の下でノードを評価する。制限を超えた時点で評価を打ち切ってエラーを返す
+func EvalWithOptions(ctx context.Context, node ast.Node, env *object.Environment, opts Options) object.Object {
+	ev := &evaluation{ctx: ctx, done: ctx.Done(), opts: opts}
+	return ev.runDeferred(ev.eval(node, env), -1)
+}
+
+// ctxが終わっていればエラーを返す。続けてよい場合はnilを返す
+func (ev *evaluation) checkContext() *object.Error {
+	select {
+	case <-ev.done:
+		return newError(object.LIMIT_ERROR, "evaluation aborted: %s", ev.ctx.Err())
+	default:
+		return nil
+	}
+}
+
+// 評価したノードを1つ数える。上限を超えた場合はエラーを返す
+func (ev *evaluation) step() *object.Error {
+	ev.steps++
+	if ev.opts.MaxSteps > 0 && ev.steps > ev.opts.MaxSteps {
+		return newError(object.LIMIT_ERROR, "step limit exceeded (max %d)", ev.opts.MaxSteps)
+	}
+	return nil
+}
+
+func (ev *evaluation) eval(node ast.Node, env *object.Environment) object.Object {
+	if err := ev.step(); err != nil {
+		return locate(err, node)
+	}
+
+	ev.enterNode(node, env)
+	result := locate(ev.evalNode(node, env), node)
+	ev.exitNode(node, env, result)
+
+	return result
+}
+
+func (ev *evaluation) enterNode(node ast.Node, env *object.Environment) {
+	if ev.opts.Hooks != nil {
+		ev.opts.Hooks.OnEnterNode(node, env)
+	}
+}
+
+func (ev *evaluation) exitNode(node ast.Node, env *object.Environment, result object.Object) {
+	if ev.opts.Hooks != nil {
+		ev.opts.Hooks.OnExitNode(node, env, result)
+	}
+}
+
+func (ev *evaluation) evalNode(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 
 	// 文
 	case *ast.Program:
-		return evalProgram(node, env)
+		return ev.evalProgram(node, env)
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return ev.eval(node.Expression, env)
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return ev.evalBlockStatement(node, env)
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		// 値を省略したreturnはnullを返す
+		if node.ReturnValue == nil {
+			return &object.ReturnValue{Value: NULL}
+		}
+		val := ev.eval(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
+	case *ast.DeferStatement:
+		ev.pushDeferred(node.Expression, env)
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
+		val := ev.eval(node.Value, env)
 		if isError(val) {
 			return val
 		}
@@ -38,81 +196,86 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 
 	// 式
 	case *ast.IntegerLiteral:
-		return &object.Integer{Value: node.Value}
+		return ev.charge(newInteger(node.Value))
 	case *ast.StringLiteral:
-		return &object.String{Value: node.Value}
+		// ループや再帰で同じリテラルを何度も評価しても、新しく確保しない
+		s, created := ev.strings.Get(node.Value)
+		if !created {
+			return s
+		}
+		return ev.charge(s)
+	case *ast.BytesLiteral:
+		return ev.charge(&object.Bytes{Value: []byte(node.Value)})
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
+		right := ev.eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return ev.charge(evalPrefixExpression(node.Operator, right))
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
-		if isError(left) {
-			return left
-		}
-		right := Eval(node.Right, env)
-		if isError(right) {
-			return right
-		}
-		return evalInfixExpression(node.Operator, left, right)
+		return ev.evalInfixChain(node, env)
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return ev.evalIfExpression(node, env)
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		fn := &object.Function{Parameters: params, Env: env, Body: body}
+		ev.recordClosure(fn)
+		return ev.charge(fn)
 	case *ast.CallExpression:
 		// quoteはその引数を評価せずに返すことが期待されている
-		if node.Function.TokenLiteral() == "quote" {
-			return quote(node.Arguments[0], env)
+		if isQuoteCall(node) {
+			return ev.quote(node, env)
 		}
 
-		function := Eval(node.Function, env)
+		function := ev.eval(node.Function, env)
 		if isError(function) {
 			return function
 		}
-		args := evalExpressions(node.Arguments, env)
+		args := ev.evalExpressions(node.Arguments, env)
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		return traceCall(ev.applyFunction(function, args, env), node)
 	case *ast.ArrayLiteral:
-		elements := evalExpressions(node.Elements, env)
+		elements := ev.evalExpressions(node.Elements, env)
 		// エラーのときはerrorオブジェクトが1つ入っている
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return ev.charge(&object.Array{Elements: elements})
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
+		left := ev.eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		index := Eval(node.Index, env)
+		index := ev.eval(node.Index, env)
 		if isError(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return ev.evalHashLiteral(node, env)
 	}
 
 	return nil
 }
 
 // 式を評価する
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+func (ev *evaluation) evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, statement := range program.Statements {
+		if err := ev.checkContext(); err != nil {
+			return err
+		}
+
 		// return文に関連付けられた式を評価する
-		result = Eval(statement, env)
+		result = ev.eval(statement, env)
 
 		// Eval呼び出しの結果を新しいobject.ReturnValueにラップし、追跡できるようにする
 		// 直近の評価結果がobject.ReturnValueかどうかを確認し、もしそうならば評価を中断し、アンラップした値を返す。object.ReturnValueを返すのではなく、ラップされていた値のほうを返す
@@ -127,12 +290,16 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	return result
 }
 
-// ブロックを評価する。ブロック内ではreturnの挙動が異なるため、evalProgram()と処理が異なる
-func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+// ブロックを評価する。ブロック内ではreturnの挙動が異なるため、ev.evalProgram()と処理が異なる
+func (ev *evaluation) evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, statement := range block.Statements {
-		result = Eval(statement, env)
+		if err := ev.checkContext(); err != nil {
+			return err
+		}
+
+		result = ev.eval(statement, env)
 
 		if result != nil {
 			rt := result.Type()
@@ -144,6 +311,10 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 		}
 	}
 
+	// 空のブロックやletで終わるブロックは値を持たないので、関数の戻り値やif式の値としてnullにする
+	if result == nil {
+		return NULL
+	}
 	return result
 }
 
@@ -163,7 +334,7 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
 	default:
-		return newError("unknown operator: %s%s", operator, right.Type())
+		return newError(object.TYPE_ERROR, "unknown operator: %s%s", operator, right.Type())
 	}
 }
 
@@ -183,12 +354,109 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 
 // -を評価する
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
-		return newError("unknown operator: -%s", right.Type())
+	switch right := right.(type) {
+	case *object.Integer:
+		if right.Value == math.MinInt64 {
+			// 符号を反転するとint64に収まらない
+			return &object.BigInt{Value: new(big.Int).Neg(big.NewInt(right.Value))}
+		}
+		return newInteger(-right.Value)
+	case *object.BigInt:
+		return newBigInteger(new(big.Int).Neg(right.Value))
+	default:
+		return newError(object.TYPE_ERROR, "unknown operator: -%s", right.Type())
 	}
+}
 
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+// +、-、*を計算する。結果がint64に収まらない場合はokが偽になる
+func checkedIntegerOp(operator string, a, b int64) (result int64, ok bool) {
+	switch operator {
+	case "+":
+		result = a + b
+		// 同じ符号同士を足して符号が変わったら桁あふれ
+		return result, (a^result)&(b^result) >= 0
+	case "-":
+		result = a - b
+		return result, (a^b)&(a^result) >= 0
+	case "*":
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		// MinInt64 * -1 は桁あふれしても割り算で元に戻ってしまうので、先に弾く
+		if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+			return 0, false
+		}
+		result = a * b
+		return result, result/b == a
+	}
+	return 0, false
+}
+
+// 中置演算子式を評価する。1 + 2 + 3 + ...のように中置演算子式が入れ子になっている部分は
+// 再帰せずにスタックを使って評価するので、生成された長い式でもGoのスタックを使い切らない
+func (ev *evaluation) evalInfixChain(root *ast.InfixExpression, env *object.Environment) object.Object {
+	type frame struct {
+		node  *ast.InfixExpression
+		state int           // 0: 左を評価する 1: 右を評価する 2: 演算する
+		left  object.Object // 評価した左のオペランド
+	}
+
+	stack := []frame{{node: root}}
+	var result object.Object // 直前に評価したオペランドもしくは演算の結果
+
+	// 一番上のフレームを取り除く。入れ子の式はevalを通らないので、ここでフックを呼ぶ
+	pop := func() {
+		node := stack[len(stack)-1].node
+		stack = stack[:len(stack)-1]
+		if len(stack) > 0 {
+			ev.exitNode(node, env, result)
+		}
+	}
+
+	for {
+		top := &stack[len(stack)-1]
+
+		// オペランドの評価でエラーが起きたら、残りは評価せずに外側へ伝える
+		if top.state > 0 && isError(result) {
+			pop()
+			if len(stack) == 0 {
+				return result
+			}
+			continue
+		}
+
+		var operand ast.Expression
+		switch top.state {
+		case 0:
+			operand = top.node.Left
+		case 1:
+			top.left = result
+			operand = top.node.Right
+		case 2:
+			result = ev.charge(evalInfixExpression(top.node.Operator, top.left, result))
+			if isError(result) {
+				// 演算のエラーは演算子の位置を指す
+				locate(result, top.node.Token)
+			}
+			pop()
+			if len(stack) == 0 {
+				return result
+			}
+			continue
+		}
+		top.state++
+
+		if inner, ok := operand.(*ast.InfixExpression); ok {
+			if err := ev.step(); err != nil {
+				result = locate(err, inner)
+				continue
+			}
+			ev.enterNode(inner, env)
+			stack = append(stack, frame{node: inner})
+			continue
+		}
+		result = ev.eval(operand, env)
+	}
 }
 
 // 中置演算子を評価する。leftとrightによって、使う関数を変える
@@ -197,68 +465,150 @@ func evalInfixExpression(
 	left, right object.Object,
 ) object.Object {
 	switch {
+	case isBigIntOperation(left, right):
+		return evalBigIntInfixExpression(operator, left, right)
+	case (operator == "<" || operator == ">") && left.Type() == right.Type():
+		return evalCompareExpression(operator, left, right)
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
+	case operator == "+" && left.Type() == object.BYTES_OBJ && right.Type() == object.BYTES_OBJ:
+		return evalBytesConcatenation(left, right)
 	case operator == "==":
-		// ポインタの比較をしている。真偽値に関してはTRUEとFALSEの2つしかないからうまくいく
-		// *object.Integerの場合は常に新しいインスタンスを生成しているので、新しいポインタが使われる。これらの異なるインスタンスのポインタを比較すると、常にfalseになる。値自体を比較したいのであって、ラップしているオブジェクト同士を比較したいわけではない
-		// そのため、整数オペランドのチェックはswitch文の上の方にあり、現在のcase分岐よりも先にマッチする
-		return nativeBoolToBooleanObject(left == right)
+		// ポインタではなく値で比べる。配列やハッシュは中身を比べる
+		return nativeBoolToBooleanObject(object.Equal(left, right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
 	case left.Type() != right.Type():
-		return newError("type mismatch: %s %s %s",
+		return newError(object.TYPE_ERROR, "type mismatch: %s %s %s",
 			left.Type(), operator, right.Type())
 	default:
-		return newError("unknown operator: %s %s %s",
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
 	}
 }
 
-// leftとrightが整数の場合に評価に使う関数
-// *object.Integerは毎回新しいインスタンスを生成しポインタ比較できないので、値をアンラップして比較する必要がある
-func evalIntegerInfixExpression(
+// 大小比較を評価する。同じ型でComparableを実装していれば比べられる
+func evalCompareExpression(
 	operator string,
 	left, right object.Object,
 ) object.Object {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
+	l, ok := left.(object.Comparable)
+	if !ok {
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+
+	c := l.Compare(right)
+	if operator == "<" {
+		return nativeBoolToBooleanObject(c < 0)
+	}
+	return nativeBoolToBooleanObject(c > 0)
+}
+
+// 片方がBigIntで、もう片方がIntegerかBigIntの場合は任意精度で計算する
+func isBigIntOperation(left, right object.Object) bool {
+	if left.Type() != object.BIGINT_OBJ && right.Type() != object.BIGINT_OBJ {
+		return false
+	}
+	_, ok := object.AsBigInt(left)
+	_, ok2 := object.AsBigInt(right)
+	return ok && ok2
+}
+
+// 任意精度の整数の値を作る。int64に収まる場合はIntegerにする
+// BigIntはint64に収まらない値だけになるので、添字や範囲など整数を受け取る場所にそのまま使える
+func newBigInteger(value *big.Int) object.Object {
+	if value.IsInt64() {
+		return newInteger(value.Int64())
+	}
+	return &object.BigInt{Value: value}
+}
+
+// 任意精度の整数の演算。結果がint64に収まる場合はIntegerに戻す
+func evalBigIntInfixExpression(
+	operator string,
+	left, right object.Object,
+) object.Object {
+	leftVal, _ := object.AsBigInt(left)
+	rightVal, _ := object.AsBigInt(right)
 
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		return newBigInteger(new(big.Int).Add(leftVal, rightVal))
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		return newBigInteger(new(big.Int).Sub(leftVal, rightVal))
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		return newBigInteger(new(big.Int).Mul(leftVal, rightVal))
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		if rightVal.Sign() == 0 {
+			return newError(object.ZERO_DIVISION_ERROR, "division by zero")
+		}
+		// Integerと同じく0の方向に切り捨てる
+		return newBigInteger(new(big.Int).Quo(leftVal, rightVal))
 	case "<":
-		return nativeBoolToBooleanObject(leftVal < rightVal)
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) < 0)
 	case ">":
-		return nativeBoolToBooleanObject(leftVal > rightVal)
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) > 0)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) != 0)
+	default:
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
+
+// leftとrightが整数の場合に評価に使う関数
+// *object.Integerは毎回新しいインスタンスを生成しポインタ比較できないので、値をアンラップして比較する必要がある
+func evalIntegerInfixExpression(
+	operator string,
+	left, right object.Object,
+) object.Object {
+	leftVal := left.(*object.Integer).Value
+	rightVal := right.(*object.Integer).Value
+
+	switch operator {
+	case "+", "-", "*":
+		result, ok := checkedIntegerOp(operator, leftVal, rightVal)
+		if !ok {
+			// int64に収まらない場合は任意精度で計算し直す
+			return evalBigIntInfixExpression(operator, left, right)
+		}
+		return newInteger(result)
+	case "/":
+		// Goの整数除算は0で割るとpanicするので、その前にエラーにする
+		if rightVal == 0 {
+			return newError(object.ZERO_DIVISION_ERROR, "division by zero")
+		}
+		if leftVal == math.MinInt64 && rightVal == -1 {
+			return evalBigIntInfixExpression(operator, left, right)
+		}
+		return newInteger(leftVal / rightVal)
+	case "..":
+		return &object.Range{Start: leftVal, End: rightVal, Step: 1}
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %s %s %s",
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
 	}
 }
 
-func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
-	condition := Eval(ie.Condition, env)
+func (ev *evaluation) evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
+	condition := ev.eval(ie.Condition, env)
 	if isError(condition) {
 		return condition
 	}
 
 	if isTruthy(condition) {
-		return Eval(ie.Consequence, env)
+		return ev.eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		return ev.eval(ie.Alternative, env)
 	} else {
 		return NULL
 	}
@@ -277,8 +627,18 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
-func newError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+func newError(kind object.ErrorKind, format string, a ...interface{}) *object.Error {
+	return &object.Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
+
+// エラーに位置がまだ付いていなければ、atの位置を付ける
+// エラーを作った関数はノードを知らないことがあるので、エラーが伝わる途中の一番内側のノードの位置を使う
+// 深い式ではPosが遡るのに時間がかかるので、位置が必要になるまで呼ばない
+func locate(obj object.Object, at interface{ Pos() token.Position }) object.Object {
+	if errObj, ok := obj.(*object.Error); ok && !errObj.Pos.IsValid() {
+		errObj.Pos = at.Pos()
+	}
+	return obj
 }
 
 func isError(obj object.Object) bool {
@@ -300,17 +660,17 @@ func evalIdentifier(
 		return builtin
 	}
 
-	return newError("identifier not found: " + node.Value)
+	return newError(object.NAME_ERROR, "identifier not found: "+node.Value)
 }
 
-func evalExpressions(
+func (ev *evaluation) evalExpressions(
 	exps []ast.Expression,
 	env *object.Environment,
 ) []object.Object {
 	var result []object.Object // 評価した引数のリストなどとして利用
 
 	for _, e := range exps {
-		evaluated := Eval(e, env)
+		evaluated := ev.eval(e, env)
 		if isError(evaluated) {
 			return []object.Object{evaluated}
 		}
@@ -320,28 +680,80 @@ func evalExpressions(
 	return result
 }
 
-// 関数を環境下で適用する
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// 関数を環境下で適用する。callerは呼び出し元の環境
+func (ev *evaluation) applyFunction(fn object.Object, args []object.Object, caller *object.Environment) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
+		if err := ev.checkContext(); err != nil {
+			return err
+		}
+		if MaxCallDepth > 0 && caller.CallDepth() >= MaxCallDepth {
+			return newError(object.LIMIT_ERROR, "maximum recursion depth exceeded")
+		}
+		if len(args) != len(fn.Parameters) {
+			return newError(object.ARITY_ERROR, "wrong number of arguments. got=%d, want=%d",
+				len(args), len(fn.Parameters))
+		}
+		if err := ev.chargeBytes(objectHeaderSize + bindingSize*len(fn.Parameters)); err != nil {
+			return err
+		}
+		extendedEnv := extendFunctionEnv(fn, args, caller)
+
+		ev.calls = append(ev.calls, callFrame{env: extendedEnv})
+		evaluated := ev.runDeferred(ev.eval(fn.Body, extendedEnv), len(ev.calls)-1)
+		frame := ev.calls[len(ev.calls)-1]
+		ev.calls = ev.calls[:len(ev.calls)-1]
+		ev.pruneClosures(frame)
+
 		return unwrapReturnValue(evaluated)
 
 	case *object.Builtin:
-		return fn.Fn(args...)
+		if err := fn.CheckArity(len(args)); err != nil {
+			return err
+		}
+		switch fn {
+		case rescueBuiltin:
+			return ev.rescue(args, caller)
+		case openBuiltin:
+			return openFile(args, ev.opts)
+		case putsBuiltin:
+			return puts(ev.opts.stdout(), args)
+		case docBuiltin:
+			return doc(ev.opts.stdout(), args)
+		case arrayBuiltin:
+			return ev.array(args)
+		}
+		// 組み込み関数の中で確保したものは、結果の大きさで見積もる
+		return ev.charge(fn.Fn(args...))
 
 	default:
-		return newError("not a function: %s", fn.Type())
+		return newError(object.TYPE_ERROR, "not a function: %s", fn.Type())
 	}
 }
 
+// 関数呼び出しの結果がエラーの場合は、呼び出しをスタックトレースに追加する
+func traceCall(result object.Object, call *ast.CallExpression) object.Object {
+	errObj, ok := result.(*object.Error)
+	if !ok {
+		return result
+	}
+
+	name := "<anonymous>"
+	if ident, ok := call.Function.(*ast.Identifier); ok {
+		name = ident.Value
+	}
+	errObj.Trace = append(errObj.Trace, object.Frame{Function: name, Pos: call.Pos()})
+
+	return errObj
+}
+
 // 新しい環境で拡張する
 func extendFunctionEnv(
 	fn *object.Function,
 	args []object.Object,
+	caller *object.Environment,
 ) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+	env := object.NewCallEnvironment(fn.Env, caller)
 
 	for paramIdx, param := range fn.Parameters {
 		env.Set(param.Value, args[paramIdx])
@@ -363,15 +775,32 @@ func evalStringInfixExpression(
 	operator string,
 	left, right object.Object,
 ) object.Object {
-	// +だけをサポート
-	if operator != "+" {
-		return newError("unknown operator: %s %s %s",
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	// 連結と比較だけをサポート
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
 	}
+}
 
-	leftVal := left.(*object.String).Value
-	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
+// バイト列を連結する。元のバイト列と領域を共有しないように新しく確保する
+func evalBytesConcatenation(left, right object.Object) object.Object {
+	leftVal := left.(*object.Bytes).Value
+	rightVal := right.(*object.Bytes).Value
+
+	value := make([]byte, 0, len(leftVal)+len(rightVal))
+	value = append(value, leftVal...)
+	value = append(value, rightVal...)
+	return &object.Bytes{Value: value}
 }
 
 // 添字の評価
@@ -379,10 +808,14 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.BYTES_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalBytesIndexExpression(left, index)
+	case left.Type() == object.RANGE_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalRangeIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
-		return newError("index operator not supported: %s", left.Type())
+		return newError(object.TYPE_ERROR, "index operator not supported: %s", left.Type())
 	}
 }
 
@@ -399,12 +832,35 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
+// バイト列の添字は、そのバイトの値を整数で返す
+func evalBytesIndexExpression(bytes, index object.Object) object.Object {
+	value := bytes.(*object.Bytes).Value
+	idx := index.(*object.Integer).Value
+
+	if idx < 0 || idx >= int64(len(value)) {
+		return NULL
+	}
+
+	return newInteger(int64(value[idx]))
+}
+
+func evalRangeIndexExpression(rng, index object.Object) object.Object {
+	r := rng.(*object.Range)
+	idx := index.(*object.Integer).Value
+
+	if idx < 0 || idx >= r.Len() {
+		return NULL
+	}
+
+	return newInteger(r.At(idx))
+}
+
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 
 	key, ok := index.(object.Hashable)
 	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
+		return newError(object.TYPE_ERROR, "unusable as hash key: %s", index.Type())
 	}
 
 	pair, ok := hashObject.Pairs[key.HashKey()]
@@ -415,14 +871,15 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 	return pair.Value
 }
 
-func evalHashLiteral(
+func (ev *evaluation) evalHashLiteral(
 	node *ast.HashLiteral,
 	env *object.Environment,
 ) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
+	for _, keyNode := range node.Keys() {
+		valueNode := node.Pairs[keyNode]
+		key := ev.eval(keyNode, env)
 		if isError(key) {
 			return key
 		}
@@ -430,17 +887,16 @@ func evalHashLiteral(
 		// 評価の結果はobject.Hashableインターフェースを実装している必要がある
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
-			return newError("unusable as hash key: %s", key.Type())
+			return newError(object.TYPE_ERROR, "unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, env)
+		value := ev.eval(valueNode, env)
 		if isError(value) {
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Set(hashKey.HashKey(), object.HashPair{Key: key, Value: value})
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return ev.charge(hash)
 }
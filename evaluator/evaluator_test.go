@@ -1,10 +1,19 @@
 package evaluator
 
 import (
+	"context"
+	"fmt"
+	"monkey/ast"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"monkey/token"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -26,6 +35,10 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"9223372036854775806 + 1", 9223372036854775807},
+		{"-9223372036854775807 - 1", -9223372036854775808},
+		{"-4611686018427387904 * 2", -9223372036854775808},
+		{"-9223372036854775807 * -1", 9223372036854775807},
 	}
 
 	for _, tt := range tests {
@@ -82,6 +95,25 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"( 1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{`"a" == "a"`, true},
+		{`"a" != "b"`, true},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"abc" > "ab"`, true},
+		// 配列とハッシュは中身で比べる
+		{"[1, [2, 3]] == [1, [2, 3]]", true},
+		{"[1, 2] == [1, 2, 3]", false},
+		{"[1, 2] != [2, 1]", true},
+		{`{"a": [1], 2: true} == {2: true, "a": [1]}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"b": 1}`, false},
+		// 型が違えば等しくない
+		{"1 == true", false},
+		{`1 != "1"`, true},
+		{"[] == {}", false},
+		// 関数は同じものだけが等しい
+		{"let f = fn() { 1 }; f == f", true},
+		{"fn() { 1 } == fn() { 1 }", false},
 	}
 
 	for _, tt := range tests {
@@ -135,6 +167,8 @@ func TestIfElseExpressions(t *testing.T) {
 		{"if (1 > 2) { 10 }", nil},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (true) { let a = 1 }", nil},
+		{"if (true) {}", nil},
 	}
 
 	for _, tt := range tests {
@@ -205,6 +239,19 @@ f(10);`,
 	}
 }
 
+func TestBareReturnStatement(t *testing.T) {
+	tests := []string{
+		"return;",
+		"let f = fn() { return; 10 }; f();",
+		"let f = fn() { if (true) { return } 10 }; f();",
+	}
+
+	for _, input := range tests {
+		evaluated := testEval(input)
+		testNullObject(t, evaluated)
+	}
+}
+
 func TestErrorHandling(t *testing.T) {
 	tests := []struct {
 		input           string
@@ -249,10 +296,26 @@ func TestErrorHandling(t *testing.T) {
 			`"Hello" - "World"`,
 			"unknown operator: STRING - STRING",
 		},
+		{
+			"true < false",
+			"unknown operator: BOOLEAN < BOOLEAN",
+		},
+		{
+			`1 < "a"`,
+			"type mismatch: INTEGER < STRING",
+		},
 		{
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			"5 / 0",
+			"division by zero",
+		},
+		{
+			"let f = fn(x) { 10 / (x - x) }; f(3);",
+			"division by zero",
+		},
 	}
 
 	for _, tt := range tests {
@@ -336,6 +399,419 @@ func TestFunctionApplication(t *testing.T) {
 	for _, tt := range tests {
 		testIntegerObject(t, testEval(tt.input), tt.expected)
 	}
+
+	// 値を持たない本体はnullを返す
+	for _, input := range []string{"fn() { let a = 1; }()", "fn() {}()"} {
+		testNullObject(t, testEval(input))
+	}
+}
+
+func TestArrowFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let double = x => x * 2; double(5);", 10},
+		{"let add = (x, y) => x + y; add(2, 3);", 5},
+		{"(() => 7)()", 7},
+		{"let adder = x => y => x + y; adder(1)(2);", 3},
+		{"let f = x => { let y = x * 3; y + 1 }; f(2);", 7},
+		{"let apply = fn(f, x) { f(x) }; apply(x => x - 1, 10);", 9},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestMaxCallDepth(t *testing.T) {
+	defer func(n int) { MaxCallDepth = n }(MaxCallDepth)
+	MaxCallDepth = 100
+
+	input := "let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } };"
+	testIntegerObject(t, testEval(input+"f(99)"), 0)
+
+	evaluated := testEval(input + "f(100)")
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "maximum recursion depth exceeded" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+
+	// 既定の上限でも、無限の再帰がGoのスタックを使い切らない
+	MaxCallDepth = 10000
+	evaluated = testEval("let g = fn() { g() }; g();")
+	if _, ok := evaluated.(*object.Error); !ok {
+		t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected object.ErrorKind
+	}{
+		{"5 + true", object.TYPE_ERROR},
+		{"-true", object.TYPE_ERROR},
+		{"1(2)", object.TYPE_ERROR},
+		{`{"a": 1}[fn(x) { x }]`, object.TYPE_ERROR},
+		{"foobar", object.NAME_ERROR},
+		{"1 / 0", object.ZERO_DIVISION_ERROR},
+		{"len(1, 2)", object.ARITY_ERROR},
+		{"fn(x, y) { x }(1)", object.ARITY_ERROR},
+		{"let f = fn() { f() }; f()", object.LIMIT_ERROR},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Kind != tt.expected {
+			t.Errorf("wrong kind for %q. want=%s, got=%s", tt.input, tt.expected, errObj.Kind)
+		}
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5 + true;", "1:3"},
+		{"let x = 1;\n  x + (2 * true);", "2:10"},
+		{"-true", "1:1"},
+		{"foobar", "1:1"},
+		{"let a = 1;\nlet b = a + missing;", "2:13"},
+		{"[1, 2][true]", "1:1"},
+		{"len(1)", "1:1"},
+		// 関数の中で起きたエラーは、呼び出し式ではなく関数の中の位置を指す
+		{"let f = fn(x) {\n  x / 0\n};\nf(1);", "2:5"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Pos.String() != tt.expected {
+			t.Errorf("wrong position for %q. want=%s, got=%s", tt.input, tt.expected, errObj.Pos)
+		}
+	}
+}
+
+func TestStackTrace(t *testing.T) {
+	input := `let inner = fn(x) { x + y };
+let outer = fn(x) {
+  inner(x)
+};
+outer(1);`
+
+	evaluated := testEval(input)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	expected := []object.Frame{
+		{Function: "inner", Pos: token.Position{Line: 3, Column: 3}},
+		{Function: "outer", Pos: token.Position{Line: 5, Column: 1}},
+	}
+	if !reflect.DeepEqual(errObj.Trace, expected) {
+		t.Errorf("wrong trace. want=%+v, got=%+v", expected, errObj.Trace)
+	}
+
+	// 名前のない関数や組み込み関数の呼び出しも記録する
+	evaluated = testEval(`fn() { len(1) }()`)
+	errObj, ok = evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	expectedInspect := "ERROR: argument to `len` not supported, got INTEGER\n    at len (1:8)\n    at <anonymous> (1:1)"
+	if errObj.Inspect() != expectedInspect {
+		t.Errorf("wrong Inspect. want=%q, got=%q", expectedInspect, errObj.Inspect())
+	}
+}
+
+func TestEvalContext(t *testing.T) {
+	program := parser.New(lexer.New(`
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+fib(35);
+`)).ParseProgram()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	tests := []struct {
+		ctx      context.Context
+		expected string
+	}{
+		{canceled, "evaluation aborted: context canceled"},
+		{timeout, "evaluation aborted: context deadline exceeded"},
+	}
+
+	for _, tt := range tests {
+		evaluated := EvalContext(tt.ctx, program, object.NewEnvironment())
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong error message. want=%q, got=%q", tt.expected, errObj.Message)
+		}
+	}
+
+	// 終わっていないctxでは普通に評価する
+	evaluated := EvalContext(context.Background(), parser.New(lexer.New("1 + 2")).ParseProgram(), object.NewEnvironment())
+	testIntegerObject(t, evaluated, 3)
+}
+
+func TestMaxSteps(t *testing.T) {
+	tests := []struct {
+		input    string
+		maxSteps int
+		expected interface{}
+	}{
+		// Program、ExpressionStatement、InfixExpression、2つのIntegerLiteralで5ステップ
+		{"1 + 2", 5, 3},
+		{"1 + 2", 4, "step limit exceeded (max 4)"},
+		{"1 + 2", 0, 3},
+		{"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(1000);", 1000, "step limit exceeded (max 1000)"},
+		{"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(10);", 1000, 0},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		opts := Options{MaxSteps: tt.maxSteps}
+		evaluated := EvalWithOptions(context.Background(), program, object.NewEnvironment(), opts)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. want=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestMaxAllocBytes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let double = fn(s, n) { if (n == 0) { len(s) } else { double(s + s, n - 1) } }; double("x", 40);`, "memory limit exceeded (max 1048576 bytes)"},
+		{`let grow = fn(a, n) { if (n == 0) { len(a) } else { grow(push(a, n), n - 1) } }; grow([], 5000);`, "memory limit exceeded (max 1048576 bytes)"},
+		{`let double = fn(s, n) { if (n == 0) { len(s) } else { double(s + s, n - 1) } }; double("x", 10);`, 1024},
+		// 範囲全体を作ってからではなく、要素を追加する途中で打ち切る
+		{`len(array(range(1000000000000)))`, "memory limit exceeded (max 1048576 bytes)"},
+		{`len(array(0..1000000000000))`, "memory limit exceeded (max 1048576 bytes)"},
+		{`let a = array; len(a(range(1000000000000)))`, "memory limit exceeded (max 1048576 bytes)"},
+		{`len(array(range(1000)))`, 1000},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		opts := Options{MaxAllocBytes: 1 << 20}
+		evaluated := EvalWithOptions(context.Background(), program, object.NewEnvironment(), opts)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. want=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestDeepInfixChain(t *testing.T) {
+	// 再帰で評価するとスタックが足りなくなるように、上限を小さくする
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+
+	const n = 100000
+
+	// 左に深い式は構文解析器がそのまま作る
+	input := "0" + strings.Repeat(" + 1", n)
+	testIntegerObject(t, testEval(input), n)
+
+	// 右に深い式は直接組み立てる
+	var exp ast.Expression = &ast.IntegerLiteral{Value: 0}
+	for i := 0; i < n; i++ {
+		exp = &ast.InfixExpression{Left: &ast.IntegerLiteral{Value: 1}, Operator: "+", Right: exp}
+	}
+	testIntegerObject(t, Eval(exp, object.NewEnvironment()), n)
+
+	// 途中のエラーで評価を打ち切る
+	evaluated := testEval("1" + strings.Repeat(" + 1", n) + " + true" + strings.Repeat(" + 1", n))
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "type mismatch: INTEGER + BOOLEAN" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestSmallIntegerCache(t *testing.T) {
+	// 範囲内の整数は、リテラルでも計算結果でも同じオブジェクトになる
+	a := testEval("let a = 100; a")
+	b := testEval("50 * 2")
+	if a != b {
+		t.Errorf("small integers are not shared. got=%p and %p", a, b)
+	}
+	testIntegerObject(t, testEval("-128"), -128)
+	testIntegerObject(t, testEval("255 + 1"), 256)
+
+	// 範囲外の整数は毎回作る
+	if testEval("1000") == testEval("1000") {
+		t.Errorf("large integers must not be shared")
+	}
+
+	program := parser.New(lexer.New("1 + 2 * 3 - 4")).ParseProgram()
+	env := object.NewEnvironment()
+	allocs := testing.AllocsPerRun(100, func() { Eval(program, env) })
+	if allocs > 2 {
+		t.Errorf("too many allocations. got=%v", allocs)
+	}
+}
+
+func TestClosureEnvironmentPruning(t *testing.T) {
+	input := `
+let make = fn(unused) {
+  let big = [1, 2, 3];
+  let x = 10;
+  let get = fn(y) { x + y };
+  get
+};
+make(0);
+`
+	evaluated := testEval(input)
+	fn, ok := evaluated.(*object.Function)
+	if !ok {
+		t.Fatalf("object is not Function. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	if _, ok := fn.Env.Get("x"); !ok {
+		t.Errorf("referenced binding x was pruned")
+	}
+	for _, name := range []string{"big", "unused", "get"} {
+		if _, ok := fn.Env.Get(name); ok {
+			t.Errorf("unreferenced binding %s was kept", name)
+		}
+	}
+
+	// 絞った後も、名前の解決結果は変わらない
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let newAdder = fn(x) { fn(y) { x + y } }; newAdder(2)(3);", 5},
+		{"let f = fn() { let g = fn(n) { if (n == 0) { 0 } else { g(n - 1) } }; g }; f()(5);", 0},
+		{"let f = fn() { let a = fn() { b() }; let b = fn() { 7 }; a }; f()();", 7},
+		{"let x = 1; let f = fn() { fn() { x } }; let x = 2; f()();", 2},
+		{"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3);", 6},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestSyncedEnvironmentConcurrentEval(t *testing.T) {
+	env := object.NewSyncedEnvironment()
+	Eval(parser.New(lexer.New("let double = fn(x) { let y = x * 2; y };")).ParseProgram(), env)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			input := fmt.Sprintf("let r%d = double(%d); r%d", i, i, i)
+			evaluated := Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+			testIntegerObject(t, evaluated, int64(i*2))
+		}(i)
+	}
+	wg.Wait()
+}
+
+// 呼ばれたフックを記録する
+type recordingHooks struct {
+	events []string
+	depth  int
+}
+
+func (h *recordingHooks) OnEnterNode(node ast.Node, env *object.Environment) {
+	h.events = append(h.events, fmt.Sprintf("%senter %s", strings.Repeat(" ", h.depth), node.String()))
+	h.depth++
+}
+
+func (h *recordingHooks) OnExitNode(node ast.Node, env *object.Environment, result object.Object) {
+	h.depth--
+	inspected := "<nil>"
+	if result != nil {
+		inspected = result.Inspect()
+	}
+	h.events = append(h.events, fmt.Sprintf("%sexit %s = %s", strings.Repeat(" ", h.depth), node.String(), inspected))
+}
+
+func TestHooks(t *testing.T) {
+	hooks := &recordingHooks{}
+	program := parser.New(lexer.New("1 + 2 * 3")).ParseProgram()
+	evaluated := EvalWithOptions(context.Background(), program, object.NewEnvironment(), Options{Hooks: hooks})
+	testIntegerObject(t, evaluated, 7)
+
+	expected := []string{
+		"enter (1 + (2 * 3))",
+		" enter (1 + (2 * 3))",
+		"  enter (1 + (2 * 3))",
+		"   enter 1",
+		"   exit 1 = 1",
+		"   enter (2 * 3)",
+		"    enter 2",
+		"    exit 2 = 2",
+		"    enter 3",
+		"    exit 3 = 3",
+		"   exit (2 * 3) = 6",
+		"  exit (1 + (2 * 3)) = 7",
+		" exit (1 + (2 * 3)) = 7",
+		"exit (1 + (2 * 3)) = 7",
+	}
+	if !reflect.DeepEqual(hooks.events, expected) {
+		t.Errorf("wrong events.\nwant=%q\ngot=%q", expected, hooks.events)
+	}
+
+	// 関数の本体やエラーも観察できる
+	hooks = &recordingHooks{}
+	program = parser.New(lexer.New("let f = fn(x) { x + true }; f(1);")).ParseProgram()
+	EvalWithOptions(context.Background(), program, object.NewEnvironment(), Options{Hooks: hooks})
+	last := hooks.events[len(hooks.events)-1]
+	if !strings.HasPrefix(last, "exit let f = fn(x) (x + true);f(1) = ERROR: type mismatch: INTEGER + BOOLEAN") {
+		t.Errorf("wrong last event. got=%q", last)
+	}
+	if hooks.depth != 0 {
+		t.Errorf("enter and exit are unbalanced. depth=%d", hooks.depth)
+	}
 }
 
 func TestStringLiteral(t *testing.T) {
@@ -352,6 +828,22 @@ func TestStringLiteral(t *testing.T) {
 	}
 }
 
+func TestStringInterning(t *testing.T) {
+	evaluated := testEval(`let f = fn() { "abc" }; [f(), f(), "abc" + ""]`)
+	array, ok := evaluated.(*object.Array)
+	if !ok {
+		t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	// 同じリテラルは同じオブジェクトになる。計算して作った文字列は共有しない
+	if array.Elements[0] != array.Elements[1] {
+		t.Errorf("string literals were not interned")
+	}
+	if array.Elements[0] == array.Elements[2] {
+		t.Errorf("computed string should be a new object")
+	}
+}
+
 func TestStringConcatenation(t *testing.T) {
 	input := `"Hello" + " " + "World!`
 
@@ -366,6 +858,213 @@ func TestStringConcatenation(t *testing.T) {
 	}
 }
 
+func TestBytes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`b"abc"`, `b"abc"`},
+		{`b"abc"[0]`, `97`},
+		{`b"abc"[3]`, `null`},
+		{`b"abc"[-1]`, `null`},
+		{`len(b"abc")`, `3`},
+		{`b"ab" + b"c"`, `b"abc"`},
+		{`b"abc" == b"abc"`, `true`},
+		{`b"abc" == "abc"`, `false`},
+		{`b"abc" < b"abd"`, `true`},
+		{`{b"k": 1}[b"k"]`, `1`},
+		{`bytes("hi")`, `b"hi"`},
+		{`bytes([0, 104, 255])`, `b"\x00h\xff"`},
+		{`string(b"hi")`, `hi`},
+		{`slice(b"hello", 1, 3)`, `b"el"`},
+		{`slice("hello", 1, 3)`, `el`},
+		{`slice([1, 2, 3], 0, 2)`, `[1, 2]`},
+		{`slice([1, 2, 3], 3, 3)`, `[]`},
+		{`b"a" - b"b"`, `ERROR: unknown operator: BYTES - BYTES`},
+		{`bytes([256])`, "ERROR: element 0 of argument to `bytes` is not a byte: 256"},
+		{`string(1)`, "ERROR: argument to `string` not supported, got INTEGER"},
+		{`slice(b"abc", 2, 1)`, `ERROR: slice bounds out of range [2:1] with length 3`},
+		{`slice(b"abc", 0, 4)`, `ERROR: slice bounds out of range [0:4] with length 3`},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			// スタックトレースは比べない
+			got = "ERROR: " + errObj.Message
+		}
+		if got != tt.expected {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1..4", "1..4"},
+		{"range(5)", "0..5"},
+		{"range(10, 0, -3)", "range(10, 0, -3)"},
+		{"len(1..4)", "3"},
+		{"len(4..1)", "0"},
+		{"len(range(10, 0, -3))", "4"},
+		{"(1..4)[0]", "1"},
+		{"(1..4)[2]", "3"},
+		{"(1..4)[3]", "null"},
+		{"range(10, 0, -3)[3]", "1"},
+		// 配列を作らないので、大きな範囲も扱える
+		{"len(0..9223372036854775807)", "9223372036854775807"},
+		{"(0..9223372036854775807)[9223372036854775806]", "9223372036854775806"},
+		{"contains(1..4, 3)", "true"},
+		{"contains(1..4, 4)", "false"},
+		{"contains(range(0, 10, 3), 9)", "true"},
+		{"contains(range(0, 10, 3), 8)", "false"},
+		{`contains(1..4, "a")`, "false"},
+		{"contains([1, [2]], [2])", "true"},
+		{`contains({"a": 1}, "a")`, "true"},
+		{`contains("monkey", "key")`, "true"},
+		{"1..4 == range(1, 4)", "true"},
+		{"range(1, 4, 5) == 1..2", "true"},
+		{"1..1 == 5..2", "true"},
+		{"1..4 == 1..5", "false"},
+		{"range(1, 10, 0)", "ERROR: step of `range` must not be zero"},
+		{"range()", "ERROR: wrong number of arguments. got=0, want=1..3"},
+		{`range("a")`, "ERROR: arguments to `range` must be INTEGER, got STRING"},
+		{"contains(1, 1)", "ERROR: argument to `contains` not supported, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = "ERROR: " + errObj.Message
+		}
+		if got != tt.expected {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestArrayBuiltin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`array([1, 2])`, "[1, 2]"},
+		{`array(range(0, 10, 4))`, "[0, 4, 8]"},
+		{`array({"b": 1, "a": 2})`, "[b, a]"},
+		{`array("héllo")`, "[h, é, l, l, o]"},
+		{`array("")`, "[]"},
+		{`array(1)`, "ERROR: argument to `array` must be iterable, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = "ERROR: " + errObj.Message
+		}
+		if got != tt.expected {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`frozen([1])`, false},
+		{`frozen(freeze([1]))`, true},
+		{`let config = {"a": [1]}; freeze(config); frozen(config["a"])`, true},
+		// pushは新しい配列を返すので、元の配列が変更できなくても変更できる
+		{`frozen(push(freeze([1]), 2))`, false},
+		{`frozen(1)`, true},
+		{`frozen("a")`, true},
+	}
+
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+
+	if !object.Equal(testEval(`freeze([1, 2])`), testEval(`[1, 2]`)) {
+		t.Errorf("freeze must return its argument")
+	}
+}
+
+func TestBigInt(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		typ      object.ObjectType
+	}{
+		// int64に収まらない結果はBigIntになる
+		{"9223372036854775807 + 1", "9223372036854775808", object.BIGINT_OBJ},
+		{"-9223372036854775807 - 2", "-9223372036854775809", object.BIGINT_OBJ},
+		{"4611686018427387904 * 2", "9223372036854775808", object.BIGINT_OBJ},
+		{"let min = -9223372036854775807 - 1; min * -1", "9223372036854775808", object.BIGINT_OBJ},
+		{"let min = -9223372036854775807 - 1; min / -1", "9223372036854775808", object.BIGINT_OBJ},
+		{"let min = -9223372036854775807 - 1; -min", "9223372036854775808", object.BIGINT_OBJ},
+		{`bigint("123456789012345678901234567890") + 1`, "123456789012345678901234567891", object.BIGINT_OBJ},
+		{`-bigint("9223372036854775808")`, "-9223372036854775808", object.INTEGER_OBJ},
+		// int64に収まる結果はIntegerに戻る
+		{"9223372036854775807 + 1 - 1", "9223372036854775807", object.INTEGER_OBJ},
+		{"(4611686018427387904 * 4) / 8", "2305843009213693952", object.INTEGER_OBJ},
+		{"bigint(2) * 3", "6", object.INTEGER_OBJ},
+		{"bigint(-7) / 2", "-3", object.INTEGER_OBJ},
+		{`bigint("0x10")`, "16", object.INTEGER_OBJ},
+		// Integerに戻った値は添字や範囲に使える
+		{"[10, 20, 30][9223372036854775807 + 1 - 9223372036854775807]", "20", object.INTEGER_OBJ},
+		{"[10, 20, 30][bigint(1)]", "20", object.INTEGER_OBJ},
+		{"1..(9223372036854775807 + 3 - 9223372036854775807)", "1..3", object.RANGE_OBJ},
+		{`slice("abc", 0, bigint("2"))`, "ab", object.STRING_OBJ},
+		{"let f = fn(n) { if (n < 2) { 1 } else { n * f(n - 1) } }; f(25)", "15511210043330985984000000", object.BIGINT_OBJ},
+		// 整数とは値で比べる
+		{"bigint(5) == 5", "true", object.BOOLEAN_OBJ},
+		{"5 != bigint(5)", "false", object.BOOLEAN_OBJ},
+		{"bigint(5) < 6", "true", object.BOOLEAN_OBJ},
+		{"9223372036854775807 + 1 > 9223372036854775807", "true", object.BOOLEAN_OBJ},
+		{"[1, bigint(2)] == [1, 2]", "true", object.BOOLEAN_OBJ},
+		{`{5: "five"}[bigint(5)]`, "five", object.STRING_OBJ},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Type() != tt.typ || evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: wrong result. want=%s %s, got=%s %s",
+				tt.input, tt.typ, tt.expected, evaluated.Type(), evaluated.Inspect())
+		}
+	}
+}
+
+func TestBigIntErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"bigint(1) / 0", "division by zero"},
+		{`bigint("9223372036854775808") + true`, "type mismatch: BIGINT + BOOLEAN"},
+		{`bigint("12a")`, `cannot convert "12a" to BIGINT`},
+		{"bigint(true)", "argument to `bigint` not supported, got BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: object is not Error. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("%s: wrong error message. want=%q, got=%q", tt.input, tt.expected, errObj.Message)
+		}
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -382,12 +1081,15 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`first([])`, nil},
 		{`first(1)`, "argument to `first` must be ARRAY, got INTEGER"},
 		{`last([1, 2, 3])`, 3},
-		{`last[]`, nil},
+		{`last([])`, nil},
 		{`last(1)`, "argument to `last` must be ARRAY, got INTEGER"},
 		{`rest([1, 2, 3])`, []int{2, 3}},
 		{`rest([])`, nil},
 		{`push([], 1)`, []int{1}},
 		{`push(1, 1)`, "argument to `push` must be ARRAY, got INTEGER"},
+		{`keys(1)`, "argument to `keys` must be HASH, got INTEGER"},
+		{`doc("nope")`, `no builtin function named "nope"`},
+		{`doc(1)`, "argument to `doc` must be STRING or BUILTIN, got INTEGER"},
 	}
 
 	for _, tt := range tests {
@@ -396,6 +1098,22 @@ func TestBuiltinFunctions(t *testing.T) {
 		switch expected := tt.expected.(type) {
 		case int:
 			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case []int:
+			array, ok := evaluated.(*object.Array)
+			if !ok {
+				t.Errorf("obj not Array. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if len(array.Elements) != len(expected) {
+				t.Errorf("wrong num of elements. want=%d, got=%d",
+					len(expected), len(array.Elements))
+				continue
+			}
+			for i, expectedElem := range expected {
+				testIntegerObject(t, array.Elements[i], int64(expectedElem))
+			}
 		case string:
 			errObj, ok := evaluated.(*object.Error)
 			if !ok {
@@ -411,6 +1129,70 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+func TestStdout(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`puts(1, "a")`, "1\na\n"},
+		// 変数に入れたものや、rescueから呼ぶものも同じ出力先に書く
+		{`let p = puts; p(2)`, "2\n"},
+		{`rescue(fn() { puts(3) }, fn(e) { e })`, "3\n"},
+		{`doc("len")`, formatBuiltinDoc(builtins["len"]) + "\n"},
+	}
+
+	for _, tt := range tests {
+		var out strings.Builder
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		EvalWithOptions(context.Background(), program, object.NewEnvironment(), Options{Stdout: &out})
+		if out.String() != tt.expected {
+			t.Errorf("%s: wrong output. want=%q, got=%q", tt.input, tt.expected, out.String())
+		}
+	}
+}
+
+func TestBuiltinMetadata(t *testing.T) {
+	for _, name := range BuiltinNames() {
+		builtin := builtins[name]
+		if builtin.Name != name {
+			t.Errorf("builtin %q has wrong name %q", name, builtin.Name)
+		}
+		if builtin.MinArgs == 0 && builtin.MaxArgs == 0 {
+			t.Errorf("builtin %q has no arity", name)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`first()`, "wrong number of arguments. got=0, want=1"},
+		{`push([])`, "wrong number of arguments. got=1, want=2"},
+		{`open("a", "r", 1)`, "wrong number of arguments. got=3, want=1..2"},
+		{`rescue(fn() { 1 })`, "wrong number of arguments. got=1, want=2"},
+	}
+	for _, tt := range tests {
+		errObj, ok := testEval(tt.input).(*object.Error)
+		if !ok {
+			t.Errorf("%s: expected an error", tt.input)
+			continue
+		}
+		if errObj.Kind != object.ARITY_ERROR || errObj.Message != tt.expected {
+			t.Errorf("%s: wrong error. want=%s %q, got=%s %q",
+				tt.input, object.ARITY_ERROR, tt.expected, errObj.Kind, errObj.Message)
+		}
+	}
+}
+
+func TestBuiltinDocs(t *testing.T) {
+	for _, name := range BuiltinNames() {
+		builtin := builtins[name]
+		if builtin.Usage == "" || builtin.Doc == "" {
+			t.Errorf("builtin %q has no documentation", name)
+		}
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	input := "[1 ,2 * 2, 3 + 3]"
 
@@ -529,6 +1311,30 @@ let two = "two";
 	}
 }
 
+func TestHashInsertionOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, 3: 3, true: 4}`, `{b: 1, a: 2, 3: 3, true: 4}`},
+		// 同じキーは最初の位置のまま、値だけ後のもので上書きされる
+		{`{"a": 1, "b": 2, "a": 3}`, `{a: 3, b: 2}`},
+		{`keys({"z": 1, "y": 2, "x": 3})`, `[z, y, x]`},
+		{`keys({})`, `[]`},
+		{`let k = "k"; keys({"b": 1, k: 2, "a" + "a": 3})`, `[b, k, aa]`},
+	}
+
+	for _, tt := range tests {
+		// mapの反復順序はランダムなので、何度か評価しても同じ結果になることを確かめる
+		for i := 0; i < 10; i++ {
+			evaluated := testEval(tt.input)
+			if evaluated.Inspect() != tt.expected {
+				t.Fatalf("%s: wrong result. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+			}
+		}
+	}
+}
+
 func TestHashIndexExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -0,0 +1,191 @@
+package evaluator
+
+import (
+	"io"
+	"monkey/object"
+	"os"
+	"strings"
+	"sync"
+)
+
+// openは評価の設定でファイルの使用を禁止できるように、組み込み関数の中ではなく評価器で処理する
+var openBuiltin = &object.Builtin{
+	Name:    "open",
+	Usage:   `open(path[, mode])`,
+	Doc:     `opens a file for reading ("r", the default), writing ("w") or appending ("a")`,
+	MinArgs: 1,
+	MaxArgs: 2,
+	Fn: func(args ...object.Object) object.Object {
+		return newError(object.TYPE_ERROR, "`open` can only be called from Monkey code")
+	},
+}
+
+// 評価中にopen()で開いたファイルの記録。閉じ忘れたファイルを、実行やREPLを終えるときにCloseでまとめて閉じる
+// 評価ごとに作ってOptions.Filesに渡す。REPLのように同じファイルを入力をまたいで使う場合は、同じものを渡し続ける
+type Files struct {
+	mu    sync.Mutex
+	files map[*object.File]struct{}
+}
+
+func NewFiles() *Files {
+	return &Files{files: make(map[*object.File]struct{})}
+}
+
+func (fs *Files) add(f *object.File) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	// close()で閉じたファイルは、ここで記録から外す
+	for opened := range fs.files {
+		if opened.Closed {
+			delete(fs.files, opened)
+		}
+	}
+	fs.files[f] = struct{}{}
+}
+
+// 記録したファイルのうち、開いたままのものを全て閉じる
+func (fs *Files) Close() {
+	fs.mu.Lock()
+	files := fs.files
+	fs.files = make(map[*object.File]struct{})
+	fs.mu.Unlock()
+
+	for f := range files {
+		if !f.Closed {
+			closeFile(f)
+		}
+	}
+}
+
+var fileModes = map[string]int{
+	"r": os.O_RDONLY,
+	"w": os.O_WRONLY | os.O_CREATE | os.O_TRUNC,
+	"a": os.O_WRONLY | os.O_CREATE | os.O_APPEND,
+}
+
+func init() {
+	builtins["open"] = openBuiltin
+	builtins["read"] = &object.Builtin{
+		Name:    "read",
+		Usage:   "read(file)",
+		Doc:     "returns the rest of a file as a string",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: fileFunc("read", func(f *object.File, args []object.Object) object.Object {
+			data, err := io.ReadAll(f.Reader())
+			if err != nil {
+				return newError(object.IO_ERROR, "%s", err)
+			}
+			return &object.String{Value: string(data)}
+		}),
+	}
+	builtins["read_line"] = &object.Builtin{
+		Name:    "read_line",
+		Usage:   "read_line(file)",
+		Doc:     "returns the next line of a file without its newline, or null at the end of the file",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: fileFunc("read_line", func(f *object.File, args []object.Object) object.Object {
+			line, err := f.Reader().ReadString('\n')
+			if err == io.EOF && line == "" {
+				return NULL
+			}
+			if err != nil && err != io.EOF {
+				return newError(object.IO_ERROR, "%s", err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			return &object.String{Value: strings.TrimSuffix(line, "\r")}
+		}),
+	}
+	builtins["write"] = &object.Builtin{
+		Name:    "write",
+		Usage:   "write(file, data)",
+		Doc:     "writes a string or byte string to a file and returns the number of bytes written",
+		MinArgs: 2,
+		MaxArgs: 2,
+		Fn: fileFunc("write", func(f *object.File, args []object.Object) object.Object {
+			var data []byte
+			switch arg := args[1].(type) {
+			case *object.String:
+				data = []byte(arg.Value)
+			case *object.Bytes:
+				data = arg.Value
+			default:
+				return newError(object.TYPE_ERROR, "second argument to `write` must be STRING or BYTES, got %s",
+					args[1].Type())
+			}
+
+			n, err := f.Handle.Write(data)
+			if err != nil {
+				return newError(object.IO_ERROR, "%s", err)
+			}
+			return newInteger(int64(n))
+		}),
+	}
+	builtins["close"] = &object.Builtin{
+		Name:    "close",
+		Usage:   "close(file)",
+		Doc:     "closes a file",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: fileFunc("close", func(f *object.File, args []object.Object) object.Object {
+			if err := closeFile(f); err != nil {
+				return newError(object.IO_ERROR, "%s", err)
+			}
+			return NULL
+		}),
+	}
+}
+
+// 最初の引数に開いているファイルを取る組み込み関数を作る
+func fileFunc(name string, fn func(f *object.File, args []object.Object) object.Object) object.BuiltinFunction {
+	return func(args ...object.Object) object.Object {
+		f, ok := args[0].(*object.File)
+		if !ok {
+			return newError(object.TYPE_ERROR, "argument to `%s` must be FILE, got %s", name, args[0].Type())
+		}
+		if f.Closed {
+			return newError(object.IO_ERROR, "file already closed: %s", f.Name)
+		}
+		return fn(f, args)
+	}
+}
+
+// ファイルを開き、閉じ忘れても閉じられるようにopts.Filesに記録する。opts.Filesがnilの場合は記録しない
+func openFile(args []object.Object, opts Options) object.Object {
+	if opts.DisableFiles {
+		return newError(object.IO_ERROR, "file access is disabled")
+	}
+	path, ok := args[0].(*object.String)
+	if !ok {
+		return newError(object.TYPE_ERROR, "argument to `open` must be STRING, got %s", args[0].Type())
+	}
+	mode := "r"
+	if len(args) == 2 {
+		m, ok := args[1].(*object.String)
+		if !ok {
+			return newError(object.TYPE_ERROR, "mode of `open` must be STRING, got %s", args[1].Type())
+		}
+		mode = m.Value
+	}
+	flag, ok := fileModes[mode]
+	if !ok {
+		return newError(object.TYPE_ERROR, `unknown file mode %q, want "r", "w" or "a"`, mode)
+	}
+
+	handle, err := os.OpenFile(path.Value, flag, 0o644)
+	if err != nil {
+		return newError(object.IO_ERROR, "%s", err)
+	}
+
+	f := &object.File{Name: path.Value, Handle: handle}
+	if opts.Files != nil {
+		opts.Files.add(f)
+	}
+	return f
+}
+
+func closeFile(f *object.File) error {
+	f.Closed = true
+	return f.Handle.Close()
+}
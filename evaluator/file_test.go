@@ -0,0 +1,106 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.txt")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let f = open(PATH, "w"); let n = write(f, "one") + write(f, bytes([10])) + write(f, b"two") + write(f, bytes([13, 10])); close(f); n`, "9"},
+		{`let f = open(PATH); let s = read(f); close(f); s`, "one\ntwo\r\n"},
+		{`let f = open(PATH, "a"); write(f, "three"); close(f)`, "null"},
+		// 改行は取り除き、終わりに達したらnullを返す
+		{`let f = open(PATH); [read_line(f), read_line(f), read_line(f), read_line(f)]`, "[one, two, three, null]"},
+		// 行を読んだ後のreadは続きを返す
+		{`let f = open(PATH); read_line(f); read(f)`, "two\r\nthree"},
+		{`let f = open(PATH); close(f); f`, "<closed file " + path + ">"},
+		{`let f = open(PATH); close(f); read(f)`, "ERROR: file already closed: " + path},
+		{`open(PATH, "x")`, `ERROR: unknown file mode "x", want "r", "w" or "a"`},
+		{`open(1)`, "ERROR: argument to `open` must be STRING, got INTEGER"},
+		{`read(1)`, "ERROR: argument to `read` must be FILE, got INTEGER"},
+		{`write(open(PATH, "a"), 1)`, "ERROR: second argument to `write` must be STRING or BYTES, got INTEGER"},
+		{`rescue(fn() { open(PATH + ".missing") }, fn(err) { err["kind"] })`, "IOError"},
+	}
+
+	files := NewFiles()
+	defer files.Close()
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.Set("PATH", &object.String{Value: path})
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+		evaluated := EvalWithOptions(context.Background(), program, env, Options{Files: files})
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = "ERROR: " + errObj.Message
+		}
+		if got != tt.expected {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestDisableFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.txt")
+	program := parser.New(lexer.New(fmt.Sprintf(`open("%s", "w")`, path))).ParseProgram()
+
+	evaluated := EvalWithOptions(context.Background(), program, object.NewEnvironment(), Options{DisableFiles: true})
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "file access is disabled" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file should not be created. err=%v", err)
+	}
+}
+
+func TestCloseFiles(t *testing.T) {
+	dir := t.TempDir()
+	open := func(files *Files, name string) *object.File {
+		t.Helper()
+		program := parser.New(lexer.New(fmt.Sprintf(`open("%s", "w")`, filepath.Join(dir, name)))).ParseProgram()
+		f, ok := EvalWithOptions(context.Background(), program, object.NewEnvironment(), Options{Files: files}).(*object.File)
+		if !ok {
+			t.Fatalf("open did not return a file")
+		}
+		return f
+	}
+
+	files := NewFiles()
+	other := NewFiles()
+	defer other.Close()
+
+	f := open(files, "a.txt")
+	g := open(other, "b.txt")
+
+	files.Close()
+	if !f.Closed {
+		t.Errorf("file was not closed")
+	}
+	if _, err := f.Handle.Write([]byte("x")); err == nil {
+		t.Errorf("handle should be closed")
+	}
+	// 別の評価で開いたファイルは閉じない
+	if g.Closed {
+		t.Errorf("file opened by another evaluation was closed")
+	}
+	if _, err := g.Handle.Write([]byte("x")); err != nil {
+		t.Errorf("handle of another evaluation should be open. err=%v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package evaluator
+
+import (
+	"fmt"
+	"monkey/ast"
+	"sync/atomic"
+)
+
+// 付け替えた名前に付ける通し番号。展開ごとに違う名前になるように、全ての評価で共有する
+var gensymCounter uint64
+
+// 元の名前に通し番号を付けた、ソースコードには書けない名前を作る
+func gensym(name string) string {
+	n := atomic.AddUint64(&gensymCounter, 1)
+	return fmt.Sprintf("%s#%d", name, n)
+}
+
+// マクロのテンプレートの中でletや関数の引数が束縛する名前を、gensymで作った名前に付け替える
+// これで、展開先の変数をテンプレートの変数が隠したり書き換えたりしなくなる
+// unquoteとunquote_spliceの引数は展開先のコードなので付け替えない。元の木は変更せずに新しい木を返す
+func renameBindings(node ast.Node) ast.Node {
+	unquoted := unquotedRanges(node)
+	bound := map[string]string{}
+
+	ast.Inspect(node, func(n ast.Node) bool {
+		if isUnquoteCall(n) || isSpliceCall(n) {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			bound[n.Name.Value] = ""
+		case *ast.FunctionLiteral:
+			for _, param := range n.Parameters {
+				bound[param.Value] = ""
+			}
+		}
+		return true
+	})
+	for name := range bound {
+		bound[name] = gensym(name)
+	}
+
+	return ast.Rewrite(node, func(n ast.Node) ast.Node {
+		ident, ok := n.(*ast.Identifier)
+		if !ok {
+			return n
+		}
+		for _, r := range unquoted {
+			if r.contains(ident.Pos()) {
+				return n
+			}
+		}
+		if renamed, ok := bound[ident.Value]; ok {
+			ident.Value = renamed
+			ident.Token.Literal = renamed
+		}
+		return ident
+	})
+}
+
+// unquoteとunquote_spliceの呼び出しの範囲を集める
+func unquotedRanges(node ast.Node) []sourceRange {
+	ranges := []sourceRange{}
+	ast.Inspect(node, func(n ast.Node) bool {
+		if !isUnquoteCall(n) && !isSpliceCall(n) {
+			return true
+		}
+		if n.Pos().IsValid() && n.End().IsValid() {
+			ranges = append(ranges, sourceRange{start: n.Pos(), end: n.End()})
+		}
+		return false
+	})
+	return ranges
+}
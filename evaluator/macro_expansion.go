@@ -1,8 +1,12 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"monkey/ast"
 	"monkey/object"
+	"monkey/token"
 )
 
 func DefineMacros(program *ast.Program, env *object.Environment) {
@@ -49,34 +53,148 @@ func addMacro(stmt ast.Statement, env *object.Environment) {
 		Parameters: macroLiteral.Parameters,
 		Env:        env,
 		Body:       macroLiteral.Body,
+		Pos:        macroLiteral.Pos(),
 	}
 
 	env.Set(letStatement.Name.Value, macro)
 }
 
-func ExpandMacros(program ast.Node, env *object.Environment) ast.Node {
+// マクロの展開中に起きたエラー。マクロ呼び出しとマクロ定義の両方の位置を持つ
+type MacroError struct {
+	Macro      string // 展開しようとしたマクロの名前
+	Message    string
+	Call       token.Position // マクロ呼び出しの位置
+	Definition token.Position // マクロ定義の中でエラーが起きた位置。分からない場合はマクロリテラルの位置
+}
+
+func (e *MacroError) Error() string {
+	var out bytes.Buffer
+	if e.Call.IsValid() {
+		out.WriteString(e.Call.String() + ": ")
+	}
+	fmt.Fprintf(&out, "in expansion of macro %s: %s", e.Macro, e.Message)
+	if e.Definition.IsValid() {
+		fmt.Fprintf(&out, "\n    in the macro definition at %s", e.Definition)
+	}
+	return out.String()
+}
+
+// マクロ呼び出しを、マクロの本体を評価して得たASTに置き換える
+// マクロは衛生的に展開する。テンプレートがletや関数の引数で束縛する名前は、展開ごとに新しい名前に付け替える
+// quoteの引数の中のマクロ呼び出しは展開しない。macroexpand(quote(...))はquoteの中身を展開したquoteに置き換える
+// 展開に失敗した呼び出しはそのまま残し、エラーを全て集めて返す
+func ExpandMacros(program ast.Node, env *object.Environment) (ast.Node, []*MacroError) {
+	e := &macroExpander{env: env}
+	expanded := e.expand(program)
+	return expanded, e.errors
+}
+
+type macroExpander struct {
+	env    *object.Environment
+	errors []*MacroError
+}
+
+func (e *macroExpander) expand(program ast.Node) ast.Node {
+	quoted := quotedRanges(program)
+
 	return ast.Modify(program, func(node ast.Node) ast.Node {
 		callExpression, ok := node.(*ast.CallExpression)
 		if !ok {
 			return node
 		}
+		for _, r := range quoted {
+			if r.contains(callExpression.Pos()) {
+				return node
+			}
+		}
+
+		if expanded, ok := e.expandMacroexpandCall(callExpression); ok {
+			return expanded
+		}
 
-		macro, ok := isMacroCall(callExpression, env)
+		macro, ok := isMacroCall(callExpression, e.env)
 		if !ok {
 			return node
 		}
+		return e.expandMacroCall(callExpression, macro)
+	})
+}
+
+// マクロの本体を評価して、呼び出しを置き換えるノードを返す。失敗した場合は呼び出しをそのまま返す
+func (e *macroExpander) expandMacroCall(call *ast.CallExpression, macro *object.Macro) ast.Node {
+	fail := func(pos token.Position, format string, a ...interface{}) ast.Node {
+		if !pos.IsValid() {
+			pos = macro.Pos
+		}
+		e.errors = append(e.errors, &MacroError{
+			Macro:      call.Function.String(),
+			Message:    fmt.Sprintf(format, a...),
+			Call:       call.Pos(),
+			Definition: pos,
+		})
+		return call
+	}
 
-		args := quoteArgs(callExpression)
-		evalEnv := extendMacroEnv(macro, args)
+	if len(call.Arguments) != len(macro.Parameters) {
+		return fail(macro.Pos, "wrong number of arguments. got=%d, want=%d",
+			len(call.Arguments), len(macro.Parameters))
+	}
 
-		evaluated := Eval(macro.Body, evalEnv)
-		quote, ok := evaluated.(*object.Quote)
-		if !ok {
-			panic("we only support returning AST-nodes from macros")
+	args := quoteArgs(call)
+	evalEnv := extendMacroEnv(macro, args)
+
+	ev := &evaluation{ctx: context.Background(), expanding: true}
+	evaluated := ev.runDeferred(ev.eval(macro.Body, evalEnv), -1)
+	if errObj, ok := evaluated.(*object.Error); ok {
+		return fail(errObj.Pos, "%s", errObj.Message)
+	}
+	quote, ok := evaluated.(*object.Quote)
+	if !ok {
+		got := "nothing"
+		if evaluated != nil {
+			got = string(evaluated.Type())
 		}
+		return fail(macro.Pos, "macro must return a quote, got %s", got)
+	}
 
-		return quote.Node
-	})
+	return quote.Node
+}
+
+// macroexpand(quote(...))とmacroexpand_1(quote(...))を、展開した結果のquote呼び出しに置き換える
+// macroexpandは展開の段階と同じように中の全てのマクロ呼び出しを展開し、macroexpand_1は一番外側の呼び出しを1回だけ展開する
+// 引数がquote呼び出しでない場合は置き換えず、実行時に組み込み関数がエラーにする
+func (e *macroExpander) expandMacroexpandCall(call *ast.CallExpression) (ast.Node, bool) {
+	name := call.Function.TokenLiteral()
+	if name != "macroexpand" && name != "macroexpand_1" {
+		return nil, false
+	}
+	if len(call.Arguments) != 1 {
+		return nil, false
+	}
+	quoteCall, ok := call.Arguments[0].(*ast.CallExpression)
+	if !ok || quoteCall.Function.TokenLiteral() != "quote" || len(quoteCall.Arguments) != 1 {
+		return nil, false
+	}
+
+	target := quoteCall.Arguments[0]
+	var expanded ast.Node
+	if name == "macroexpand" {
+		expanded = e.expand(target)
+	} else {
+		expanded = target
+		if inner, ok := target.(*ast.CallExpression); ok {
+			if macro, ok := isMacroCall(inner, e.env); ok {
+				expanded = e.expandMacroCall(inner, macro)
+			}
+		}
+	}
+
+	exp, ok := expanded.(ast.Expression)
+	if !ok {
+		return nil, false
+	}
+	quoteCall.Arguments[0] = exp
+	return quoteCall, true
 }
 
 func isMacroCall(
@@ -5,6 +5,7 @@ import (
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"strings"
 	"testing"
 )
 
@@ -111,7 +112,10 @@ unless(10 > 5, puts("not greater"), puts("greater"));
 
 		env := object.NewEnvironment()
 		DefineMacros(program, env)
-		expanded := ExpandMacros(program, env)
+		expanded, errors := ExpandMacros(program, env)
+		if len(errors) != 0 {
+			t.Fatalf("macro errors: %v", errors)
+		}
 
 		if expanded.String() != expected.String() {
 			t.Errorf("not equal. want=%q, got=%q",
@@ -119,3 +123,198 @@ unless(10 > 5, puts("not greater"), puts("greater"));
 		}
 	}
 }
+
+func testExpandAndEval(input string) object.Object {
+	program := testParseProgram(input)
+	macroEnv := object.NewEnvironment()
+	DefineMacros(program, macroEnv)
+	expanded, errors := ExpandMacros(program, macroEnv)
+	if len(errors) != 0 {
+		return &object.Error{Message: errors[0].Error()}
+	}
+	return Eval(expanded, object.NewEnvironment())
+}
+
+func TestHygienicMacros(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{
+			// テンプレートの引数tmpは、展開先のtmpを隠さない
+			`
+let myor = macro(a, b) {
+  quote(fn(tmp) { if (tmp) { tmp } else { unquote(b) } }(unquote(a)));
+};
+let tmp = 5;
+myor(false, tmp);
+`,
+			5,
+		},
+		{
+			// テンプレートのletは、展開先の変数を書き換えない
+			`
+let twice = macro(x) {
+  quote(if (true) { let y = unquote(x); y + y });
+};
+let y = 1;
+let z = twice(10);
+y + z;
+`,
+			21,
+		},
+		{
+			// 同じマクロを何度展開しても、それぞれ別の名前になる
+			`
+let myor = macro(a, b) {
+  quote(fn(tmp) { if (tmp) { tmp } else { unquote(b) } }(unquote(a)));
+};
+let tmp = 1;
+myor(false, tmp) + myor(2, tmp);
+`,
+			3,
+		},
+		{
+			// quote_unhygienicはわざと展開先の名前を束縛できる
+			`
+let aif = macro(condition, body) {
+  quote_unhygienic(fn(it) { if (it) { unquote(body) } }(unquote(condition)));
+};
+aif(5, it * 2);
+`,
+			10,
+		},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testExpandAndEval(tt.input), tt.expected)
+	}
+}
+
+func TestHygienicRenaming(t *testing.T) {
+	program := testParseProgram(`
+let m = macro(x) { quote(fn(a) { a + unquote(x) }) };
+m(a);
+`)
+	env := object.NewEnvironment()
+	DefineMacros(program, env)
+	node, _ := ExpandMacros(program, env)
+	expanded := node.String()
+
+	// 引数のaは付け替えるが、展開先から渡したaはそのまま残る
+	if !strings.Contains(expanded, "fn(a#") || !strings.HasSuffix(expanded, " + a)") {
+		t.Errorf("wrong expansion. got=%q", expanded)
+	}
+
+	// 展開の外のquoteでは付け替えない
+	quoted := testEval("quote(fn(a) { a })")
+	if quoted.Inspect() != "QUOTE(fn(a) a)" {
+		t.Errorf("quote outside macros must not rename. got=%q", quoted.Inspect())
+	}
+}
+
+func TestMacroexpand(t *testing.T) {
+	macros := `
+let unless = macro(condition, consequence, alternative) {
+  quote(if (!(unquote(condition))) { unquote(consequence) } else { unquote(alternative) });
+};
+let double = macro(x) { quote(unquote(x) * 2) };
+`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`macroexpand(quote(unless(10 > 5, 1, 2)))`,
+			`QUOTE(if(!(10 > 5)) 1else 2)`,
+		},
+		{
+			// 引数の中のマクロ呼び出しも展開する
+			`macroexpand(quote(unless(double(1) > 5, 1, 2)))`,
+			`QUOTE(if(!((1 * 2) > 5)) 1else 2)`,
+		},
+		{
+			// 一番外側だけを展開する
+			`macroexpand_1(quote(unless(double(1) > 5, 1, 2)))`,
+			`QUOTE(if(!(double(1) > 5)) 1else 2)`,
+		},
+		{
+			`macroexpand_1(quote(len([1])))`,
+			`QUOTE(len([1]))`,
+		},
+		{
+			// quoteの中のマクロ呼び出しは展開しない
+			`quote(double(3))`,
+			`QUOTE(double(3))`,
+		},
+		{
+			`let q = quote(double(3)); macroexpand(q)`,
+			"ERROR: argument to `macroexpand` must be a quote(...) expression\n    at macroexpand (6:27)",
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := testExpandAndEval(macros + tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q.\nwant=%q\ngot=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestMacroErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let m = macro(a, b) { quote(unquote(a) + unquote(b)) };
+m(1);`,
+			"2:1: in expansion of macro m: wrong number of arguments. got=1, want=2\n    in the macro definition at 1:9",
+		},
+		{
+			`let m = macro() { 1 };
+m();`,
+			"2:1: in expansion of macro m: macro must return a quote, got INTEGER\n    in the macro definition at 1:9",
+		},
+		{
+			// 本体の中のエラーは、本体の中の位置を指す
+			`let m = macro(x) {
+  1 + true;
+};
+let a = 1;
+m(a);`,
+			"5:1: in expansion of macro m: type mismatch: INTEGER + BOOLEAN\n    in the macro definition at 2:5",
+		},
+	}
+
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		expanded, errors := ExpandMacros(program, env)
+
+		if len(errors) != 1 {
+			t.Errorf("wrong number of errors for %q. got=%v", tt.input, errors)
+			continue
+		}
+		if errors[0].Error() != tt.expected {
+			t.Errorf("wrong error.\nwant=%q\ngot=%q", tt.expected, errors[0].Error())
+		}
+		// 失敗した呼び出しはそのまま残る
+		if !strings.Contains(expanded.String(), "m(") {
+			t.Errorf("failed call must be kept. got=%q", expanded.String())
+		}
+	}
+}
+
+func TestVariadicMacros(t *testing.T) {
+	// 可変個の引数は配列リテラルで受け取り、unquote_spliceで並べる
+	input := `
+let apply = macro(f, args) { quote(unquote(f)(unquote_splice(args))) };
+let progn = macro(exps) { quote(if (true) { unquote_splice(exps) }) };
+let add = fn(a, b, c) { a + b + c };
+let x = apply(add, [1, 2, 3]);
+progn([x * 2, x + 10]);
+`
+	testIntegerObject(t, testExpandAndEval(input), 16)
+}
@@ -0,0 +1,93 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+)
+
+// 評価器の外で評価器と同じ意味の演算をするための関数。VMから使う
+// エラーは評価器と同じく*object.Errorで返す
+
+// 中置演算子を計算する
+func InfixOperation(operator string, left, right object.Object) object.Object {
+	return evalInfixExpression(operator, left, right)
+}
+
+// 整数の+、-、*を計算する。結果がint64に収まらない場合はokが偽になる
+func CheckedIntegerOp(operator string, a, b int64) (result int64, ok bool) {
+	return checkedIntegerOp(operator, a, b)
+}
+
+// 整数の値を作る。小さい値は評価器と同じくキャッシュしたものを返す
+func NewInteger(value int64) *object.Integer {
+	return newInteger(value)
+}
+
+// 前置演算子を計算する
+func PrefixOperation(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
+// 添字でコレクションの要素を取り出す
+func IndexOperation(left, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+// 組み込み関数を呼び出す。評価器で処理するrescueやopen、putsも同じように呼び出せる
+// callは関数の値を呼び出す方法で、rescueが引数の関数を呼び出すのに使う
+// optsのうち、openはDisableFilesとFilesに、putsとdocはStdoutに従う
+func CallBuiltin(fn *object.Builtin, args []object.Object, call func(fn object.Object, args []object.Object) object.Object, opts Options) object.Object {
+	if err := fn.CheckArity(len(args)); err != nil {
+		return err
+	}
+	switch fn {
+	case rescueBuiltin:
+		return rescue(args, call)
+	case openBuiltin:
+		return openFile(args, opts)
+	case putsBuiltin:
+		return puts(opts.stdout(), args)
+	case docBuiltin:
+		return doc(opts.stdout(), args)
+	}
+	return fn.Fn(args...)
+}
+
+// quoteの引数のunquoteとunquote_spliceを置き換えたquoteを作る
+// 置き換える値は、UnquotedExpressionsが返すi番目の式の値をeval(i)で求める
+func Quote(node ast.Node, eval func(i int) object.Object) *object.Quote {
+	i := 0
+	return &object.Quote{Node: evalUnquoteCalls(node, func(ast.Expression) object.Object {
+		value := eval(i)
+		i++
+		return value
+	})}
+}
+
+// quoteの引数を評価するときに、unquoteとunquote_spliceの引数として評価する式を評価の順に返す
+// unquoteの中にunquoteがある場合は、評価した値で次に評価する式が変わるのでokが偽になる
+func UnquotedExpressions(node ast.Node) (exps []ast.Expression, ok bool) {
+	if hasNestedUnquote(node) {
+		return nil, false
+	}
+	evalUnquoteCalls(node, func(exp ast.Expression) object.Object {
+		exps = append(exps, exp)
+		return NULL
+	})
+	return exps, true
+}
+
+// quoteの呼び出しか判定する。評価器と同じく、quote_unhygienicと、名前を束縛し直したquoteも含む
+func IsQuoteCall(call *ast.CallExpression) bool {
+	return isQuoteCall(call)
+}
+
+// if式の条件として真になるか判定する
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
+// エラーを作る
+func NewError(kind object.ErrorKind, format string, a ...interface{}) *object.Error {
+	return newError(kind, format, a...)
+}
@@ -0,0 +1,105 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+	"monkey/token"
+)
+
+// 評価の前に、定数だけからなる整数と真偽値の式を計算しておく。2 * 3 + 4 は 10 になる
+// 元の木は変更せずに新しい木を返す。0での除算や桁あふれのように評価するとエラーになる式は、実行時にエラーにするためそのまま残す
+// quoteの引数は式そのものに意味があるので計算しない
+func Optimize(node ast.Node) ast.Node {
+	quoted := quotedRanges(node)
+
+	return ast.Rewrite(node, func(n ast.Node) ast.Node {
+		for _, r := range quoted {
+			if r.contains(n.Pos()) {
+				return n
+			}
+		}
+		return foldConstant(n)
+	})
+}
+
+// ソースコード上の範囲。endは含まない
+type sourceRange struct {
+	start, end token.Position
+}
+
+func (r sourceRange) contains(pos token.Position) bool {
+	if !pos.IsValid() {
+		return false
+	}
+	return !positionLess(pos, r.start) && positionLess(pos, r.end)
+}
+
+func positionLess(a, b token.Position) bool {
+	if a.Line != b.Line {
+		return a.Line < b.Line
+	}
+	return a.Column < b.Column
+}
+
+// quote呼び出しの引数の範囲を集める
+func quotedRanges(node ast.Node) []sourceRange {
+	ranges := []sourceRange{}
+	ast.Inspect(node, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpression)
+		if !ok || !isQuoteCall(call) {
+			return true
+		}
+		for _, arg := range call.Arguments {
+			if arg.Pos().IsValid() && arg.End().IsValid() {
+				ranges = append(ranges, sourceRange{start: arg.Pos(), end: arg.End()})
+			}
+		}
+		return false
+	})
+	return ranges
+}
+
+// オペランドが全てリテラルの前置・中置演算子式を、計算結果のリテラルに置き換える
+func foldConstant(node ast.Node) ast.Node {
+	var result object.Object
+
+	switch node := node.(type) {
+	case *ast.PrefixExpression:
+		right, ok := constantValue(node.Right)
+		if !ok {
+			return node
+		}
+		result = evalPrefixExpression(node.Operator, right)
+	case *ast.InfixExpression:
+		left, ok := constantValue(node.Left)
+		if !ok {
+			return node
+		}
+		right, ok := constantValue(node.Right)
+		if !ok {
+			return node
+		}
+		result = evalInfixExpression(node.Operator, left, right)
+	default:
+		return node
+	}
+
+	switch result.(type) {
+	case *object.Integer, *object.Boolean:
+		return convertObjectToASTNode(result, node.Pos())
+	default:
+		return node
+	}
+}
+
+// 整数と真偽値のリテラルを評価した値を返す
+func constantValue(exp ast.Expression) (object.Object, bool) {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return newInteger(exp.Value), true
+	case *ast.Boolean:
+		return nativeBoolToBooleanObject(exp.Value), true
+	default:
+		return nil, false
+	}
+}
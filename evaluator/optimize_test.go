@@ -0,0 +1,55 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"2 * 3 + 4", "10"},
+		{"-(1 + 2)", "-3"},
+		{"!true", "false"},
+		{"1 < 2 == true", "true"},
+		{"x + 2 * 3", "(x + 6)"},
+		{"let f = fn(x) { x * (10 - 8) };", "let f = fn(x) (x * 2);"},
+		{"if (1 > 2) { 3 + 4 }", "iffalse 7"},
+		{"[1 + 1, \"a\" + \"b\"]", "[2, (a + b)]"},
+		// 実行時のエラーはそのまま残す
+		{"1 / 0", "(1 / 0)"},
+		{"9223372036854775807 + 1", "(9223372036854775807 + 1)"},
+		{"1 + true", "(1 + true)"},
+		// quoteの引数は計算しない
+		{"quote(1 + 2) + (3 + 4)", "(quote((1 + 2)) + 7)"},
+	}
+
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+		original := program.String()
+
+		optimized := Optimize(program)
+		if optimized.String() != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, optimized.String())
+		}
+		if program.String() != original {
+			t.Errorf("original program was modified. got=%q", program.String())
+		}
+	}
+}
+
+func TestOptimizeKeepsPositions(t *testing.T) {
+	program := testParseProgram("let x = 1;\nlet y = 2 * (3 + 4);")
+	optimized := Optimize(program).(*ast.Program)
+
+	value := optimized.Statements[1].(*ast.LetStatement).Value
+	lit, ok := value.(*ast.IntegerLiteral)
+	if !ok {
+		t.Fatalf("value is not *ast.IntegerLiteral. got=%T", value)
+	}
+	if lit.Value != 14 || lit.Pos().String() != "2:9" {
+		t.Errorf("wrong literal. got=%d at %s", lit.Value, lit.Pos())
+	}
+}
@@ -7,13 +7,41 @@ import (
 	"monkey/token"
 )
 
-func quote(node ast.Node, env *object.Environment) object.Object {
-	node = evalUnquoteCalls(node, env)
+// quote_unhygienicはマクロの展開中でも名前を付け替えない。展開先の変数をわざと束縛したい場合に使う
+func isQuoteCall(call *ast.CallExpression) bool {
+	name := call.Function.TokenLiteral()
+	return name == "quote" || name == "quote_unhygienic"
+}
+
+func (ev *evaluation) quote(call *ast.CallExpression, env *object.Environment) object.Object {
+	if len(call.Arguments) != 1 {
+		return locate(newError(object.ARITY_ERROR, "wrong number of arguments. got=%d, want=1", len(call.Arguments)), call)
+	}
+	var node ast.Node = call.Arguments[0]
+	if ev.expanding && call.Function.TokenLiteral() == "quote" {
+		node = renameBindings(node)
+	}
+	node = evalUnquoteCalls(node, func(exp ast.Expression) object.Object {
+		return ev.eval(exp, env)
+	})
 	return &object.Quote{Node: node}
 }
 
-func evalUnquoteCalls(quoted ast.Node, env *object.Environment) ast.Node {
+// quotedの中のunquoteとunquote_spliceを、引数をevalで評価した値に置き換える
+// 同じquoteを何度評価しても置き換える前の木から始められるように、木をコピーしてから置き換える
+func evalUnquoteCalls(quoted ast.Node, eval func(ast.Expression) object.Object) ast.Node {
+	quoted = ast.Rewrite(quoted, func(n ast.Node) ast.Node { return n })
 	return ast.Modify(quoted, func(node ast.Node) ast.Node {
+		// unquote_spliceは、それを要素に持つリストの側で展開する
+		switch node := node.(type) {
+		case *ast.CallExpression:
+			node.Arguments = spliceExpressions(node.Arguments, eval)
+		case *ast.ArrayLiteral:
+			node.Elements = spliceExpressions(node.Elements, eval)
+		case *ast.BlockStatement:
+			node.Statements = spliceStatements(node.Statements, eval)
+		}
+
 		// 呼び出しがunquoteではなかったら何もしない
 		if !isUnquoteCall(node) {
 			return node
@@ -27,12 +55,33 @@ func evalUnquoteCalls(quoted ast.Node, env *object.Environment) ast.Node {
 			return node
 		}
 
-		unquoted := Eval(call.Arguments[0], env)
+		unquoted := eval(call.Arguments[0])
 		// unquoteの呼び出しを置換し、結果を逆に未評価のast.Nodeに挿入する。そのためにEvalした結果(object.Object)をast.Nodeに変換する
-		return convertObjectToASTNode(unquoted)
+		return convertObjectToASTNode(unquoted, call.Token.Pos())
 	})
 }
 
+// unquoteかunquote_spliceの引数の中に、さらにunquoteかunquote_spliceがあるか判定する
+// 内側を置き換えた結果で外側の引数が変わるので、VMでは評価する式を前もって決められない
+func hasNestedUnquote(node ast.Node) bool {
+	nested := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if nested || (!isUnquoteCall(n) && !isSpliceCall(n)) {
+			return !nested
+		}
+		for _, arg := range n.(*ast.CallExpression).Arguments {
+			ast.Inspect(arg, func(n ast.Node) bool {
+				if isUnquoteCall(n) || isSpliceCall(n) {
+					nested = true
+				}
+				return !nested
+			})
+		}
+		return false
+	})
+	return nested
+}
+
 func isUnquoteCall(node ast.Node) bool {
 	callExpression, ok := node.(*ast.CallExpression)
 	if !ok {
@@ -42,12 +91,101 @@ func isUnquoteCall(node ast.Node) bool {
 	return callExpression.Function.TokenLiteral() == "unquote"
 }
 
-func convertObjectToASTNode(obj object.Object) ast.Node {
+func isSpliceCall(node ast.Node) bool {
+	callExpression, ok := node.(*ast.CallExpression)
+	if !ok {
+		return false
+	}
+
+	return callExpression.Function.TokenLiteral() == "unquote_splice"
+}
+
+// 式のリストの中のunquote_splice(list)を評価して、listの要素をその場所に並べる
+func spliceExpressions(exps []ast.Expression, eval func(ast.Expression) object.Object) []ast.Expression {
+	result := make([]ast.Expression, 0, len(exps))
+	for _, exp := range exps {
+		nodes, ok := evalSpliceCall(exp, eval)
+		if !ok {
+			result = append(result, exp)
+			continue
+		}
+		for _, node := range nodes {
+			if e, ok := node.(ast.Expression); ok {
+				result = append(result, e)
+			}
+		}
+	}
+	return result
+}
+
+// ブロックの中で文として書かれたunquote_splice(list)を評価して、listの要素を文として並べる
+func spliceStatements(stmts []ast.Statement, eval func(ast.Expression) object.Object) []ast.Statement {
+	result := make([]ast.Statement, 0, len(stmts))
+	for _, stmt := range stmts {
+		es, ok := stmt.(*ast.ExpressionStatement)
+		if !ok {
+			result = append(result, stmt)
+			continue
+		}
+		nodes, ok := evalSpliceCall(es.Expression, eval)
+		if !ok {
+			result = append(result, stmt)
+			continue
+		}
+		for _, node := range nodes {
+			switch node := node.(type) {
+			case ast.Statement:
+				result = append(result, node)
+			case ast.Expression:
+				result = append(result, &ast.ExpressionStatement{Token: es.Token, Expression: node})
+			}
+		}
+	}
+	return result
+}
+
+// unquote_spliceの引数を評価して、並べるノードを返す。引数は配列か、配列リテラルのquote
+// 呼び出しがunquote_spliceでない場合や、引数を並べられない場合はfalseを返す
+func evalSpliceCall(exp ast.Expression, eval func(ast.Expression) object.Object) ([]ast.Node, bool) {
+	if !isSpliceCall(exp) {
+		return nil, false
+	}
+	call := exp.(*ast.CallExpression)
+	if len(call.Arguments) != 1 {
+		return nil, false
+	}
+
+	nodes := []ast.Node{}
+	switch list := eval(call.Arguments[0]).(type) {
+	case *object.Array:
+		for _, el := range list.Elements {
+			if node := convertObjectToASTNode(el, call.Token.Pos()); node != nil {
+				nodes = append(nodes, node)
+			}
+		}
+	case *object.Quote:
+		array, ok := list.Node.(*ast.ArrayLiteral)
+		if !ok {
+			return nil, false
+		}
+		for _, el := range array.Elements {
+			nodes = append(nodes, el)
+		}
+	default:
+		return nil, false
+	}
+	return nodes, true
+}
+
+// 生成するノードのトークンには、元になったunquote呼び出しの位置を付ける
+func convertObjectToASTNode(obj object.Object, pos token.Position) ast.Node {
 	switch obj := obj.(type) {
 	case *object.Integer:
 		t := token.Token{
 			Type:    token.INT,
 			Literal: fmt.Sprintf("%d", obj.Value),
+			Line:    pos.Line,
+			Column:  pos.Column,
 		}
 		return &ast.IntegerLiteral{Token: t, Value: obj.Value}
 	case *object.Boolean:
@@ -57,6 +195,7 @@ func convertObjectToASTNode(obj object.Object) ast.Node {
 		} else {
 			t = token.Token{Type: token.FALSE, Literal: "false"}
 		}
+		t.Line, t.Column = pos.Line, pos.Column
 		return &ast.Boolean{Token: t, Value: obj.Value}
 	case *object.Quote:
 		return obj.Node
@@ -3,6 +3,7 @@ package evaluator
 import (
 	"testing"
 
+	"monkey/ast"
 	"monkey/object"
 )
 
@@ -109,3 +110,68 @@ func TestQuoteUnquote(t *testing.T) {
 		}
 	}
 }
+
+func TestUnquotePosition(t *testing.T) {
+	evaluated := testEval("quote(1 +\n  unquote(2))")
+	quote, ok := evaluated.(*object.Quote)
+	if !ok {
+		t.Fatalf("expected *object.Quote, got=%T (%+v)", evaluated, evaluated)
+	}
+
+	infix, ok := quote.Node.(*ast.InfixExpression)
+	if !ok {
+		t.Fatalf("quote.Node is not *ast.InfixExpression. got=%T", quote.Node)
+	}
+
+	// unquote呼び出しの(の位置を引き継ぐ
+	pos := infix.Right.(*ast.IntegerLiteral).Token.Pos()
+	if pos.String() != "2:10" {
+		t.Errorf("position of unquoted node wrong. got=%s, want=2:10", pos)
+	}
+}
+
+func TestUnquoteSplice(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`quote([0, unquote_splice([1, 2]), 3])`,
+			`[0, 1, 2, 3]`,
+		},
+		{
+			`quote(f(unquote_splice([true, 2])))`,
+			`f(true, 2)`,
+		},
+		{
+			`let args = quote([a, b + 1]); quote(f(unquote_splice(args), c))`,
+			`f(a, (b + 1), c)`,
+		},
+		{
+			`quote(f(unquote_splice([])))`,
+			`f()`,
+		},
+		{
+			// ブロックの中では文として並べる
+			`let stmts = quote([puts(1), 2]); quote(if (true) { unquote_splice(stmts) })`,
+			`iftrue puts(1)2`,
+		},
+		{
+			// 配列でない引数は展開しない
+			`quote(f(unquote_splice(1)))`,
+			`f(unquote_splice(1))`,
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		quote, ok := evaluated.(*object.Quote)
+		if !ok {
+			t.Fatalf("expected *object.Quote. got=%T (%+v)", evaluated, evaluated)
+		}
+
+		if quote.Node.String() != tt.expected {
+			t.Errorf("not equal. got=%q, want=%q", quote.Node.String(), tt.expected)
+		}
+	}
+}
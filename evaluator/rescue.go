@@ -0,0 +1,66 @@
+package evaluator
+
+import (
+	"monkey/object"
+)
+
+// rescueは引数の関数を呼び出すので、組み込み関数の中ではなく評価器で処理する
+var rescueBuiltin = &object.Builtin{
+	Name:    "rescue",
+	Usage:   "rescue(body, handler)",
+	Doc:     "calls body and, if it fails, calls handler with a hash of the error's kind and message",
+	MinArgs: 2,
+	MaxArgs: 2,
+	Fn: func(args ...object.Object) object.Object {
+		return newError(object.TYPE_ERROR, "`rescue` can only be called from Monkey code")
+	},
+}
+
+func init() {
+	builtins["rescue"] = rescueBuiltin
+}
+
+// bodyを引数なしで呼び出し、エラーになった場合はhandlerにエラーを表すハッシュを渡して呼び出す
+func (ev *evaluation) rescue(args []object.Object, caller *object.Environment) object.Object {
+	return rescue(args, func(fn object.Object, fnArgs []object.Object) object.Object {
+		// handlerに渡すハッシュはrescueで作るので、ここで大きさを加算する
+		for _, arg := range fnArgs {
+			if err := ev.charge(arg); isError(err) {
+				return err
+			}
+		}
+		return ev.applyFunction(fn, fnArgs, caller)
+	})
+}
+
+// rescueの本体。callは関数の値を呼び出す方法で、評価器とVMでそれぞれ渡す
+// エラーのままの値は参照しただけで伝わってしまうので、kindとmessageを持つハッシュに変換して渡す
+// 制限による打ち切りは評価を止めるためのものなので、捕まえずにそのまま伝える
+func rescue(args []object.Object, call func(fn object.Object, args []object.Object) object.Object) object.Object {
+	for _, arg := range args {
+		if arg.Type() != object.FUNCTION_OBJ && arg.Type() != object.BUILTIN_OBJ {
+			return newError(object.TYPE_ERROR, "argument to `rescue` must be FUNCTION, got %s", arg.Type())
+		}
+	}
+
+	result := call(args[0], []object.Object{})
+	errObj, ok := result.(*object.Error)
+	if !ok || errObj.Kind == object.LIMIT_ERROR {
+		return result
+	}
+
+	return call(args[1], []object.Object{errorHash(errObj)})
+}
+
+// エラーをkindとmessageを持つハッシュにする
+func errorHash(err *object.Error) *object.Hash {
+	hash := object.NewHash()
+	for _, field := range []struct{ key, value string }{
+		{"kind", string(err.Kind)},
+		{"message", err.Message},
+	} {
+		key := &object.String{Value: field.key}
+		hash.Set(key.HashKey(), object.HashPair{Key: key, Value: &object.String{Value: field.value}})
+	}
+	return hash
+}
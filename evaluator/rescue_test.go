@@ -0,0 +1,49 @@
+package evaluator
+
+import (
+	"monkey/object"
+	"testing"
+)
+
+func TestRescue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		// エラーにならなければbodyの結果を返す
+		{`rescue(fn() { 1 + 2 }, fn(err) { 0 })`, 3},
+		{`rescue(fn() { 1 / 0 }, fn(err) { 0 })`, 0},
+		{`rescue(fn() { 1 / 0 }, fn(err) { err["kind"] })`, "ZeroDivisionError"},
+		{`rescue(fn() { foo }, fn(err) { err["message"] })`, "identifier not found: foo"},
+		// 深い呼び出しの中のエラーも捕まえる
+		{`let f = fn(n) { if (n == 0) { 1 + true } else { f(n - 1) } };
+rescue(fn() { f(3) }, fn(err) { err["kind"] })`, "TypeError"},
+		// ハンドラのエラーはそのまま伝わる
+		{`rescue(fn() { 1 / 0 }, fn(err) { -true })`, "unknown operator: -BOOLEAN"},
+		{`rescue(fn() { 1 }, 2)`, "argument to `rescue` must be FUNCTION, got INTEGER"},
+		{`rescue(fn() { 1 })`, "wrong number of arguments. got=1, want=2"},
+		// 制限による打ち切りは捕まえない
+		{`let f = fn() { f() }; rescue(f, fn(err) { 0 })`, "maximum recursion depth exceeded"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			switch obj := evaluated.(type) {
+			case *object.String:
+				if obj.Value != expected {
+					t.Errorf("wrong string for %q. want=%q, got=%q", tt.input, expected, obj.Value)
+				}
+			case *object.Error:
+				if obj.Message != expected {
+					t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, expected, obj.Message)
+				}
+			default:
+				t.Errorf("unexpected result for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+			}
+		}
+	}
+}
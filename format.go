@@ -0,0 +1,88 @@
+// 整形モード。monkey fmt [-w] a.mky b.mky ...
+// ファイルを指定しない場合は標準入力を整形して標準出力に書く
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"monkey/diag"
+	"monkey/format"
+	"os"
+)
+
+// fmtサブコマンドの引数を処理し、終了コードを返す
+func fmtCommand(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: monkey fmt [flags] [file...]")
+		fs.PrintDefaults()
+	}
+	write := fs.Bool("w", false, "write result to the source file instead of stdout")
+	list := fs.Bool("l", false, "list files whose formatting differs")
+	noColor := fs.Bool("no-color", false, "disable colored output")
+	if err := fs.Parse(args); err != nil {
+		return exitRuntimeError
+	}
+
+	printer := diag.NewPrinter(os.Stderr, diag.ColorEnabled(!*noColor, os.Stderr))
+
+	if fs.NArg() == 0 {
+		return formatFile(STDIN_NAME, os.Stdin, false, false, os.Stdout, printer)
+	}
+
+	code := exitOK
+	for _, filename := range fs.Args() {
+		f, err := os.Open(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = exitRuntimeError
+			continue
+		}
+
+		if c := formatFile(filename, f, *write, *list, os.Stdout, printer); c != exitOK && code == exitOK {
+			code = c
+		}
+		f.Close()
+	}
+
+	return code
+}
+
+// srcを整形する。writeなら元のファイルに書き戻し、listなら差分のあるファイル名だけを出力する
+func formatFile(filename string, src io.Reader, write, list bool, out io.Writer, printer *diag.Printer) int {
+	input, err := io.ReadAll(src)
+	if err != nil {
+		printer.Print(diag.RuntimeError, filename+": "+err.Error())
+		return exitRuntimeError
+	}
+
+	program := parseSource(filename, bytes.NewReader(input), printer, 0)
+	if program == nil {
+		return exitParseError
+	}
+	output := []byte(format.Node(program))
+
+	if list {
+		if !bytes.Equal(input, output) {
+			fmt.Fprintln(out, filename)
+		}
+		return exitOK
+	}
+
+	if write {
+		if bytes.Equal(input, output) {
+			return exitOK
+		}
+		if err := os.WriteFile(filename, output, 0644); err != nil {
+			printer.Print(diag.RuntimeError, err.Error())
+			return exitRuntimeError
+		}
+		return exitOK
+	}
+
+	out.Write(output)
+	return exitOK
+}
@@ -0,0 +1,314 @@
+// Monkeyのソースコードを整形する
+// ASTを決まったインデントと空白、セミコロンで出力し直す。コメントと文の間の空行は残す
+
+package format
+
+import (
+	"bytes"
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/parser"
+	"strconv"
+	"strings"
+)
+
+// インデントの単位
+const INDENT = "  "
+
+// 二項演算子の優先順位。parserの優先順位と同じ順に並べる
+var precedences = map[string]int{
+	"==": 1,
+	"!=": 1,
+	"<":  2,
+	">":  2,
+	"..": 3,
+	"+":  4,
+	"-":  4,
+	"*":  5,
+	"/":  5,
+}
+
+// 前置演算子の優先順位。どの二項演算子よりも強く結合する
+const prefixPrecedence = 6
+
+// ソースコードを整形する。構文エラーがある場合は最初のエラーを返す
+func Source(src []byte) ([]byte, error) {
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if errs := p.ParseErrors(); len(errs) != 0 {
+		return nil, errs[0]
+	}
+
+	return []byte(Node(program)), nil
+}
+
+// ノードを整形した文字列にする。Programの場合は末尾に改行を付ける
+func Node(node ast.Node) string {
+	pr := &printer{}
+
+	switch node := node.(type) {
+	case *ast.Program:
+		pr.statements(node.Statements, node.Comments, 0)
+	case ast.Statement:
+		pr.statement(node)
+	case ast.Expression:
+		pr.expression(node)
+	}
+
+	return pr.buf.String()
+}
+
+type printer struct {
+	buf    bytes.Buffer
+	indent int
+}
+
+func (pr *printer) write(s string) {
+	pr.buf.WriteString(s)
+}
+
+// 改行して、現在の深さまでインデントする
+func (pr *printer) newline() {
+	pr.write("\n" + strings.Repeat(INDENT, pr.indent))
+}
+
+// 文を1行ずつ並べる。各行はインデント済みの位置から書き始め、改行で終える
+// 元のソースで文の間に空行があった場合は1行だけ残す
+// startLineは{の行。直後の同じ行にあるコメントは{の後ろに置く
+func (pr *printer) statements(stmts []ast.Statement, trailing []*ast.Comment, startLine int) {
+	prevLine := 0         // 直前の文の最後の行
+	lastLine := startLine // 直前に書いた行の、元のソースでの行番号
+
+	for _, stmt := range stmts {
+		doc := pr.lineComments(ast.DocComments(stmt), lastLine)
+
+		start := stmt.Pos().Line
+		if len(doc) > 0 {
+			start = doc[0].Pos().Line
+		}
+		pr.blankLine(prevLine, start)
+
+		for _, c := range doc {
+			pr.writeLine(c.String())
+		}
+		pr.indented()
+		pr.statement(stmt)
+		pr.write("\n")
+
+		prevLine = stmt.End().Line
+		lastLine = prevLine
+	}
+
+	for _, c := range pr.lineComments(trailing, lastLine) {
+		pr.blankLine(prevLine, c.Pos().Line)
+		pr.writeLine(c.String())
+		prevLine = c.Pos().Line
+	}
+}
+
+// 行末のコメントを直前に書いた行の後ろに付け、残りのコメントを返す
+func (pr *printer) lineComments(comments []*ast.Comment, line int) []*ast.Comment {
+	if len(comments) == 0 || line == 0 || comments[0].Pos().Line != line {
+		return comments
+	}
+
+	pr.buf.Truncate(pr.buf.Len() - 1) // 改行を取り除く
+	pr.write(" " + comments[0].String() + "\n")
+	return comments[1:]
+}
+
+func (pr *printer) blankLine(prevLine, line int) {
+	if prevLine > 0 && line > prevLine+1 {
+		pr.write("\n")
+	}
+}
+
+func (pr *printer) indented() {
+	pr.write(strings.Repeat(INDENT, pr.indent))
+}
+
+func (pr *printer) writeLine(s string) {
+	pr.indented()
+	pr.write(s + "\n")
+}
+
+func (pr *printer) statement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		pr.write("let " + stmt.Name.Value + " = ")
+		pr.expression(stmt.Value)
+		pr.write(";")
+	case *ast.ReturnStatement:
+		if stmt.ReturnValue == nil {
+			pr.write("return;")
+			return
+		}
+		pr.write("return ")
+		pr.expression(stmt.ReturnValue)
+		pr.write(";")
+	case *ast.DeferStatement:
+		pr.write("defer ")
+		pr.expression(stmt.Expression)
+		pr.write(";")
+	case *ast.ExpressionStatement:
+		pr.expression(stmt.Expression)
+		// ブロックで終わるif式にはセミコロンを付けない
+		if _, ok := stmt.Expression.(*ast.IfExpression); !ok {
+			pr.write(";")
+		}
+	case *ast.BlockStatement:
+		pr.block(stmt)
+	}
+}
+
+// { から } までを書く。中の文は1段深くインデントする
+func (pr *printer) block(block *ast.BlockStatement) {
+	if len(block.Statements) == 0 && len(block.Comments) == 0 {
+		pr.write("{}")
+		return
+	}
+
+	pr.write("{\n")
+	pr.indent++
+	pr.statements(block.Statements, block.Comments, block.Pos().Line)
+	pr.indent--
+	pr.indented()
+	pr.write("}")
+}
+
+func (pr *printer) expression(exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		pr.write(exp.Value)
+	case *ast.IntegerLiteral:
+		// 書かれた通りの表記を残す。位置のないノードは値から書く
+		if exp.Token.Literal != "" {
+			pr.write(exp.Token.Literal)
+		} else {
+			pr.write(strconv.FormatInt(exp.Value, 10))
+		}
+	case *ast.StringLiteral:
+		pr.write(`"` + exp.Value + `"`)
+	case *ast.BytesLiteral:
+		pr.write(`b"` + exp.Value + `"`)
+	case *ast.Boolean:
+		pr.write(strconv.FormatBool(exp.Value))
+	case *ast.PrefixExpression:
+		pr.write(exp.Operator)
+		pr.operand(exp.Right, prefixPrecedence, false)
+	case *ast.InfixExpression:
+		precedence := infixPrecedence(exp)
+		pr.operand(exp.Left, precedence, false)
+		if exp.Operator == ".." {
+			// 範囲は1..10のように詰めて書く
+			pr.write(exp.Operator)
+		} else {
+			pr.write(" " + exp.Operator + " ")
+		}
+		// 左結合なので、右側に同じ優先順位の式が来る場合は括弧が必要
+		pr.operand(exp.Right, precedence, true)
+	case *ast.IfExpression:
+		pr.write("if (")
+		pr.expression(exp.Condition)
+		pr.write(") ")
+		pr.block(exp.Consequence)
+		if exp.Alternative != nil {
+			pr.write(" else ")
+			pr.block(exp.Alternative)
+		}
+	case *ast.FunctionLiteral:
+		pr.write("fn")
+		pr.parameters(exp.Parameters)
+		pr.write(" ")
+		pr.block(exp.Body)
+	case *ast.MacroLiteral:
+		pr.write("macro")
+		pr.parameters(exp.Parameters)
+		pr.write(" ")
+		pr.block(exp.Body)
+	case *ast.CallExpression:
+		pr.callee(exp.Function)
+		pr.write("(")
+		pr.list(exp.Arguments)
+		pr.write(")")
+	case *ast.ArrayLiteral:
+		pr.write("[")
+		pr.list(exp.Elements)
+		pr.write("]")
+	case *ast.IndexExpression:
+		pr.callee(exp.Left)
+		pr.write("[")
+		pr.expression(exp.Index)
+		pr.write("]")
+	case *ast.HashLiteral:
+		pr.write("{")
+		for i, key := range exp.Keys() {
+			if i > 0 {
+				pr.write(", ")
+			}
+			pr.expression(key)
+			pr.write(": ")
+			pr.expression(exp.Pairs[key])
+		}
+		pr.write("}")
+	default:
+		// 整形の方法を知らないノードはString()の表現をそのまま使う
+		if exp != nil {
+			pr.write(exp.String())
+		}
+	}
+}
+
+// 演算子のオペランドを書く。結合の強さがprecedenceに足りない場合は括弧で囲む
+func (pr *printer) operand(exp ast.Expression, precedence int, right bool) {
+	var needParen bool
+	switch exp := exp.(type) {
+	case *ast.InfixExpression:
+		p := infixPrecedence(exp)
+		needParen = p < precedence || right && p == precedence
+	case *ast.PrefixExpression:
+		needParen = prefixPrecedence < precedence
+	}
+
+	if needParen {
+		pr.write("(")
+		pr.expression(exp)
+		pr.write(")")
+		return
+	}
+	pr.expression(exp)
+}
+
+// 呼び出しや添字の左側を書く。演算子の式は括弧で囲む
+func (pr *printer) callee(exp ast.Expression) {
+	switch exp.(type) {
+	case *ast.InfixExpression, *ast.PrefixExpression:
+		pr.write("(")
+		pr.expression(exp)
+		pr.write(")")
+	default:
+		pr.expression(exp)
+	}
+}
+
+func (pr *printer) list(exps []ast.Expression) {
+	for i, exp := range exps {
+		if i > 0 {
+			pr.write(", ")
+		}
+		pr.expression(exp)
+	}
+}
+
+func (pr *printer) parameters(params []*ast.Identifier) {
+	names := make([]string, len(params))
+	for i, p := range params {
+		names[i] = p.Value
+	}
+	pr.write("(" + strings.Join(names, ", ") + ")")
+}
+
+// 知らない演算子は最も弱く結合するものとして扱い、常に括弧で囲ませる
+func infixPrecedence(exp *ast.InfixExpression) int {
+	return precedences[exp.Operator]
+}
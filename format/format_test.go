@@ -0,0 +1,89 @@
+package format
+
+import (
+	"monkey/ast"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=1+2*3", "let x = 1 + 2 * 3;\n"},
+		{"(1+2)*3;1-(2-3);(1-2)-3", "(1 + 2) * 3;\n1 - (2 - 3);\n1 - 2 - 3;\n"},
+		{"-(a+b);!-x;(-f)(x);-f(x)", "-(a + b);\n!-x;\n(-f)(x);\n-f(x);\n"},
+		{"return;return x", "return;\nreturn x;\n"},
+		{"defer  close(f)", "defer close(f);\n"},
+		{`let b=b"x"+b`, "let b = b\"x\" + b;\n"},
+		{"0 .. n+1;a..(b..c)", "0..n + 1;\na..(b..c);\n"},
+		{
+			"let add=fn(a,b){a+b};add(1,2)",
+			"let add = fn(a, b) {\n  a + b;\n};\nadd(1, 2);\n",
+		},
+		{
+			"if(x){1}else{if(y){2}}",
+			"if (x) {\n  1;\n} else {\n  if (y) {\n    2;\n  }\n}\n",
+		},
+		{"fn(){}", "fn() {};\n"},
+		{`{"b":1,"a":[1,"s",true]}["a"][0]`, "{\"b\": 1, \"a\": [1, \"s\", true]}[\"a\"][0];\n"},
+		{"macro(x){quote(unquote(x))}", "macro(x) {\n  quote(unquote(x));\n};\n"},
+		{
+			"// doc\nlet x = 1; // trailing\n\n\n// next\nx\n// end",
+			"// doc\nlet x = 1; // trailing\n\n// next\nx;\n// end\n",
+		},
+		{
+			"fn() { // open\n  1\n  // close\n}",
+			"fn() { // open\n  1;\n  // close\n};\n",
+		},
+	}
+
+	for _, tt := range tests {
+		output, err := Source([]byte(tt.input))
+		if err != nil {
+			t.Fatalf("Source(%q) returned error: %s", tt.input, err)
+		}
+		if string(output) != tt.expected {
+			t.Errorf("Source(%q) wrong.\nwant=%q\ngot=%q", tt.input, tt.expected, output)
+		}
+
+		// 整形済みのソースは変わらない
+		again, err := Source(output)
+		if err != nil {
+			t.Fatalf("Source(%q) returned error: %s", output, err)
+		}
+		if string(again) != string(output) {
+			t.Errorf("formatting is not idempotent.\nfirst=%q\nsecond=%q", output, again)
+		}
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	_, err := Source([]byte("let = 1;"))
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+
+	expected := "1:5: expected identifier but got '='\nlet = 1;\n    ^"
+	if err.Error() != expected {
+		t.Errorf("error wrong.\nwant=%q\ngot=%q", expected, err.Error())
+	}
+}
+
+func TestNodeWithoutPositions(t *testing.T) {
+	// マクロ展開などで作られた、トークンを持たないノードも整形できる
+	node := &ast.InfixExpression{
+		Left:     &ast.Boolean{Value: true},
+		Operator: "*",
+		Right: &ast.InfixExpression{
+			Left:     &ast.IntegerLiteral{Value: 1},
+			Operator: "+",
+			Right:    &ast.IntegerLiteral{Value: 2},
+		},
+	}
+
+	expected := "true * (1 + 2)"
+	if got := Node(node); got != expected {
+		t.Errorf("Node() wrong. want=%q, got=%q", expected, got)
+	}
+}
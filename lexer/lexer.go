@@ -2,38 +2,152 @@
 
 package lexer
 
-import "monkey/token"
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"monkey/token"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// エラー表示のために、ストリームから読み終えた行を何行残しておくか
+const keepLines = 8
 
 type Lexer struct {
 	input        string
 	position     int // 現在検査中のバイトchの位置
 	readPosition int // 入力における次の位置
 	ch           byte
+	line         int // chの行番号
+	column       int // chの列番号
+
+	// NewReaderで初期化した場合に使う。nilの場合はinputから読む
+	reader      *bufio.Reader
+	lineBuf     []byte   // 読み込み中の行
+	recentLines []string // 読み終えた直近の行
+	linesRead   int      // 読み終えた行数
+
+	operators []operator // AddOperatorで追加した演算子。長いものから順に並べる
+}
+
+// 利用者が追加した演算子
+type operator struct {
+	literal   string
+	tokenType token.TokenType
 }
 
 // ソースコード文字列を引数に取り、初期化する
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
+	l.skipShebang()
 	return l
 }
 
+// io.Readerから少しずつ読み込む字句解析器を初期化する。入力全体をメモリに載せずに済む
+func NewReader(r io.Reader) *Lexer {
+	l := &Lexer{reader: bufio.NewReader(r), line: 1}
+	l.readChar()
+	l.skipShebang()
+	return l
+}
+
+// 実行可能なスクリプトにできるように、先頭の#!で始まる行を読み飛ばす
+func (l *Lexer) skipShebang() {
+	if l.ch != '#' || l.peekChar() != '!' {
+		return
+	}
+
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+}
+
 // 次の1文字を読んでinput文字列の現在位置を進める
 func (l *Lexer) readChar() {
-	if l.readPosition >= len(l.input) {
-		l.ch = 0 // ASCIIコードの"NUL"文字に対応している
-	} else {
-		l.ch = l.input[l.readPosition]
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
 	}
+
+	l.ch = l.nextByte()
 	l.position = l.readPosition
 	l.readPosition += 1
+	l.column += 1
 }
 
-// 現在の1文字を読みこんでトークンを返す
+// 入力から次の1バイトを読む。終端に達した場合は0(ASCIIコードの"NUL"文字)を返す
+func (l *Lexer) nextByte() byte {
+	if l.reader == nil {
+		if l.readPosition >= len(l.input) {
+			return 0
+		}
+		return l.input[l.readPosition]
+	}
+
+	b, err := l.reader.ReadByte()
+	if err != nil {
+		return 0
+	}
+	l.recordByte(b)
+	return b
+}
+
+// ストリームから読んだバイトを行ごとに記録する
+func (l *Lexer) recordByte(b byte) {
+	if b != '\n' {
+		l.lineBuf = append(l.lineBuf, b)
+		return
+	}
+
+	l.recentLines = append(l.recentLines, string(l.lineBuf))
+	if len(l.recentLines) > keepLines {
+		l.recentLines = l.recentLines[1:]
+	}
+	l.lineBuf = l.lineBuf[:0]
+	l.linesRead++
+}
+
+// 次のトークンを読み込み、トークン先頭の位置を付けて返す
 func (l *Lexer) NextToken() token.Token {
+	l.skipWhitespace()
+
+	line, column := l.line, l.column
+	tok := l.readToken()
+	tok.Line = line
+	tok.Column = column
+
+	return tok
+}
+
+// 演算子を追加する。組み込みの演算子よりも優先して、最も長く一致するものを使う
+// パーサのRegisterInfix/RegisterPrefixと組み合わせて文法を拡張できる
+func (l *Lexer) AddOperator(literal string, tokenType token.TokenType) {
+	if literal == "" {
+		return
+	}
+
+	op := operator{literal: literal, tokenType: tokenType}
+	i := sort.Search(len(l.operators), func(i int) bool {
+		return len(l.operators[i].literal) < len(literal)
+	})
+	l.operators = append(l.operators, operator{})
+	copy(l.operators[i+1:], l.operators[i:])
+	l.operators[i] = op
+}
+
+// 現在の1文字を読みこんでトークンを返す
+func (l *Lexer) readToken() token.Token {
 	var tok token.Token
 
-	l.skipWhitespace()
+	for _, op := range l.operators {
+		if l.hasPrefix(op.literal) {
+			return token.Token{Type: op.tokenType, Literal: l.readBytes(len(op.literal))}
+		}
+	}
 
 	switch l.ch {
 	case '=':
@@ -43,6 +157,11 @@ func (l *Lexer) NextToken() token.Token {
 			l.readChar() // 確定したので進める
 			literal := string(ch) + string(l.ch)
 			tok = token.Token{Type: token.EQ, Literal: literal}
+		} else if l.peekChar() == '>' {
+			ch := l.ch
+			l.readChar()
+			literal := string(ch) + string(l.ch)
+			tok = token.Token{Type: token.ARROW, Literal: literal}
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
@@ -62,7 +181,21 @@ func (l *Lexer) NextToken() token.Token {
 	case '*':
 		tok = newToken(token.ASTERISK, l.ch)
 	case '/':
+		if l.peekChar() == '/' {
+			tok.Type = token.COMMENT
+			tok.Literal = l.readComment()
+			return tok
+		}
 		tok = newToken(token.SLASH, l.ch)
+	case '.':
+		if l.peekChar() == '.' {
+			ch := l.ch
+			l.readChar()
+			literal := string(ch) + string(l.ch)
+			tok = token.Token{Type: token.DOTDOT, Literal: literal}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
 	case '<':
 		tok = newToken(token.LT, l.ch)
 	case '>':
@@ -92,7 +225,15 @@ func (l *Lexer) NextToken() token.Token {
 		tok.Literal = ""
 		tok.Type = token.EOF
 	default:
-		if isLetter(l.ch) {
+		r, size := l.currentRune()
+		if l.ch == 'b' && l.peekChar() == '"' {
+			// b"..."はバイト列リテラル。識別子より先に判定する
+			l.readChar()
+			tok.Type = token.BYTES
+			tok.Literal = l.readString()
+			l.readChar()
+			return tok
+		} else if isLetter(r) {
 			// 2文字以上のトークンが予約語か、ユーザ定義の識別子か判定する
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal) // 予約語
@@ -103,7 +244,10 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Type = token.INT
 			return tok
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch)
+			// 複数バイトの文字は分割せずに1つのトークンにする
+			tok.Type = token.ILLEGAL
+			tok.Literal = l.readBytes(size)
+			return tok
 		}
 	}
 
@@ -111,18 +255,111 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
+// 入力のn行目(1始まり)を改行を除いて返す。範囲外の場合は空文字を返す
+// NewReaderで初期化した場合は、直近に読んだ行だけを返せる
+func (l *Lexer) SourceLine(n int) string {
+	if l.reader != nil {
+		return l.streamLine(n)
+	}
+
+	lines := strings.Split(l.input, "\n")
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return strings.TrimSuffix(lines[n-1], "\r")
+}
+
+// SourceLineで取得できる行番号の範囲を返す
+// NewReaderで初期化した場合は、まだ読んでいない行は含まない
+func (l *Lexer) AvailableLines() (first, last int) {
+	if l.reader != nil {
+		return l.linesRead - len(l.recentLines) + 1, l.linesRead + 1
+	}
+	return 1, strings.Count(l.input, "\n") + 1
+}
+
+func (l *Lexer) streamLine(n int) string {
+	if n == l.linesRead+1 {
+		// 読み込み中の行は、バッファに残っている部分も含めて返す
+		rest, _ := l.reader.Peek(l.reader.Buffered())
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			rest = rest[:i]
+		}
+		return strings.TrimSuffix(string(l.lineBuf)+string(rest), "\r")
+	}
+
+	idx := n - (l.linesRead - len(l.recentLines)) - 1
+	if idx < 0 || idx >= len(l.recentLines) {
+		return ""
+	}
+	return strings.TrimSuffix(l.recentLines[idx], "\r")
+}
+
 // トークンを初期化する
 func newToken(tokenType token.TokenType, ch byte) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
-// 予約語を読み込み
+// 予約語を読み込み。2文字目以降には数字も使える
 func (l *Lexer) readIdentifier() string {
-	position := l.position
-	for isLetter(l.ch) {
+	var out strings.Builder
+	for {
+		r, size := l.currentRune()
+		if !isLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		out.WriteString(l.readBytes(size))
+	}
+	return out.String()
+}
+
+// 現在位置から始まる1文字をデコードし、その文字とバイト数を返す
+func (l *Lexer) currentRune() (rune, int) {
+	if l.ch < utf8.RuneSelf {
+		return rune(l.ch), 1
+	}
+
+	buf := []byte{l.ch}
+	if l.reader != nil {
+		next, _ := l.reader.Peek(utf8.UTFMax - 1)
+		buf = append(buf, next...)
+	} else {
+		end := l.readPosition + utf8.UTFMax - 1
+		if end > len(l.input) {
+			end = len(l.input)
+		}
+		buf = append(buf, l.input[l.readPosition:end]...)
+	}
+
+	return utf8.DecodeRune(buf)
+}
+
+// 現在位置からの入力がsで始まるか判定する。読み進めはしない
+func (l *Lexer) hasPrefix(s string) bool {
+	if l.ch != s[0] {
+		return false
+	}
+	rest := s[1:]
+
+	if l.reader != nil {
+		next, err := l.reader.Peek(len(rest))
+		return err == nil && string(next) == rest
+	}
+
+	if l.readPosition > len(l.input) {
+		return false
+	}
+	return strings.HasPrefix(l.input[l.readPosition:], rest)
+}
+
+// nバイト読み進め、読んだバイト列を返す
+func (l *Lexer) readBytes(n int) string {
+	buf := make([]byte, n)
+	for i := range buf {
+		buf[i] = l.ch
 		l.readChar()
 	}
-	return l.input[position:l.position]
+	return string(buf)
 }
 
 // 半角スペースを読み飛ばす
@@ -134,15 +371,24 @@ func (l *Lexer) skipWhitespace() {
 
 // 整数を読み込む
 func (l *Lexer) readNumber() string {
-	position := l.position
+	var out strings.Builder
 	for isDigit(l.ch) {
+		out.WriteByte(l.ch)
 		l.readChar()
 	}
-	return l.input[position:l.position]
+	return out.String()
 }
 
 // のぞき見(peek)。readChar()の、文字解析器を進めずないバージョン。先読みだけを行う
 func (l *Lexer) peekChar() byte {
+	if l.reader != nil {
+		b, err := l.reader.Peek(1)
+		if err != nil {
+			return 0
+		}
+		return b[0]
+	}
+
 	if l.readPosition >= len(l.input) {
 		return 0
 	} else {
@@ -151,14 +397,25 @@ func (l *Lexer) peekChar() byte {
 }
 
 func (l *Lexer) readString() string {
-	position := l.position + 1
+	var out strings.Builder
 	for {
 		l.readChar()
 		if l.ch == '"' || l.ch == 0 {
 			break
 		}
+		out.WriteByte(l.ch)
+	}
+	return out.String()
+}
+
+// //から行末までを読み込む。改行は含めない
+func (l *Lexer) readComment() string {
+	var out strings.Builder
+	for l.ch != '\n' && l.ch != 0 {
+		out.WriteByte(l.ch)
+		l.readChar()
 	}
-	return l.input[position:l.position]
+	return strings.TrimRight(out.String(), "\r")
 }
 
 // 数字か判定する
@@ -166,7 +423,7 @@ func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-// 英字か判定する
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// 識別子に使える文字か判定する。英字に限らずUnicodeの文字を受け付ける
+func isLetter(r rune) bool {
+	return unicode.IsLetter(r) || r == '_'
 }
@@ -3,6 +3,7 @@
 package lexer
 
 import (
+	"strings"
 	"testing"
 
 	"monkey/token"
@@ -34,6 +35,7 @@ if (5 < 10) {
 [1, 2];
 {"foo": "bar"}
 macro(x, y) { x + y; };
+x => x;
 `
 
 	tests := []struct {
@@ -139,6 +141,11 @@ macro(x, y) { x + y; };
 		{token.SEMICOLON, ";"},
 		{token.RBRACE, "}"},
 		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.ARROW, "=>"},
+		{token.IDENT, "x"},
+		{token.SEMICOLON, ";"},
+		{token.EOF, ""},
 	}
 
 	l := New(input)
@@ -157,3 +164,257 @@ macro(x, y) { x + y; };
 		}
 	}
 }
+
+func TestTokenPosition(t *testing.T) {
+	input := `let x = 10;
+  "foo" == x
+!=`
+
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedLine   int
+		expectedColumn int
+	}{
+		{token.LET, 1, 1},
+		{token.IDENT, 1, 5},
+		{token.ASSIGN, 1, 7},
+		{token.INT, 1, 9},
+		{token.SEMICOLON, 1, 11},
+		{token.STRING, 2, 3},
+		{token.EQ, 2, 9},
+		{token.IDENT, 2, 12},
+		{token.NOT_EQ, 3, 1},
+		{token.EOF, 3, 3},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Line != tt.expectedLine || tok.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Line, tok.Column)
+		}
+	}
+}
+
+func TestShebang(t *testing.T) {
+	input := "#!/usr/bin/env monkey run\nlet x = 1;"
+
+	l := New(input)
+	tok := l.NextToken()
+
+	if tok.Type != token.LET {
+		t.Fatalf("tokentype wrong. expected=%q, got=%q", token.LET, tok.Type)
+	}
+	if tok.Line != 2 || tok.Column != 1 {
+		t.Fatalf("position wrong. expected=2:1, got=%d:%d", tok.Line, tok.Column)
+	}
+}
+
+func TestIdentifierWithDigits(t *testing.T) {
+	l := New("x1 _2 3y")
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "x1"},
+		{token.IDENT, "_2"},
+		{token.INT, "3"},
+		{token.IDENT, "y"},
+	}
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token wrong. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestNewReader(t *testing.T) {
+	input := `#!/usr/bin/env monkey
+let add = fn(x, y) {
+  x + y;
+};
+let s = "foo bar";
+let 名前 = s;
+[1, 2] != {"a": add(10, 20)};
+`
+
+	expected := New(input)
+	l := NewReader(strings.NewReader(input))
+
+	for i := 0; ; i++ {
+		want := expected.NextToken()
+		got := l.NextToken()
+
+		if got != want {
+			t.Fatalf("tokens[%d] wrong. want=%+v, got=%+v", i, want, got)
+		}
+		if got.Type == token.EOF {
+			break
+		}
+	}
+}
+
+func TestNewReaderSourceLine(t *testing.T) {
+	input := "let a = 1;\nlet b = 2;\nlet c = 3;\n"
+	l := NewReader(strings.NewReader(input))
+
+	// 2行目のletまで読み進める
+	for tok := l.NextToken(); tok.Line < 2; tok = l.NextToken() {
+	}
+
+	if line := l.SourceLine(1); line != "let a = 1;" {
+		t.Errorf("SourceLine(1) wrong. got=%q", line)
+	}
+	if line := l.SourceLine(2); line != "let b = 2;" {
+		t.Errorf("SourceLine(2) wrong. got=%q", line)
+	}
+	if line := l.SourceLine(5); line != "" {
+		t.Errorf("SourceLine(5) should be empty. got=%q", line)
+	}
+}
+
+func TestComment(t *testing.T) {
+	l := New("10 / 2 // half\r\n// next\nx")
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "10"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.COMMENT, "// half"},
+		{token.COMMENT, "// next"},
+		{token.IDENT, "x"},
+		{token.EOF, ""},
+	}
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token wrong. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestUnicodeIdentifier(t *testing.T) {
+	l := New("let 変数 = café1 + ñ_２; →")
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "変数"},
+		{token.ASSIGN, "="},
+		{token.IDENT, "café1"},
+		{token.PLUS, "+"},
+		{token.IDENT, "ñ_２"},
+		{token.SEMICOLON, ";"},
+		{token.ILLEGAL, "→"},
+		{token.EOF, ""},
+	}
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token wrong. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestAddOperator(t *testing.T) {
+	input := "a |> b % c | d"
+
+	for _, l := range []*Lexer{New(input), NewReader(strings.NewReader(input))} {
+		l.AddOperator("|", "|")
+		l.AddOperator("%", "%")
+		l.AddOperator("|>", "|>")
+
+		tests := []struct {
+			expectedType    token.TokenType
+			expectedLiteral string
+		}{
+			{token.IDENT, "a"},
+			{"|>", "|>"},
+			{token.IDENT, "b"},
+			{"%", "%"},
+			{token.IDENT, "c"},
+			{"|", "|"},
+			{token.IDENT, "d"},
+			{token.EOF, ""},
+		}
+
+		for i, tt := range tests {
+			tok := l.NextToken()
+			if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+				t.Fatalf("tests[%d] - token wrong. expected=%q %q, got=%q %q",
+					i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+			}
+		}
+	}
+}
+
+func TestBytesLiteral(t *testing.T) {
+	l := New(`b"abc" b + bar"x" b""`)
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedColumn  int
+	}{
+		{token.BYTES, "abc", 1},
+		{token.IDENT, "b", 8},
+		{token.PLUS, "+", 10},
+		// bで始まる識別子の直後の文字列はバイト列にしない
+		{token.IDENT, "bar", 12},
+		{token.STRING, "x", 15},
+		{token.BYTES, "", 19},
+		{token.EOF, "", 22},
+	}
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral || tok.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - token wrong. expected=%q %q at %d, got=%q %q at %d",
+				i, tt.expectedType, tt.expectedLiteral, tt.expectedColumn, tok.Type, tok.Literal, tok.Column)
+		}
+	}
+}
+
+func TestDotDot(t *testing.T) {
+	l := New("1..10 .")
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "1"},
+		{token.DOTDOT, ".."},
+		{token.INT, "10"},
+		{token.ILLEGAL, "."},
+		{token.EOF, ""},
+	}
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token wrong. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
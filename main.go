@@ -1,13 +1,48 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"monkey/diag"
 	"monkey/repl"
 	"os"
 	"os/user"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "run":
+			os.Exit(runCommand(os.Args[2:]))
+		case "check":
+			os.Exit(checkCommand(os.Args[2:]))
+		case "bench":
+			os.Exit(benchCommand(os.Args[2:]))
+		case "fmt":
+			os.Exit(fmtCommand(os.Args[2:]))
+		case "disasm":
+			os.Exit(disasmCommand(os.Args[2:]))
+		}
+	}
+
+	noColor := flag.Bool("no-color", false, "disable colored output")
+	engine := engineFlag(flag.CommandLine)
+	flag.Parse()
+	if err := checkEngine(*engine); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitRuntimeError)
+	}
+
+	// #!/usr/bin/env monkey から起動された場合はファイル名が渡される
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(append([]string{"-engine=" + *engine}, flag.Args()...)))
+	}
+
+	// パイプで渡された場合は、入力全体を1つのプログラムとして評価する
+	if !isInteractive(os.Stdin) {
+		os.Exit(runFile("-", runOptions{noColor: *noColor, engine: *engine}, os.Stdout, os.Stderr))
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -15,5 +50,19 @@ func main() {
 	fmt.Printf("Hello %s! This is the Monkey programming language!\n",
 		user.Username)
 	fmt.Printf("Feel free to type in commnds\n")
-	repl.Start(os.Stdin, os.Stdout)
+
+	opts := repl.DefaultOptions()
+	opts.Color = diag.ColorEnabled(!*noColor, os.Stdout)
+	opts.RCFile = repl.DefaultRCPath()
+	opts.Engine = *engine
+	repl.StartWithOptions(os.Stdin, os.Stdout, opts)
+}
+
+// 端末からの入力か判定する
+func isInteractive(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
 }
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"monkey/repl"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// 一時ディレクトリにスクリプトを書き出して、そのパスを返す
+func writeScript(t *testing.T, name, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		opts   runOptions
+		code   int
+		out    string // 標準出力と一致する
+		errOut string // 標準エラー出力に含まれる。空の場合は何も出力しない
+	}{
+		{"ok", "let a = 1; a + 1", runOptions{}, exitOK, "", ""},
+		{"parse error", "let = 1", runOptions{}, exitParseError, "", "/script.mky:1:5: expected identifier but got '='\nlet = 1\n    ^\n"},
+		{"runtime error", "let a = 1; a + true", runOptions{}, exitRuntimeError, "", "/script.mky:1:14: type mismatch: INTEGER + BOOLEAN\n"},
+		{"vm", "let a = 1; a + 1", runOptions{engine: "vm"}, exitOK, "", ""},
+		{"runtime error vm", "let a = 1;\na + true", runOptions{engine: "vm"}, exitRuntimeError, "", "/script.mky:2:3: type mismatch: INTEGER + BOOLEAN"},
+		{"compile error", "quote(unquote(unquote(1)))", runOptions{engine: "vm"}, exitRuntimeError, "", "nested unquote is not supported by the compiler"},
+		// 構文エラーがあれば、ASTを表示する場合も構文エラーとして終了する
+		{"ast parse error", "let = 1", runOptions{dumpAst: true}, exitParseError, "", "expected identifier but got '='"},
+		// ARGVが期待と違えばエラーにする
+		{"argv", `let want = {"a": 0, "-b": 1}; if (len(ARGV) != 2) { 1 + true }; if (want[ARGV[0]] != 0) { 1 + true }; if (want[ARGV[1]] != 1) { 1 + true }`, runOptions{scriptArgs: []string{"a", "-b"}}, exitOK, "", ""},
+		{"empty argv", `if (len(ARGV) != 0) { 1 + true }`, runOptions{}, exitOK, "", ""},
+		// putsは標準出力に書く
+		{"puts", `puts("hello")`, runOptions{}, exitOK, "hello\n", ""},
+		{"puts vm", `puts("hello")`, runOptions{engine: "vm"}, exitOK, "hello\n", ""},
+		{"ast", "1 + 2", runOptions{dumpAst: true}, exitOK, "Program\n  ExpressionStatement\n    InfixExpression +\n      IntegerLiteral 1\n      IntegerLiteral 2\n", ""},
+		// ASTを表示する場合は評価しない
+		{"ast without eval", "1 + true", runOptions{dumpAst: true}, exitOK, "Program\n  ExpressionStatement\n    InfixExpression +\n      IntegerLiteral 1\n      Boolean true\n", ""},
+	}
+
+	for _, tt := range tests {
+		tt.opts.noColor = true
+		if tt.opts.engine == "" {
+			tt.opts.engine = "eval"
+		}
+		path := writeScript(t, "script.mky", tt.src)
+
+		var out, errOut bytes.Buffer
+		code := runFile(path, tt.opts, &out, &errOut)
+
+		if code != tt.code {
+			t.Errorf("%s: wrong exit code. want=%d, got=%d (stderr=%q)", tt.name, tt.code, code, errOut.String())
+		}
+		if out.String() != tt.out {
+			t.Errorf("%s: wrong stdout. want=%q, got=%q", tt.name, tt.out, out.String())
+		}
+		if tt.errOut == "" && errOut.Len() != 0 {
+			t.Errorf("%s: unexpected stderr. got=%q", tt.name, errOut.String())
+		}
+		if !strings.Contains(errOut.String(), tt.errOut) || strings.Contains(errOut.String(), "\x1b[") {
+			t.Errorf("%s: wrong stderr. want to contain %q, got=%q", tt.name, tt.errOut, errOut.String())
+		}
+	}
+}
+
+func TestDefaultEngine(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	engine := engineFlag(fs)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if *engine != repl.EngineVM {
+		t.Errorf("wrong default engine. got=%q", *engine)
+	}
+	if got := repl.DefaultOptions().Engine; got != repl.EngineVM {
+		t.Errorf("wrong default engine of the REPL. got=%q", got)
+	}
+}
+
+func TestRunFileMissing(t *testing.T) {
+	var out, errOut bytes.Buffer
+	code := runFile(filepath.Join(t.TempDir(), "missing.mky"), runOptions{noColor: true, engine: "eval"}, &out, &errOut)
+	if code != exitRuntimeError {
+		t.Errorf("wrong exit code. want=%d, got=%d", exitRuntimeError, code)
+	}
+	if !strings.Contains(errOut.String(), "no such file or directory") {
+		t.Errorf("wrong stderr. got=%q", errOut.String())
+	}
+}
+
+func TestCheckFiles(t *testing.T) {
+	good := writeScript(t, "good.mky", "let a = 1;")
+	bad := writeScript(t, "bad.mky", "let = 1;\nlet b = ;")
+	// 評価はしないので、実行時エラーになるプログラムも通す
+	runtimeError := writeScript(t, "runtime.mky", "1 + true")
+	missing := filepath.Join(t.TempDir(), "missing.mky")
+
+	tests := []struct {
+		files  []string
+		code   int
+		errOut []string // 標準エラー出力に含まれる。空の場合は何も出力しない
+	}{
+		{[]string{good}, exitOK, nil},
+		{[]string{good, runtimeError}, exitOK, nil},
+		// 全てのファイルの全てのエラーを報告する
+		{[]string{bad, good, bad}, exitParseError, []string{"parse error: " + bad + ":1:5: ", "parse error: " + bad + ":2:9: "}},
+		// 読めないファイルがあれば、構文エラーよりも優先する
+		{[]string{missing, bad}, exitRuntimeError, []string{"no such file or directory", bad + ":1:5: "}},
+		{[]string{bad, missing}, exitRuntimeError, []string{bad + ":1:5: ", "no such file or directory"}},
+	}
+
+	for _, tt := range tests {
+		var errOut bytes.Buffer
+		code := checkFiles(tt.files, false, 0, &errOut)
+		if code != tt.code {
+			t.Errorf("%v: wrong exit code. want=%d, got=%d", tt.files, tt.code, code)
+		}
+		if len(tt.errOut) == 0 && errOut.Len() != 0 {
+			t.Errorf("%v: unexpected stderr. got=%q", tt.files, errOut.String())
+		}
+		for _, want := range tt.errOut {
+			if !strings.Contains(errOut.String(), want) {
+				t.Errorf("%v: wrong stderr. want to contain %q, got=%q", tt.files, want, errOut.String())
+			}
+		}
+	}
+}
+
+func TestRunStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// パイプは端末ではない
+	if isInteractive(r) {
+		t.Errorf("a pipe should not be interactive")
+	}
+
+	w.WriteString("let a = 1;\na + true")
+	w.Close()
+
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	// 入力全体を1つのプログラムとして評価し、エラーには標準入力の名前を付ける
+	var out, errOut bytes.Buffer
+	code := runFile("-", runOptions{noColor: true, engine: "eval"}, &out, &errOut)
+	if code != exitRuntimeError {
+		t.Errorf("wrong exit code. want=%d, got=%d", exitRuntimeError, code)
+	}
+	expected := "runtime error: " + STDIN_NAME + ":2:3: type mismatch: INTEGER + BOOLEAN\n"
+	if errOut.String() != expected {
+		t.Errorf("wrong stderr. want=%q, got=%q", expected, errOut.String())
+	}
+}
+
+func TestBenchFile(t *testing.T) {
+	good := writeScript(t, "good.mky", "let f = fn(x) { x * 2 }; f(3)")
+	bad := writeScript(t, "bad.mky", "1 + true")
+	broken := writeScript(t, "broken.mky", "let = 1")
+
+	// 時間とアロケーションは実行ごとに変わるので、形式だけを確かめる
+	result := regexp.MustCompile(`^runs: 3\nmin: \S+  avg: \S+  max: \S+\nallocs/run: \d+  bytes/run: \d+\n$`)
+	for _, engine := range []string{"eval", "vm"} {
+		var out, errOut bytes.Buffer
+		code := benchFile(good, benchOptions{runs: 3, noColor: true, engine: engine}, &out, &errOut)
+		if code != exitOK {
+			t.Errorf("%s: wrong exit code. want=%d, got=%d (stderr=%q)", engine, exitOK, code, errOut.String())
+		}
+		if !result.MatchString(out.String()) || errOut.Len() != 0 {
+			t.Errorf("%s: wrong output. stdout=%q, stderr=%q", engine, out.String(), errOut.String())
+		}
+	}
+
+	tests := []struct {
+		file   string
+		code   int
+		errOut string
+	}{
+		{bad, exitRuntimeError, "runtime error: " + bad + ":1:3: type mismatch: INTEGER + BOOLEAN\n"},
+		{broken, exitParseError, "parse error: " + broken + ":1:5: "},
+		{filepath.Join(t.TempDir(), "missing.mky"), exitRuntimeError, "no such file or directory"},
+	}
+
+	for _, tt := range tests {
+		var out, errOut bytes.Buffer
+		code := benchFile(tt.file, benchOptions{runs: 3, noColor: true, engine: "eval"}, &out, &errOut)
+		if code != tt.code {
+			t.Errorf("%s: wrong exit code. want=%d, got=%d", tt.file, tt.code, code)
+		}
+		if out.Len() != 0 || !strings.Contains(errOut.String(), tt.errOut) {
+			t.Errorf("%s: wrong output. stdout=%q, stderr=%q", tt.file, out.String(), errOut.String())
+		}
+	}
+}
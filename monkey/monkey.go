@@ -0,0 +1,104 @@
+// GoのプログラムにMonkeyを組み込むためのパッケージ
+// 字句解析、構文解析、マクロの展開、評価をまとめて行うので、このパッケージだけをimportすればよい
+//
+//	result, err := monkey.Run(`let add = fn(a, b) { a + b }; add(1, 2)`)
+//	fmt.Println(result) // 3
+
+package monkey
+
+import (
+	"context"
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"strings"
+)
+
+// 構文エラー。見つかったエラーをすべて持つ
+type ParseError struct {
+	Errors []parser.ParseError
+}
+
+func (e *ParseError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// マクロの展開に失敗したエラー。失敗したすべての展開を持つ
+type MacroError struct {
+	Errors []*evaluator.MacroError
+}
+
+func (e *MacroError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// 実行時エラー。スクリプトの中で起きたエラーを、位置とスタックトレースと合わせて持つ
+type RuntimeError struct {
+	Err *object.Error
+}
+
+func (e *RuntimeError) Error() string {
+	return e.Err.Located("") + e.Err.StackTrace()
+}
+
+// 実行した結果
+type Result struct {
+	Value object.Object       // 最後に評価した値
+	Env   *object.Environment // 実行後のグローバル変数の束縛。letで定義した値を取り出せる
+}
+
+// 値をREPLと同じ形で表示する
+func (r Result) String() string {
+	if r.Value == nil {
+		return ""
+	}
+	return r.Value.Inspect()
+}
+
+// ソースを構文解析する。構文エラーがあれば*ParseErrorを返す
+func Parse(src string) (*ast.Program, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.ParseErrors()) != 0 {
+		return nil, &ParseError{Errors: p.ParseErrors()}
+	}
+	return program, nil
+}
+
+// ソースを構文解析し、マクロを展開して評価器で評価する
+// 失敗した段階に応じて*ParseError、*MacroError、*RuntimeErrorを返す
+// 実行時エラーの場合も、それまでに束縛した値はResult.Envから読める
+func Run(src string) (Result, error) {
+	program, err := Parse(src)
+	if err != nil {
+		return Result{}, err
+	}
+
+	macroEnv := object.NewEnvironment()
+	evaluator.DefineMacros(program, macroEnv)
+	expanded, macroErrors := evaluator.ExpandMacros(program, macroEnv)
+	if len(macroErrors) != 0 {
+		return Result{}, &MacroError{Errors: macroErrors}
+	}
+
+	// 閉じ忘れたファイルは実行が終わったら閉じる
+	files := evaluator.NewFiles()
+	defer files.Close()
+
+	env := object.NewEnvironment()
+	evaluated := evaluator.EvalWithOptions(context.Background(), expanded, env, evaluator.Options{Files: files})
+	if errObj, ok := evaluated.(*object.Error); ok {
+		return Result{Env: env}, &RuntimeError{Err: errObj}
+	}
+	return Result{Value: evaluated, Env: env}, nil
+}
@@ -0,0 +1,105 @@
+package monkey
+
+import (
+	"errors"
+	"fmt"
+	"monkey/object"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2", "3"},
+		{"let add = fn(a, b) { a + b }; add(1, 2)", "3"},
+		{`let unless = macro(c, a, b) { quote(if (!(unquote(c))) { unquote(a) } else { unquote(b) }) }; unless(false, "yes", "no")`, "yes"},
+		{"quote(1 + 2)", "QUOTE((1 + 2))"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		result, err := Run(tt.input)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %s", tt.input, err)
+		}
+		if got := result.String(); got != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestRunEnv(t *testing.T) {
+	result, err := Run("let x = 10; let y = x * 2;")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	y, ok := result.Env.Get("y")
+	if !ok || y.Inspect() != "20" {
+		t.Errorf("wrong value of y. got=%v", y)
+	}
+}
+
+func TestRunClosesFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.txt")
+	result, err := Run(fmt.Sprintf(`open("%s", "w")`, path))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f, ok := result.Value.(*object.File)
+	if !ok {
+		t.Fatalf("object is not File. got=%T (%+v)", result.Value, result.Value)
+	}
+	if !f.Closed {
+		t.Errorf("file was not closed after Run")
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	// 構文エラーはすべて返す
+	_, err := Run("let = 1; let = 2;")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected *ParseError. got=%T (%v)", err, err)
+	}
+	if len(parseErr.Errors) < 2 {
+		t.Errorf("expected errors from both statements. got=%d", len(parseErr.Errors))
+	}
+
+	_, err = Run("let m = macro() { 1 + true }; m()")
+	var macroErr *MacroError
+	if !errors.As(err, &macroErr) {
+		t.Fatalf("expected *MacroError. got=%T (%v)", err, err)
+	}
+
+	// 実行時エラーは位置とスタックトレースを含み、それまでの束縛は残る
+	result, err := Run("let x = 1;\nlet f = fn() { x + true };\nf()")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected *RuntimeError. got=%T (%v)", err, err)
+	}
+	expected := "2:18: type mismatch: INTEGER + BOOLEAN\n    at f (3:1)"
+	if err.Error() != expected {
+		t.Errorf("wrong error message. want=%q, got=%q", expected, err.Error())
+	}
+	if _, ok := result.Env.Get("x"); !ok {
+		t.Errorf("x is not in the environment")
+	}
+}
+
+func TestParse(t *testing.T) {
+	program, err := Parse("let x = 1; x")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(program.Statements) != 2 {
+		t.Errorf("expected 2 statements. got=%d", len(program.Statements))
+	}
+
+	if _, err := Parse("let x 1"); err == nil || !strings.Contains(err.Error(), "1:7") {
+		t.Errorf("expected a parse error at 1:7. got=%v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package object
+
+import "bytes"
+
+// 大小比較できるオブジェクト
+// Compareはレシーバがotherより小さければ負、等しければ0、大きければ正を返す
+// otherはレシーバと同じ型でなければならない
+type Comparable interface {
+	Compare(other Object) int
+}
+
+func (i *Integer) Compare(other Object) int {
+	o := other.(*Integer).Value
+	switch {
+	case i.Value < o:
+		return -1
+	case i.Value > o:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (b *BigInt) Compare(other Object) int {
+	return b.Value.Cmp(other.(*BigInt).Value)
+}
+
+func (s *String) Compare(other Object) int {
+	o := other.(*String).Value
+	switch {
+	case s.Value < o:
+		return -1
+	case s.Value > o:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (b *Bytes) Compare(other Object) int {
+	return bytes.Compare(b.Value, other.(*Bytes).Value)
+}
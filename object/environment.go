@@ -1,9 +1,23 @@
 // 環境。文字列とオブジェクトを関連付けるハッシュマップが本質
 package object
 
+import (
+	"sort"
+	"sync"
+)
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
+	env.depth = outer.depth
+	return env
+}
+
+// 関数呼び出しのための環境を作る。closureは関数を定義した環境、callerは呼び出し元の環境
+// 名前はclosureから探し、呼び出しの深さはcallerより1つ深くする
+func NewCallEnvironment(closure, caller *Environment) *Environment {
+	env := NewEnclosedEnvironment(closure)
+	env.depth = caller.depth + 1
 	return env
 }
 
@@ -12,13 +26,56 @@ func NewEnvironment() *Environment {
 	return &Environment{store: s}
 }
 
+// 複数のgoroutineから同時に使える環境を作る。GetとSetをロックで保護する
+// 関数呼び出しのたびに作る内側の環境は呼び出しごとに別なので、ロックしない
+func NewSyncedEnvironment() *Environment {
+	env := NewEnvironment()
+	env.mu = &sync.RWMutex{}
+	return env
+}
+
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store  map[string]Object
+	outer  *Environment
+	depth  int           // 関数呼び出しの深さ。トップレベルは0
+	mu     *sync.RWMutex // NewSyncedEnvironmentで作った場合だけ使う
+	shared bool          // storeをスナップショットと共有している。次のSetでコピーする
+}
+
+// Getは評価中に何度も呼ばれるので、ロックしない環境では余計な処理をしない
+func (e *Environment) rlock() {
+	if e.mu != nil {
+		e.mu.RLock()
+	}
+}
+
+func (e *Environment) runlock() {
+	if e.mu != nil {
+		e.mu.RUnlock()
+	}
+}
+
+func (e *Environment) lock() {
+	if e.mu != nil {
+		e.mu.Lock()
+	}
+}
+
+func (e *Environment) unlock() {
+	if e.mu != nil {
+		e.mu.Unlock()
+	}
+}
+
+// 関数呼び出しの深さを返す
+func (e *Environment) CallDepth() int {
+	return e.depth
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
+	e.rlock()
 	obj, ok := e.store[name]
+	e.runlock()
 
 	// 見つからない場合、包み込んでいる環境から再帰的に探す
 	if !ok && e.outer != nil {
@@ -28,6 +85,93 @@ func (e *Environment) Get(name string) (Object, bool) {
 }
 
 func (e *Environment) Set(name string, val Object) Object {
+	if e.mu != nil {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+	}
+	if e.shared {
+		e.store = copyStore(e.store)
+		e.shared = false
+	}
 	e.store[name] = val
 	return val
 }
+
+// ある時点の環境の束縛。Restoreで戻せる
+type Snapshot struct {
+	store map[string]Object
+}
+
+// 今の束縛を記録したスナップショットを返す。包み込んでいる環境の束縛は含まない
+// 束縛はコピーせずに共有し、次にSetするときに初めてコピーする
+func (e *Environment) Snapshot() *Snapshot {
+	e.lock()
+	defer e.unlock()
+	e.shared = true
+	return &Snapshot{store: e.store}
+}
+
+// スナップショットを取った時点の束縛に戻す。同じスナップショットから何度でも戻せる
+func (e *Environment) Restore(s *Snapshot) {
+	e.lock()
+	defer e.unlock()
+	e.store = s.store
+	e.shared = true
+}
+
+func copyStore(store map[string]Object) map[string]Object {
+	c := make(map[string]Object, len(store))
+	for name, val := range store {
+		c[name] = val
+	}
+	return c
+}
+
+// namesのうちこの環境で束縛されているものだけを持つ、新しい環境を返す
+// 包み込んでいる環境と呼び出しの深さは同じものを使うので、namesの解決結果は変わらない
+func (e *Environment) Prune(names []string) *Environment {
+	env := NewEnvironment()
+	env.outer = e.outer
+	env.depth = e.depth
+
+	e.rlock()
+	defer e.runlock()
+	for _, name := range names {
+		if val, ok := e.store[name]; ok {
+			env.store[name] = val
+		}
+	}
+	return env
+}
+
+// 包み込んでいる環境を返す。一番外側の環境ではnilを返す
+func (e *Environment) Outer() *Environment {
+	return e.outer
+}
+
+// この環境の束縛をコピーして返す。包み込んでいる環境の束縛は含まないので、Outerでたどる
+func (e *Environment) All() map[string]Object {
+	e.rlock()
+	defer e.runlock()
+	return copyStore(e.store)
+}
+
+// 束縛されている名前の一覧をソートして返す。包み込んでいる環境の名前も含む
+func (e *Environment) Names() []string {
+	seen := make(map[string]bool)
+	names := []string{}
+
+	for env := e; env != nil; env = env.outer {
+		env.rlock()
+		for name := range env.store {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		env.runlock()
+	}
+
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,149 @@
+package object
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEnvironmentNames(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("b", &Integer{Value: 1})
+	outer.Set("x", &Integer{Value: 2})
+
+	inner := NewEnclosedEnvironment(outer)
+	inner.Set("a", &Integer{Value: 3})
+	inner.Set("x", &Integer{Value: 4})
+
+	expected := []string{"a", "b", "x"}
+	if names := inner.Names(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("inner.Names() wrong. want=%v, got=%v", expected, names)
+	}
+}
+
+func TestCallDepth(t *testing.T) {
+	global := NewEnvironment()
+	closure := NewEnclosedEnvironment(global)
+	caller := NewCallEnvironment(global, global)
+	callee := NewCallEnvironment(closure, caller)
+
+	tests := []struct {
+		env      *Environment
+		expected int
+	}{
+		{global, 0},
+		{closure, 0},
+		{caller, 1},
+		{callee, 2},
+		{NewEnclosedEnvironment(callee), 2},
+	}
+
+	for i, tt := range tests {
+		if got := tt.env.CallDepth(); got != tt.expected {
+			t.Errorf("tests[%d] - wrong depth. want=%d, got=%d", i, tt.expected, got)
+		}
+	}
+}
+
+func TestEnvironmentPrune(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("o", &Integer{Value: 1})
+
+	env := NewCallEnvironment(outer, outer)
+	env.Set("a", &Integer{Value: 2})
+	env.Set("b", &Integer{Value: 3})
+
+	pruned := env.Prune([]string{"a", "o", "missing"})
+
+	expected := []string{"a", "o"}
+	if names := pruned.Names(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("pruned.Names() wrong. want=%v, got=%v", expected, names)
+	}
+	if pruned.CallDepth() != env.CallDepth() {
+		t.Errorf("wrong depth. want=%d, got=%d", env.CallDepth(), pruned.CallDepth())
+	}
+}
+
+func TestSyncedEnvironment(t *testing.T) {
+	env := NewSyncedEnvironment()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				name := fmt.Sprintf("v%d_%d", i, j)
+				env.Set(name, &Integer{Value: int64(j)})
+				if _, ok := env.Get(name); !ok {
+					t.Errorf("%s not found", name)
+				}
+				NewEnclosedEnvironment(env).Get(name)
+				env.Names()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := len(env.Names()); n != 800 {
+		t.Errorf("wrong number of names. want=800, got=%d", n)
+	}
+}
+
+func TestEnvironmentSnapshot(t *testing.T) {
+	env := NewEnvironment()
+	env.Set("a", &Integer{Value: 1})
+
+	snap := env.Snapshot()
+	env.Set("a", &Integer{Value: 2})
+	env.Set("b", &Integer{Value: 3})
+
+	// スナップショットを取った後の変更はスナップショットに影響しない
+	if _, ok := snap.store["b"]; ok {
+		t.Fatalf("snapshot was modified by Set")
+	}
+
+	env.Restore(snap)
+	if val, _ := env.Get("a"); val.(*Integer).Value != 1 {
+		t.Errorf("a was not restored. got=%s", val.Inspect())
+	}
+	if _, ok := env.Get("b"); ok {
+		t.Errorf("b must not exist after Restore")
+	}
+
+	// 戻した後の変更もスナップショットに影響しないので、何度でも戻せる
+	env.Set("c", &Integer{Value: 4})
+	env.Restore(snap)
+	if names := env.Names(); len(names) != 1 || names[0] != "a" {
+		t.Errorf("wrong names after second Restore. got=%v", names)
+	}
+}
+
+func TestEnvironmentIntrospection(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("a", &Integer{Value: 1})
+	outer.Set("b", &Integer{Value: 2})
+	inner := NewEnclosedEnvironment(outer)
+	inner.Set("b", &Integer{Value: 3})
+
+	if inner.Outer() != outer || outer.Outer() != nil {
+		t.Fatalf("wrong outer environment")
+	}
+
+	// Allはその環境の束縛だけを返す
+	all := inner.All()
+	if len(all) != 1 || all["b"].Inspect() != "3" {
+		t.Errorf("wrong bindings. got=%v", all)
+	}
+	if got := strings.Join(inner.Names(), ","); got != "a,b" {
+		t.Errorf("wrong names. got=%q", got)
+	}
+
+	// 返したmapを書き換えても環境は変わらない
+	all["c"] = &Integer{Value: 4}
+	if _, ok := inner.Get("c"); ok {
+		t.Errorf("All must return a copy")
+	}
+}
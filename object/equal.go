@@ -0,0 +1,70 @@
+package object
+
+import "bytes"
+
+// 2つのオブジェクトが等しいかを返す
+// 整数・真偽値・文字列・バイト列・null・範囲は値で比べ、整数と任意精度の整数も値で比べる、配列とハッシュは要素を再帰的に比べる
+// 関数のようにそれ以外のものは、同じオブジェクトの場合だけ等しい
+func Equal(a, b Object) bool {
+	if a == b {
+		return true
+	}
+	if a == nil || b == nil {
+		return false
+	}
+	// IntegerとBigIntは型が違っても値で比べる
+	if a.Type() == BIGINT_OBJ || b.Type() == BIGINT_OBJ {
+		x, ok := AsBigInt(a)
+		y, ok2 := AsBigInt(b)
+		return ok && ok2 && x.Cmp(y) == 0
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a := a.(type) {
+	case *Integer:
+		return a.Value == b.(*Integer).Value
+	case *Boolean:
+		return a.Value == b.(*Boolean).Value
+	case *String:
+		return a.Value == b.(*String).Value
+	case *Bytes:
+		return bytes.Equal(a.Value, b.(*Bytes).Value)
+	case *Null:
+		return true
+	case *Range:
+		// 要素の並びが同じなら等しい。1..1と3..3のような空の範囲も等しい
+		other := b.(*Range)
+		n := a.Len()
+		if n != other.Len() {
+			return false
+		}
+		return n == 0 || a.Start == other.Start && (n == 1 || a.Step == other.Step)
+	case *Array:
+		other := b.(*Array)
+		if len(a.Elements) != len(other.Elements) {
+			return false
+		}
+		for i, el := range a.Elements {
+			if !Equal(el, other.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		other := b.(*Hash)
+		if len(a.Pairs) != len(other.Pairs) {
+			return false
+		}
+		for key, pair := range a.Pairs {
+			otherPair, ok := other.Pairs[key]
+			if !ok || !Equal(pair.Value, otherPair.Value) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
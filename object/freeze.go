@@ -0,0 +1,44 @@
+package object
+
+import "fmt"
+
+// 配列とハッシュを、中に含む配列とハッシュも含めて変更できなくする
+// 既に変更できないものはたどらないので、自分自身を含んでいても止まる
+func Freeze(obj Object) {
+	switch obj := obj.(type) {
+	case *Array:
+		if obj.Frozen {
+			return
+		}
+		obj.Frozen = true
+		for _, el := range obj.Elements {
+			Freeze(el)
+		}
+	case *Hash:
+		if obj.Frozen {
+			return
+		}
+		obj.Frozen = true
+		for _, pair := range obj.Pairs {
+			Freeze(pair.Key)
+			Freeze(pair.Value)
+		}
+	}
+}
+
+// 変更できない値かどうかを返す。配列とハッシュ以外の値は、もともと変更できない
+func IsFrozen(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Array:
+		return obj.Frozen
+	case *Hash:
+		return obj.Frozen
+	default:
+		return true
+	}
+}
+
+// 変更できなくした値を変更しようとしたときのエラー
+func frozenError(obj Object) *Error {
+	return &Error{Kind: TYPE_ERROR, Message: fmt.Sprintf("cannot modify a frozen %s", obj.Type())}
+}
@@ -0,0 +1,119 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+)
+
+// InspectWithの表示の設定。ゼロ値ではInspectと同じく1行で全て表示する
+type InspectOptions struct {
+	// 入れ物の要素を1行ずつ書くときの字下げ。空の場合は1行にまとめる
+	Indent string
+	// 表示する入れ物の深さの上限。これより深い入れ物は[...]や{...}と書く。0以下の場合は制限しない
+	MaxDepth int
+	// 1つの入れ物から表示する要素の数の上限。残りは数だけを書く。0以下の場合は制限しない
+	MaxItems int
+}
+
+// 設定に従ってオブジェクトを文字列にする。大きな入れ物を読みやすく表示するのに使う
+func InspectWith(obj Object, opts InspectOptions) string {
+	ins := &inspector{opts: opts}
+	ins.write(obj, 0)
+	return ins.out.String()
+}
+
+// 配列とハッシュを設定なしで文字列にする
+func inspect(obj Object) string {
+	return InspectWith(obj, InspectOptions{})
+}
+
+// visitingは表示している途中の入れ物
+// 入れ物が自分自身を含んでいても無限に再帰しないように、表示中の入れ物にもう一度出会ったら[...]や{...}と書く
+// 同じ入れ物を別々の場所から参照しているだけなら、循環ではないのでそれぞれ表示する
+type inspector struct {
+	opts     InspectOptions
+	out      strings.Builder
+	visiting map[Object]bool
+}
+
+func (ins *inspector) write(obj Object, depth int) {
+	switch obj := obj.(type) {
+	case *Array:
+		if ins.visiting[obj] || ins.tooDeep(depth) {
+			ins.out.WriteString("[...]")
+			return
+		}
+		ins.enter(obj)
+		defer delete(ins.visiting, obj)
+
+		ins.items("[", "]", len(obj.Elements), depth, func(i int) {
+			ins.write(obj.Elements[i], depth+1)
+		})
+	case *Hash:
+		if ins.visiting[obj] || ins.tooDeep(depth) {
+			ins.out.WriteString("{...}")
+			return
+		}
+		ins.enter(obj)
+		defer delete(ins.visiting, obj)
+
+		pairs := obj.Ordered()
+		ins.items("{", "}", len(pairs), depth, func(i int) {
+			ins.write(pairs[i].Key, depth+1)
+			ins.out.WriteString(": ")
+			ins.write(pairs[i].Value, depth+1)
+		})
+	default:
+		ins.out.WriteString(obj.Inspect())
+	}
+}
+
+// 入れ物のn個の要素をopenとcloseで囲んで書く。要素はitemで書く
+func (ins *inspector) items(open, close string, n, depth int, item func(i int)) {
+	shown := n
+	if ins.opts.MaxItems > 0 && n > ins.opts.MaxItems {
+		shown = ins.opts.MaxItems
+	}
+
+	ins.out.WriteString(open)
+	for i := 0; i < shown; i++ {
+		ins.separate(i == 0, depth+1)
+		item(i)
+	}
+	if shown < n {
+		ins.separate(shown == 0, depth+1)
+		fmt.Fprintf(&ins.out, "...(%d more)", n-shown)
+	}
+	if n > 0 && ins.opts.Indent != "" {
+		ins.newline(depth)
+	}
+	ins.out.WriteString(close)
+}
+
+// 要素の前の区切りを書く。字下げする場合は要素ごとに改行する
+func (ins *inspector) separate(first bool, depth int) {
+	if !first {
+		ins.out.WriteString(",")
+	}
+	if ins.opts.Indent != "" {
+		ins.newline(depth)
+	} else if !first {
+		ins.out.WriteString(" ")
+	}
+}
+
+func (ins *inspector) newline(depth int) {
+	ins.out.WriteString("\n")
+	ins.out.WriteString(strings.Repeat(ins.opts.Indent, depth))
+}
+
+func (ins *inspector) tooDeep(depth int) bool {
+	return ins.opts.MaxDepth > 0 && depth >= ins.opts.MaxDepth
+}
+
+func (ins *inspector) enter(obj Object) {
+	if ins.visiting == nil {
+		ins.visiting = make(map[Object]bool)
+	}
+	ins.visiting[obj] = true
+}
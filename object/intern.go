@@ -0,0 +1,35 @@
+package object
+
+// 共有する文字列の長さと数の上限。長い文字列は繰り返し現れることが少ないので共有しない
+const (
+	maxInternLength = 64
+	maxInternCount  = 4096
+)
+
+// 同じ値の短い文字列を1つのオブジェクトで共有するための表
+// 文字列は変更できないので共有しても見分けがつかない。ハッシュキーも作るときに1度だけ計算する
+// ゴルーチンの間で共有できないので、評価ごとに作る。ゼロ値で使える
+type StringPool struct {
+	strings map[string]*String
+}
+
+// valueを値に持つ文字列を返す。共有したものを返した場合はcreatedが偽になる
+func (p *StringPool) Get(value string) (s *String, created bool) {
+	if len(value) > maxInternLength {
+		return &String{Value: value}, true
+	}
+	if s, ok := p.strings[value]; ok {
+		return s, false
+	}
+
+	s = &String{Value: value}
+	key := s.HashKey()
+	s.hashKey = &key
+	if p.strings == nil {
+		p.strings = make(map[string]*String)
+	}
+	if len(p.strings) < maxInternCount {
+		p.strings[value] = s
+	}
+	return s, true
+}
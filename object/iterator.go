@@ -0,0 +1,77 @@
+package object
+
+import "unicode/utf8"
+
+// 要素を順に返す。要素が尽きたらokが偽になる
+type Iterator interface {
+	Next() (obj Object, ok bool)
+}
+
+// 要素を順にたどれる値。Iterを呼ぶたびに先頭から始まる新しいIteratorを返す
+// 配列は要素、ハッシュは挿入された順のキー、範囲は整数、文字列は1文字ずつの文字列を返す
+type Iterable interface {
+	Iter() Iterator
+}
+
+type arrayIterator struct {
+	elements []Object
+	i        int
+}
+
+func (ao *Array) Iter() Iterator { return &arrayIterator{elements: ao.Elements} }
+
+func (it *arrayIterator) Next() (Object, bool) {
+	if it.i >= len(it.elements) {
+		return nil, false
+	}
+	it.i++
+	return it.elements[it.i-1], true
+}
+
+type hashIterator struct {
+	pairs []HashPair
+	i     int
+}
+
+func (h *Hash) Iter() Iterator { return &hashIterator{pairs: h.Ordered()} }
+
+func (it *hashIterator) Next() (Object, bool) {
+	if it.i >= len(it.pairs) {
+		return nil, false
+	}
+	it.i++
+	return it.pairs[it.i-1].Key, true
+}
+
+// 範囲の要素は配列にせず、1つずつ計算する
+type rangeIterator struct {
+	r    *Range
+	i, n int64
+}
+
+func (r *Range) Iter() Iterator { return &rangeIterator{r: r, n: r.Len()} }
+
+func (it *rangeIterator) Next() (Object, bool) {
+	if it.i >= it.n {
+		return nil, false
+	}
+	it.i++
+	return &Integer{Value: it.r.At(it.i - 1)}, true
+}
+
+// UTF-8の1文字ずつ返す。不正なバイトは1バイトずつU+FFFDとして返す
+type stringIterator struct {
+	value string
+	pos   int
+}
+
+func (s *String) Iter() Iterator { return &stringIterator{value: s.Value} }
+
+func (it *stringIterator) Next() (Object, bool) {
+	if it.pos >= len(it.value) {
+		return nil, false
+	}
+	r, size := utf8.DecodeRuneInString(it.value[it.pos:])
+	it.pos += size
+	return &String{Value: string(r)}, true
+}
@@ -3,10 +3,16 @@
 package object
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"math/big"
 	"monkey/ast"
+	"monkey/code"
+	"monkey/token"
+	"os"
 	"strings"
 )
 
@@ -14,12 +20,18 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	BIGINT_OBJ       = "BIGINT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
 	ERROR_OBJ        = "ERROR"
 	FUNCTION_OBJ     = "FUNCTION"
+	COMPILED_FN_OBJ  = "COMPILED_FUNCTION"
+	CELL_OBJ         = "CELL"
 	STRING_OBJ       = "STRING"
+	BYTES_OBJ        = "BYTES"
+	RANGE_OBJ        = "RANGE"
+	FILE_OBJ         = "FILE"
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
@@ -41,6 +53,28 @@ type Integer struct {
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 
+// 任意精度の整数。整数の演算が桁あふれしたときや、bigint()で作られる
+// 一度BigIntになった値は、演算してもBigIntのまま
+// Valueは他のオブジェクトと共有されることがあるので、書き換えずに新しいbig.Intへ結果を入れる
+type BigInt struct {
+	Value *big.Int
+}
+
+func (b *BigInt) Type() ObjectType { return BIGINT_OBJ }
+func (b *BigInt) Inspect() string  { return b.Value.String() }
+
+// IntegerかBigIntの値をbig.Intで返す。それ以外の場合はokが偽になる
+func AsBigInt(obj Object) (value *big.Int, ok bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value), true
+	case *BigInt:
+		return obj.Value, true
+	default:
+		return nil, false
+	}
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -60,12 +94,76 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// エラーの種類。メッセージを解析しなくても、種類で見分けられるようにする
+type ErrorKind string
+
+const (
+	TYPE_ERROR          ErrorKind = "TypeError"         // 演算や関数に渡した値の型が合わない
+	NAME_ERROR          ErrorKind = "NameError"         // 名前が見つからない
+	INDEX_ERROR         ErrorKind = "IndexError"        // 添字が範囲の外にある
+	ZERO_DIVISION_ERROR ErrorKind = "ZeroDivisionError" // 0で割った
+	ARITY_ERROR         ErrorKind = "ArityError"        // 引数の数が合わない
+	IO_ERROR            ErrorKind = "IOError"           // ファイルの読み書きに失敗した
+	LIMIT_ERROR         ErrorKind = "LimitError"        // 時間や深さ、メモリの制限で評価を打ち切った
+)
+
 type Error struct {
+	Kind    ErrorKind
 	Message string
+	Pos     token.Position // エラーが起きた式の位置。分からない場合はゼロ値
+	Trace   []Frame        // エラーが伝わってきた関数呼び出し。内側の呼び出しから順に並ぶ
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string  { return "ERROR: " + e.Message + e.StackTrace() }
+
+// "ファイル名:行:列: メッセージ"の形でメッセージを返す
+// ファイル名が空の場合や位置が分からない場合は、その部分を省く
+func (e *Error) Located(filename string) string {
+	prefix := filename
+	if e.Pos.IsValid() {
+		if prefix != "" {
+			prefix += ":"
+		}
+		prefix += e.Pos.String()
+	}
+	if prefix == "" {
+		return e.Message
+	}
+	return prefix + ": " + e.Message
+}
+
+// StackTraceで先頭と末尾それぞれに表示する呼び出しの数。深い再帰で表示が長くなりすぎないようにする
+const traceEdge = 10
+
+// 関数呼び出しの記録を1行ずつ並べた文字列を返す。記録がない場合は空文字を返す
+// 各行は改行から始まるので、メッセージの後ろにそのまま繋げられる
+func (e *Error) StackTrace() string {
+	var out bytes.Buffer
+	for i, f := range e.Trace {
+		if len(e.Trace) > traceEdge*2 && i >= traceEdge && i < len(e.Trace)-traceEdge {
+			if i == traceEdge {
+				fmt.Fprintf(&out, "\n    ... %d more calls", len(e.Trace)-traceEdge*2)
+			}
+			continue
+		}
+		if f.TailCalls > 0 {
+			fmt.Fprintf(&out, "\n    ... %d tail calls", f.TailCalls)
+		}
+		fmt.Fprintf(&out, "\n    at %s (%s)", f.Function, f.Pos)
+	}
+	return out.String()
+}
+
+// スタックトレースの1つの呼び出し
+type Frame struct {
+	Function string         // 呼び出した関数の名前。識別子で呼び出していない場合は<anonymous>
+	Pos      token.Position // 呼び出し式の位置
+
+	// この呼び出しの内側で、フレームを置き換えたため記録できなかった末尾呼び出しの数
+	// VMは末尾呼び出しでフレームを増やさないので、評価器ならここに並ぶ呼び出しを数だけ示す
+	TailCalls int
+}
 
 type Function struct {
 	Parameters []*ast.Identifier
@@ -74,11 +172,13 @@ type Function struct {
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
-func (f *Function) Inspect() string {
+func (f *Function) Inspect() string  { return inspectFunction(f.Parameters, f.Body) }
+
+func inspectFunction(parameters []*ast.Identifier, body *ast.BlockStatement) string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range f.Parameters {
+	for _, p := range parameters {
 		params = append(params, p.String())
 	}
 
@@ -86,45 +186,236 @@ func (f *Function) Inspect() string {
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") {\n")
-	out.WriteString(f.Body.String())
+	out.WriteString(body.String())
 	out.WriteString("\n}")
 
 	return out.String()
 }
 
+// コンパイルした関数。定数プールに入り、実行時にはClosureに包まれる
+// ParametersとBodyは表示のために元の関数リテラルから持っておく
+// LocalNamesとFreeNamesは、まだ値のない変数を読んだときのエラーメッセージに使う
+// PositionsとCallNamesは、実行時のエラーの位置とスタックトレースに使う
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	Positions     code.PositionTable
+	CallNames     map[int]string // 識別子で関数を呼び出す命令の位置と、その名前
+	NumLocals     int
+	NumParameters int
+	LocalNames    []string
+	FreeNames     []string
+	Parameters    []*ast.Identifier
+	Body          *ast.BlockStatement
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FN_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// 逆アセンブルのための命令とソースの位置の対応
+func (cf *CompiledFunction) Code() (code.Instructions, code.PositionTable) {
+	return cf.Instructions, cf.Positions
+}
+
+// 仮引数を並べた見出し
+func (cf *CompiledFunction) Signature() string {
+	params := make([]string, len(cf.Parameters))
+	for i, p := range cf.Parameters {
+		params[i] = p.Value
+	}
+	return "fn(" + strings.Join(params, ", ") + ")"
+}
+
+// VMで実行する関数の値。評価器のFunctionと同じ型として見せる
+// Freeは関数が参照する外側の関数のローカル変数。評価器の環境と同じく、値ではなく変数そのものを共有する
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []*Cell
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string {
+	if c.Fn.Body == nil {
+		return c.Fn.Inspect()
+	}
+	return inspectFunction(c.Fn.Parameters, c.Fn.Body)
+}
+
+// 複数の関数から共有される変数。Valueがnilの場合は、まだletで値が束縛されていない
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string {
+	if c.Value == nil {
+		return "cell()"
+	}
+	return "cell(" + c.Value.Inspect() + ")"
+}
+
 type String struct {
 	Value string
+
+	hashKey *HashKey // StringPoolで作った場合に、先に計算しておいたキー
 }
 
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
 
+// 変更できないバイト列。ファイルやソケットの入出力でバイナリを扱うのに使う
+// Valueは他のオブジェクトと共有されることがあるので、書き換えてはいけない
+type Bytes struct {
+	Value []byte
+}
+
+func (b *Bytes) Type() ObjectType { return BYTES_OBJ }
+func (b *Bytes) Inspect() string  { return fmt.Sprintf("b%q", b.Value) }
+
+// 整数の範囲。StartからStepずつ進み、Endの手前で終わる。Stepは0以外
+// 要素を配列として持たず、長さや添字の値は必要なときに計算する
+type Range struct {
+	Start, End, Step int64
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	if r.Step == 1 {
+		return fmt.Sprintf("%d..%d", r.Start, r.End)
+	}
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.End, r.Step)
+}
+
+// 要素の数を返す
+// int64の両端をまたぐ範囲でも桁あふれしないように、差は符号なしで計算する
+func (r *Range) Len() int64 {
+	var distance, step uint64
+	switch {
+	case r.Step > 0 && r.Start < r.End:
+		distance, step = uint64(r.End)-uint64(r.Start), uint64(r.Step)
+	case r.Step < 0 && r.Start > r.End:
+		distance, step = uint64(r.Start)-uint64(r.End), -uint64(r.Step)
+	default:
+		return 0
+	}
+
+	n := (distance-1)/step + 1
+	if n > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(n)
+}
+
+// i番目の要素を返す。iは0以上Len()未満でなければならない
+func (r *Range) At(i int64) int64 {
+	return r.Start + i*r.Step
+}
+
+// nが範囲の要素かどうかを返す
+func (r *Range) Contains(n int64) bool {
+	switch {
+	case r.Step > 0 && r.Start <= n && n < r.End:
+		return (uint64(n)-uint64(r.Start))%uint64(r.Step) == 0
+	case r.Step < 0 && r.End < n && n <= r.Start:
+		return (uint64(r.Start)-uint64(n))%(-uint64(r.Step)) == 0
+	default:
+		return false
+	}
+}
+
+// open()で開いたファイル。close()で閉じた後は読み書きできない
+type File struct {
+	Name   string
+	Handle *os.File
+	Closed bool
+
+	reader *bufio.Reader
+}
+
+func (f *File) Type() ObjectType { return FILE_OBJ }
+func (f *File) Inspect() string {
+	if f.Closed {
+		return fmt.Sprintf("<closed file %s>", f.Name)
+	}
+	return fmt.Sprintf("<file %s>", f.Name)
+}
+
+// バッファ付きのReaderを返す。行単位で読むときに先読みした分を失わないように、読み込みは全てこれを通す
+func (f *File) Reader() *bufio.Reader {
+	if f.reader == nil {
+		f.reader = bufio.NewReader(f.Handle)
+	}
+	return f.reader
+}
+
+// 引数の数に上限がない組み込み関数のMaxArgs
+const VARIADIC = -1
+
 type BuiltinFunction func(args ...Object) Object
 type Builtin struct {
-	Fn BuiltinFunction
+	Name  string // 呼び出すときの名前。例: len
+	Fn    BuiltinFunction
+	Usage string // 呼び出し方。例: len(arg)
+	Doc   string // 短い説明
+
+	// 受け取る引数の数の範囲。評価器がFnを呼ぶ前に確かめる
+	// MaxArgsがVARIADICの場合は上限がない。どちらも0の場合は確かめずにFnに任せる
+	MinArgs int
+	MaxArgs int
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
 func (b *Builtin) Inspect() string  { return "builtin function" }
 
+// 引数の数が範囲の外にある場合はエラーを返す
+func (b *Builtin) CheckArity(got int) *Error {
+	if b.MinArgs == 0 && b.MaxArgs == 0 {
+		return nil
+	}
+	if got >= b.MinArgs && (b.MaxArgs == VARIADIC || got <= b.MaxArgs) {
+		return nil
+	}
+
+	var want string
+	switch {
+	case b.MaxArgs == VARIADIC:
+		want = fmt.Sprintf(">=%d", b.MinArgs)
+	case b.MinArgs == b.MaxArgs:
+		want = fmt.Sprintf("%d", b.MinArgs)
+	default:
+		want = fmt.Sprintf("%d..%d", b.MinArgs, b.MaxArgs)
+	}
+	return &Error{Kind: ARITY_ERROR, Message: fmt.Sprintf("wrong number of arguments. got=%d, want=%s", got, want)}
+}
+
 type Array struct {
 	Elements []Object
+	Frozen   bool // freeze()で変更できなくした。変更する操作はエラーにする
 }
 
 func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
-func (ao *Array) Inspect() string {
-	var out bytes.Buffer
+func (ao *Array) Inspect() string  { return inspect(ao) }
 
-	elements := []string{}
-	for _, e := range ao.Elements {
-		elements = append(elements, e.Inspect())
+// i番目の要素を置き換える。範囲の外の添字や、変更できなくした配列にはエラーを返す
+func (ao *Array) Set(i int, value Object) *Error {
+	if ao.Frozen {
+		return frozenError(ao)
 	}
+	if i < 0 || i >= len(ao.Elements) {
+		return &Error{Kind: INDEX_ERROR, Message: fmt.Sprintf("index out of range: %d", i)}
+	}
+	ao.Elements[i] = value
+	return nil
+}
 
-	out.WriteString("[")
-	out.WriteString(strings.Join(elements, ", "))
-	out.WriteString("]")
-
-	return out.String()
+// 末尾に要素を追加する。変更できなくした配列にはエラーを返す
+func (ao *Array) Append(values ...Object) *Error {
+	if ao.Frozen {
+		return frozenError(ao)
+	}
+	ao.Elements = append(ao.Elements, values...)
+	return nil
 }
 
 type HashKey struct {
@@ -148,40 +439,80 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// int64に収まる値は、同じ値のIntegerと同じキーになる
+func (b *BigInt) HashKey() HashKey {
+	if b.Value.IsInt64() {
+		return (&Integer{Value: b.Value.Int64()}).HashKey()
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(b.Value.String()))
+
+	return HashKey{Type: b.Type(), Value: h.Sum64()}
+}
+
 func (s *String) HashKey() HashKey {
+	if s.hashKey != nil {
+		return *s.hashKey
+	}
+
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
 
 	return HashKey{Type: s.Type(), Value: h.Sum64()}
 }
 
+func (b *Bytes) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write(b.Value)
+
+	return HashKey{Type: b.Type(), Value: h.Sum64()}
+}
+
 type HashPair struct {
 	// 値側にキーを保存しておくことで、REPLで両方表示できたり、range関数を実装するときに役立つ
 	Key   Object
 	Value Object
 }
 
+// Keysは挿入された順にキーを持つ。表示や列挙の順序を実行ごとに変えないために使う
+// Pairsを直接書き換えず、Setで追加する
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	Pairs  map[HashKey]HashPair
+	Keys   []HashKey
+	Frozen bool // freeze()で変更できなくした。変更する操作はエラーにする
 }
 
-func (h *Hash) Type() ObjectType { return HASH_OBJ }
-
-func (h *Hash) Inspect() string {
-	var out bytes.Buffer
+func NewHash() *Hash {
+	return &Hash{Pairs: make(map[HashKey]HashPair)}
+}
 
-	pairs := []string{}
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s",
-			pair.Key.Inspect(), pair.Value.Inspect()))
+// ペアを追加する。既にあるキーは値だけを置き換え、順序は最初に追加したときのままにする
+// 変更できなくしたハッシュにはエラーを返す
+func (h *Hash) Set(key HashKey, pair HashPair) *Error {
+	if h.Frozen {
+		return frozenError(h)
+	}
+	if _, ok := h.Pairs[key]; !ok {
+		h.Keys = append(h.Keys, key)
 	}
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
+	h.Pairs[key] = pair
+	return nil
+}
 
-	return out.String()
+// 挿入順にペアを返す
+func (h *Hash) Ordered() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Keys))
+	for _, key := range h.Keys {
+		pairs = append(pairs, h.Pairs[key])
+	}
+	return pairs
 }
 
+func (h *Hash) Type() ObjectType { return HASH_OBJ }
+
+func (h *Hash) Inspect() string { return inspect(h) }
+
 // 与えられたオブジェクトがハッシュキーとして利用可能かをチェックできる
 type Hashable interface {
 	HashKey() HashKey
@@ -200,6 +531,7 @@ type Macro struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
+	Pos        token.Position // マクロリテラルの位置
 }
 
 func (m *Macro) Type() ObjectType { return MACRO_OBJ }
@@ -1,6 +1,12 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"math/big"
+	"monkey/token"
+	"strings"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -20,3 +26,398 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestHashKeys(t *testing.T) {
+	// 同じ値なら別のオブジェクトでも同じキーになる
+	same := []struct{ a, b Hashable }{
+		{&Integer{Value: 1}, &Integer{Value: 1}},
+		{&Integer{Value: -1}, &Integer{Value: -1}},
+		{&Boolean{Value: true}, &Boolean{Value: true}},
+		{&String{Value: ""}, &String{Value: ""}},
+		{&Bytes{Value: []byte("a")}, &Bytes{Value: []byte("a")}},
+		// int64に収まるBigIntは同じ値のIntegerと同じキーになる
+		{&BigInt{Value: big.NewInt(-1)}, &Integer{Value: -1}},
+	}
+	for _, tt := range same {
+		if tt.a.HashKey() != tt.b.HashKey() {
+			t.Errorf("equal values have different hash keys: %v and %v", tt.a, tt.b)
+		}
+	}
+
+	// 値が同じでも型が違えば別のキーになる
+	different := []struct{ a, b Hashable }{
+		{&Integer{Value: 1}, &Integer{Value: 2}},
+		{&Integer{Value: 1}, &Boolean{Value: true}},
+		{&Integer{Value: 0}, &Boolean{Value: false}},
+		{&Boolean{Value: true}, &Boolean{Value: false}},
+		{&Bytes{Value: []byte("a")}, &String{Value: "a"}},
+		{&BigInt{Value: new(big.Int).Lsh(big.NewInt(1), 64)}, &Integer{Value: 0}},
+	}
+	for _, tt := range different {
+		if tt.a.HashKey() == tt.b.HashKey() {
+			t.Errorf("different values have the same hash key: %v and %v", tt.a, tt.b)
+		}
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	err := &Error{Message: "boom"}
+	if err.Inspect() != "ERROR: boom" {
+		t.Errorf("wrong Inspect without trace. got=%q", err.Inspect())
+	}
+
+	err.Trace = []Frame{
+		{Function: "inner", Pos: token.Position{Line: 3, Column: 3}},
+		{Function: "<anonymous>", Pos: token.Position{Line: 5, Column: 1}},
+	}
+	expected := "ERROR: boom\n    at inner (3:3)\n    at <anonymous> (5:1)"
+	if err.Inspect() != expected {
+		t.Errorf("wrong Inspect. want=%q, got=%q", expected, err.Inspect())
+	}
+
+	// 記録できなかった末尾呼び出しは、その呼び出しの前に数を示す
+	err.Trace[1].TailCalls = 2
+	expected = "ERROR: boom\n    at inner (3:3)\n    ... 2 tail calls\n    at <anonymous> (5:1)"
+	if err.Inspect() != expected {
+		t.Errorf("wrong Inspect with tail calls. want=%q, got=%q", expected, err.Inspect())
+	}
+
+	// 長いトレースは途中を省略する
+	err.Trace = make([]Frame, 100)
+	for i := range err.Trace {
+		err.Trace[i] = Frame{Function: "f", Pos: token.Position{Line: 1, Column: 1}}
+	}
+	lines := strings.Split(err.StackTrace(), "\n")[1:]
+	if len(lines) != traceEdge*2+1 {
+		t.Fatalf("wrong number of lines. got=%d", len(lines))
+	}
+	if lines[traceEdge] != "    ... 80 more calls" {
+		t.Errorf("wrong omission line. got=%q", lines[traceEdge])
+	}
+}
+
+func TestErrorLocated(t *testing.T) {
+	tests := []struct {
+		filename string
+		pos      token.Position
+		expected string
+	}{
+		{"script.mky", token.Position{Line: 12, Column: 5}, "script.mky:12:5: boom"},
+		{"", token.Position{Line: 12, Column: 5}, "12:5: boom"},
+		{"script.mky", token.Position{}, "script.mky: boom"},
+		{"", token.Position{}, "boom"},
+	}
+
+	for _, tt := range tests {
+		err := &Error{Message: "boom", Pos: tt.pos}
+		if got := err.Located(tt.filename); got != tt.expected {
+			t.Errorf("wrong message. want=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	array := func(elements ...Object) *Array { return &Array{Elements: elements} }
+	hash := func(key Hashable, value Object) *Hash {
+		return &Hash{Pairs: map[HashKey]HashPair{key.HashKey(): {Key: key.(Object), Value: value}}}
+	}
+	fn := &Function{}
+
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{&Integer{Value: 1}, &Integer{Value: 1}, true},
+		{&Integer{Value: 1}, &Integer{Value: 2}, false},
+		{&String{Value: "a"}, &String{Value: "a"}, true},
+		{&Bytes{Value: []byte("a")}, &Bytes{Value: []byte("a")}, true},
+		{&Bytes{Value: []byte("a")}, &String{Value: "a"}, false},
+		{&Boolean{Value: true}, &Boolean{Value: true}, true},
+		{&Null{}, &Null{}, true},
+		{&Integer{Value: 1}, &Boolean{Value: true}, false},
+		{&BigInt{Value: big.NewInt(1)}, &Integer{Value: 1}, true},
+		{&Integer{Value: 2}, &BigInt{Value: big.NewInt(1)}, false},
+		{&BigInt{Value: big.NewInt(1)}, &Boolean{Value: true}, false},
+		{array(&Integer{Value: 1}, array(&String{Value: "x"})), array(&Integer{Value: 1}, array(&String{Value: "x"})), true},
+		{array(&Integer{Value: 1}), array(&Integer{Value: 1}, &Integer{Value: 2}), false},
+		{hash(&String{Value: "k"}, array()), hash(&String{Value: "k"}, array()), true},
+		{hash(&String{Value: "k"}, &Integer{Value: 1}), hash(&String{Value: "k"}, &Integer{Value: 2}), false},
+		{fn, fn, true},
+		{fn, &Function{}, false},
+		{nil, &Null{}, false},
+	}
+
+	for _, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.expected {
+			t.Errorf("Equal(%v, %v) wrong. want=%t, got=%t", tt.a, tt.b, tt.expected, got)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b     Comparable
+		expected int
+	}{
+		{&Integer{Value: 1}, &Integer{Value: 2}, -1},
+		{&Integer{Value: 2}, &Integer{Value: 2}, 0},
+		{&Integer{Value: 3}, &Integer{Value: -3}, 1},
+		{&String{Value: "a"}, &String{Value: "b"}, -1},
+		{&String{Value: "b"}, &String{Value: "b"}, 0},
+		{&String{Value: "ab"}, &String{Value: "a"}, 1},
+		{&Bytes{Value: []byte("a")}, &Bytes{Value: []byte("b")}, -1},
+		{&Bytes{Value: []byte("a")}, &Bytes{Value: []byte("a")}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := tt.a.Compare(tt.b.(Object)); got != tt.expected {
+			t.Errorf("%v.Compare(%v) wrong. want=%d, got=%d", tt.a, tt.b, tt.expected, got)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	tests := []struct {
+		r        *Range
+		length   int64
+		contains []int64
+		excludes []int64
+	}{
+		{&Range{Start: 0, End: 3, Step: 1}, 3, []int64{0, 1, 2}, []int64{-1, 3}},
+		{&Range{Start: 3, End: 0, Step: 1}, 0, nil, []int64{0, 1, 3}},
+		{&Range{Start: 0, End: 10, Step: 4}, 3, []int64{0, 4, 8}, []int64{2, 10, 12}},
+		{&Range{Start: 5, End: -1, Step: -2}, 3, []int64{5, 3, 1}, []int64{4, -1, 7}},
+		// 差がint64に収まらない範囲
+		{&Range{Start: math.MinInt64, End: math.MaxInt64, Step: math.MaxInt64}, 3, []int64{math.MinInt64, -1, math.MaxInt64 - 1}, []int64{0, math.MaxInt64}},
+		{&Range{Start: math.MaxInt64, End: math.MinInt64, Step: math.MinInt64}, 2, []int64{math.MaxInt64, -1}, []int64{math.MinInt64}},
+	}
+
+	for _, tt := range tests {
+		if got := tt.r.Len(); got != tt.length {
+			t.Errorf("%s: wrong length. want=%d, got=%d", tt.r.Inspect(), tt.length, got)
+		}
+		for i, n := range tt.contains {
+			if !tt.r.Contains(n) {
+				t.Errorf("%s: should contain %d", tt.r.Inspect(), n)
+			}
+			if got := tt.r.At(int64(i)); got != n {
+				t.Errorf("%s: wrong element %d. want=%d, got=%d", tt.r.Inspect(), i, n, got)
+			}
+		}
+		for _, n := range tt.excludes {
+			if tt.r.Contains(n) {
+				t.Errorf("%s: should not contain %d", tt.r.Inspect(), n)
+			}
+		}
+	}
+}
+
+func TestBuiltinCheckArity(t *testing.T) {
+	tests := []struct {
+		min, max int
+		got      int
+		expected string
+	}{
+		{1, 1, 1, ""},
+		{1, 1, 2, "wrong number of arguments. got=2, want=1"},
+		{1, 3, 0, "wrong number of arguments. got=0, want=1..3"},
+		{1, 3, 3, ""},
+		{2, VARIADIC, 10, ""},
+		{2, VARIADIC, 1, "wrong number of arguments. got=1, want=>=2"},
+		// 範囲を指定しない場合は確かめない
+		{0, 0, 5, ""},
+	}
+
+	for _, tt := range tests {
+		b := &Builtin{MinArgs: tt.min, MaxArgs: tt.max}
+		err := b.CheckArity(tt.got)
+		switch {
+		case tt.expected == "" && err != nil:
+			t.Errorf("%d..%d with %d: unexpected error %q", tt.min, tt.max, tt.got, err.Message)
+		case tt.expected != "" && (err == nil || err.Message != tt.expected || err.Kind != ARITY_ERROR):
+			t.Errorf("%d..%d with %d: wrong error. want=%q, got=%+v", tt.min, tt.max, tt.got, tt.expected, err)
+		}
+	}
+}
+
+func TestInspectCycles(t *testing.T) {
+	array := &Array{Elements: []Object{&Integer{Value: 1}}}
+	array.Elements = append(array.Elements, array)
+
+	hash := NewHash()
+	key := &String{Value: "self"}
+	hash.Set(key.HashKey(), HashPair{Key: key, Value: hash})
+	other := &String{Value: "array"}
+	hash.Set(other.HashKey(), HashPair{Key: other, Value: array})
+
+	// 循環していなければ、同じ配列を何度参照しても全て表示する
+	shared := &Array{Elements: []Object{&Integer{Value: 2}}}
+	twice := &Array{Elements: []Object{shared, shared}}
+
+	tests := []struct {
+		obj      Object
+		expected string
+	}{
+		{array, "[1, [...]]"},
+		{hash, "{self: {...}, array: [1, [...]]}"},
+		{twice, "[[2], [2]]"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.obj.Inspect(); got != tt.expected {
+			t.Errorf("wrong Inspect. want=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestInspectWith(t *testing.T) {
+	ints := func(values ...int64) *Array {
+		array := &Array{}
+		for _, v := range values {
+			array.Elements = append(array.Elements, &Integer{Value: v})
+		}
+		return array
+	}
+	hash := NewHash()
+	key := &String{Value: "a"}
+	hash.Set(key.HashKey(), HashPair{Key: key, Value: &Array{Elements: []Object{ints(1, 2), ints()}}})
+	empty := &String{Value: "b"}
+	hash.Set(empty.HashKey(), HashPair{Key: empty, Value: NewHash()})
+
+	tests := []struct {
+		obj      Object
+		opts     InspectOptions
+		expected string
+	}{
+		{hash, InspectOptions{}, "{a: [[1, 2], []], b: {}}"},
+		{hash, InspectOptions{Indent: "  "}, "{\n  a: [\n    [\n      1,\n      2\n    ],\n    []\n  ],\n  b: {}\n}"},
+		{hash, InspectOptions{MaxDepth: 1}, "{a: [...], b: {...}}"},
+		{hash, InspectOptions{MaxDepth: 2}, "{a: [[...], [...]], b: {}}"},
+		{ints(1, 2, 3, 4), InspectOptions{MaxItems: 2}, "[1, 2, ...(2 more)]"},
+		{ints(1, 2, 3), InspectOptions{MaxItems: 3}, "[1, 2, 3]"},
+		{ints(1, 2, 3), InspectOptions{Indent: "\t", MaxItems: 1}, "[\n\t1,\n\t...(2 more)\n]"},
+		{&Integer{Value: 5}, InspectOptions{Indent: "  "}, "5"},
+	}
+
+	for _, tt := range tests {
+		if got := InspectWith(tt.obj, tt.opts); got != tt.expected {
+			t.Errorf("wrong result with %+v. want=%q, got=%q", tt.opts, tt.expected, got)
+		}
+	}
+}
+
+func TestStringPool(t *testing.T) {
+	var pool StringPool
+
+	a, created := pool.Get("key")
+	if !created {
+		t.Errorf("first Get should create a string")
+	}
+	b, created := pool.Get("key")
+	if created || a != b {
+		t.Errorf("second Get should return the same string. created=%t", created)
+	}
+	if a.HashKey() != (&String{Value: "key"}).HashKey() {
+		t.Errorf("pooled string has wrong hash key")
+	}
+
+	long := strings.Repeat("x", maxInternLength+1)
+	c, _ := pool.Get(long)
+	d, created := pool.Get(long)
+	if !created || c == d {
+		t.Errorf("long strings should not be shared")
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	inner := &Array{}
+	outer := &Array{Elements: []Object{inner}}
+	outer.Elements = append(outer.Elements, outer)
+	hash := NewHash()
+	key := &String{Value: "k"}
+	hash.Set(key.HashKey(), HashPair{Key: key, Value: outer})
+
+	// 自分自身を含んでいても止まる
+	Freeze(hash)
+	for _, obj := range []Object{hash, outer, inner} {
+		if !IsFrozen(obj) {
+			t.Errorf("%s is not frozen", obj.Inspect())
+		}
+	}
+	if IsFrozen(&Array{}) || !IsFrozen(&Integer{Value: 1}) {
+		t.Errorf("wrong IsFrozen for unfrozen values")
+	}
+
+	// 変更する操作はエラーになり、値は変わらない
+	other := &String{Value: "other"}
+	if err := hash.Set(other.HashKey(), HashPair{Key: other, Value: inner}); err == nil || err.Message != "cannot modify a frozen HASH" {
+		t.Errorf("expected an error from Set on a frozen hash. got=%v", err)
+	}
+	if err := hash.Set(key.HashKey(), HashPair{Key: key, Value: inner}); err == nil {
+		t.Errorf("expected an error from replacing a value in a frozen hash")
+	}
+	if len(hash.Keys) != 1 || hash.Pairs[key.HashKey()].Value != outer {
+		t.Errorf("frozen hash was modified: %s", hash.Inspect())
+	}
+	if err := inner.Append(other); err == nil || err.Message != "cannot modify a frozen ARRAY" {
+		t.Errorf("expected an error from Append on a frozen array. got=%v", err)
+	}
+	if err := outer.Set(0, other); err == nil {
+		t.Errorf("expected an error from Set on a frozen array")
+	}
+	if len(inner.Elements) != 0 || outer.Elements[0] != inner {
+		t.Errorf("frozen array was modified: %s", outer.Inspect())
+	}
+}
+
+func TestArrayMutation(t *testing.T) {
+	array := &Array{Elements: []Object{&Integer{Value: 1}}}
+	if err := array.Append(&Integer{Value: 2}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Message)
+	}
+	if err := array.Set(0, &Integer{Value: 3}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Message)
+	}
+	if array.Inspect() != "[3, 2]" {
+		t.Errorf("wrong array. got=%s", array.Inspect())
+	}
+	if err := array.Set(2, &Integer{Value: 4}); err == nil || err.Kind != INDEX_ERROR {
+		t.Errorf("expected an index error. got=%v", err)
+	}
+}
+
+func TestIterators(t *testing.T) {
+	hash := NewHash()
+	for _, k := range []string{"b", "a"} {
+		key := &String{Value: k}
+		hash.Set(key.HashKey(), HashPair{Key: key, Value: &Integer{Value: 1}})
+	}
+
+	tests := []struct {
+		iterable Iterable
+		expected []string
+	}{
+		{&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "x"}}}, []string{"1", "x"}},
+		{&Array{}, []string{}},
+		{hash, []string{"b", "a"}},
+		{&Range{Start: 3, End: 0, Step: -1}, []string{"3", "2", "1"}},
+		{&String{Value: "aé\xff"}, []string{"a", "é", "�"}},
+	}
+
+	for _, tt := range tests {
+		// Iterを呼ぶたびに先頭からたどり直せる
+		for round := 0; round < 2; round++ {
+			got := []string{}
+			it := tt.iterable.Iter()
+			for obj, ok := it.Next(); ok; obj, ok = it.Next() {
+				got = append(got, obj.Inspect())
+			}
+			if _, ok := it.Next(); ok {
+				t.Errorf("iterator must stay exhausted")
+			}
+			if strings.Join(got, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("wrong elements. want=%q, got=%q", tt.expected, got)
+			}
+		}
+	}
+}
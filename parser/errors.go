@@ -0,0 +1,167 @@
+package parser
+
+import (
+	"fmt"
+	"monkey/token"
+	"strconv"
+	"strings"
+)
+
+// 構文エラーの種類
+type ErrorCode int
+
+const (
+	ErrUnexpectedToken    ErrorCode = iota + 1 // 期待したトークンと異なる
+	ErrNoPrefixParseFn                         // 式の先頭に置けないトークン
+	ErrInvalidInteger                          // 整数リテラルを変換できない
+	ErrIncompleteInput                         // 括弧やブロックの途中で入力が終わった
+	ErrDuplicateParameter                      // 同じ名前の仮引数がある
+	ErrTooDeep                                 // 式の入れ子が深すぎる
+)
+
+func (c ErrorCode) String() string {
+	switch c {
+	case ErrUnexpectedToken:
+		return "unexpected token"
+	case ErrNoPrefixParseFn:
+		return "no prefix parse function"
+	case ErrInvalidInteger:
+		return "invalid integer"
+	case ErrIncompleteInput:
+		return "incomplete input"
+	case ErrDuplicateParameter:
+		return "duplicate parameter"
+	case ErrTooDeep:
+		return "too deep"
+	default:
+		return fmt.Sprintf("ErrorCode(%d)", int(c))
+	}
+}
+
+// 構文エラー
+type ParseError struct {
+	Code     ErrorCode
+	Pos      token.Position
+	Expected token.TokenType // 期待したトークン。ErrUnexpectedTokenかErrIncompleteInputの場合だけ設定する
+	Got      token.TokenType // 実際のトークン
+	Message  string          // 位置を含まないメッセージ
+	Excerpt  string          // エラーのある行と、その位置を指すキャレット
+}
+
+// 位置とメッセージ、該当行を複数行の文字列にする
+func (e ParseError) Error() string {
+	if e.Excerpt == "" {
+		return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+	}
+	return fmt.Sprintf("%s: %s\n%s", e.Pos, e.Message, e.Excerpt)
+}
+
+// エラーのアクセサ。文字列のスライスとして返す
+func (p *Parser) Errors() []string {
+	msgs := make([]string, len(p.errors))
+	for i, err := range p.errors {
+		msgs[i] = err.Error()
+	}
+	return msgs
+}
+
+// エラーのアクセサ。位置などの情報を持ったまま返す
+func (p *Parser) ParseErrors() []ParseError {
+	return p.errors
+}
+
+// 入力の途中で終わっているために構文解析できなかったか判定する
+// 最初のエラーが入力の終端で起きた場合に真になる。REPLは続きの行を読むかの判断に使う
+func (p *Parser) Incomplete() bool {
+	return len(p.errors) > 0 && p.errors[0].Code == ErrIncompleteInput
+}
+
+// エラーを追加する
+func (p *Parser) peekError(t token.TokenType) {
+	p.addError(ErrUnexpectedToken, p.peekToken, t,
+		"expected %s but got %s",
+		t.Describe(),
+		p.peekToken.Describe(),
+	)
+}
+
+// tokの位置と該当する行、その位置を指すキャレットを付けてエラーを追加する
+func (p *Parser) addError(
+	code ErrorCode,
+	tok token.Token,
+	expected token.TokenType,
+	format string,
+	a ...interface{},
+) {
+	// 入力の終端で起きたエラーは、続きを入力すれば解消できる
+	if tok.Type == token.EOF {
+		code = ErrIncompleteInput
+	}
+
+	p.errors = append(p.errors, ParseError{
+		Code:     code,
+		Pos:      tok.Pos(),
+		Expected: expected,
+		Got:      tok.Type,
+		Message:  fmt.Sprintf(format, a...),
+		Excerpt:  p.caretExcerpt(tok),
+	})
+}
+
+// エラー表示で、該当行の前後n行も表示するようにする
+// 0の場合は該当行だけを行番号なしで表示する。1以上の場合は各行に行番号を付ける
+func (p *Parser) SetContextLines(n int) {
+	p.contextLines = n
+}
+
+// トークンのある行と、その下にトークンの位置を指す^を並べた文字列を返す
+func (p *Parser) caretExcerpt(tok token.Token) string {
+	line := p.l.SourceLine(tok.Line)
+	caret := caretLine(line, tok.Column)
+
+	if p.contextLines <= 0 {
+		return line + "\n" + caret
+	}
+
+	// ストリームから読んでいる場合は、まだ読んでいない後ろの行は表示できない
+	first, last := p.l.AvailableLines()
+	from, to := tok.Line-p.contextLines, tok.Line+p.contextLines
+	if from < first {
+		from = first
+	}
+	if to > last {
+		to = last
+	}
+	width := len(strconv.Itoa(to))
+
+	lines := []string{}
+	for n := from; n <= to; n++ {
+		lines = append(lines, fmt.Sprintf("%*d | %s", width, n, p.l.SourceLine(n)))
+		if n == tok.Line {
+			lines = append(lines, fmt.Sprintf("%*s | %s", width, "", caret))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// columnの位置を指す^を、lineと位置が揃うように空白で字下げして返す
+func caretLine(line string, column int) string {
+	// タブはそのまま残し、それ以外の文字を空白に置き換えて位置を揃える
+	var pad strings.Builder
+	for i, ch := range line {
+		if i >= column-1 {
+			break
+		}
+		if ch == '\t' {
+			pad.WriteRune('\t')
+		} else {
+			pad.WriteRune(' ')
+		}
+	}
+	if n := column - 1 - len(line); n > 0 {
+		pad.WriteString(strings.Repeat(" ", n))
+	}
+
+	return pad.String() + "^"
+}
@@ -0,0 +1,45 @@
+// 文法を拡張するための公開API
+// RegisterPrefix/RegisterInfixで登録した構文解析関数から、パーサの状態を読み進めるのに使う
+
+package parser
+
+import (
+	"monkey/ast"
+	"monkey/token"
+)
+
+// 現在のトークンを返す
+func (p *Parser) CurToken() token.Token {
+	return p.curToken
+}
+
+// 次のトークンを返す
+func (p *Parser) PeekToken() token.Token {
+	return p.peekToken
+}
+
+// トークンを1つ読み進める
+func (p *Parser) NextToken() {
+	p.nextToken()
+}
+
+// 次のトークンが期待したタイプなら読み進める。違う場合はエラーを追加してfalseを返す
+func (p *Parser) ExpectPeek(t token.TokenType) bool {
+	return p.expectPeek(t)
+}
+
+// 現在のトークンから、precedenceより強く結合する式をパースする
+func (p *Parser) ParseExpression(precedence int) ast.Expression {
+	return p.parseExpression(precedence)
+}
+
+// 現在のトークンを演算子とする、通常の中置式をパースする
+// 新しい二項演算子は RegisterInfix(t, p.ParseInfixExpression) で追加できる
+func (p *Parser) ParseInfixExpression(left ast.Expression) ast.Expression {
+	return p.parseInfixExpression(left)
+}
+
+// 現在のトークンの優先順位を返す
+func (p *Parser) CurPrecedence() int {
+	return p.curPrecedence()
+}
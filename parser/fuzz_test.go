@@ -0,0 +1,45 @@
+package parser
+
+import (
+	"monkey/ast"
+	"monkey/lexer"
+	"strings"
+	"testing"
+)
+
+// 構文解析器はどんな入力でもパニックせず、エラーを返す
+func FuzzParseProgram(f *testing.F) {
+	seeds := []string{
+		"let x = 5;",
+		"fn(",
+		"((((",
+		"let = ;",
+		"if (x) { y } else {",
+		"add(1, 2",
+		"[1, 2",
+		"{1: 2, 3",
+		"x[1",
+		"macro(a, b) { quote(unquote(a) + b) }",
+		"return",
+		"-",
+		"1 +",
+		"let f = fn(x) { x |> y };",
+		"// comment\nlet 値 = \"str",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := New(lexer.New(input))
+		program := p.ParseProgram()
+
+		// エラーがあっても、途中までのASTはnilを含まずにたどれる
+		_ = program.String()
+		_ = program.Pos()
+		_ = program.End()
+		if strings.Contains(ast.Dump(program), "<nil>") {
+			t.Errorf("AST has nil node. input=%q", input)
+		}
+	})
+}
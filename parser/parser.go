@@ -3,7 +3,7 @@
 package parser
 
 import (
-	"fmt"
+	"io"
 	"monkey/ast"
 	"monkey/lexer"
 	"monkey/token"
@@ -12,14 +12,29 @@ import (
 
 type Parser struct {
 	l      *lexer.Lexer
-	errors []string
+	errors []ParseError
 
 	curToken  token.Token // 現在のトークン
 	peekToken token.Token // 次のトークン
 
+	comments []*ast.Comment // まだ文に付けていないコメント
+
 	// 構文解析関数がどちらの中置もしくは前置のマップにあるかをチェックする
-	prefixParseFns map[token.TokenType]prefixParseFn
-	infixParseFns  map[token.TokenType]infixParseFn
+	prefixParseFns map[token.TokenType]PrefixParseFn
+	infixParseFns  map[token.TokenType]InfixParseFn
+
+	precedences map[token.TokenType]int // 中置トークンの優先順位
+
+	contextLines int // エラー表示で、該当行の前後に何行表示するか
+
+	nesting int // 囲んでいる()、[]、ハッシュの{}の深さ。0の場合は改行で文が終わる
+
+	depth    int  // parseExpressionの再帰の深さ
+	maxDepth int  // depthの上限。0以下の場合は制限しない
+	tooDeep  bool // depthが上限を超えた。以降の構文解析は打ち切る
+
+	traceOut   io.Writer // nilでなければ構文解析関数の呼び出しを書き出す
+	traceLevel int
 }
 
 type (
@@ -27,19 +42,23 @@ type (
 
 	// 前置構文解析関数 ++1
 	// 前置演算子には「左側」が存在しない
-	prefixParseFn func() ast.Expression
+	PrefixParseFn func() ast.Expression
 
 	// 中置構文解析関数 n + 1
 	// 引数は中置演算子の「左側」
-	infixParseFn func(ast.Expression) ast.Expression
+	InfixParseFn func(ast.Expression) ast.Expression
 )
 
+// 式の入れ子の深さの上限の初期値。深すぎる入力でGoのスタックを使い切らないようにする
+const DefaultMaxDepth = 1000
+
 // iotaで割り当てられる整数の値は重要ではない。演算子の優先順位を表現するものとして重要。
 const (
 	_ int = iota
 	LOWEST
 	EQUALS      // ==
 	LESSGREATER // > または <
+	RANGE       // ..
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X または !X
@@ -53,6 +72,7 @@ var precedences = map[token.TokenType]int{
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
 	token.GT:       LESSGREATER,
+	token.DOTDOT:   RANGE,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
@@ -64,38 +84,46 @@ var precedences = map[token.TokenType]int{
 // 字句解析器を受け取って初期化する
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []string{},
+		l:           l,
+		errors:      []ParseError{},
+		precedences: make(map[token.TokenType]int, len(precedences)),
+		maxDepth:    DefaultMaxDepth,
+	}
+
+	for t, precedence := range precedences {
+		p.precedences[t] = precedence
 	}
 
 	// 前置トークン
-	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
-	p.registerPrefix(token.IDENT, p.parseIdentifier) // もしトークンタイプtoken.IDENTが出現したら、呼び出すべき構文解析関数はparseIdentifier
-	p.registerPrefix(token.INT, p.parseIntegerLiteral)
-	p.registerPrefix(token.BANG, p.parsePrefixExpression)
-	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
-	p.registerPrefix(token.TRUE, p.parseBoolean)
-	p.registerPrefix(token.FALSE, p.parseBoolean)
-	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
-	p.registerPrefix(token.IF, p.parseIfExpression)
-	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
-	p.registerPrefix(token.STRING, p.parseStringLiteral)
-	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
-	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
-	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
+	p.prefixParseFns = make(map[token.TokenType]PrefixParseFn)
+	p.RegisterPrefix(token.IDENT, p.parseIdentifier) // もしトークンタイプtoken.IDENTが出現したら、呼び出すべき構文解析関数はparseIdentifier
+	p.RegisterPrefix(token.INT, p.parseIntegerLiteral)
+	p.RegisterPrefix(token.BANG, p.parsePrefixExpression)
+	p.RegisterPrefix(token.MINUS, p.parsePrefixExpression)
+	p.RegisterPrefix(token.TRUE, p.parseBoolean)
+	p.RegisterPrefix(token.FALSE, p.parseBoolean)
+	p.RegisterPrefix(token.LPAREN, p.parseGroupedExpression)
+	p.RegisterPrefix(token.IF, p.parseIfExpression)
+	p.RegisterPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	p.RegisterPrefix(token.STRING, p.parseStringLiteral)
+	p.RegisterPrefix(token.BYTES, p.parseBytesLiteral)
+	p.RegisterPrefix(token.LBRACKET, p.parseArrayLiteral)
+	p.RegisterPrefix(token.LBRACE, p.parseHashLiteral)
+	p.RegisterPrefix(token.MACRO, p.parseMacroLiteral)
 
 	// 中置トークン
-	p.infixParseFns = make(map[token.TokenType]infixParseFn)
-	p.registerInfix(token.PLUS, p.parseInfixExpression)
-	p.registerInfix(token.MINUS, p.parseInfixExpression)
-	p.registerInfix(token.SLASH, p.parseInfixExpression)
-	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
-	p.registerInfix(token.EQ, p.parseInfixExpression)
-	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
-	p.registerInfix(token.LT, p.parseInfixExpression)
-	p.registerInfix(token.GT, p.parseInfixExpression)
-	p.registerInfix(token.LPAREN, p.parseCallExpression)
-	p.registerInfix(token.LBRACKET, p.parseIndexExpression) // 実際には添字演算子式は両側のオペランドの間に演算子を1つ持つものというわけではない。が、そのように扱うとうまくいく。
+	p.infixParseFns = make(map[token.TokenType]InfixParseFn)
+	p.RegisterInfix(token.PLUS, p.parseInfixExpression)
+	p.RegisterInfix(token.MINUS, p.parseInfixExpression)
+	p.RegisterInfix(token.SLASH, p.parseInfixExpression)
+	p.RegisterInfix(token.ASTERISK, p.parseInfixExpression)
+	p.RegisterInfix(token.EQ, p.parseInfixExpression)
+	p.RegisterInfix(token.NOT_EQ, p.parseInfixExpression)
+	p.RegisterInfix(token.LT, p.parseInfixExpression)
+	p.RegisterInfix(token.GT, p.parseInfixExpression)
+	p.RegisterInfix(token.DOTDOT, p.parseInfixExpression)
+	p.RegisterInfix(token.LPAREN, p.parseCallExpression)
+	p.RegisterInfix(token.LBRACKET, p.parseIndexExpression) // 実際には添字演算子式は両側のオペランドの間に演算子を1つ持つものというわけではない。が、そのように扱うとうまくいく。
 
 	// 2つトークンを読み込む。curTokenとpeekTokenの両方がセットされる
 	p.nextToken()
@@ -104,24 +132,36 @@ func New(l *lexer.Lexer) *Parser {
 	return p
 }
 
-// エラーのアクセサ
-func (p *Parser) Errors() []string {
-	return p.errors
-}
-
-// エラーを追加する
-func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
-		t,
-		p.peekToken.Type,
-	)
-	p.errors = append(p.errors, msg)
-}
-
 // 次のトークンに進む
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	// コメントは構文に関係しないので、文に付けるまで取っておく
+	for p.peekToken.Type == token.COMMENT {
+		p.comments = append(p.comments, &ast.Comment{Token: p.peekToken})
+		p.peekToken = p.l.NextToken()
+	}
+}
+
+// posより前にあるコメントを取り出す
+func (p *Parser) takeComments(pos token.Position) []*ast.Comment {
+	n := 0
+	for n < len(p.comments) && before(p.comments[n].Pos(), pos) {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+
+	taken := p.comments[:n]
+	p.comments = p.comments[n:]
+	return taken
+}
+
+// aがbより前の位置か判定する
+func before(a, b token.Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
 }
 
 // パースを開始する。トークンを1つずつ辿る
@@ -129,13 +169,14 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{}
 	program.Statements = []ast.Statement{}
 
-	for p.curToken.Type != token.EOF {
+	for p.curToken.Type != token.EOF && !p.tooDeep {
 		stmt := p.parseStatement()
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
 		p.nextToken()
 	}
+	program.Comments = p.takeComments(p.curToken.Pos())
 
 	return program
 }
@@ -143,14 +184,39 @@ func (p *Parser) ParseProgram() *ast.Program {
 // 文をパースする。トークンの型によって適用関数を変える
 // Monkey言語では、文で構成されるのはこれだけ
 func (p *Parser) parseStatement() ast.Statement {
+	doc := p.takeComments(p.curToken.Pos())
+
+	// 型付きのnilをast.Statementとして返さないように、失敗した場合はnilを返す
 	switch p.curToken.Type {
 	case token.LET:
-		return p.parseLetStatement()
+		stmt := p.parseLetStatement()
+		if stmt == nil {
+			return nil
+		}
+		stmt.Doc = doc
+		return stmt
 	case token.RETURN:
-		return p.parseReturnStatement()
+		stmt := p.parseReturnStatement()
+		if stmt == nil {
+			return nil
+		}
+		stmt.Doc = doc
+		return stmt
+	case token.DEFER:
+		stmt := p.parseDeferStatement()
+		if stmt == nil {
+			return nil
+		}
+		stmt.Doc = doc
+		return stmt
 	default:
 		// 式文の構文解析を試みる
-		return p.parseExpressionStatement()
+		stmt := p.parseExpressionStatement()
+		if stmt == nil {
+			return nil
+		}
+		stmt.Doc = doc
+		return stmt
 	}
 }
 
@@ -176,6 +242,9 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 
 	stmt.Value = p.parseExpression(LOWEST)
+	if stmt.Value == nil {
+		return nil
+	}
 
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
@@ -188,9 +257,39 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{Token: p.curToken}
 
+	// 値を省略したreturn
+	if p.peekTokenIs(token.SEMICOLON) || p.peekTokenIs(token.RBRACE) || p.peekTokenIs(token.EOF) || p.newlineEnds() {
+		if p.peekTokenIs(token.SEMICOLON) {
+			p.nextToken()
+		}
+		return stmt
+	}
+
 	p.nextToken()
 
 	stmt.ReturnValue = p.parseExpression(LOWEST)
+	if stmt.ReturnValue == nil {
+		return nil
+	}
+
+	// 省略可能なセミコロン
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// deferをパースする
+func (p *Parser) parseDeferStatement() *ast.DeferStatement {
+	stmt := &ast.DeferStatement{Token: p.curToken}
+
+	p.nextToken()
+
+	stmt.Expression = p.parseExpression(LOWEST)
+	if stmt.Expression == nil {
+		return nil
+	}
 
 	// 省略可能なセミコロン
 	if p.peekTokenIs(token.SEMICOLON) {
@@ -226,22 +325,63 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 	}
 }
 
-// 構文解析関数を登録する
-func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
+// 現在のトークンの後に改行があり、そこで文が終わるか判定する
+// 括弧の中では改行を無視するので、複数行にまたがる引数や配列も書ける
+func (p *Parser) newlineEnds() bool {
+	return p.nesting == 0 && p.peekToken.Line > p.curToken.Line
+}
+
+// 式の入れ子を1段深くする。上限を超えた場合は一度だけエラーにして偽を返す
+func (p *Parser) enterDepth() bool {
+	if p.tooDeep {
+		return false
+	}
+	if p.maxDepth > 0 && p.depth >= p.maxDepth {
+		p.tooDeep = true
+		p.addError(ErrTooDeep, p.curToken, "",
+			"expression nested too deeply (max depth %d)", p.maxDepth)
+		return false
+	}
+	p.depth++
+	return true
+}
+
+// 式の入れ子の深さの上限を設定する。0以下の場合は制限しない
+func (p *Parser) SetMaxDepth(n int) {
+	p.maxDepth = n
+}
+
+// 括弧の中に入る。戻り値の関数を呼ぶと外に出る
+func (p *Parser) enterNesting() func() {
+	p.nesting++
+	return func() { p.nesting-- }
+}
+
+// 前置の構文解析関数を登録する。登録済みのトークンタイプは上書きする
+func (p *Parser) RegisterPrefix(tokenType token.TokenType, fn PrefixParseFn) {
 	p.prefixParseFns[tokenType] = fn
 }
 
-// 構文解析関数を登録する
-func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
+// 中置の構文解析関数を登録する。登録済みのトークンタイプは上書きする
+// 優先順位がLOWESTのままだと呼ばれないので、SetPrecedenceも合わせて使う
+func (p *Parser) RegisterInfix(tokenType token.TokenType, fn InfixParseFn) {
 	p.infixParseFns[tokenType] = fn
 }
 
+// 中置トークンの優先順位を設定する。このパーサにだけ影響する
+func (p *Parser) SetPrecedence(tokenType token.TokenType, precedence int) {
+	p.precedences[tokenType] = precedence
+}
+
 // 式文を構文解析する
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
-	defer untrace(trace("parseExpressionStatement"))
+	defer p.untrace(p.trace("parseExpressionStatement"))
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 
 	stmt.Expression = p.parseExpression(LOWEST)
+	if stmt.Expression == nil {
+		return nil
+	}
 
 	// セミコロンは省略可能。あとでREPLに入力しやすくなる
 	if p.peekTokenIs(token.SEMICOLON) {
@@ -267,17 +407,22 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 // / ┃ ┃━┃
 // / 1+2+3
 //
-// / 前置演算子の場合。定義からPREFIXは高い優先順位を持つ。このため、parseExpression(PREFIX)は-1の中の1を構文解析しようとしてinfixParseFnに渡すことは決してない。どのinfixParseFnも1を左腕に取ることはなく、1は前置式の右腕として返される。
+// / 前置演算子の場合。定義からPREFIXは高い優先順位を持つ。このため、parseExpression(PREFIX)は-1の中の1を構文解析しようとしてInfixParseFnに渡すことは決してない。どのInfixParseFnも1を左腕に取ることはなく、1は前置式の右腕として返される。
 // / -1+2
 // /     ┃
 // /   ┃━┃
 // / ┃━┃ ┃
 // / - 1+2
 func (p *Parser) parseExpression(precedence int) ast.Expression {
-	defer untrace(trace("parseExpression"))
+	defer p.untrace(p.trace("parseExpression"))
+	if !p.enterDepth() {
+		return nil
+	}
+	defer func() { p.depth-- }()
+
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
-		p.noPrefixParseFnError(p.curToken.Type)
+		p.noPrefixParseFnError()
 		return nil
 	}
 	leftExp := prefix()
@@ -285,7 +430,8 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	// 優先順位の処理を行っている重要な部分
 	// より低い優先順位のトークンに遭遇する間繰り返す
 	// 優先順位が同じもしくは高いトークンに遭遇すると実行しない
-	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
+	// 構文解析に失敗した部分式はnilになる。nilを子に持つノードを作らないように、そこで打ち切る
+	for leftExp != nil && !p.peekTokenIs(token.SEMICOLON) && !p.newlineEnds() && precedence < p.peekPrecedence() {
 		infix := p.infixParseFns[p.peekToken.Type]
 		if infix == nil {
 			return leftExp
@@ -303,19 +449,25 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 func (p *Parser) parseIdentifier() ast.Expression {
 	// 現在のトークンをTokenフィールドに、トークンのリテラル値をValueフィールドに格納する
 	// トークンは進めない
-	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	// x => x * 2 は仮引数が1つのアロー関数
+	if p.peekTokenIs(token.ARROW) {
+		return p.parseArrowFunction(ident.Token, []*ast.Identifier{ident})
+	}
+
+	return ident
 }
 
 // 整数パース
 // p.curToken.Literalの文字列をint64に変換する
 func (p *Parser) parseIntegerLiteral() ast.Expression {
-	defer untrace(trace("parseIntegerLiteral"))
+	defer p.untrace(p.trace("parseIntegerLiteral"))
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(ErrInvalidInteger, p.curToken, "", "could not parse %q as integer", p.curToken.Literal)
 		return nil
 	}
 
@@ -326,7 +478,7 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 // 前置式パース。ほかのパース関数と異なり、トークンが進むのに注意
 func (p *Parser) parsePrefixExpression() ast.Expression {
-	defer untrace(trace("parsePrefixExpression"))
+	defer p.untrace(p.trace("parsePrefixExpression"))
 	expression := &ast.PrefixExpression{
 		Token:    p.curToken,
 		Operator: p.curToken.Literal,
@@ -340,6 +492,9 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	// この時点のトークンの位置は、1つ進んでいる。
 	// -5の場合、 p.curToken.Type は token.INT 。この値をRightフィールドに設定して、返却
 	expression.Right = p.parseExpression(PREFIX)
+	if expression.Right == nil {
+		return nil
+	}
 
 	return expression
 }
@@ -347,7 +502,7 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 // 中置演算式パース
 // 引数としてleftという名前のast.Expressionを取ることに注意
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
-	defer untrace(trace("parseInfixExpression"))
+	defer p.untrace(p.trace("parseInfixExpression"))
 	expression := &ast.InfixExpression{
 		Token:    p.curToken, // 現在のトークンは中置演算子式の演算子である
 		Operator: p.curToken.Literal,
@@ -359,21 +514,90 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 
 	// この時点のトークンの位置は、1つ進んでいる
 	expression.Right = p.parseExpression(precedence)
+	if expression.Right == nil {
+		return nil
+	}
 
 	return expression
 }
 
 // 括弧をパース
 // 括られた式の優先順位が高まる
+// 括弧で囲まれた式をパースする
+// 閉じ括弧の次が=>の場合は、括弧の中身をアロー関数の仮引数リストとして扱う
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	start := p.curToken
+
+	list := p.parseExpressionList(token.RPAREN)
+	if list == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.ARROW) {
+		params := p.arrowParameters(list)
+		if params == nil {
+			return nil
+		}
+		return p.parseArrowFunction(start, params)
+	}
+
+	// ()や(a, b)はアロー関数の仮引数リストにしかならない
+	if len(list) != 1 {
+		p.peekError(token.ARROW)
+		return nil
+	}
+
+	return list[0]
+}
+
+// 括弧の中の式を仮引数に変換する。識別子以外があればエラーにしてnilを返す
+func (p *Parser) arrowParameters(list []ast.Expression) []*ast.Identifier {
+	params := []*ast.Identifier{}
+	for _, exp := range list {
+		ident, ok := exp.(*ast.Identifier)
+		if !ok {
+			pos := exp.Pos()
+			tok := token.Token{Literal: exp.TokenLiteral(), Line: pos.Line, Column: pos.Column}
+			p.addError(ErrUnexpectedToken, tok, token.IDENT,
+				"expected %s in arrow function parameters", token.TokenType(token.IDENT).Describe())
+			return nil
+		}
+		params = append(params, ident)
+	}
+	return params
+}
+
+// =>から後ろをパースして、関数リテラルを組み立てる。startは仮引数リストの先頭のトークン
+// 本体が{で始まる場合はブロック、それ以外は式を1つだけ持つブロックとして扱う
+func (p *Parser) parseArrowFunction(start token.Token, params []*ast.Identifier) ast.Expression {
+	p.checkDuplicateParameters(params)
+
+	fnTok := token.Token{Type: token.FUNCTION, Literal: "fn", Line: start.Line, Column: start.Column}
+	lit := &ast.FunctionLiteral{Token: fnTok, Parameters: params}
+
+	p.nextToken() // =>
+
+	if p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		lit.Body = p.parseBlockStatement()
+		return lit
+	}
+
+	arrow := p.curToken
 	p.nextToken()
+	first := p.curToken
 
-	exp := p.parseExpression(LOWEST)
-	if !p.expectPeek(token.RPAREN) {
+	body := p.parseExpression(LOWEST)
+	if body == nil {
 		return nil
 	}
 
-	return exp
+	lit.Body = &ast.BlockStatement{
+		Token:      token.Token{Type: token.LBRACE, Literal: "{", Line: arrow.Line, Column: arrow.Column},
+		Statements: []ast.Statement{&ast.ExpressionStatement{Token: first, Expression: body}},
+	}
+
+	return lit
 }
 
 // ifをパース
@@ -385,7 +609,12 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	}
 
 	p.nextToken()
+	leave := p.enterNesting()
 	expression.Condition = p.parseExpression(LOWEST)
+	leave()
+	if expression.Condition == nil {
+		return nil
+	}
 
 	if !p.expectPeek(token.RPAREN) {
 		return nil
@@ -413,12 +642,17 @@ func (p *Parser) parseIfExpression() ast.Expression {
 }
 
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
+	// ブロックの中は文が並ぶので、外側の括弧に関係なく改行で文が終わる
+	nesting := p.nesting
+	p.nesting = 0
+	defer func() { p.nesting = nesting }()
+
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
 
 	p.nextToken()
 
-	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) && !p.tooDeep {
 		stmt := p.parseStatement()
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
@@ -426,6 +660,14 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		p.nextToken()
 	}
 
+	block.Comments = p.takeComments(p.curToken.Pos())
+	if p.curTokenIs(token.RBRACE) {
+		block.Rbrace = p.curToken.Pos()
+	} else if !p.tooDeep {
+		p.addError(ErrUnexpectedToken, p.curToken, token.RBRACE,
+			"expected %s but got %s", token.TokenType(token.RBRACE).Describe(), p.curToken.Describe())
+	}
+
 	return block
 }
 
@@ -438,6 +680,9 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	}
 
 	lit.Parameters = p.parseFunctionParameters()
+	if lit.Parameters == nil {
+		return nil
+	}
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
@@ -457,14 +702,21 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 		return identifiers
 	}
 
-	p.nextToken()
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
 
 	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	identifiers = append(identifiers, ident)
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
-		p.nextToken()
+		if p.peekTokenIs(token.RPAREN) {
+			break
+		}
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
 		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 		identifiers = append(identifiers, ident)
 	}
@@ -473,14 +725,32 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 		return nil
 	}
 
+	p.checkDuplicateParameters(identifiers)
+
 	return identifiers
 }
 
+// 同じ名前の仮引数があればエラーにする。後の仮引数が前のものを隠してしまうので
+// 構文解析は続けられるので、仮引数のリストはそのまま使う
+func (p *Parser) checkDuplicateParameters(params []*ast.Identifier) {
+	seen := make(map[string]bool, len(params))
+	for _, param := range params {
+		if seen[param.Value] {
+			p.addError(ErrDuplicateParameter, param.Token, "", "duplicate parameter '%s'", param.Value)
+		}
+		seen[param.Value] = true
+	}
+}
+
 // 関数呼び出しをパース
 // すでに構文解析されたfunctionを引数として受け取り、ノードの構築に使う
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	if exp.Arguments == nil {
+		return nil
+	}
+	exp.Rparen = p.curToken.Pos()
 	return exp
 }
 
@@ -489,15 +759,24 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+func (p *Parser) parseBytesLiteral() ast.Expression {
+	return &ast.BytesLiteral{Token: p.curToken, Value: p.curToken.Literal}
+}
+
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	if array.Elements == nil {
+		return nil
+	}
+	array.Rbracket = p.curToken.Pos()
 
 	return array
 }
 
 func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
+	defer p.enterNesting()()
 	list := []ast.Expression{}
 
 	// リストの終端が来たら、次に進んで終了
@@ -512,10 +791,20 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	// 次のトークンがコンマのときだけ繰り返すので、リストの最後の要素で止まる
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken() // [1<,> 2]
+		// 閉じ括弧の直前のコンマは無視する
+		if p.peekTokenIs(end) {
+			break
+		}
 		p.nextToken() // [1, <2>]
 		list = append(list, p.parseExpression(LOWEST))
 	}
 
+	for _, exp := range list {
+		if exp == nil {
+			return nil
+		}
+	}
+
 	if !p.expectPeek(end) {
 		return nil
 	}
@@ -525,27 +814,31 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 
 // 添字演算子式。myArray[1] がある場合、myArrayが左のオペランド、[が中置演算子、1が右のオペランドになる
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	defer p.enterNesting()()
 	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
 
 	p.nextToken()
 	exp.Index = p.parseExpression(LOWEST)
+	if exp.Index == nil {
+		return nil
+	}
 
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
+	exp.Rbracket = p.curToken.Pos()
 
 	return exp
 }
 
 // デバッグしやすいようにエラーメッセージを追加する
-func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+func (p *Parser) noPrefixParseFnError() {
+	p.addError(ErrNoPrefixParseFn, p.curToken, "", "expected an expression but got %s", p.curToken.Describe())
 }
 
 // 次のトークンタイプに対応している優先順位を返す
 func (p *Parser) peekPrecedence() int {
-	if p, ok := precedences[p.peekToken.Type]; ok {
+	if p, ok := p.precedences[p.peekToken.Type]; ok {
 		return p
 	}
 
@@ -554,7 +847,7 @@ func (p *Parser) peekPrecedence() int {
 
 // 現在のトークンタイプに対応している優先順位を返す
 func (p *Parser) curPrecedence() int {
-	if p, ok := precedences[p.curToken.Type]; ok {
+	if p, ok := p.precedences[p.curToken.Type]; ok {
 		return p
 	}
 
@@ -562,6 +855,7 @@ func (p *Parser) curPrecedence() int {
 }
 
 func (p *Parser) parseHashLiteral() ast.Expression {
+	defer p.enterNesting()()
 	hash := &ast.HashLiteral{Token: p.curToken}
 	hash.Pairs = make(map[ast.Expression]ast.Expression)
 
@@ -571,6 +865,9 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		// {<key>: "value"}
 		key := p.parseExpression(LOWEST)
+		if key == nil {
+			return nil
+		}
 
 		if !p.expectPeek(token.COLON) {
 			return nil
@@ -580,6 +877,9 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		// {key: <"value">}
 		value := p.parseExpression(LOWEST)
+		if value == nil {
+			return nil
+		}
 		hash.Pairs[key] = value
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
@@ -590,6 +890,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	if !p.expectPeek(token.RBRACE) {
 		return nil
 	}
+	hash.Rbrace = p.curToken.Pos()
 
 	return hash
 }
@@ -601,6 +902,9 @@ func (p *Parser) parseMacroLiteral() ast.Expression {
 	}
 
 	lit.Parameters = p.parseFunctionParameters()
+	if lit.Parameters == nil {
+		return nil
+	}
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
@@ -1,9 +1,12 @@
 package parser
 
 import (
+	"bytes"
 	"fmt"
 	"monkey/ast"
 	"monkey/lexer"
+	"monkey/token"
+	"strings"
 	"testing"
 )
 
@@ -68,32 +71,102 @@ func testLetStatement(t *testing.T, s ast.Statement, name string) bool {
 }
 
 func TestReturnStatements(t *testing.T) {
-	input := `
-return 5;
-return 10;
-return 993322;
-`
-	l := lexer.New(input)
-	p := New(l)
+	tests := []struct {
+		input         string
+		expectedValue interface{}
+	}{
+		{"return 5;", 5},
+		{"return true;", true},
+		{"return foobar;", "foobar"},
+	}
 
-	program := p.ParseProgram()
-	checkParserErrors(t, p)
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
 
-	if len(program.Statements) != 3 {
-		t.Fatalf("program.Statements does not contain 3 statements. got=%d",
-			len(program.Statements))
-	}
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+				len(program.Statements))
+		}
 
-	for _, stmt := range program.Statements {
-		returnStmt, ok := stmt.(*ast.ReturnStatement)
+		returnStmt, ok := program.Statements[0].(*ast.ReturnStatement)
 		if !ok {
-			t.Errorf("stmt not *ast.ReturnStatement. got=%T", stmt)
-			continue
+			t.Fatalf("stmt not *ast.ReturnStatement. got=%T", program.Statements[0])
 		}
 		if returnStmt.TokenLiteral() != "return" {
-			t.Errorf("returnStmt.TokenLiteral not 'return', got %q",
+			t.Fatalf("returnStmt.TokenLiteral not 'return', got %q",
 				returnStmt.TokenLiteral())
 		}
+		if !testLiteralExpression(t, returnStmt.ReturnValue, tt.expectedValue) {
+			return
+		}
+	}
+}
+
+func TestDeferStatement(t *testing.T) {
+	l := lexer.New("defer puts(x);")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+
+	deferStmt, ok := program.Statements[0].(*ast.DeferStatement)
+	if !ok {
+		t.Fatalf("stmt not *ast.DeferStatement. got=%T", program.Statements[0])
+	}
+	if deferStmt.String() != "defer puts(x);" {
+		t.Errorf("wrong String. got=%q", deferStmt.String())
+	}
+	if _, ok := deferStmt.Expression.(*ast.CallExpression); !ok {
+		t.Errorf("deferStmt.Expression not *ast.CallExpression. got=%T", deferStmt.Expression)
+	}
+
+	// 式のないdeferはエラーになる
+	p = New(lexer.New("defer;"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("defer without an expression must be an error")
+	}
+}
+
+func TestBareReturnStatement(t *testing.T) {
+	l := lexer.New("return;")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	returnStmt, ok := program.Statements[0].(*ast.ReturnStatement)
+	if !ok {
+		t.Fatalf("stmt not *ast.ReturnStatement. got=%T", program.Statements[0])
+	}
+	if returnStmt.ReturnValue != nil {
+		t.Errorf("returnStmt.ReturnValue is not nil. got=%s", returnStmt.ReturnValue)
+	}
+}
+
+func TestStatementRoundTrip(t *testing.T) {
+	tests := []string{
+		"let x = (1 + (2 * 3));",
+		"let f = add(a, b);",
+		"return (x == y);",
+		"return;",
+	}
+
+	for _, input := range tests {
+		l := lexer.New(input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != input {
+			t.Errorf("round trip wrong. want=%q, got=%q", input, program.String())
+		}
 	}
 }
 
@@ -364,6 +437,10 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"!-a",
 			"(!(-a))",
 		},
+		{
+			"0..n + 1 < m",
+			"((0 .. (n + 1)) < m)",
+		},
 		{
 			"a + b - c",
 			"((a + b) - c)",
@@ -766,6 +843,28 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestBytesLiteralExpression(t *testing.T) {
+	input := `b"hello";`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.BytesLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.BytesLiteral. got=%T", stmt.Expression)
+	}
+
+	if literal.Value != "hello" {
+		t.Errorf("literal.Value not %q. got=%q", "hello", literal.Value)
+	}
+	if end := literal.End(); end.Column != 9 {
+		t.Errorf("literal.End() wrong. got=%s", end)
+	}
+}
+
 func TestParsingArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 
@@ -961,3 +1060,554 @@ func TestMacroLiteralParsing(t *testing.T) {
 
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
+
+func TestParserErrorPosition(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"let = 5;",
+			"1:5: expected identifier but got '='\nlet = 5;\n    ^",
+		},
+		{
+			"let x = 1;\n\tlet y 2;",
+			"2:8: expected '=' but got integer '2'\n\tlet y 2;\n\t      ^",
+		},
+		{
+			"if (x",
+			"1:6: expected ')' but got end of input\nif (x\n     ^",
+		},
+		{
+			"fn(x, 1) { x }",
+			"1:7: expected identifier but got integer '1'\nfn(x, 1) { x }\n      ^",
+		},
+		{
+			"if (x) { y",
+			"1:11: expected '}' but got end of input\nif (x) { y\n          ^",
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser errors for %q", tt.input)
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("error message wrong.\nwant=%q\ngot=%q", tt.expected, errors[0])
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	l := lexer.New("let x 5;\nlet = 1;")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.ParseErrors()
+	if len(errors) < 2 {
+		t.Fatalf("expected at least 2 parse errors. got=%d", len(errors))
+	}
+
+	first := errors[0]
+	if first.Code != ErrUnexpectedToken {
+		t.Errorf("errors[0].Code wrong. want=%s, got=%s", ErrUnexpectedToken, first.Code)
+	}
+	if first.Pos.Line != 1 || first.Pos.Column != 7 {
+		t.Errorf("errors[0].Pos wrong. want=1:7, got=%s", first.Pos)
+	}
+	if first.Expected != "=" || first.Got != "INT" {
+		t.Errorf("errors[0] expected/got wrong. got=%q/%q", first.Expected, first.Got)
+	}
+
+	second := errors[1]
+	if second.Code != ErrUnexpectedToken || second.Pos.Line != 2 {
+		t.Errorf("errors[1] wrong. got=%s at %s", second.Code, second.Pos)
+	}
+
+	if p.Errors()[0] != first.Error() {
+		t.Errorf("Errors() and ParseErrors() disagree. %q != %q", p.Errors()[0], first.Error())
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(x, y) {
+  x + y
+};
+add(1, [2, 3][0]);
+{"a": "b"}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	let := program.Statements[0].(*ast.LetStatement)
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	index := call.Arguments[1].(*ast.IndexExpression)
+	hash := program.Statements[2].(*ast.ExpressionStatement).Expression.(*ast.HashLiteral)
+	body := let.Value.(*ast.FunctionLiteral).Body
+
+	tests := []struct {
+		node     ast.Node
+		pos, end string
+	}{
+		{program, "1:1", "5:11"},
+		{let, "1:1", "3:2"},
+		{let.Name, "1:5", "1:8"},
+		{body, "1:20", "3:2"},
+		{body.Statements[0], "2:3", "2:8"},
+		{call, "4:1", "4:18"},
+		{call.Arguments[0], "4:5", "4:6"},
+		{index, "4:8", "4:17"},
+		{index.Left, "4:8", "4:14"},
+		{hash, "5:1", "5:11"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.node.Pos().String(); got != tt.pos {
+			t.Errorf("%T(%q).Pos() wrong. want=%s, got=%s", tt.node, tt.node.String(), tt.pos, got)
+		}
+		if got := tt.node.End().String(); got != tt.end {
+			t.Errorf("%T(%q).End() wrong. want=%s, got=%s", tt.node, tt.node.String(), tt.end, got)
+		}
+	}
+}
+
+func TestCommentAttachment(t *testing.T) {
+	input := `// add two numbers
+// and return the sum
+let add = fn(x, y) {
+  // inside
+  return x + y; // trailing
+};
+
+add(1, 2) / 3;
+// end`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+
+	let := program.Statements[0].(*ast.LetStatement)
+	if len(let.Doc) != 2 || let.Doc[0].Text() != "add two numbers" || let.Doc[1].Text() != "and return the sum" {
+		t.Errorf("let.Doc wrong. got=%v", let.Doc)
+	}
+
+	body := let.Value.(*ast.FunctionLiteral).Body
+	ret := body.Statements[0].(*ast.ReturnStatement)
+	if len(ret.Doc) != 1 || ret.Doc[0].Text() != "inside" {
+		t.Errorf("ret.Doc wrong. got=%v", ret.Doc)
+	}
+	if len(body.Comments) != 1 || body.Comments[0].Text() != "trailing" {
+		t.Errorf("body.Comments wrong. got=%v", body.Comments)
+	}
+
+	call := program.Statements[1]
+	if doc := ast.DocComments(call); len(doc) != 0 {
+		t.Errorf("call has unexpected comments. got=%v", doc)
+	}
+	if call.String() != "(add(1, 2) / 3)" {
+		t.Errorf("comments changed the expression. got=%q", call.String())
+	}
+
+	if len(program.Comments) != 1 || program.Comments[0].Text() != "end" {
+		t.Errorf("program.Comments wrong. got=%v", program.Comments)
+	}
+}
+
+func TestRegisterCustomOperators(t *testing.T) {
+	const PIPE token.TokenType = "|>"
+	const MOD token.TokenType = "%"
+
+	l := lexer.New("x |> f |> g; a + b % c")
+	l.AddOperator("|>", PIPE)
+	l.AddOperator("%", MOD)
+
+	p := New(l)
+	// x |> f を f(x) に変換する
+	p.RegisterInfix(PIPE, func(left ast.Expression) ast.Expression {
+		tok := p.CurToken()
+		precedence := p.CurPrecedence()
+		p.NextToken()
+		fn := p.ParseExpression(precedence)
+		return &ast.CallExpression{Token: tok, Function: fn, Arguments: []ast.Expression{left}}
+	})
+	p.SetPrecedence(PIPE, LOWEST+1)
+	p.RegisterInfix(MOD, p.ParseInfixExpression)
+	p.SetPrecedence(MOD, PRODUCT)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	expected := "g(f(x))(a + (b % c))"
+	if program.String() != expected {
+		t.Errorf("expected=%q, got=%q", expected, program.String())
+	}
+}
+
+func TestIncompleteInput(t *testing.T) {
+	tests := []struct {
+		input      string
+		incomplete bool
+	}{
+		{"let x = 1;", false},
+		{"let add = fn(x, y) {", true},
+		{"let add = fn(x, y) {\n  x + y", true},
+		{"add(1,", true},
+		{"[1, 2", true},
+		{"{\"a\": 1", true},
+		{"let x =", true},
+		{"1 +", true},
+		{"if (x) { 1 } else {", true},
+		{"let = 1; fn(", false},
+		{"let x 1;", false},
+		{")", false},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		if p.Incomplete() != tt.incomplete {
+			t.Errorf("Incomplete() wrong for %q. want=%t, got=%t (errors=%v)",
+				tt.input, tt.incomplete, p.Incomplete(), p.Errors())
+		}
+		if tt.incomplete && p.ParseErrors()[0].Code != ErrIncompleteInput {
+			t.Errorf("error code wrong for %q. got=%s", tt.input, p.ParseErrors()[0].Code)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	input := `// doc
+let add = fn(x, y) { x + y; // sum
+};
+let m = macro(a) { quote(unquote(a) * 2) };
+if (add(1, -2) < 3) { [1, "two", true][0] } else { return {"a": 1, 2: false}; }
+return;`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	data, err := ast.MarshalJSON(program)
+	if err != nil {
+		t.Fatalf("MarshalJSON returned error: %s", err)
+	}
+
+	node, err := ast.UnmarshalJSON(data)
+	if err != nil {
+		t.Fatalf("UnmarshalJSON returned error: %s", err)
+	}
+
+	// ハッシュのString()は順序が不定なので、Dump()で比べる
+	if ast.Dump(node) != ast.Dump(program) {
+		t.Errorf("Dump() differs after round trip.\nwant=%s\ngot=%s", ast.Dump(program), ast.Dump(node))
+	}
+
+	again, err := ast.MarshalJSON(node)
+	if err != nil {
+		t.Fatalf("MarshalJSON returned error: %s", err)
+	}
+	if string(again) != string(data) {
+		t.Errorf("JSON differs after round trip.\nwant=%s\ngot=%s", data, again)
+	}
+}
+
+func TestErrorContextLines(t *testing.T) {
+	input := "let a = 1;\nlet b = 2;\nlet c 3;\nlet d = 4;\nlet e = 5;\nlet f = 6;\nlet g = 7;\nlet h = 8;\nlet i = 9;\nlet j 10;"
+
+	tests := []struct {
+		context  int
+		stream   bool
+		expected string
+	}{
+		{
+			0, false,
+			"3:7: expected '=' but got integer '3'\nlet c 3;\n      ^",
+		},
+		{
+			1, false,
+			"3:7: expected '=' but got integer '3'\n2 | let b = 2;\n3 | let c 3;\n  |       ^\n4 | let d = 4;",
+		},
+		{
+			5, false,
+			"3:7: expected '=' but got integer '3'\n1 | let a = 1;\n2 | let b = 2;\n3 | let c 3;\n  |       ^\n" +
+				"4 | let d = 4;\n5 | let e = 5;\n6 | let f = 6;\n7 | let g = 7;\n8 | let h = 8;",
+		},
+		{
+			// ストリームではまだ読んでいない行は表示しない
+			1, true,
+			"3:7: expected '=' but got integer '3'\n2 | let b = 2;\n3 | let c 3;\n  |       ^",
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(input)
+		if tt.stream {
+			l = lexer.NewReader(strings.NewReader(input))
+		}
+		p := New(l)
+		p.SetContextLines(tt.context)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser errors")
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("error wrong (context=%d).\nwant=%q\ngot=%q", tt.context, tt.expected, errors[0])
+		}
+	}
+
+	// 行番号の桁数が変わっても揃える
+	p := New(lexer.New(input))
+	p.SetContextLines(1)
+	p.ParseProgram()
+
+	expected := "10:7: expected '=' but got integer '10'\n 9 | let i = 9;\n10 | let j 10;\n   |       ^"
+	if errors := p.Errors(); errors[len(errors)-1] != expected {
+		t.Errorf("error wrong.\nwant=%q\ngot=%q", expected, errors[len(errors)-1])
+	}
+}
+
+func TestTrace(t *testing.T) {
+	var out bytes.Buffer
+
+	p := New(lexer.New("-1 + 2"))
+	p.SetTrace(&out)
+	p.ParseProgram()
+	checkParserErrors(t, p)
+
+	expected := `BEGIN parseExpressionStatement
+	BEGIN parseExpression
+		BEGIN parsePrefixExpression
+			BEGIN parseExpression
+				BEGIN parseIntegerLiteral
+				END parseIntegerLiteral
+			END parseExpression
+		END parsePrefixExpression
+		BEGIN parseInfixExpression
+			BEGIN parseExpression
+				BEGIN parseIntegerLiteral
+				END parseIntegerLiteral
+			END parseExpression
+		END parseInfixExpression
+	END parseExpression
+END parseExpressionStatement
+`
+	if out.String() != expected {
+		t.Errorf("trace wrong.\nwant=%q\ngot=%q", expected, out.String())
+	}
+
+	// 無効にすると何も書かない
+	out.Reset()
+	p = New(lexer.New("1"))
+	p.SetTrace(nil)
+	p.ParseProgram()
+	if out.Len() != 0 {
+		t.Errorf("trace written after disabling. got=%q", out.String())
+	}
+}
+
+func TestNewlineTerminatesStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"a\n(b)", []string{"a", "b"}},
+		{"x\n-1", []string{"x", "(-1)"}},
+		{"[1, 2]\n[0]", []string{"[1, 2]", "[0]"}},
+		{"let f = fn(x) {\n  x\n  -x\n}\nf(1)", []string{"let f = fn(x) x(-x);", "f(1)"}},
+		{"return\nx", []string{"return;", "x"}},
+		// 行末の演算子や括弧の中では改行を無視する
+		{"1 +\n2", []string{"(1 + 2)"}},
+		{"f(1,\n  2\n  + 3)", []string{"f(1, (2 + 3))"}},
+		{"(a\n+ b)", []string{"(a + b)"}},
+		{"[1\n, 2][a\n- 1]", []string{"([1, 2][(a - 1)])"}},
+		{"{\"a\": 1\n + 2}", []string{"{a:(1 + 2)}"}},
+		{"if (a\n== b) { c }\nelse { d }", []string{"if(a == b) celse d"}},
+		{"f(fn() {\n  a\n  -b\n})", []string{"f(fn() a(-b))"}},
+		{"let x = 1; let y = 2", []string{"let x = 1;", "let y = 2;"}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		got := []string{}
+		for _, stmt := range program.Statements {
+			got = append(got, stmt.String())
+		}
+		if strings.Join(got, "|") != strings.Join(tt.expected, "|") {
+			t.Errorf("statements wrong for %q.\nwant=%q\ngot=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestTrailingCommas(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(a, b,) { a }", "fn(a, b) a"},
+		{"add(1, 2,)", "add(1, 2)"},
+		{"[1, 2,]", "[1, 2]"},
+		{"{\"a\": 1,}", "{a:1}"},
+		{"f(\n  1,\n  2,\n)", "f(1, 2)"},
+		{"let xs = [\n  1,\n  2,\n];", "let xs = [1, 2];"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong for %q. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	// コンマだけや、コンマが続く場合はエラーにする
+	for _, input := range []string{"f(,)", "[1,,]", "fn(,) {}", "{,}"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q", input)
+		}
+	}
+}
+
+func TestArrowFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x => x * 2", "fn(x) (x * 2)"},
+		{"(x, y) => x + y", "fn(x, y) (x + y)"},
+		{"() => 1", "fn() 1"},
+		{"(x) => { let y = x; y }", "fn(x) let y = x;y"},
+		{"map(xs, x => x + 1)", "map(xs, fn(x) (x + 1))"},
+		{"x => y => x + y", "fn(x) fn(y) (x + y)"},
+		{"(a, b,) => a", "fn(a, b) a"},
+		{"(1 + 2) * 3", "((1 + 2) * 3)"},
+		{"let f = x => x\nf(1)", "let f = fn(x) x;f(1)"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong for %q. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	// 本体は元の式の位置を指す
+	program := New(lexer.New("(a) => a + 1")).ParseProgram()
+	fn := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if fn.Pos().String() != "1:1" || fn.End().String() != "1:13" {
+		t.Errorf("wrong position. got=%s-%s", fn.Pos(), fn.End())
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{"(1, x) => x", "1:2: expected identifier in arrow function parameters\n(1, x) => x\n ^"},
+		{"(x, y)", "1:7: expected '=>' but got end of input\n(x, y)\n      ^"},
+		{"(x, x) => x", "1:5: duplicate parameter 'x'\n(x, x) => x\n    ^"},
+	}
+
+	for _, tt := range errorTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestDuplicateParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"fn(x, x) { x }", []string{"1:7: duplicate parameter 'x'\nfn(x, x) { x }\n      ^"}},
+		{"macro(a, b, a) { a }", []string{"1:13: duplicate parameter 'a'\nmacro(a, b, a) { a }\n            ^"}},
+		{
+			"fn(x, y, x, y) {}",
+			[]string{
+				"1:10: duplicate parameter 'x'\nfn(x, y, x, y) {}\n         ^",
+				"1:13: duplicate parameter 'y'\nfn(x, y, x, y) {}\n            ^",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+
+		errors := p.Errors()
+		if strings.Join(errors, "|") != strings.Join(tt.expected, "|") {
+			t.Errorf("errors wrong for %q.\nwant=%q\ngot=%q", tt.input, tt.expected, errors)
+		}
+		if p.ParseErrors()[0].Code != ErrDuplicateParameter {
+			t.Errorf("error code wrong. got=%s", p.ParseErrors()[0].Code)
+		}
+		// 構文解析は最後まで続ける
+		if len(program.Statements) != 1 {
+			t.Errorf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	tests := []struct {
+		input string
+		depth int
+	}{
+		{strings.Repeat("(", 10000) + "1" + strings.Repeat(")", 10000), DefaultMaxDepth},
+		{strings.Repeat("-", 10000) + "1", DefaultMaxDepth},
+		{strings.Repeat("fn() {", 5000), DefaultMaxDepth},
+		{strings.Repeat("[", 20) + strings.Repeat("]", 20), 10},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.SetMaxDepth(tt.depth)
+		p.ParseProgram()
+
+		errors := p.ParseErrors()
+		if len(errors) != 1 {
+			t.Fatalf("expected 1 error for depth %d. got=%d", tt.depth, len(errors))
+		}
+		want := fmt.Sprintf("expression nested too deeply (max depth %d)", tt.depth)
+		if errors[0].Code != ErrTooDeep || errors[0].Message != want {
+			t.Errorf("wrong error. got=%v %q", errors[0].Code, errors[0].Message)
+		}
+	}
+
+	// 上限以内なら構文解析できる。0以下の場合は制限しない
+	input := strings.Repeat("(", 20) + "1" + strings.Repeat(")", 20)
+	for _, depth := range []int{21, 0} {
+		p := New(lexer.New(input))
+		p.SetMaxDepth(depth)
+		p.ParseProgram()
+		checkParserErrors(t, p)
+	}
+}
@@ -2,36 +2,40 @@ package parser
 
 import (
 	"fmt"
+	"io"
 	"strings"
 )
 
-var traceLevel int = 0
-
 const traceIdentPlaceholder string = "\t"
-const debug bool = false
 
-func identLevel() string {
-	return strings.Repeat(traceIdentPlaceholder, traceLevel-1)
+// 構文解析関数の呼び出しをwに書き出すようにする。nilを渡すと止める
+func (p *Parser) SetTrace(w io.Writer) {
+	p.traceOut = w
+	p.traceLevel = 0
+}
+
+func (p *Parser) identLevel() string {
+	return strings.Repeat(traceIdentPlaceholder, p.traceLevel-1)
 }
 
-func tracePrint(fs string) {
-	fmt.Printf("%s%s\n", identLevel(), fs)
+func (p *Parser) tracePrint(fs string) {
+	fmt.Fprintf(p.traceOut, "%s%s\n", p.identLevel(), fs)
 }
 
-func incIdent() { traceLevel = traceLevel + 1 }
-func decIdent() { traceLevel = traceLevel - 1 }
+func (p *Parser) incIdent() { p.traceLevel = p.traceLevel + 1 }
+func (p *Parser) decIdent() { p.traceLevel = p.traceLevel - 1 }
 
-func trace(msg string) string {
-	if debug {
-		incIdent()
-		tracePrint("BEGIN " + msg)
+func (p *Parser) trace(msg string) string {
+	if p.traceOut != nil {
+		p.incIdent()
+		p.tracePrint("BEGIN " + msg)
 	}
 	return msg
 }
 
-func untrace(msg string) {
-	if debug {
-		tracePrint("END " + msg)
-		decIdent()
+func (p *Parser) untrace(msg string) {
+	if p.traceOut != nil {
+		p.tracePrint("END " + msg)
+		p.decIdent()
 	}
 }
@@ -0,0 +1,145 @@
+// プロファイラ。評価のフックを使って、関数ごとの呼び出し回数と評価にかかった時間を記録する
+
+package profiler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/object"
+	"monkey/token"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// 1つの関数の記録
+type Entry struct {
+	Name  string         // letで束縛した名前。それ以外の関数は<anonymous>
+	Pos   token.Position // 関数リテラルの位置
+	Calls int            // 呼び出された回数
+	Total time.Duration  // 評価にかかった時間の合計。再帰呼び出しの分は二重に数えない
+}
+
+type Profiler struct {
+	// 関数リテラルの本体ごとの記録。評価中に関数の本体に入ったことを、本体のノードで見分ける
+	functions map[*ast.BlockStatement]*Entry
+
+	active map[*ast.BlockStatement]int       // 評価中の呼び出しの数。再帰していると2以上になる
+	starts map[*ast.BlockStatement]time.Time // 一番外側の呼び出しを始めた時刻
+
+	now func() time.Time
+
+	// Runで使う評価の設定。Hooksはこのプロファイラで上書きする
+	Options evaluator.Options
+}
+
+func New() *Profiler {
+	return &Profiler{
+		functions: make(map[*ast.BlockStatement]*Entry),
+		active:    make(map[*ast.BlockStatement]int),
+		starts:    make(map[*ast.BlockStatement]time.Time),
+		now:       time.Now,
+	}
+}
+
+// nodeの中の関数を記録の対象にして評価する。組み込み関数は記録しない
+// 記録は次の実行にも引き継いで合計する
+func (p *Profiler) Run(ctx context.Context, node ast.Node, env *object.Environment) object.Object {
+	p.Register(node)
+	opts := p.Options
+	opts.Hooks = p
+	return evaluator.EvalWithOptions(ctx, node, env, opts)
+}
+
+// nodeの中の関数リテラルを記録の対象にする。フックとして直接使う場合は、評価の前に呼ぶ
+func (p *Profiler) Register(node ast.Node) {
+	names := make(map[*ast.FunctionLiteral]string)
+
+	// 文の方が先に訪れられるので、関数リテラルより先に名前が決まる
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			if fn, ok := n.Value.(*ast.FunctionLiteral); ok {
+				names[fn] = n.Name.Value
+			}
+		case *ast.FunctionLiteral:
+			if _, ok := p.functions[n.Body]; ok {
+				return true
+			}
+			name, ok := names[n]
+			if !ok {
+				name = "<anonymous>"
+			}
+			p.functions[n.Body] = &Entry{Name: name, Pos: n.Pos()}
+		}
+		return true
+	})
+}
+
+func (p *Profiler) OnEnterNode(node ast.Node, env *object.Environment) {
+	body, ok := node.(*ast.BlockStatement)
+	if !ok {
+		return
+	}
+	entry, ok := p.functions[body]
+	if !ok {
+		return
+	}
+
+	entry.Calls++
+	if p.active[body] == 0 {
+		p.starts[body] = p.now()
+	}
+	p.active[body]++
+}
+
+func (p *Profiler) OnExitNode(node ast.Node, env *object.Environment, result object.Object) {
+	body, ok := node.(*ast.BlockStatement)
+	if !ok || p.active[body] == 0 {
+		return
+	}
+
+	p.active[body]--
+	if p.active[body] == 0 {
+		p.functions[body].Total += p.now().Sub(p.starts[body])
+	}
+}
+
+// 呼び出された関数の記録を、時間の長い順に返す
+func (p *Profiler) Entries() []Entry {
+	entries := []Entry{}
+	for _, entry := range p.functions {
+		if entry.Calls > 0 {
+			entries = append(entries, *entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		if a.Calls != b.Calls {
+			return a.Calls > b.Calls
+		}
+		if a.Pos.Line != b.Pos.Line {
+			return a.Pos.Line < b.Pos.Line
+		}
+		return a.Pos.Column < b.Pos.Column
+	})
+	return entries
+}
+
+// 記録を表にして書き出す
+func (p *Profiler) WriteReport(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "function\tcalls\ttotal\tper call")
+	for _, e := range p.Entries() {
+		perCall := e.Total / time.Duration(e.Calls)
+		fmt.Fprintf(w, "%s (%s)\t%d\t%s\t%s\n", e.Name, e.Pos, e.Calls, e.Total, perCall)
+	}
+	w.Flush()
+}
@@ -0,0 +1,94 @@
+package profiler
+
+import (
+	"bytes"
+	"context"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 呼ばれるたびに1ミリ秒進む時計
+func fakeClock() func() time.Time {
+	t := time.Unix(0, 0)
+	return func() time.Time {
+		t = t.Add(time.Millisecond)
+		return t
+	}
+}
+
+func TestProfiler(t *testing.T) {
+	input := `let fact = fn(n) { if (n == 0) { 1 } else { n * fact(n - 1) } };
+let twice = fn(f, x) { f(f(x)) };
+fact(3);
+twice(fn(x) { x + 1 }, 0);`
+
+	program := parser.New(lexer.New(input)).ParseProgram()
+	p := New()
+	p.now = fakeClock()
+
+	result := p.Run(context.Background(), program, object.NewEnvironment())
+	if integer, ok := result.(*object.Integer); !ok || integer.Value != 2 {
+		t.Fatalf("wrong result. got=%v", result)
+	}
+
+	expected := []struct {
+		name  string
+		pos   string
+		calls int
+		total time.Duration
+	}{
+		// 再帰の内側の呼び出しは回数にだけ数える
+		{"fact", "1:12", 4, time.Millisecond},
+		{"twice", "2:13", 1, 5 * time.Millisecond},
+		{"<anonymous>", "4:7", 2, 2 * time.Millisecond},
+	}
+
+	entries := p.Entries()
+	if len(entries) != len(expected) {
+		t.Fatalf("wrong number of entries. want=%d, got=%d", len(expected), len(entries))
+	}
+
+	byName := map[string]Entry{}
+	for _, e := range entries {
+		byName[e.Name] = e
+	}
+	for _, tt := range expected {
+		e := byName[tt.name]
+		if e.Pos.String() != tt.pos || e.Calls != tt.calls || e.Total != tt.total {
+			t.Errorf("wrong entry for %s. got=%+v", tt.name, e)
+		}
+	}
+
+	// 時間の長い順に並ぶ
+	if entries[0].Name != "twice" {
+		t.Errorf("entries are not sorted by total time. got=%+v", entries)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	program := parser.New(lexer.New("let f = fn() { 1 }; f(); f();")).ParseProgram()
+	p := New()
+	p.now = fakeClock()
+	p.Run(context.Background(), program, object.NewEnvironment())
+
+	var out bytes.Buffer
+	p.WriteReport(&out)
+
+	expected := "function  calls  total  per call\nf (1:9)   2      2ms    1ms\n"
+	if out.String() != expected {
+		t.Errorf("wrong report.\nwant=%q\ngot=%q", expected, out.String())
+	}
+
+	// 呼ばれなかった関数は表示しない
+	p = New()
+	p.Register(parser.New(lexer.New("let g = fn() { 1 };")).ParseProgram())
+	out.Reset()
+	p.WriteReport(&out)
+	if strings.Count(out.String(), "\n") != 1 {
+		t.Errorf("uncalled functions must not be reported. got=%q", out.String())
+	}
+}
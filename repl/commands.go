@@ -0,0 +1,339 @@
+// REPLのコマンド。:から始まる入力は構文解析せずにここで処理する
+
+package repl
+
+import (
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/debugger"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"os"
+	"sort"
+	"strings"
+)
+
+type command struct {
+	usage string
+	help  string
+	// falseを返すとREPLを終了する
+	run func(s *session, arg string) bool
+}
+
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		"quit": {
+			usage: ":quit",
+			help:  "exit the REPL",
+			run:   func(s *session, arg string) bool { return false },
+		},
+		"env": {
+			usage: ":env",
+			help:  "list the current bindings",
+			run:   commandEnv,
+		},
+		"ast": {
+			usage: ":ast <expr>",
+			help:  "parse the expression and print its AST",
+			run:   commandAst,
+		},
+		"reset": {
+			usage: ":reset",
+			help:  "start over with a fresh environment",
+			run:   commandReset,
+		},
+		"undo": {
+			usage: ":undo",
+			help:  "revert the bindings made by the last input",
+			run:   commandUndo,
+		},
+		"load": {
+			usage: ":load <file>",
+			help:  "evaluate a file into the current environment",
+			run:   commandLoad,
+		},
+		"paste": {
+			usage: ":paste",
+			help:  "read lines until :end and evaluate them as one program",
+			run:   commandPaste,
+		},
+		"save": {
+			usage: ":save <file>",
+			help:  "write the successfully evaluated inputs of this session to a file",
+			run:   commandSave,
+		},
+		"replay": {
+			usage: ":replay <file>",
+			help:  "re-run a saved session statement by statement",
+			run:   commandReplay,
+		},
+		"time": {
+			usage: ":time [on|off]",
+			help:  "print how long each evaluation takes",
+			run:   commandTime,
+		},
+		"debug": {
+			usage: ":debug <file>",
+			help:  "evaluate a file in the debugger, stopping at its first statement (always uses the evaluator)",
+			run:   commandDebug,
+		},
+		"doc": {
+			usage: ":doc [name]",
+			help:  "show the documentation of a builtin function, or list them all",
+			run:   commandDoc,
+		},
+		"help": {
+			usage: ":help",
+			help:  "show this help",
+			run:   commandHelp,
+		},
+	}
+}
+
+// :から始まる入力か判定する
+func isCommand(input string) bool {
+	return strings.HasPrefix(strings.TrimSpace(input), ":")
+}
+
+// コマンドを実行する。REPLを終了する場合はfalseを返す
+func (s *session) runCommand(input string) bool {
+	name, arg, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(input), ":"), " ")
+	arg = strings.TrimSpace(arg)
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(s.out, "unknown command: :%s (type :help for a list of commands)\n", name)
+		return true
+	}
+
+	return cmd.run(s, arg)
+}
+
+func commandEnv(s *session, arg string) bool {
+	for _, name := range s.env.Names() {
+		val, _ := s.env.Get(name)
+		fmt.Fprintf(s.out, "%s = %s\n", name, val.Inspect())
+	}
+	return true
+}
+
+func commandAst(s *session, arg string) bool {
+	l := lexer.New(arg)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		s.printParserErrors(p.Errors())
+		return true
+	}
+
+	io.WriteString(s.out, ast.Dump(program))
+	return true
+}
+
+func commandReset(s *session, arg string) bool {
+	s.env = object.NewEnvironment()
+	s.macroEnv = object.NewEnvironment()
+	if s.vm != nil {
+		s.vm = s.newVM()
+	}
+	s.inputs = nil
+	s.results = 0
+	s.undo = nil
+	return true
+}
+
+func commandUndo(s *session, arg string) bool {
+	if !s.rollback() {
+		io.WriteString(s.out, "nothing to undo\n")
+	}
+	return true
+}
+
+func commandHelp(s *session, arg string) bool {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(s.out, "  %-20s %s\n", cmd.usage, cmd.help)
+	}
+	return true
+}
+
+func commandLoad(s *session, arg string) bool {
+	if arg == "" {
+		io.WriteString(s.out, "usage: :load <file>\n")
+		return true
+	}
+
+	s.checkpoint()
+	s.loadFile(arg)
+	return true
+}
+
+// 貼り付けモードを終了する行
+const PASTE_END = ":end"
+
+func commandPaste(s *session, arg string) bool {
+	io.WriteString(s.out, "// entering paste mode ("+PASTE_END+" or Ctrl-D to finish)\n")
+
+	lines := []string{}
+	for {
+		line, err := s.reader.ReadLine("")
+		if err != nil || strings.TrimSpace(line) == PASTE_END {
+			break
+		}
+		lines = append(lines, line)
+	}
+
+	s.eval(strings.Join(lines, "\n"))
+	return true
+}
+
+func commandSave(s *session, arg string) bool {
+	if arg == "" {
+		io.WriteString(s.out, "usage: :save <file>\n")
+		return true
+	}
+
+	var out strings.Builder
+	for _, input := range s.inputs {
+		input = strings.TrimSpace(input)
+		// 続く入力と1つの式として結合されないように、文を終端する
+		if !strings.HasSuffix(input, ";") {
+			input += ";"
+		}
+		out.WriteString(input + "\n")
+	}
+
+	if err := os.WriteFile(arg, []byte(out.String()), 0644); err != nil {
+		fmt.Fprintln(s.out, err)
+		return true
+	}
+
+	fmt.Fprintf(s.out, "saved %d inputs to %s\n", len(s.inputs), arg)
+	return true
+}
+
+func commandReplay(s *session, arg string) bool {
+	if arg == "" {
+		io.WriteString(s.out, "usage: :replay <file>\n")
+		return true
+	}
+
+	src, err := os.ReadFile(arg)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return true
+	}
+
+	l := lexer.New(string(src))
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		s.printParserErrors(p.Errors())
+		return true
+	}
+
+	// 入力した時と同じように、文を1つずつ評価して結果を表示する
+	// :undoではファイル全体をまとめて戻す
+	s.checkpoint()
+	for _, stmt := range program.Statements {
+		evaluated := s.evalProgram(&ast.Program{Statements: []ast.Statement{stmt}})
+		s.printResult(evaluated)
+		s.bindResult(evaluated)
+	}
+
+	s.inputs = append(s.inputs, string(src))
+	return true
+}
+
+func commandTime(s *session, arg string) bool {
+	switch arg {
+	case "on":
+		s.timing = true
+	case "off":
+		s.timing = false
+	case "":
+	default:
+		io.WriteString(s.out, "usage: :time [on|off]\n")
+		return true
+	}
+
+	if s.timing {
+		io.WriteString(s.out, "timing is on\n")
+	} else {
+		io.WriteString(s.out, "timing is off\n")
+	}
+	return true
+}
+
+func commandDoc(s *session, arg string) bool {
+	names := []string{arg}
+	if arg == "" {
+		names = evaluator.BuiltinNames()
+	}
+
+	for _, name := range names {
+		doc, ok := evaluator.BuiltinDoc(name)
+		if !ok {
+			fmt.Fprintf(s.out, "no builtin function named %q\n", name)
+			continue
+		}
+		io.WriteString(s.out, doc+"\n")
+	}
+	return true
+}
+
+func commandDebug(s *session, arg string) bool {
+	if arg == "" {
+		io.WriteString(s.out, "usage: :debug <file>\n")
+		return true
+	}
+
+	src, err := os.ReadFile(arg)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return true
+	}
+
+	l := lexer.New(string(src))
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		s.printParserErrors(p.Errors())
+		return true
+	}
+
+	s.checkpoint()
+	expanded, ok := s.expandMacros(program)
+	if !ok {
+		return true
+	}
+
+	// ブレークポイントを次の:debugにも引き継ぐ
+	if s.debugger == nil {
+		s.debugger = debugger.New(s.reader, s.out)
+		s.debugger.Options = s.evalOptions()
+	}
+	io.WriteString(s.out, "// entering the debugger (type help for a list of commands)\n")
+
+	evaluated := s.debugger.Run(expanded, s.env)
+	if evaluated == nil {
+		io.WriteString(s.out, "// debugging stopped\n")
+		return true
+	}
+	s.printResult(evaluated)
+	return true
+}
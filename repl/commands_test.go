@@ -0,0 +1,186 @@
+package repl
+
+import (
+	"monkey/evaluator"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"unknown", ":nope\n", ">> unknown command: :nope (type :help for a list of commands)\n>> "},
+		{"quit", ":quit\n1\n", ">> "},
+		{"env", "let x = 5\nlet y = \"a\"\n:env\n", ">> >> >> x = 5\ny = a\n>> "},
+		{"reset", "let x = 5\n:reset\nx\n", ">> >> >> runtime error: 1:1: identifier not found: x\n>> "},
+		{"ast", ":ast 1 + 2 * 3\n", ">> Program\n  ExpressionStatement\n    InfixExpression +\n      IntegerLiteral 1\n      InfixExpression *\n        IntegerLiteral 2\n        IntegerLiteral 3\n>> "},
+		{"doc", ":doc len\n", ">> len(arg)\n    returns the number of characters in a string, bytes in a byte string or elements in an array or range\n>> "},
+		{"doc unknown", ":doc nope\n", ">> no builtin function named \"nope\"\n>> "},
+		// コマンドは括弧が閉じていなくても1行で終わる
+		{"ast error", ":ast (1\n", ">> " + MONKEY_FACE + "Woops! We ran into some monkey business here!\nparse error: 1:3: expected ')' but got end of input\n(1\n  ^\n>> "},
+	}
+
+	for _, tt := range tests {
+		if got := runREPL(tt.input, EngineEval); got != tt.expected {
+			t.Errorf("%s: wrong output. want=%q, got=%q", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestDocCommand(t *testing.T) {
+	// 引数なしなら全ての組み込み関数を一覧する
+	out := runREPL(":doc\n", EngineEval)
+	for _, name := range evaluator.BuiltinNames() {
+		doc, _ := evaluator.BuiltinDoc(name)
+		if !strings.Contains(out, doc+"\n") {
+			t.Errorf(":doc does not describe %q. got=%q", name, out)
+		}
+	}
+}
+
+func TestHelpCommand(t *testing.T) {
+	out := runREPL(":help\n", EngineEval)
+	for name, cmd := range commands {
+		if !strings.Contains(out, cmd.usage) || !strings.Contains(out, cmd.help) {
+			t.Errorf(":help does not describe %q. got=%q", name, out)
+		}
+	}
+}
+
+// 一時ディレクトリにファイルを書き出して、そのパスを返す
+func writeFile(t *testing.T, name, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadCommand(t *testing.T) {
+	lib := writeFile(t, "lib.mky", "let double = fn(x) { x * 2 };\nlet ten = double(5);\nten")
+	broken := writeFile(t, "broken.mky", "let a = 1;\na + true")
+	missing := filepath.Join(t.TempDir(), "missing.mky")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{":load\n", ">> usage: :load <file>\n>> "},
+		// ファイルの結果は表示せずに、束縛だけを残す
+		{":load " + lib + "\ndouble(ten)\n", ">> >> 20\n>> "},
+		{":load " + broken + "\na\n", ">> runtime error: " + broken + ":2:3: type mismatch: INTEGER + BOOLEAN\n>> 1\n>> "},
+		{":load " + missing + "\n", ">> open " + missing + ": no such file or directory\n>> "},
+	}
+
+	for _, tt := range tests {
+		if got := runREPL(tt.input, EngineEval); got != tt.expected {
+			t.Errorf("wrong output for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestPasteCommand(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// 括弧の対応によらず、:endまでの行をまとめて評価する
+		{":paste\nlet a = 1;\nlet f = fn(x) {\n x + a\n};\nf(1)\n:end\nf(2)\n", ">> // entering paste mode (:end or Ctrl-D to finish)\n2\n>> 3\n>> "},
+		// 入力の終わりでも評価する
+		{":paste\n1 + 1\n", ">> // entering paste mode (:end or Ctrl-D to finish)\n2\n>> "},
+		{":paste\n:end\n", ">> // entering paste mode (:end or Ctrl-D to finish)\n>> "},
+	}
+
+	for _, tt := range tests {
+		if got := runREPL(tt.input, EngineEval); got != tt.expected {
+			t.Errorf("wrong output for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestSaveAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.mky")
+
+	out := runREPL("let a = 1\nlet f = fn(x) {\nx + a\n}\nf(1)\nc\n:save "+path+"\n", EngineEval)
+	if !strings.HasSuffix(out, "saved 3 inputs to "+path+"\n>> ") {
+		t.Fatalf("wrong output of :save. got=%q", out)
+	}
+
+	// エラーになった入力は保存しない。続く入力とつながらないように文を終端する
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "let a = 1;\nlet f = fn(x) {\nx + a\n};\nf(1);\n"
+	if string(data) != expected {
+		t.Errorf("wrong saved file. want=%q, got=%q", expected, string(data))
+	}
+
+	// 文ごとに評価して、入力したときと同じように結果を表示する
+	if got := runREPL(":replay "+path+"\nf(10)\n", EngineEval); got != ">> 2\n>> 11\n>> " {
+		t.Errorf("wrong output of :replay. got=%q", got)
+	}
+
+	// 再実行した内容も次の:saveに含める
+	saved := filepath.Join(t.TempDir(), "again.mky")
+	runREPL(":replay "+path+"\n:save "+saved+"\n", EngineEval)
+	data, err = os.ReadFile(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected {
+		t.Errorf("wrong file saved after :replay. want=%q, got=%q", expected, string(data))
+	}
+}
+
+func TestSaveAndReplayErrors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.mky")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{":save\n", ">> usage: :save <file>\n>> "},
+		{":replay\n", ">> usage: :replay <file>\n>> "},
+		{":replay " + missing + "\n", ">> open " + missing + ": no such file or directory\n>> "},
+		// :resetの前の入力は保存しない
+		{"1\n:reset\n:save " + missing + "\n", ">> 1\n>> >> saved 0 inputs to " + missing + "\n>> "},
+	}
+
+	for _, tt := range tests {
+		if got := runREPL(tt.input, EngineEval); got != tt.expected {
+			t.Errorf("wrong output for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestTimeCommand(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{":time\n", ">> timing is off\n>> "},
+		{":time off\n", ">> timing is off\n>> "},
+		{":time on\n:time\n", ">> timing is on\n>> timing is on\n>> "},
+		{":time bad\n", ">> usage: :time [on|off]\n>> "},
+	}
+
+	for _, tt := range tests {
+		if got := runREPL(tt.input, EngineEval); got != tt.expected {
+			t.Errorf("wrong output for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	// 時間とアロケーション回数は実行ごとに変わるので、形式だけを確かめる
+	timed := regexp.MustCompile(`^>> timing is on\n>> 3\n// \S+, \d+ allocs\n>> timing is off\n>> 3\n>> $`)
+	if got := runREPL(":time on\n1 + 2\n:time off\n1 + 2\n", EngineEval); !timed.MatchString(got) {
+		t.Errorf("wrong output with timing. got=%q", got)
+	}
+}
@@ -0,0 +1,52 @@
+package repl
+
+import (
+	"monkey/evaluator"
+	"monkey/object"
+	"monkey/token"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// 補完候補を返す関数
+type completer func(prefix string) []string
+
+// 環境の束縛・組み込み関数・予約語のうち、prefixで始まるものを返す
+func completionCandidates(env *object.Environment, prefix string) []string {
+	names := env.Names()
+	names = append(names, evaluator.BuiltinNames()...)
+	names = append(names, token.Keywords()...)
+
+	seen := make(map[string]bool)
+	candidates := []string{}
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) && !seen[name] {
+			seen[name] = true
+			candidates = append(candidates, name)
+		}
+	}
+
+	sort.Strings(candidates)
+	return candidates
+}
+
+// 識別子を構成する文字か判定する
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// 候補に共通する接頭辞を返す
+func commonPrefix(candidates []string) string {
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
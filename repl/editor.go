@@ -0,0 +1,271 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// 制御文字
+const (
+	keyCtrlA     = 1
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlK     = 11
+	keyCtrlU     = 21
+	keyEnter     = '\r'
+	keyNewline   = '\n'
+	keyEscape    = 27
+	keyBackspace = 127
+	keyCtrlH     = 8
+	keyTab       = '\t'
+)
+
+// 端末用の行エディタ。カーソル移動と履歴の呼び出しができる
+type editor struct {
+	fd      int
+	in      *bufio.Reader
+	out     io.Writer
+	history *history
+
+	complete completer // nilの場合は補完しない
+	color    bool      // 入力中の行に色を付けるか
+}
+
+func newEditor(in *os.File, out io.Writer, h *history) *editor {
+	return &editor{
+		fd:      int(in.Fd()),
+		in:      bufio.NewReader(in),
+		out:     out,
+		history: h,
+	}
+}
+
+// 編集中の行の状態
+type lineState struct {
+	prompt string
+	buf    []rune
+	pos    int // カーソル位置(ルーン単位)
+
+	histIdx int    // 表示中の履歴の位置。len(entries)は編集中の行
+	saved   string // 履歴を辿る前に編集していた行
+}
+
+func (e *editor) ReadLine(prompt string) (string, error) {
+	state, err := makeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restoreTerminal(e.fd, state)
+
+	ls := &lineState{prompt: prompt, histIdx: len(e.history.entries)}
+	e.refresh(ls)
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case keyEnter, keyNewline:
+			io.WriteString(e.out, "\r\n")
+			line := string(ls.buf)
+			e.history.Add(line)
+			return line, nil
+		case keyCtrlD:
+			if len(ls.buf) == 0 {
+				io.WriteString(e.out, "\r\n")
+				return "", io.EOF
+			}
+			ls.deleteForward()
+		case keyCtrlC:
+			// 入力中の行を破棄する
+			io.WriteString(e.out, "^C\r\n")
+			ls.buf = ls.buf[:0]
+			ls.pos = 0
+			ls.histIdx = len(e.history.entries)
+		case keyBackspace, keyCtrlH:
+			ls.deleteBackward()
+		case keyCtrlA:
+			ls.pos = 0
+		case keyCtrlE:
+			ls.pos = len(ls.buf)
+		case keyCtrlK:
+			ls.buf = ls.buf[:ls.pos]
+		case keyCtrlU:
+			ls.buf = ls.buf[ls.pos:]
+			ls.pos = 0
+		case keyTab:
+			e.completeWord(ls)
+		case keyEscape:
+			e.handleEscape(ls)
+		default:
+			if unicode.IsPrint(r) {
+				ls.insert(r)
+			}
+		}
+
+		e.refresh(ls)
+	}
+}
+
+// ESC [ に続くエスケープシーケンスを処理する
+func (e *editor) handleEscape(ls *lineState) {
+	b, _, err := e.in.ReadRune()
+	if err != nil || (b != '[' && b != 'O') {
+		return
+	}
+
+	c, _, err := e.in.ReadRune()
+	if err != nil {
+		return
+	}
+
+	switch c {
+	case 'A': // 上
+		e.historyPrev(ls)
+	case 'B': // 下
+		e.historyNext(ls)
+	case 'C': // 右
+		if ls.pos < len(ls.buf) {
+			ls.pos++
+		}
+	case 'D': // 左
+		if ls.pos > 0 {
+			ls.pos--
+		}
+	case 'H':
+		ls.pos = 0
+	case 'F':
+		ls.pos = len(ls.buf)
+	case '3': // Delete: ESC [ 3 ~
+		if t, _, err := e.in.ReadRune(); err == nil && t == '~' {
+			ls.deleteForward()
+		}
+	}
+}
+
+// カーソル直前の単語を補完する。候補が複数ある場合は共通部分まで補完し、それ以上進めない場合は候補を一覧表示する
+func (e *editor) completeWord(ls *lineState) {
+	if e.complete == nil {
+		return
+	}
+
+	start := ls.pos
+	for start > 0 && isIdentRune(ls.buf[start-1]) {
+		start--
+	}
+	word := string(ls.buf[start:ls.pos])
+
+	candidates := e.complete(word)
+	if len(candidates) == 0 {
+		return
+	}
+
+	prefix := commonPrefix(candidates)
+	if len(candidates) == 1 {
+		prefix += " "
+	}
+	if prefix != word {
+		for _, r := range []rune(prefix)[len([]rune(word)):] {
+			ls.insert(r)
+		}
+		return
+	}
+
+	io.WriteString(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+}
+
+// 1つ前の履歴を表示する
+func (e *editor) historyPrev(ls *lineState) {
+	if ls.histIdx == 0 {
+		return
+	}
+	if ls.histIdx == len(e.history.entries) {
+		ls.saved = string(ls.buf)
+	}
+
+	ls.histIdx--
+	ls.setLine(e.history.entries[ls.histIdx])
+}
+
+// 1つ後の履歴を表示する。最後まで進んだら編集中だった行に戻す
+func (e *editor) historyNext(ls *lineState) {
+	if ls.histIdx >= len(e.history.entries) {
+		return
+	}
+
+	ls.histIdx++
+	if ls.histIdx == len(e.history.entries) {
+		ls.setLine(ls.saved)
+	} else {
+		ls.setLine(e.history.entries[ls.histIdx])
+	}
+}
+
+// 行を再描画し、カーソルを正しい位置に移動する
+func (e *editor) refresh(ls *lineState) {
+	line := string(ls.buf)
+	if e.color {
+		line = highlight(line)
+	}
+
+	cursor := visibleWidth(ls.prompt) + ls.pos
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K\r", ls.prompt, line)
+	if cursor > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dC", cursor)
+	}
+}
+
+func (ls *lineState) insert(r rune) {
+	ls.buf = append(ls.buf, 0)
+	copy(ls.buf[ls.pos+1:], ls.buf[ls.pos:])
+	ls.buf[ls.pos] = r
+	ls.pos++
+}
+
+func (ls *lineState) deleteBackward() {
+	if ls.pos == 0 {
+		return
+	}
+	ls.buf = append(ls.buf[:ls.pos-1], ls.buf[ls.pos:]...)
+	ls.pos--
+}
+
+func (ls *lineState) deleteForward() {
+	if ls.pos >= len(ls.buf) {
+		return
+	}
+	ls.buf = append(ls.buf[:ls.pos], ls.buf[ls.pos+1:]...)
+}
+
+func (ls *lineState) setLine(line string) {
+	ls.buf = []rune(line)
+	ls.pos = len(ls.buf)
+}
+
+// エスケープシーケンスを除いた文字数を返す
+func visibleWidth(s string) int {
+	width := 0
+	inEscape := false
+
+	for _, r := range s {
+		switch {
+		case inEscape:
+			// CSIシーケンスは英字で終わる
+			if unicode.IsLetter(r) {
+				inEscape = false
+			}
+		case r == keyEscape:
+			inEscape = true
+		default:
+			width++
+		}
+	}
+	return width
+}
@@ -0,0 +1,87 @@
+package repl
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLineStateEditing(t *testing.T) {
+	ls := &lineState{}
+	for _, r := range "helo" {
+		ls.insert(r)
+	}
+	ls.pos = 3
+	ls.insert('l')
+	if got := string(ls.buf); got != "hello" || ls.pos != 4 {
+		t.Fatalf("insert wrong. got=%q pos=%d", got, ls.pos)
+	}
+
+	ls.deleteBackward()
+	if got := string(ls.buf); got != "helo" || ls.pos != 3 {
+		t.Errorf("deleteBackward wrong. got=%q pos=%d", got, ls.pos)
+	}
+
+	ls.deleteForward()
+	if got := string(ls.buf); got != "hel" || ls.pos != 3 {
+		t.Errorf("deleteForward wrong. got=%q pos=%d", got, ls.pos)
+	}
+
+	// 端では何もしない
+	ls.deleteForward()
+	ls.pos = 0
+	ls.deleteBackward()
+	if got := string(ls.buf); got != "hel" || ls.pos != 0 {
+		t.Errorf("edits at the ends should do nothing. got=%q pos=%d", got, ls.pos)
+	}
+
+	ls.setLine("日本語")
+	if ls.pos != 3 {
+		t.Errorf("setLine should move the cursor to the end of the runes. got=%d", ls.pos)
+	}
+}
+
+// escapesを続きの入力にしたエディタを作る
+func newTestEditor(escapes string, entries ...string) *editor {
+	return &editor{
+		in:      bufio.NewReader(strings.NewReader(escapes)),
+		out:     &bytes.Buffer{},
+		history: &history{entries: entries},
+	}
+}
+
+func TestEditorHistory(t *testing.T) {
+	// 上、上、上、下、下。最後まで進むと編集中だった行に戻す
+	e := newTestEditor("[A[A[A[B[B", "first", "second")
+	ls := &lineState{histIdx: len(e.history.entries)}
+	ls.setLine("draft")
+
+	expected := []string{"second", "first", "first", "second", "draft"}
+	for i, want := range expected {
+		e.handleEscape(ls)
+		if got := string(ls.buf); got != want {
+			t.Errorf("step %d: wrong line. want=%q, got=%q", i, want, got)
+		}
+	}
+}
+
+func TestEditorCursorKeys(t *testing.T) {
+	// 左、左、右、Home、End、Delete
+	e := newTestEditor("[D[D[C[H[F[D[3~")
+	ls := &lineState{}
+	ls.setLine("abc")
+
+	expected := []struct {
+		line string
+		pos  int
+	}{
+		{"abc", 2}, {"abc", 1}, {"abc", 2}, {"abc", 0}, {"abc", 3}, {"abc", 2}, {"ab", 2},
+	}
+	for i, want := range expected {
+		e.handleEscape(ls)
+		if got := string(ls.buf); got != want.line || ls.pos != want.pos {
+			t.Errorf("step %d: want=%q pos=%d, got=%q pos=%d", i, want.line, want.pos, got, ls.pos)
+		}
+	}
+}
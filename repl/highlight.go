@@ -0,0 +1,90 @@
+package repl
+
+import (
+	"monkey/lexer"
+	"monkey/token"
+	"strings"
+)
+
+// ANSIエスケープシーケンス
+const (
+	colorReset   = "\x1b[0m"
+	colorKeyword = "\x1b[35m"
+	colorNumber  = "\x1b[36m"
+	colorString  = "\x1b[32m"
+	colorOper    = "\x1b[33m"
+	colorComment = "\x1b[90m"
+)
+
+// トークンタイプごとの色
+var tokenColors = map[token.TokenType]string{
+	token.FUNCTION: colorKeyword,
+	token.LET:      colorKeyword,
+	token.TRUE:     colorKeyword,
+	token.FALSE:    colorKeyword,
+	token.IF:       colorKeyword,
+	token.ELSE:     colorKeyword,
+	token.RETURN:   colorKeyword,
+	token.MACRO:    colorKeyword,
+	token.DEFER:    colorKeyword,
+
+	token.INT:     colorNumber,
+	token.STRING:  colorString,
+	token.BYTES:   colorString,
+	token.COMMENT: colorComment,
+
+	token.ASSIGN:   colorOper,
+	token.PLUS:     colorOper,
+	token.MINUS:    colorOper,
+	token.BANG:     colorOper,
+	token.ASTERISK: colorOper,
+	token.SLASH:    colorOper,
+	token.LT:       colorOper,
+	token.GT:       colorOper,
+	token.EQ:       colorOper,
+	token.NOT_EQ:   colorOper,
+	token.ARROW:    colorOper,
+	token.DOTDOT:   colorOper,
+}
+
+// 字句解析器のトークン列に従って、1行のソースコードに色を付ける
+func highlight(line string) string {
+	var out strings.Builder
+	cursor := 0
+	l := lexer.New(line)
+
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		color, ok := tokenColors[tok.Type]
+		if !ok {
+			continue
+		}
+
+		// 1行だけを字句解析しているので、列番号からバイト位置が分かる
+		start := tok.Column - 1
+		end := start + len(tok.Literal)
+		if tok.Type == token.STRING {
+			// 文字列リテラルは引用符を含めて色を付ける
+			end = start + 1 + len(tok.Literal)
+			if end < len(line) && line[end] == '"' {
+				end++
+			}
+		}
+		if tok.Type == token.BYTES {
+			// bと引用符を含めて色を付ける
+			end = start + 2 + len(tok.Literal)
+			if end < len(line) && line[end] == '"' {
+				end++
+			}
+		}
+		if start < cursor || end > len(line) {
+			break
+		}
+
+		out.WriteString(line[cursor:start])
+		out.WriteString(color + line[start:end] + colorReset)
+		cursor = end
+	}
+
+	out.WriteString(line[cursor:])
+	return out.String()
+}
@@ -0,0 +1,42 @@
+package repl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 5;", colorKeyword + "let" + colorReset + " x " + colorOper + "=" + colorReset + " " + colorNumber + "5" + colorReset + ";"},
+		{`puts("a b")`, `puts(` + colorString + `"a b"` + colorReset + `)`},
+		// 閉じていない文字列も色を付ける
+		{`"abc`, colorString + `"abc` + colorReset},
+		{"  if (a) {", "  " + colorKeyword + "if" + colorReset + " (a) {"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := highlight(tt.input); got != tt.expected {
+			t.Errorf("highlight(%q) wrong.\nwant=%q\ngot=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestEditorRefreshColor(t *testing.T) {
+	ls := &lineState{prompt: PROMPT}
+	ls.setLine("let")
+
+	for _, color := range []bool{false, true} {
+		var out bytes.Buffer
+		e := &editor{out: &out, color: color}
+		e.refresh(ls)
+
+		if got := strings.Contains(out.String(), colorKeyword); got != color {
+			t.Errorf("color=%t: wrong output. got=%q", color, out.String())
+		}
+	}
+}
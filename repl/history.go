@@ -0,0 +1,74 @@
+package repl
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+)
+
+// 履歴ファイル名。ホームディレクトリに置く
+const HISTORY_FILE = ".monkey_history"
+
+// 読み込む履歴の最大件数
+const maxHistory = 1000
+
+// 入力履歴。pathが空の場合はファイルに保存しない
+type history struct {
+	entries []string
+	path    string
+}
+
+// ホームディレクトリの履歴ファイルのパスを返す。取得できない場合は空文字を返す
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, HISTORY_FILE)
+}
+
+// 履歴ファイルを読み込む。ファイルが存在しない場合は空の履歴を返す
+func loadHistory(path string) *history {
+	h := &history{path: path}
+	if path == "" {
+		return h
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return h
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		h.entries = append(h.entries, scanner.Text())
+	}
+	if len(h.entries) > maxHistory {
+		h.entries = h.entries[len(h.entries)-maxHistory:]
+	}
+
+	return h
+}
+
+// 履歴に追加し、ファイルにも追記する。空行と直前と同じ入力は追加しない
+func (h *history) Add(line string) {
+	if line == "" {
+		return
+	}
+	if n := len(h.entries); n > 0 && h.entries[n-1] == line {
+		return
+	}
+
+	h.entries = append(h.entries, line)
+
+	if h.path == "" {
+		return
+	}
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.WriteString(line + "\n")
+}
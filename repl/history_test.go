@@ -0,0 +1,61 @@
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), HISTORY_FILE)
+
+	h := loadHistory(path)
+	if len(h.entries) != 0 {
+		t.Fatalf("history of a missing file is not empty. got=%q", h.entries)
+	}
+
+	for _, line := range []string{"let a = 1", "", "a", "a", "a + 1"} {
+		h.Add(line)
+	}
+	expected := []string{"let a = 1", "a", "a + 1"}
+	if !reflect.DeepEqual(h.entries, expected) {
+		t.Errorf("wrong entries. want=%q, got=%q", expected, h.entries)
+	}
+
+	// 次に起動したときに読み込める
+	if got := loadHistory(path).entries; !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong entries after reload. want=%q, got=%q", expected, got)
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), HISTORY_FILE)
+
+	lines := make([]string, maxHistory+10)
+	for i := range lines {
+		lines[i] = strings.Repeat("x", i+1)
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// 古いものから捨てる
+	h := loadHistory(path)
+	if len(h.entries) != maxHistory {
+		t.Fatalf("wrong number of entries. want=%d, got=%d", maxHistory, len(h.entries))
+	}
+	if h.entries[0] != lines[10] {
+		t.Errorf("wrong first entry. want=%q, got=%q", lines[10], h.entries[0])
+	}
+}
+
+func TestHistoryWithoutFile(t *testing.T) {
+	// パスが空の場合はファイルに書き出さずに覚えておく
+	h := loadHistory("")
+	h.Add("1")
+	if !reflect.DeepEqual(h.entries, []string{"1"}) {
+		t.Errorf("wrong entries. got=%q", h.entries)
+	}
+}
@@ -0,0 +1,39 @@
+package repl
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// プロンプトを表示して1行読み込む
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+}
+
+// 入力が端末の場合は行編集と履歴をサポートするエディタを、それ以外の場合は1行ずつ読むだけのリーダーを返す
+func newLineReader(in io.Reader, out io.Writer) lineReader {
+	if f, ok := in.(*os.File); ok && isTerminal(int(f.Fd())) {
+		return newEditor(f, out, loadHistory(historyPath()))
+	}
+
+	return &scannerReader{scanner: bufio.NewScanner(in), out: out}
+}
+
+// パイプやテスト用の入力を読むリーダー
+type scannerReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func (r *scannerReader) ReadLine(prompt string) (string, error) {
+	io.WriteString(r.out, prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+
+	return r.scanner.Text(), nil
+}
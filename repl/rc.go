@@ -0,0 +1,76 @@
+// 起動時に読み込む設定ファイル。Monkeyのプログラムとして評価し、束縛された値で設定を上書きする
+//
+//	let PROMPT = "monkey> ";
+//	let CONTINUATION_PROMPT = "...> ";
+//	let PROMPT_COLOR = "green";
+
+package repl
+
+import (
+	"monkey/object"
+	"os"
+	"path/filepath"
+)
+
+// 設定ファイル名。ホームディレクトリに置く
+const RC_FILE = ".monkeyrc"
+
+// プロンプトに使える色
+var promptColors = map[string]string{
+	"black":   "\x1b[30m",
+	"red":     "\x1b[31m",
+	"green":   "\x1b[32m",
+	"yellow":  "\x1b[33m",
+	"blue":    "\x1b[34m",
+	"magenta": "\x1b[35m",
+	"cyan":    "\x1b[36m",
+	"white":   "\x1b[37m",
+}
+
+// ホームディレクトリの設定ファイルのパスを返す。取得できない場合は空文字を返す
+func DefaultRCPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, RC_FILE)
+}
+
+// 設定ファイルを初期環境に評価し、プロンプトの設定を反映する。ファイルがなければ何もしない
+func (s *session) loadRC(path string, color bool) {
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+
+	s.loadFile(path)
+
+	if prompt, ok := s.stringBinding("PROMPT"); ok {
+		s.prompt = prompt
+	}
+	if prompt, ok := s.stringBinding("CONTINUATION_PROMPT"); ok {
+		s.continuationPrompt = prompt
+	}
+
+	name, ok := s.stringBinding("PROMPT_COLOR")
+	if !ok || !color {
+		return
+	}
+	if c, ok := promptColors[name]; ok {
+		s.prompt = c + s.prompt + colorReset
+		s.continuationPrompt = c + s.continuationPrompt + colorReset
+	}
+}
+
+// 環境に束縛された文字列を返す
+func (s *session) stringBinding(name string) (string, bool) {
+	obj, ok := s.env.Get(name)
+	if !ok {
+		return "", false
+	}
+
+	str, ok := obj.(*object.String)
+	if !ok {
+		return "", false
+	}
+	return str.Value, true
+}
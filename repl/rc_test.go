@@ -0,0 +1,47 @@
+package repl
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRCFile(t *testing.T) {
+	rc := writeFile(t, RC_FILE, `let PROMPT = "monkey> "; let CONTINUATION_PROMPT = "...> "; let PROMPT_COLOR = "green"; let greet = fn(name) { "hi " + name };`)
+
+	tests := []struct {
+		name     string
+		opts     Options
+		input    string
+		expected string
+	}{
+		// 設定ファイルで定義した値はREPLから使える
+		{"prompt", Options{RCFile: rc}, "greet(\n\"a\")\n", "monkey> ...> hi a\nmonkey> "},
+		{"color", Options{RCFile: rc, Color: true}, "1\n", "\x1b[32mmonkey> \x1b[0m1\n\x1b[32mmonkey> \x1b[0m"},
+		// ファイルがなければデフォルトのまま
+		{"missing", Options{RCFile: filepath.Join(t.TempDir(), RC_FILE)}, "1\n", ">> 1\n>> "},
+		{"none", Options{}, "greet\n", ">> runtime error: 1:1: identifier not found: greet\n>> "},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		StartWithOptions(strings.NewReader(tt.input), &out, tt.opts)
+		if got := out.String(); got != tt.expected {
+			t.Errorf("%s: wrong output. want=%q, got=%q", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestRCFileErrors(t *testing.T) {
+	// 設定ファイルのエラーは表示して、REPLは続ける。文字列以外の設定は使わない
+	rc := writeFile(t, RC_FILE, "let PROMPT = 1; let PROMPT_COLOR = \"green\"; 1 + true")
+
+	var out bytes.Buffer
+	StartWithOptions(strings.NewReader("PROMPT\n"), &out, Options{RCFile: rc, Color: true})
+
+	expected := "\x1b[1;31mruntime error:\x1b[0m " + rc + ":1:47: type mismatch: INTEGER + BOOLEAN\n\x1b[32m>> \x1b[0m1\n\x1b[32m>> \x1b[0m"
+	if got := out.String(); got != expected {
+		t.Errorf("wrong output. want=%q, got=%q", expected, got)
+	}
+}
@@ -1,60 +1,318 @@
 package repl
 
 import (
-	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"monkey/ast"
+	"monkey/debugger"
+	"monkey/diag"
 	"monkey/evaluator"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"monkey/vm"
+	"os"
+	"strings"
 )
 
 const PROMPT = ">> "
+const CONTINUATION_PROMPT = ".. "
 const MONKEY_FACE = `
 (=ФωФ=)
 `
 
-// REPLを開始する
+// 入力を実行する方法
+const (
+	EngineEval = "eval" // ASTをそのまま評価する
+	EngineVM   = "vm"   // バイトコードにコンパイルしてVMで実行する
+)
+
+// REPLの設定
+type Options struct {
+	Color  bool   // 端末への出力に色を付けるか
+	RCFile string // 起動時に評価するファイル。空の場合は何も読み込まない
+	Engine string // EngineEvalかEngineVM
+}
+
+// デフォルトの設定。VMで実行する
+func DefaultOptions() Options {
+	return Options{Color: true, Engine: EngineVM}
+}
+
+// REPLの状態
+type session struct {
+	out      io.Writer
+	reader   lineReader
+	env      *object.Environment
+	macroEnv *object.Environment
+
+	// EngineVMで使う。入力をまたいで変数の番号と定数を引き継ぐ。評価器で実行する場合はnil
+	vm *vm.Session
+
+	inputs []string // エラーなく評価できた入力。:saveで書き出す
+
+	results int // これまでに束縛した結果の数。_1, _2, ...の番号になる
+
+	undo []checkpoint // 入力を評価する前の状態。:undoで最後のものに戻す
+
+	timing bool // 評価にかかった時間を表示するか
+
+	debugger *debugger.Debugger // :debugで使う。最初に使うときに作る
+
+	files *evaluator.Files // 入力で開いたファイル。REPLを終了するときに閉じる
+
+	prompt             string
+	continuationPrompt string
+
+	diag *diag.Printer
+}
+
+// デフォルトの設定でREPLを開始する
 func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
-	macroEnv := object.NewEnvironment()
+	StartWithOptions(in, out, DefaultOptions())
+}
+
+// 設定を指定してREPLを開始する
+func StartWithOptions(in io.Reader, out io.Writer, opts Options) {
+	s := &session{
+		out:      out,
+		reader:   newLineReader(in, out),
+		env:      object.NewEnvironment(),
+		macroEnv: object.NewEnvironment(),
+
+		prompt:             PROMPT,
+		continuationPrompt: CONTINUATION_PROMPT,
+
+		diag: diag.NewPrinter(out, opts.Color),
+
+		files: evaluator.NewFiles(),
+	}
+	if opts.Engine == EngineVM {
+		s.vm = s.newVM()
+	}
+
+	if e, ok := s.reader.(*editor); ok {
+		e.color = opts.Color
+		e.complete = func(prefix string) []string {
+			return completionCandidates(s.env, prefix)
+		}
+	}
+
+	// 入力をまたいで使えるように、開いたファイルはREPLを終了するときに閉じる
+	defer s.files.Close()
+
+	if opts.RCFile != "" {
+		s.loadRC(opts.RCFile, opts.Color)
+	}
 
 	for {
-		fmt.Printf(PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		input, ok := s.readInput()
+		if !ok {
 			return
 		}
 
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
+		if isCommand(input) {
+			if !s.runCommand(input) {
+				return
+			}
+			continue
+		}
+
+		s.eval(input)
+	}
+}
+
+// 入力を構文解析して評価し、結果を表示する
+func (s *session) eval(input string) {
+	l := lexer.New(input)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		s.printParserErrors(p.Errors())
+		return
+	}
+
+	var stats timingStats
+	if s.timing {
+		stats = startTiming()
+	}
+
+	s.checkpoint()
+	evaluated := s.evalProgram(program)
+	s.printResult(evaluated)
+	s.bindResult(evaluated)
+
+	if s.timing {
+		io.WriteString(s.out, stats.stop()+"\n")
+	}
+
+	if evaluated == nil || evaluated.Type() != object.ERROR_OBJ {
+		s.inputs = append(s.inputs, input)
+	}
+}
+
+// 評価結果を表示する。エラーは実行時エラーとして表示する
+func (s *session) printResult(obj object.Object) {
+	if obj == nil {
+		return
+	}
 
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+	if errObj, ok := obj.(*object.Error); ok {
+		s.diag.Print(diag.RuntimeError, errObj.Located("")+errObj.StackTrace())
+		return
+	}
+
+	io.WriteString(s.out, inspectResult(obj)+"\n")
+}
+
+// 1行で表示すると長すぎる結果は、要素ごとに改行して表示する
+const maxResultWidth = 80
+
+var prettyInspectOptions = object.InspectOptions{Indent: "  ", MaxItems: 100}
+
+func inspectResult(obj object.Object) string {
+	out := obj.Inspect()
+	if len(out) > maxResultWidth {
+		return object.InspectWith(obj, prettyInspectOptions)
+	}
+	return out
+}
+
+// 評価結果を_と_1, _2, ...に束縛して、後の入力から参照できるようにする
+func (s *session) bindResult(obj object.Object) {
+	if obj == nil || obj.Type() == object.ERROR_OBJ {
+		return
+	}
+
+	s.results++
+	s.env.Set("_", obj)
+	s.env.Set(fmt.Sprintf("_%d", s.results), obj)
+}
+
+// ファイルを構文解析して現在の環境で評価する。エラーは表示するだけで、REPLは続行する
+func (s *session) loadFile(filename string) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return
+	}
+
+	l := lexer.New(string(src))
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		s.printParserErrors(p.Errors())
+		return
+	}
+
+	evaluated := s.evalProgram(program)
+	if errObj, ok := evaluated.(*object.Error); ok {
+		s.diag.Print(diag.RuntimeError, errObj.Located(filename))
+	}
+}
+
+// マクロを展開してから、現在の環境でプログラムを評価する
+// 展開やコンパイルに失敗した場合はエラーを表示してnilを返す
+func (s *session) evalProgram(program *ast.Program) object.Object {
+	expanded, ok := s.expandMacros(program)
+	if !ok {
+		return nil
+	}
+
+	if s.vm == nil {
+		return evaluator.EvalWithOptions(context.Background(), expanded, s.env, s.evalOptions())
+	}
+	evaluated, err := s.vm.Run(expanded, s.env)
+	if err != nil {
+		s.diag.Print(diag.CompileError, err.Error())
+		return nil
+	}
+	return evaluated
+}
+
+// 入力の評価に使う設定
+func (s *session) evalOptions() evaluator.Options {
+	return evaluator.Options{Files: s.files, Stdout: s.out}
+}
+
+// 入力をまたいで変数と定数を引き継ぐVMを作る
+func (s *session) newVM() *vm.Session {
+	machine := vm.NewSession()
+	machine.Files = s.files
+	machine.Stdout = s.out
+	return machine
+}
+
+// マクロを定義して展開する。展開に失敗した場合はエラーを全て表示してfalseを返す
+func (s *session) expandMacros(program *ast.Program) (ast.Node, bool) {
+	evaluator.DefineMacros(program, s.macroEnv)
+	expanded, errors := evaluator.ExpandMacros(program, s.macroEnv)
+	for _, err := range errors {
+		s.diag.Print(diag.MacroError, err.Error())
+	}
+
+	return expanded, len(errors) == 0
+}
+
+// 1つの入力を読み込む。括弧が閉じていない場合や行末が\の場合は、継続プロンプトを表示して次の行も読み込む
+func (s *session) readInput() (string, bool) {
+	lines := []string{}
+	joined := "" // 行末の\で続けた行。改行は文の区切りになるので、次の行とは空白でつなげる
+	prompt := s.prompt
+
+	for {
+		line, err := s.reader.ReadLine(prompt)
+		if err != nil {
+			return "", false
+		}
+
+		// コマンドは1行で完結する
+		if len(lines) == 0 && joined == "" && isCommand(line) {
+			return line, true
+		}
+
+		prompt = s.continuationPrompt
+
+		// 明示的な行継続
+		if strings.HasSuffix(line, "\\") {
+			joined += strings.TrimSuffix(line, "\\") + " "
 			continue
 		}
 
-		evaluator.DefineMacros(program, macroEnv)
-		expanded := evaluator.ExpandMacros(program, macroEnv)
+		// 継続中の空行で入力を打ち切る。閉じ忘れたまま抜け出せなくならないように
+		if (len(lines) > 0 || joined != "") && strings.TrimSpace(line) == "" {
+			if joined != "" {
+				lines = append(lines, joined)
+			}
+			return strings.Join(lines, "\n"), true
+		}
 
-		evaluated := evaluator.Eval(expanded, env)
-		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
-			io.WriteString(out, "\n")
+		lines = append(lines, joined+line)
+		joined = ""
+		input := strings.Join(lines, "\n")
+		if isIncomplete(input) {
+			continue
 		}
+
+		return input, true
 	}
 }
 
+// 入力が括弧やブロックの途中で終わっていて、続きの行が必要か判定する
+func isIncomplete(input string) bool {
+	p := parser.New(lexer.New(input))
+	p.ParseProgram()
+	return p.Incomplete()
+}
+
 // エラーを表示する
-func printParserErrors(out io.Writer, errors []string) {
-	io.WriteString(out, MONKEY_FACE)
-	io.WriteString(out, "Woops! We ran into some monkey business here!\n")
-	io.WriteString(out, " parser errors:\n")
+func (s *session) printParserErrors(errors []string) {
+	io.WriteString(s.out, MONKEY_FACE)
+	io.WriteString(s.out, "Woops! We ran into some monkey business here!\n")
 	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+		s.diag.Print(diag.ParseError, msg)
 	}
 }
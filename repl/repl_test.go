@@ -0,0 +1,132 @@
+package repl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// 入力を1行ずつREPLに渡して、出力を返す。色は付けない
+func runREPL(input string, engine string) string {
+	var out bytes.Buffer
+	StartWithOptions(strings.NewReader(input), &out, Options{Engine: engine})
+	return out.String()
+}
+
+func TestMultiLineInput(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// 括弧が閉じるまで続きの行を読む
+		{"let f = fn(x) {\nx * 2\n}\nf(4)\n", ">> .. .. >> 8\n>> "},
+		{"[1,\n2,\n3]\n", ">> .. .. [1, 2, 3]\n>> "},
+		// 文字列の中の括弧は数えない
+		{"\"(\"\n", ">> (\n>> "},
+		// 行末の\で次の行に続ける
+		{"1 + \\\n2\n", ">> .. 3\n>> "},
+		// 式の途中で終わった行も続きを読む
+		{"let x =\n5\nx\n", ">> .. >> 5\n>> "},
+		// 継続中の空行で入力を打ち切る
+		{"let f = fn(x) {\n\n1\n", ">> .. " + MONKEY_FACE + "Woops! We ran into some monkey business here!\nparse error: 1:16: expected '}' but got end of input\nlet f = fn(x) {\n               ^\n>> 1\n>> "},
+		// 入力の途中で終わった場合は何も評価しない
+		{"let f = fn(x) {\n", ">> .. "},
+	}
+
+	for _, engine := range []string{EngineEval, EngineVM} {
+		for _, tt := range tests {
+			if got := runREPL(tt.input, engine); got != tt.expected {
+				t.Errorf("%s: wrong output for %q. want=%q, got=%q", engine, tt.input, tt.expected, got)
+			}
+		}
+	}
+}
+
+func TestUndo(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{":undo\n", ">> nothing to undo\n>> "},
+		{"let a = 1\n:undo\na\n", ">> >> >> runtime error: 1:1: identifier not found: a\n>> "},
+		{"let a = 1\nlet a = 2\n:undo\na\n", ">> >> >> >> 1\n>> "},
+		// 結果の番号も戻す
+		{"1\n2\n:undo\n3\n_2\n", ">> 1\n>> 2\n>> >> 3\n>> 3\n>> "},
+	}
+
+	for _, engine := range []string{EngineEval, EngineVM} {
+		for _, tt := range tests {
+			if got := runREPL(tt.input, engine); got != tt.expected {
+				t.Errorf("%s: wrong output for %q. want=%q, got=%q", engine, tt.input, tt.expected, got)
+			}
+		}
+	}
+}
+
+func TestResultBindings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2\n_ * 2\n_1\n_2\n", ">> 3\n>> 6\n>> 3\n>> 6\n>> "},
+		// letとエラーは結果にならない
+		{"1\nlet a = 2\na + true\n_\n_2\n", ">> 1\n>> >> runtime error: 1:3: type mismatch: INTEGER + BOOLEAN\n>> 1\n>> 1\n>> "},
+		// :resetで番号を振り直す
+		{"1\n2\n:reset\n3\n_1\n", ">> 1\n>> 2\n>> >> 3\n>> 3\n>> "},
+	}
+
+	for _, engine := range []string{EngineEval, EngineVM} {
+		for _, tt := range tests {
+			if got := runREPL(tt.input, engine); got != tt.expected {
+				t.Errorf("%s: wrong output for %q. want=%q, got=%q", engine, tt.input, tt.expected, got)
+			}
+		}
+	}
+}
+
+func TestEngine(t *testing.T) {
+	// 入れ子のunquoteはコンパイラだけが拒否するので、どちらのエンジンで実行したか分かる
+	input := "quote(unquote(unquote(1)))\n"
+	tests := []struct {
+		engine   string
+		expected string
+	}{
+		{EngineEval, ">> QUOTE(1)\n>> "},
+		{EngineVM, ">> compile error: 1:1: nested unquote is not supported by the compiler\n>> "},
+	}
+
+	for _, tt := range tests {
+		if got := runREPL(input, tt.engine); got != tt.expected {
+			t.Errorf("%s: wrong output. want=%q, got=%q", tt.engine, tt.expected, got)
+		}
+	}
+}
+
+func TestPutsOutput(t *testing.T) {
+	// putsはREPLの出力先に書く
+	for _, engine := range []string{EngineEval, EngineVM} {
+		if got := runREPL("puts(1)\n", engine); got != ">> 1\nnull\n>> " {
+			t.Errorf("%s: wrong output. got=%q", engine, got)
+		}
+	}
+}
+
+func TestLineContinuation(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// 行末の\で続けた行は、改行で文が終わらずに次の行とつながる
+		{"let a = 1 \\\n+ 2\na\n", ">> .. >> 3\n>> "},
+		{"let double = fn(x) \\\n{ x * 2 }\ndouble(3)\n", ">> .. >> 6\n>> "},
+		{"[1, \\\n2,\n3]\n", ">> .. .. [1, 2, 3]\n>> "},
+	}
+
+	for _, engine := range []string{EngineEval, EngineVM} {
+		for _, tt := range tests {
+			if got := runREPL(tt.input, engine); got != tt.expected {
+				t.Errorf("%s: wrong output for %q. want=%q, got=%q", engine, tt.input, tt.expected, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package repl
+
+import "errors"
+
+// 端末状態。linux以外ではrawモードをサポートしない
+type termState struct{}
+
+func isTerminal(fd int) bool { return false }
+
+func makeRaw(fd int) (*termState, error) {
+	return nil, errors.New("raw mode is not supported on this platform")
+}
+
+func restoreTerminal(fd int, state *termState) error { return nil }
@@ -0,0 +1,29 @@
+package repl
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// 評価の計測を開始した時点の状態
+type timingStats struct {
+	start   time.Time
+	mallocs uint64
+}
+
+func startTiming() timingStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return timingStats{start: time.Now(), mallocs: m.Mallocs}
+}
+
+// 計測を終了し、経過時間とアロケーション回数を表示用の文字列にして返す
+func (t timingStats) stop() string {
+	elapsed := time.Since(t.start)
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	return fmt.Sprintf("// %s, %d allocs", elapsed, m.Mallocs-t.mallocs)
+}
@@ -0,0 +1,47 @@
+// :undoのための状態の記録
+
+package repl
+
+import (
+	"monkey/object"
+)
+
+// 覚えておく状態の数。古いものから捨てる
+const maxUndo = 100
+
+// 入力を評価する前のREPLの状態
+type checkpoint struct {
+	env      *object.Snapshot
+	macroEnv *object.Snapshot
+	inputs   int // 評価できた入力の数
+	results  int
+}
+
+// 入力を評価する前に呼び、今の状態を:undoで戻せるように記録する
+func (s *session) checkpoint() {
+	s.undo = append(s.undo, checkpoint{
+		env:      s.env.Snapshot(),
+		macroEnv: s.macroEnv.Snapshot(),
+		inputs:   len(s.inputs),
+		results:  s.results,
+	})
+	if len(s.undo) > maxUndo {
+		s.undo = s.undo[1:]
+	}
+}
+
+// 最後に記録した状態に戻す。記録がない場合はfalseを返す
+func (s *session) rollback() bool {
+	if len(s.undo) == 0 {
+		return false
+	}
+
+	c := s.undo[len(s.undo)-1]
+	s.undo = s.undo[:len(s.undo)-1]
+
+	s.env.Restore(c.env)
+	s.macroEnv.Restore(c.macroEnv)
+	s.inputs = s.inputs[:c.inputs]
+	s.results = c.results
+	return true
+}
@@ -0,0 +1,222 @@
+// ファイル実行モード。monkey run script.mky [args...]
+// ファイル名に-を指定すると標準入力からプログラムを読み込む
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/diag"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/profiler"
+	"monkey/token"
+	"os"
+)
+
+// 終了コード
+const (
+	exitOK           = 0
+	exitRuntimeError = 1 // 実行時エラー、ファイルが読めないなど
+	exitParseError   = 2 // 構文エラー
+)
+
+// 標準入力から読み込んだプログラムのエラー表示に使う名前
+const STDIN_NAME = "<stdin>"
+
+// runサブコマンドの設定
+type runOptions struct {
+	dumpAst    bool // 評価せずにASTを表示する
+	dumpTokens bool // 構文解析せずにトークン列を表示する
+	dumpDot    bool // 評価せずにASTをDOT形式で出力する
+	dumpJSON   bool // 評価せずにASTをJSONで出力する
+
+	optimize     bool   // 評価の前に定数式を計算しておく
+	profile      bool   // 評価の後に関数ごとの呼び出し回数と時間を表示する
+	noColor      bool   // エラー表示に色を付けない
+	contextLines int    // 構文エラーの該当行の前後に表示する行数
+	watch        bool   // ファイルが変更されるたびに実行し直す
+	engine       string // 実行エンジン。repl.EngineEvalかrepl.EngineVM
+
+	scriptArgs []string // ファイル名以降の引数。スクリプトからはARGVで参照する
+}
+
+// runサブコマンドの引数を処理し、終了コードを返す
+func runCommand(args []string) int {
+	var opts runOptions
+
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: monkey run [flags] <file> [args...]")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&opts.dumpAst, "ast", false, "print the parsed AST instead of evaluating")
+	fs.BoolVar(&opts.dumpTokens, "tokens", false, "print the token stream instead of evaluating")
+	fs.BoolVar(&opts.dumpDot, "dot", false, "print the parsed AST as a Graphviz DOT graph instead of evaluating")
+	fs.BoolVar(&opts.dumpJSON, "json", false, "print the parsed AST as JSON instead of evaluating")
+	fs.BoolVar(&opts.optimize, "optimize", false, "fold constant expressions before evaluating")
+	fs.BoolVar(&opts.profile, "profile", false, "print per-function call counts and times after evaluating")
+	fs.BoolVar(&opts.watch, "watch", false, "re-run the file whenever it changes")
+	fs.BoolVar(&opts.noColor, "no-color", false, "disable colored output")
+	fs.IntVar(&opts.contextLines, "context", 0, "number of source lines to show around parse errors")
+	engine := engineFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return exitRuntimeError
+	}
+	if err := checkEngine(*engine); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitRuntimeError
+	}
+	opts.engine = *engine
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return exitRuntimeError
+	}
+
+	opts.scriptArgs = fs.Args()[1:]
+
+	if opts.watch {
+		return watchFile(fs.Arg(0), opts, os.Stdout, os.Stderr)
+	}
+
+	return runFile(fs.Arg(0), opts, os.Stdout, os.Stderr)
+}
+
+// ファイルを読み込み、構文解析して評価する。ファイル名が-の場合は標準入力から読み込む
+func runFile(filename string, opts runOptions, out, errOut io.Writer) int {
+	if filename == "-" {
+		return runSource(STDIN_NAME, os.Stdin, opts, out, errOut)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		fmt.Fprintln(errOut, err)
+		return exitRuntimeError
+	}
+	defer f.Close()
+
+	return runSource(filename, f, opts, out, errOut)
+}
+
+// ソースコードを構文解析して評価する。filenameはエラー表示にだけ使う
+func runSource(filename string, src io.Reader, opts runOptions, out, errOut io.Writer) int {
+	if opts.dumpTokens {
+		dumpTokens(out, src)
+		return exitOK
+	}
+
+	printer := diag.NewPrinter(errOut, diag.ColorEnabled(!opts.noColor, errOut))
+
+	program := parseSource(filename, src, printer, opts.contextLines)
+	if program == nil {
+		return exitParseError
+	}
+
+	if opts.dumpAst {
+		io.WriteString(out, ast.Dump(program))
+		return exitOK
+	}
+
+	if opts.dumpDot {
+		io.WriteString(out, ast.Dot(program))
+		return exitOK
+	}
+
+	if opts.dumpJSON {
+		data, err := ast.MarshalJSON(program)
+		if err != nil {
+			printer.Print(diag.RuntimeError, filename+": "+err.Error())
+			return exitRuntimeError
+		}
+		fmt.Fprintln(out, string(data))
+		return exitOK
+	}
+
+	env := object.NewEnvironment()
+	env.Set("ARGV", argvArray(opts.scriptArgs))
+	macroEnv := object.NewEnvironment()
+
+	evaluator.DefineMacros(program, macroEnv)
+	expanded, macroErrors := evaluator.ExpandMacros(program, macroEnv)
+	if len(macroErrors) != 0 {
+		for _, err := range macroErrors {
+			printer.Print(diag.MacroError, filename+":"+err.Error())
+		}
+		return exitRuntimeError
+	}
+	if opts.optimize {
+		expanded = evaluator.Optimize(expanded)
+	}
+
+	// スクリプトが閉じ忘れたファイルは終了時に閉じる
+	files := evaluator.NewFiles()
+	defer files.Close()
+
+	var evaluated object.Object
+	if opts.profile {
+		// プロファイラは評価器のフックで計測するので、エンジンの指定によらず評価器で実行する
+		prof := profiler.New()
+		prof.Options = evaluator.Options{Files: files, Stdout: out}
+		evaluated = prof.Run(context.Background(), expanded, env)
+		defer prof.WriteReport(errOut)
+	} else {
+		var err error
+		evaluated, err = execute(opts.engine, expanded, env, evaluator.Options{Files: files, Stdout: out})
+		if err != nil {
+			printer.Print(diag.CompileError, filename+":"+err.Error())
+			return exitRuntimeError
+		}
+	}
+
+	if errObj, ok := evaluated.(*object.Error); ok {
+		printer.Print(diag.RuntimeError, errObj.Located(filename)+errObj.StackTrace())
+		return exitRuntimeError
+	}
+
+	return exitOK
+}
+
+// ソースを構文解析する。エラーがあった場合は、前後contextLines行と合わせて全て表示してnilを返す
+func parseSource(filename string, src io.Reader, printer *diag.Printer, contextLines int) *ast.Program {
+	l := lexer.NewReader(src)
+	p := parser.New(l)
+	p.SetContextLines(contextLines)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			printer.Print(diag.ParseError, filename+":"+msg)
+		}
+		return nil
+	}
+
+	return program
+}
+
+// 字句解析器が返すトークンを、位置・タイプ・リテラルの順に1行ずつ表示する
+func dumpTokens(out io.Writer, src io.Reader) {
+	l := lexer.NewReader(src)
+
+	for {
+		tok := l.NextToken()
+		fmt.Fprintf(out, "%d:%d\t%-10s %q\n", tok.Line, tok.Column, tok.Type, tok.Literal)
+		if tok.Type == token.EOF {
+			return
+		}
+	}
+}
+
+// スクリプトに渡す引数を文字列の配列にする
+func argvArray(args []string) *object.Array {
+	elements := make([]object.Object, len(args))
+	for i, arg := range args {
+		elements[i] = &object.String{Value: arg}
+	}
+	return &object.Array{Elements: elements}
+}
@@ -0,0 +1,132 @@
+package token
+
+import "fmt"
+
+// トークンの分類
+type Category int
+
+const (
+	Special   Category = iota // ILLEGAL, EOF, COMMENT
+	Literal                   // 識別子と値のリテラル
+	Operator                  // 演算子
+	Delimiter                 // 区切り文字と括弧
+	Keyword                   // 予約語
+)
+
+func (c Category) String() string {
+	switch c {
+	case Special:
+		return "special"
+	case Literal:
+		return "literal"
+	case Operator:
+		return "operator"
+	case Delimiter:
+		return "delimiter"
+	case Keyword:
+		return "keyword"
+	default:
+		return fmt.Sprintf("Category(%d)", int(c))
+	}
+}
+
+// トークンタイプごとの情報
+type info struct {
+	name     string
+	category Category
+}
+
+var infos = map[TokenType]info{
+	ILLEGAL: {"illegal character", Special},
+	EOF:     {"end of input", Special},
+	COMMENT: {"comment", Special},
+
+	IDENT:  {"identifier", Literal},
+	INT:    {"integer", Literal},
+	STRING: {"string", Literal},
+	BYTES:  {"bytes", Literal},
+
+	ASSIGN:   {"assignment", Operator},
+	PLUS:     {"plus", Operator},
+	MINUS:    {"minus", Operator},
+	BANG:     {"bang", Operator},
+	ASTERISK: {"asterisk", Operator},
+	SLASH:    {"slash", Operator},
+	LT:       {"less than", Operator},
+	GT:       {"greater than", Operator},
+	EQ:       {"equal", Operator},
+	NOT_EQ:   {"not equal", Operator},
+	ARROW:    {"arrow", Operator},
+	DOTDOT:   {"range", Operator},
+
+	COMMA:     {"comma", Delimiter},
+	SEMICOLON: {"semicolon", Delimiter},
+	COLON:     {"colon", Delimiter},
+	LPAREN:    {"left paren", Delimiter},
+	RPAREN:    {"right paren", Delimiter},
+	LBRACE:    {"left brace", Delimiter},
+	RBRACE:    {"right brace", Delimiter},
+	LBRACKET:  {"left bracket", Delimiter},
+	RBRACKET:  {"right bracket", Delimiter},
+
+	FUNCTION: {"fn", Keyword},
+	LET:      {"let", Keyword},
+	TRUE:     {"true", Keyword},
+	FALSE:    {"false", Keyword},
+	IF:       {"if", Keyword},
+	ELSE:     {"else", Keyword},
+	RETURN:   {"return", Keyword},
+	MACRO:    {"macro", Keyword},
+	DEFER:    {"defer", Keyword},
+}
+
+// 人が読むための名前を返す。"right paren"、"identifier"など
+// 知らないトークンタイプはそのまま返す
+func (t TokenType) Name() string {
+	if i, ok := infos[t]; ok {
+		return i.name
+	}
+	return string(t)
+}
+
+// トークンタイプの分類を返す。知らないトークンタイプは演算子として扱う
+func (t TokenType) Category() Category {
+	if i, ok := infos[t]; ok {
+		return i.category
+	}
+	return Operator
+}
+
+// ソースコード上の表記を返す。表記が決まっていないリテラルなどは空文字を返す
+func (t TokenType) Text() string {
+	switch t.Category() {
+	case Operator, Delimiter:
+		// 演算子と区切り文字のトークンタイプは表記そのもの
+		return string(t)
+	case Keyword:
+		return t.Name()
+	default:
+		return ""
+	}
+}
+
+// エラーメッセージ向けの表現を返す。表記が決まっていれば引用符で囲み、そうでなければ名前を返す
+func (t TokenType) Describe() string {
+	if text := t.Text(); text != "" {
+		return "'" + text + "'"
+	}
+	return t.Name()
+}
+
+// エラーメッセージ向けの表現を返す。リテラルは値も含める
+func (t Token) Describe() string {
+	switch t.Type.Category() {
+	case Literal, Special:
+		if t.Literal == "" || t.Type == EOF {
+			return t.Type.Name()
+		}
+		return fmt.Sprintf("%s '%s'", t.Type.Name(), t.Literal)
+	default:
+		return t.Type.Describe()
+	}
+}
@@ -0,0 +1,54 @@
+package token
+
+import "testing"
+
+func TestTokenTypeInfo(t *testing.T) {
+	tests := []struct {
+		tokenType TokenType
+		name      string
+		category  Category
+		text      string
+		describe  string
+	}{
+		{RPAREN, "right paren", Delimiter, ")", "')'"},
+		{LET, "let", Keyword, "let", "'let'"},
+		{NOT_EQ, "not equal", Operator, "!=", "'!='"},
+		{IDENT, "identifier", Literal, "", "identifier"},
+		{EOF, "end of input", Special, "", "end of input"},
+		{"|>", "|>", Operator, "|>", "'|>'"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.tokenType.Name(); got != tt.name {
+			t.Errorf("%s.Name() wrong. want=%q, got=%q", tt.tokenType, tt.name, got)
+		}
+		if got := tt.tokenType.Category(); got != tt.category {
+			t.Errorf("%s.Category() wrong. want=%s, got=%s", tt.tokenType, tt.category, got)
+		}
+		if got := tt.tokenType.Text(); got != tt.text {
+			t.Errorf("%s.Text() wrong. want=%q, got=%q", tt.tokenType, tt.text, got)
+		}
+		if got := tt.tokenType.Describe(); got != tt.describe {
+			t.Errorf("%s.Describe() wrong. want=%q, got=%q", tt.tokenType, tt.describe, got)
+		}
+	}
+}
+
+func TestTokenDescribe(t *testing.T) {
+	tests := []struct {
+		tok      Token
+		expected string
+	}{
+		{Token{Type: IDENT, Literal: "foo"}, "identifier 'foo'"},
+		{Token{Type: INT, Literal: "5"}, "integer '5'"},
+		{Token{Type: ILLEGAL, Literal: "@"}, "illegal character '@'"},
+		{Token{Type: EOF, Literal: ""}, "end of input"},
+		{Token{Type: ELSE, Literal: "else"}, "'else'"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.tok.Describe(); got != tt.expected {
+			t.Errorf("Describe() wrong. want=%q, got=%q", tt.expected, got)
+		}
+	}
+}
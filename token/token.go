@@ -1,10 +1,38 @@
 package token
 
+import (
+	"fmt"
+	"sort"
+)
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Line    int // トークン先頭の行番号(1始まり)
+	Column  int // トークン先頭の列番号(1始まり、バイト単位)
+}
+
+// ソースコード上の位置
+type Position struct {
+	Line   int
+	Column int
+}
+
+// 位置が設定されているか。字句解析器を通さずに作られたトークンは位置を持たない
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// トークン先頭の位置を返す
+func (t Token) Pos() Position {
+	return Position{Line: t.Line, Column: t.Column}
 }
 
 const (
@@ -15,6 +43,10 @@ const (
 	IDENT  = "IDENT"
 	INT    = "INT"
 	STRING = "STRING"
+	BYTES  = "BYTES" // b"..."
+
+	// 行コメント。//から行末まで
+	COMMENT = "COMMENT"
 
 	// 演算子
 	ASSIGN   = "="
@@ -27,6 +59,8 @@ const (
 	GT       = ">"
 	EQ       = "=="
 	NOT_EQ   = "!="
+	ARROW    = "=>" // アロー関数
+	DOTDOT   = ".." // 範囲
 
 	// デリミタ
 	COMMA     = ","
@@ -49,6 +83,7 @@ const (
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
 	MACRO    = "MACRO"
+	DEFER    = "DEFER"
 )
 
 // 予約語
@@ -61,6 +96,17 @@ var keywords = map[string]TokenType{
 	"else":   ELSE,
 	"return": RETURN,
 	"macro":  MACRO,
+	"defer":  DEFER,
+}
+
+// 予約語の一覧をソートして返す
+func Keywords() []string {
+	words := make([]string, 0, len(keywords))
+	for word := range keywords {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	return words
 }
 
 // 予約語の場合はその種類を、それ意外の場合はIDENTを返す
@@ -0,0 +1,344 @@
+package vm
+
+import (
+	"context"
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+// 評価器とVMで同じ結果になることを確かめるプログラム
+// 値は表示と型が、エラーは種類とメッセージ、位置とスタックトレースが一致すればよい。putsの出力も一致する
+// VMでは末尾呼び出しの分だけスタックトレースが短くなるので、エラーになる末尾呼び出しはTestTailCallDifferencesで確かめる
+var conformancePrograms = []string{
+	// 整数
+	"5",
+	"-10",
+	"1 + 2 * 3 - 4 / 2",
+	"(5 + 10 * 2 + 15 / 3) * 2 + -10",
+	"7 / -2",
+	"9223372036854775807 + 1",
+	"-9223372036854775807 - 2",
+	"(9223372036854775807 + 1) - 1",
+	"1 / 0",
+	"1..4",
+	"(1..10)[3]",
+	// 整数に特化した命令でも、桁あふれや整数以外のオペランドは汎用の演算と同じになる
+	"let x = 9223372036854775807; [x + 1, x * 2, -x - 2, 1 - -x]",
+	"let big = 9223372036854775807 + 1; [big > 1, 1 < big, big == 1, big != 1]",
+	`"a" + 1`,
+	"1 < true",
+	"true > 1",
+	"[1] != 1",
+	"let sum = fn(n) { if (n == 0) { 0 } else { n + sum(n - 1) } }; sum(100)",
+	"(1..10)[20]",
+	"let a = [1, 2]; [len(a) + 1, len(a) - -3, len(a) * 2, len(a) == 2, len(a) != 2, len(a) > 1, len(a) < 1]",
+	"len(1) + 1",
+	"let len = fn(x) { true }; len([1]) + 1",
+
+	// 真偽値と比較
+	"true",
+	"!5",
+	"!!false",
+	"1 < 2",
+	"2 > 1",
+	"1 == 1",
+	"1 != 2",
+	"true == false",
+	`"a" < "b"`,
+	"[1, 2] == [1, 2]",
+	"{1: 2} != {1: 3}",
+	"1 == true",
+
+	// 文字列とバイト列
+	`"Hello" + " " + "World!"`,
+	`"a" == "a"`,
+	`"a" - "b"`,
+	`b"ab" + b"c"`,
+	`b"abc"[1]`,
+
+	// 条件
+	"if (true) { 10 }",
+	"if (false) { 10 }",
+	"if (1 < 2) { 10 } else { 20 }",
+	"if (0) { 1 } else { 2 }",
+	"if ((if (false) { 10 })) { 10 } else { 20 }",
+
+	// 配列とハッシュ
+	"[]",
+	"[1, 2 * 2, 3 + 3]",
+	"[1, 2, 3][1]",
+	"[1, 2, 3][3]",
+	"[1, 2, 3][-1]",
+	"[[1, 1, 1]][0][0]",
+	`{"b": 1, "a": 2}`,
+	`{1: 1, 2: 2}[2]`,
+	`{"a": 1}["b"]`,
+	"{[1]: 2}",
+	"{1: 2}[[1]]",
+	"1[0]",
+
+	// 関数
+	"fn() { 5 + 10 }()",
+	"fn() { 1; 2 }()",
+	"fn() { return 99; 100 }()",
+	"fn() { if (true) { return 1 } else { return 2 } }()",
+	"fn() { if (false) { 1 } else { return 2 }; 3 }()",
+	"fn() { fn() { 1 } }()()",
+	"fn() { return }()",
+	"fn() { 1 }",
+	"1()",
+	"fn() { 1 }(2)",
+	"fn() { 1 } + 1",
+	// 到達できない命令を取り除いても、位置とスタックトレースは変わらない
+	"fn(c) { if (c) { return 1 }; 2 }(false)",
+	"if (!0) { 1 } else { 2 }",
+	"fn(c) { if (c) { return 1 }; 1 + true }(false)",
+	"let f = fn(c) { if (c) { return 1 }; let r = g(); r }; let g = fn() { 1 + true }; f(false)",
+
+	// 変数
+	"let one = 1; one",
+	"let one = 1; let two = one + one; one + two",
+	"let a = 1; let a = a + 1; a",
+	"let a = 1;",
+	"1; let a = 2",
+	"x",
+	"let f = fn() { y }; f()",
+	"if (true) { let z = 5 }; z",
+	"fn(a, b) { a + b }(1, 2)",
+	"fn(a) { let b = a * 2; b }(3)",
+	"fn(a) { let a = a + 1; a }(1)",
+	"fn(a) { a }()",
+	"fn() { let x = 1; fn() { x } }()()",
+	// 値のないブロックと関数はnullになる
+	"fn() { let a = 1; }()",
+	"fn() {}()",
+	"if (true) { let a = 1 }",
+	"if (true) {}",
+	"let f = fn() { let a = 1; }; [f()]",
+	"fn() { defer 1; let a = 1 }()",
+	"fn() { if (true) { let a = 1 } }()",
+	"let f = fn() { let a = 1; }; puts(f())",
+	// letの右辺とletより前では、外側の同じ名前を参照する
+	"let x = 10; let f = fn() { let x = x * 2; x }; f()",
+	"let x = 10; fn() { let y = x; let x = 1; [x, y] }()",
+	"let x = 10; fn() { let g = fn() { x }; let x = x + 1; g() }()",
+	"let x = 1; fn() { if (true) { let x = x + 1 }; x }()",
+	"fn() { let x = x + 1; x }()",
+
+	// クロージャ
+	"let newAdder = fn(a) { fn(b) { a + b } }; newAdder(2)(3)",
+	"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)",
+	"fn() { let x = 1; let get = fn() { x }; let x = 2; get() }()",
+	"let counter = fn() { let n = 0; fn() { n } }; let c = counter(); c() + c()",
+	"let f = fn() { g() }; let g = fn() { 42 }; f()",
+	"let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } }; let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } }; isEven(10)",
+	"fn() { let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(20) }()",
+	"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)",
+	"fn() { let f = fn() { g() }; let g = fn() { 7 }; f() }()",
+	"fn(a) { fn() { a } }",
+	"let f = fn() { fn() { undefinedName } }; f()()",
+	"fn() { let inner = fn() { later }; let r = inner(); r }()",
+
+	// 末尾呼び出し
+	"let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + n) } }; loop(100, 0)",
+	"let f = fn(n) { if (n == 0) { 1 + true } else { return f(n - 1) } }; f(0)",
+	"let f = fn() { defer 1 + true; len([]) }; f()",
+	"let f = fn(n) { puts(n); if (n > 0) { f(n - 1) } }; f(2)",
+
+	// 組み込み関数
+	"len([1, 2, 3])",
+	`len("abc", 1)`,
+	"len(1)",
+	"rest([1, 2, 3])",
+	"rest([])",
+	"push(rest([1, 2, 3]), 4)",
+	"let f = fn(xs) { first(xs) }; f([7, 8])",
+	"let len = fn(x) { 0 }; len([1])",
+	"fn() { let len = 5; len }()",
+	"len",
+	"first()",
+
+	// defer
+	"fn() { defer 5; 10 }()",
+	"fn() { defer 1 + true; 5 }()",
+	`fn() { defer 1 + true; 1 + "a" }()`,
+	`fn() { let x = 1; defer x + true; let x = "s"; x }()`,
+	"fn() { defer 1 + true; fn() { defer 2; 1 + -true }() }()",
+	"let x = 1; defer x + true; x",
+	"let f = fn(n) { defer n + true; n }; f(1); 2",
+	"fn() { defer fn() { defer -true; 1 }() + 0; 2 }()",
+
+	// rescue
+	`rescue(fn() { 1 / 0 }, fn(e) { e["kind"] })`,
+	"rescue(fn() { 5 }, fn(e) { 0 })",
+	`rescue(fn() { x }, fn(e) { e["message"] })`,
+	"rescue(1, fn(e) { e })",
+	`rescue(fn(a) { a }, fn(e) { e["message"] })`,
+	`rescue(fn() { fn() { defer 1 + true; 2 }() }, fn(e) { e["message"] })`,
+	`rescue(fn() { 1 / 0 }, fn(e) { e + 1 })`,
+	`rescue(len, fn(e) { e["kind"] })`,
+	"rescue(fn() { 1 })",
+	`rescue(fn() { puts("before"); 1 / 0; puts("after") }, fn(e) { puts(e["kind"]) })`,
+
+	// quoteとマクロ
+	"quote(1 + 2)",
+	"let x = 5; quote(x + unquote(x * 2))",
+	"let f = fn(x) { quote(1 + unquote(x)) }; [f(1), f(2)]",
+	"fn(a) { quote(unquote(a) + unquote(fn() { a * 2 }())) }(3)",
+	"quote(f(unquote_splice([1, true]), 3))",
+	"quote(fn() { unquote_splice(quote([1, 2])); 3 })",
+	"let quote = 1; quote(x)",
+	"quote_unhygienic(let y = 1)",
+	"quote(unquote(quote(a + b)))",
+	"macroexpand(quote(1))",
+	"let double = macro(a) { quote(unquote(a) * 2) }; macroexpand(quote(double(3)))",
+	"let double = macro(a) { quote(unquote(a) * 2) }; double(3)",
+	"macroexpand(1)",
+
+	// トップレベルのreturn
+	"return 10; 9",
+	"if (true) { if (true) { return 10 }; return 1 }",
+	"return; 1",
+
+	// エラーは残りを実行しない
+	"5 + true; 5",
+	"puts(1); 1 + true; puts(2)",
+	"-true",
+	"if (10 > 1) { true + false }",
+	"[1, -true, 3]",
+	"1 + 2; 3 + -fn() { 1 }",
+}
+
+func TestConformance(t *testing.T) {
+	for _, input := range conformancePrograms {
+		program := parse(input)
+		// ファイルの実行と同じく、マクロを展開してから実行する
+		macroEnv := object.NewEnvironment()
+		evaluator.DefineMacros(program, macroEnv)
+		expanded, errs := evaluator.ExpandMacros(program, macroEnv)
+		if len(errs) != 0 {
+			t.Fatalf("%s: macro error: %s", input, errs[0])
+		}
+
+		var expectedOut, actualOut strings.Builder
+		expected := evaluator.EvalWithOptions(context.Background(), expanded, object.NewEnvironment(), evaluator.Options{Stdout: &expectedOut})
+
+		session := NewSession()
+		session.Stdout = &actualOut
+		actual, err := session.Run(expanded, object.NewEnvironment())
+		if err != nil {
+			t.Errorf("%s: compiler error: %s", input, err)
+			continue
+		}
+
+		if msg := compareResults(expected, actual); msg != "" {
+			t.Errorf("%s: %s", input, msg)
+		}
+		if expectedOut.String() != actualOut.String() {
+			t.Errorf("%s: wrong output. want=%q, got=%q", input, expectedOut.String(), actualOut.String())
+		}
+	}
+}
+
+// 末尾呼び出しは評価器とVMで結果が異なる。VMは末尾呼び出しでフレームを置き換えるので、
+// スタックトレースには置き換えた呼び出しを数だけ示し、深い末尾再帰でも呼び出しの深さの上限を超えない
+func TestTailCallDifferences(t *testing.T) {
+	defer func(n int) { evaluator.MaxCallDepth = n }(evaluator.MaxCallDepth)
+	evaluator.MaxCallDepth = 3
+
+	tests := []struct {
+		input string
+		eval  string
+		vm    string
+	}{
+		{
+			"let f = fn(n) { if (n == 0) { 1 / 0 } else { f(n - 1) } }; f(2)",
+			"1:33: division by zero\n    at f (1:46)\n    at f (1:46)\n    at f (1:60)",
+			"1:33: division by zero\n    ... 2 tail calls\n    at f (1:60)",
+		},
+		// 末尾ではない呼び出しのフレームは残る
+		{
+			"let g = fn(n) { if (n == 0) { 1 / 0 } else { g(n - 1) } }; let f = fn() { g(1) + 1 }; f()",
+			"1:33: division by zero\n    at g (1:46)\n    at g (1:75)\n    at f (1:87)",
+			"1:33: division by zero\n    ... 1 tail calls\n    at g (1:75)\n    at f (1:87)",
+		},
+		{
+			"let r = fn(n) { if (n == 0) { 0 } else { r(n - 1) } }; r(5)",
+			"1:42: maximum recursion depth exceeded\n    at r (1:42)\n    at r (1:42)\n    at r (1:42)\n    at r (1:56)",
+			"INTEGER 0",
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		if got := describeResult(evaluator.Eval(program, object.NewEnvironment())); got != tt.eval {
+			t.Errorf("%s: wrong result of the evaluator.\nwant=%s\ngot=%s", tt.input, tt.eval, got)
+		}
+
+		actual, err := NewSession().Run(program, object.NewEnvironment())
+		if err != nil {
+			t.Errorf("%s: compiler error: %s", tt.input, err)
+			continue
+		}
+		if got := describeResult(actual); got != tt.vm {
+			t.Errorf("%s: wrong result of the VM.\nwant=%s\ngot=%s", tt.input, tt.vm, got)
+		}
+	}
+}
+
+// エラーは位置とスタックトレースを付けて、それ以外は値を表示する
+func describeResult(obj object.Object) string {
+	if errObj, ok := obj.(*object.Error); ok {
+		return errObj.Located("") + errObj.StackTrace()
+	}
+	return describe(obj)
+}
+
+// 評価器の結果expectedとVMの結果actualを比べて、違いを説明する。一致すれば空文字を返す
+func compareResults(expected, actual object.Object) string {
+	if expected == nil || actual == nil {
+		if expected != actual {
+			return "want=" + describe(expected) + ", got=" + describe(actual)
+		}
+		return ""
+	}
+
+	if expectedErr, ok := expected.(*object.Error); ok {
+		actualErr, ok := actual.(*object.Error)
+		if !ok {
+			return "expected an error " + expectedErr.Message + ", got=" + actual.Inspect()
+		}
+		if actualErr.Kind != expectedErr.Kind || actualErr.Message != expectedErr.Message {
+			return "wrong error. want=" + string(expectedErr.Kind) + ": " + expectedErr.Message +
+				", got=" + string(actualErr.Kind) + ": " + actualErr.Message
+		}
+		if actualErr.Located("") != expectedErr.Located("") || actualErr.StackTrace() != expectedErr.StackTrace() {
+			return "wrong location. want=" + expectedErr.Located("") + expectedErr.StackTrace() +
+				", got=" + actualErr.Located("") + actualErr.StackTrace()
+		}
+		return ""
+	}
+
+	if expected.Type() != actual.Type() || expected.Inspect() != actual.Inspect() {
+		return "want=" + describe(expected) + ", got=" + describe(actual)
+	}
+	return ""
+}
+
+func describe(obj object.Object) string {
+	if obj == nil {
+		return "nil"
+	}
+	return string(obj.Type()) + " " + obj.Inspect()
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
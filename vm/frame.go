@@ -0,0 +1,24 @@
+package vm
+
+import (
+	"monkey/code"
+	"monkey/object"
+)
+
+// 関数呼び出し1回分の実行状態
+type Frame struct {
+	cl          *object.Closure
+	ip          int // 次に実行する命令の位置
+	basePointer int // 呼び出し時のスタックの位置。ここから引数とローカル変数が並ぶ
+
+	defers    []*object.Closure // deferで積んだ関数。フレームを抜けるときに後に積んだものから呼び出す
+	tailCalls int               // このフレームを置き換えた末尾呼び出しの数。スタックトレースに示す
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: 0, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
@@ -0,0 +1,76 @@
+package vm
+
+import (
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/object"
+)
+
+// 環境と変数をやり取りしながら、プログラムを続けてコンパイルして実行する
+// REPLやファイルの実行で評価器の代わりに使う。環境に束縛した値は、グローバル変数として読める
+type Session struct {
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+
+	// open()でファイルを開けないようにする。評価器のOptions.DisableFilesと同じ
+	DisableFiles bool
+	// open()で開いたファイルを記録する。評価器のOptions.Filesと同じ
+	Files *evaluator.Files
+	// puts()とdoc()の出力先。評価器のOptions.Stdoutと同じ
+	Stdout io.Writer
+}
+
+func NewSession() *Session {
+	return &Session{
+		symbolTable: compiler.NewGlobalSymbolTable(),
+		constants:   []object.Object{},
+	}
+}
+
+// プログラムをコンパイルして実行する。envの値をグローバル変数の初期値にし、実行中に束縛した値をenvに書き戻す
+// コンパイルできない場合はerrorを返す。実行時のエラーは評価器と同じく*object.Errorの結果になる
+func (s *Session) Run(program ast.Node, env *object.Environment) (object.Object, error) {
+	// 評価器では環境の束縛が組み込み関数より優先されるので、組み込み関数と同じ名前の束縛はグローバル変数にする
+	for _, name := range env.Names() {
+		if sym, ok := s.symbolTable.Resolve(name); ok && sym.Scope == compiler.BuiltinScope {
+			s.symbolTable.Define(name)
+		}
+	}
+
+	comp := compiler.NewWithState(s.symbolTable, s.constants)
+	if err := comp.Compile(program); err != nil {
+		return nil, err
+	}
+	bytecode := comp.Bytecode()
+	s.constants = bytecode.Constants
+
+	// 以前の入力で定義した関数も同じ番号で変数を参照するので、毎回すべての変数を読み直す
+	// 組み込み関数と同じ名前の変数は、環境から消えていれば評価器と同じく組み込み関数になる
+	globals := make([]object.Object, len(bytecode.GlobalNames))
+	for i, name := range bytecode.GlobalNames {
+		if value, ok := env.Get(name); ok {
+			globals[i] = value
+		} else if builtin, ok := evaluator.LookupBuiltin(name); ok {
+			globals[i] = builtin
+		}
+	}
+	initial := make([]object.Object, len(globals))
+	copy(initial, globals)
+
+	machine := NewWithGlobals(bytecode, globals)
+	machine.DisableFiles = s.DisableFiles
+	machine.Files = s.Files
+	machine.Stdout = s.Stdout
+	result := machine.Run()
+
+	// エラーで止まった場合も、それまでに束縛した値は評価器と同じく残す
+	for i, value := range machine.Globals() {
+		if value != nil && value != initial[i] {
+			env.Set(bytecode.GlobalNames[i], value)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,140 @@
+package vm
+
+import (
+	"fmt"
+	"monkey/object"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSession(t *testing.T) {
+	// REPLと同じく、同じ環境で1行ずつ実行する
+	session := NewSession()
+	env := object.NewEnvironment()
+	env.Set("preset", &object.Integer{Value: 10})
+	env.Set("first", &object.Integer{Value: 1})
+
+	inputs := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn() { g() + x }", ""},
+		{"let x = 1", ""},
+		{"let g = fn() { 41 }", ""},
+		{"f()", "42"},
+		{"let x = 2; f()", "43"},
+		{"preset + len([1])", "11"},
+		{"first", "1"},
+		{"let len = fn(a) { 0 }; len([1])", "0"},
+		{"let y = 5; y + true", "type mismatch: INTEGER + BOOLEAN"},
+		{"y", "5"},
+		{"z", "identifier not found: z"},
+		// 関数の中のletの右辺は、前の入力で定義した変数を参照する
+		{"let h = fn() { let y = y * 2; y }; h()", "10"},
+	}
+
+	for _, tt := range inputs {
+		result, err := session.Run(parse(tt.input), env)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		got := ""
+		if result != nil {
+			got = result.Inspect()
+			if errObj, ok := result.(*object.Error); ok {
+				got = errObj.Message
+			}
+		}
+		if got != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	// 実行中に束縛した値は環境に書き戻す
+	for _, name := range []string{"f", "g", "x", "y", "len"} {
+		if _, ok := env.Get(name); !ok {
+			t.Errorf("%s is not in the environment", name)
+		}
+	}
+
+	// 環境から消えた値は、次の実行では未定義になる
+	env.Restore(object.NewEnvironment().Snapshot())
+	result, err := session.Run(parse("f()"), env)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	if errObj, ok := result.(*object.Error); !ok || errObj.Message != "identifier not found: f" {
+		t.Errorf("expected f to be undefined. got=%v", result)
+	}
+
+	// 組み込み関数と同じ名前の変数が消えると、組み込み関数に戻る
+	result, err = session.Run(parse("len([1, 2])"), env)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	if result == nil || result.Inspect() != "2" {
+		t.Errorf("expected the builtin len. got=%v", result)
+	}
+}
+
+func TestSessionCompileError(t *testing.T) {
+	env := object.NewEnvironment()
+	if _, err := NewSession().Run(parse("quote(unquote(unquote(1)))"), env); err == nil {
+		t.Errorf("expected a compile error")
+	}
+}
+
+func TestSessionDisableFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.txt")
+
+	inputs := []string{
+		fmt.Sprintf(`open("%s", "w")`, path),
+		// 変数に入れたopenや、rescueから呼ぶopenも同じく拒否する
+		fmt.Sprintf(`let o = open; o("%s", "w")`, path),
+		fmt.Sprintf(`rescue(fn() { open("%s", "w") }, fn(e) { e })["message"]`, path),
+	}
+
+	for _, input := range inputs {
+		session := NewSession()
+		session.DisableFiles = true
+
+		result, err := session.Run(parse(input), object.NewEnvironment())
+		if err != nil {
+			t.Fatalf("compile error for %q: %s", input, err)
+		}
+		var message string
+		switch result := result.(type) {
+		case *object.Error:
+			message = result.Message
+		case *object.String:
+			message = result.Value
+		default:
+			t.Fatalf("result is not Error. input=%q, got=%T (%+v)", input, result, result)
+		}
+		if message != "file access is disabled" {
+			t.Errorf("wrong error message for %q. got=%q", input, message)
+		}
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file should not be created. err=%v", err)
+	}
+}
+
+func TestSessionStdout(t *testing.T) {
+	var out strings.Builder
+	session := NewSession()
+	session.Stdout = &out
+
+	// 変数に入れたputsや、rescueから呼ぶputsも同じ出力先に書く
+	input := `puts(1); let p = puts; p("a"); rescue(fn() { puts(2) }, fn(e) { e })`
+	if _, err := session.Run(parse(input), object.NewEnvironment()); err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	if out.String() != "1\na\n2\n" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+}
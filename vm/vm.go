@@ -0,0 +1,602 @@
+// コンパイラが作ったバイトコードを、オペランドスタックと呼び出しフレームを使って実行する
+// 演算の意味は評価器と同じにするため、演算そのものは評価器の関数を使う
+
+package vm
+
+import (
+	"io"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/object"
+)
+
+// スタックの初期の大きさ。足りなくなったら伸ばす
+const StackSize = 2048
+
+var (
+	NULL  = evaluator.NULL
+	TRUE  = evaluator.TRUE
+	FALSE = evaluator.FALSE
+)
+
+// 組み込み関数の表。番号はコンパイラがOpGetBuiltinのオペランドにした番号と同じく、evaluator.BuiltinNamesの順
+var builtins = func() []*object.Builtin {
+	names := evaluator.BuiltinNames()
+	table := make([]*object.Builtin, len(names))
+	for i, name := range names {
+		table[i], _ = evaluator.LookupBuiltin(name)
+	}
+	return table
+}()
+
+type VM struct {
+	constants []object.Object
+
+	globals     []object.Object
+	globalNames []string
+
+	stack []object.Object
+	sp    int // 次に積む位置。スタックの一番上はstack[sp-1]
+
+	frames []*Frame
+
+	// open()でファイルを開けないようにする。評価器のOptions.DisableFilesと同じ
+	DisableFiles bool
+	// open()で開いたファイルを記録する。評価器のOptions.Filesと同じ
+	Files *evaluator.Files
+	// puts()とdoc()の出力先。評価器のOptions.Stdoutと同じ
+	Stdout io.Writer
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	return NewWithGlobals(bytecode, nil)
+}
+
+// 前回の実行のグローバル変数を引き継いで実行する。REPLで入力ごとに実行するときに使う
+func NewWithGlobals(bytecode *compiler.Bytecode, globals []object.Object) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Positions:    bytecode.Positions,
+		CallNames:    bytecode.CallNames,
+	}
+	mainFrame := NewFrame(&object.Closure{Fn: mainFn}, 0)
+
+	// 前回の後に定義されたグローバル変数の分を足す
+	if len(globals) < len(bytecode.GlobalNames) {
+		extended := make([]object.Object, len(bytecode.GlobalNames))
+		copy(extended, globals)
+		globals = extended
+	}
+
+	return &VM{
+		constants:   bytecode.Constants,
+		globals:     globals,
+		globalNames: bytecode.GlobalNames,
+		stack:       make([]object.Object, StackSize),
+		sp:          0,
+		frames:      []*Frame{mainFrame},
+	}
+}
+
+// グローバル変数の値。番号はBytecode.GlobalNamesと対応する。値のない変数はnil
+func (vm *VM) Globals() []object.Object {
+	return vm.globals
+}
+
+// バイトコードを最後まで実行して、プログラムの結果を返す
+// 評価器と同じく、実行時のエラーは*object.Errorとして返す。値を残さないプログラムの結果はnil
+func (vm *VM) Run() object.Object {
+	return vm.execute(0)
+}
+
+// フレームの数がstopに減るまで命令を実行し、最後に抜けたフレームの結果を返す
+// エラーの場合は、stopより内側のフレームのdeferを実行しながら抜けてからエラーを返す
+func (vm *VM) execute(stop int) object.Object {
+	for {
+		frame := vm.frames[len(vm.frames)-1]
+		ins := frame.Instructions()
+		if frame.ip >= len(ins) {
+			// 関数は必ずreturnの命令で終わるので、最後まで進むのはトップレベルだけ
+			var result object.Object
+			if vm.sp > 0 {
+				result = vm.stack[vm.sp-1]
+			}
+			return vm.leave(vm.returnFrom(result), stop)
+		}
+
+		start := frame.ip
+		op := code.Opcode(ins[frame.ip])
+		frame.ip++
+
+		var err *object.Error
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[frame.ip:])
+			frame.ip += 2
+			vm.push(vm.constants[constIndex])
+
+		case code.OpPop:
+			vm.pop()
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan, code.OpRange:
+			right := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.InfixOperation(infixOperators[op], left, right))
+
+		case code.OpAddInt, code.OpSubInt, code.OpMulInt,
+			code.OpEqualInt, code.OpNotEqualInt, code.OpGreaterThanInt, code.OpLessThanInt:
+			err = vm.executeIntOperation(op)
+
+		case code.OpMinus:
+			err = vm.pushResult(evaluator.PrefixOperation("-", vm.pop()))
+		case code.OpBang:
+			err = vm.pushResult(evaluator.PrefixOperation("!", vm.pop()))
+
+		case code.OpTrue:
+			vm.push(TRUE)
+		case code.OpFalse:
+			vm.push(FALSE)
+		case code.OpNull:
+			vm.push(NULL)
+
+		case code.OpJump:
+			frame.ip = int(code.ReadUint16(ins[frame.ip:]))
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[frame.ip:]))
+			frame.ip += 2
+			if !evaluator.IsTruthy(vm.pop()) {
+				frame.ip = pos
+			}
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[frame.ip:]))
+			frame.ip += 2
+			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			vm.sp -= numElements
+			vm.push(array)
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[frame.ip:]))
+			frame.ip += 2
+			hash, e := vm.buildHash(vm.sp-numElements, vm.sp)
+			if e != nil {
+				err = e
+				break
+			}
+			vm.sp -= numElements
+			vm.push(hash)
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.IndexOperation(left, index))
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[frame.ip:])
+			frame.ip += 2
+			value := vm.globals[globalIndex]
+			if value == nil {
+				err = undefined(vm.globalNames[globalIndex])
+				break
+			}
+			vm.push(value)
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[frame.ip:])
+			frame.ip += 2
+			vm.globals[globalIndex] = vm.pop()
+
+		case code.OpGetLocal:
+			localIndex := int(code.ReadUint8(ins[frame.ip:]))
+			frame.ip++
+			value := vm.stack[frame.basePointer+localIndex]
+			if value == nil {
+				err = undefined(frame.cl.Fn.LocalNames[localIndex])
+				break
+			}
+			vm.push(value)
+		case code.OpSetLocal:
+			localIndex := int(code.ReadUint8(ins[frame.ip:]))
+			frame.ip++
+			vm.stack[frame.basePointer+localIndex] = vm.pop()
+
+		case code.OpMakeCell:
+			localIndex := int(code.ReadUint8(ins[frame.ip:]))
+			frame.ip++
+			slot := frame.basePointer + localIndex
+			vm.stack[slot] = &object.Cell{Value: vm.stack[slot]}
+		case code.OpGetCell:
+			localIndex := int(code.ReadUint8(ins[frame.ip:]))
+			frame.ip++
+			value := vm.stack[frame.basePointer+localIndex].(*object.Cell).Value
+			if value == nil {
+				err = undefined(frame.cl.Fn.LocalNames[localIndex])
+				break
+			}
+			vm.push(value)
+		case code.OpSetCell:
+			localIndex := int(code.ReadUint8(ins[frame.ip:]))
+			frame.ip++
+			vm.stack[frame.basePointer+localIndex].(*object.Cell).Value = vm.pop()
+		case code.OpLoadCell:
+			localIndex := int(code.ReadUint8(ins[frame.ip:]))
+			frame.ip++
+			vm.push(vm.stack[frame.basePointer+localIndex])
+
+		case code.OpGetFree:
+			freeIndex := int(code.ReadUint8(ins[frame.ip:]))
+			frame.ip++
+			value := frame.cl.Free[freeIndex].Value
+			if value == nil {
+				err = undefined(frame.cl.Fn.FreeNames[freeIndex])
+				break
+			}
+			vm.push(value)
+		case code.OpLoadFree:
+			freeIndex := int(code.ReadUint8(ins[frame.ip:]))
+			frame.ip++
+			vm.push(frame.cl.Free[freeIndex])
+
+		case code.OpGetBuiltin:
+			builtinIndex := int(code.ReadUint8(ins[frame.ip:]))
+			frame.ip++
+			vm.push(builtins[builtinIndex])
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[frame.ip:])
+			numFree := int(code.ReadUint8(ins[frame.ip+2:]))
+			frame.ip += 3
+			vm.pushClosure(int(constIndex), numFree)
+		case code.OpCall:
+			numArgs := int(code.ReadUint8(ins[frame.ip:]))
+			frame.ip++
+			err = vm.callFunction(numArgs)
+		case code.OpTailCall:
+			numArgs := int(code.ReadUint8(ins[frame.ip:]))
+			frame.ip++
+			err = vm.tailCall(numArgs)
+
+		case code.OpReturnValue, code.OpReturn:
+			// トップレベルのreturnはプログラムを終える
+			var returnValue object.Object = NULL
+			if op == code.OpReturnValue {
+				returnValue = vm.pop()
+			}
+			returnValue = vm.returnFrom(returnValue)
+			if errObj, ok := returnValue.(*object.Error); ok {
+				// deferの関数のエラーは、評価器と同じく関数の呼び出しから出てきたものとして扱う
+				if len(vm.frames) > stop {
+					vm.traceCall(errObj)
+				}
+				return vm.leave(errObj, stop)
+			}
+			if len(vm.frames) == stop {
+				return returnValue
+			}
+			vm.push(returnValue)
+
+		case code.OpDefer:
+			frame.defers = append(frame.defers, vm.pop().(*object.Closure))
+		case code.OpQuote:
+			constIndex := code.ReadUint16(ins[frame.ip:])
+			numUnquotes := int(code.ReadUint8(ins[frame.ip+2:]))
+			frame.ip += 3
+
+			unquotes := make([]object.Object, numUnquotes)
+			copy(unquotes, vm.stack[vm.sp-numUnquotes:vm.sp])
+			vm.sp -= numUnquotes
+			template := vm.constants[constIndex].(*object.Quote)
+			vm.push(evaluator.Quote(template.Node, func(i int) object.Object {
+				return vm.call(unquotes[i], nil)
+			}))
+
+		default:
+			def, _ := code.Lookup(byte(op))
+			name := "unknown"
+			if def != nil {
+				name = def.Name
+			}
+			err = evaluator.NewError(object.TYPE_ERROR, "unsupported instruction: %s", name)
+		}
+
+		if err != nil {
+			if !err.Pos.IsValid() {
+				err.Pos = frame.cl.Fn.Positions.Pos(start)
+			}
+			if op == code.OpCall || op == code.OpTailCall {
+				vm.traceCall(err)
+			}
+			return vm.leave(err, stop)
+		}
+	}
+}
+
+// executeを終える。resultがエラーなら、stopより内側に残ったフレームをdeferを実行しながら抜ける
+// 抜けたフレームを呼び出した命令を、評価器と同じく内側から順にスタックトレースに加える
+func (vm *VM) leave(result object.Object, stop int) object.Object {
+	err, ok := result.(*object.Error)
+	if !ok {
+		return result
+	}
+	for len(vm.frames) > stop {
+		tailCalls := vm.frames[len(vm.frames)-1].tailCalls
+		vm.returnFrom(err)
+		if len(vm.frames) > stop {
+			vm.traceCall(err)
+			err.Trace[len(err.Trace)-1].TailCalls = tailCalls
+		}
+	}
+	return err
+}
+
+// OpCallとOpTailCallの長さ。呼び出し元のフレームは、呼び出しの命令の直後まで進んでいる
+const callWidth = 2
+
+// 一番上のフレームが実行した呼び出しを、エラーのスタックトレースに加える
+func (vm *VM) traceCall(err *object.Error) {
+	frame := vm.frames[len(vm.frames)-1]
+	offset := frame.ip - callWidth
+
+	name, ok := frame.cl.Fn.CallNames[offset]
+	if !ok {
+		name = "<anonymous>"
+	}
+	err.Trace = append(err.Trace, object.Frame{Function: name, Pos: frame.cl.Fn.Positions.Pos(offset)})
+}
+
+func isError(obj object.Object) bool {
+	_, ok := obj.(*object.Error)
+	return ok
+}
+
+// まだ値が束縛されていない変数を読んだときのエラー。評価器で名前が見つからない場合と同じにする
+func undefined(name string) *object.Error {
+	return evaluator.NewError(object.NAME_ERROR, "identifier not found: "+name)
+}
+
+// 演算の命令と、評価器での演算子
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:         "+",
+	code.OpSub:         "-",
+	code.OpMul:         "*",
+	code.OpDiv:         "/",
+	code.OpEqual:       "==",
+	code.OpNotEqual:    "!=",
+	code.OpGreaterThan: ">",
+	code.OpLessThan:    "<",
+	code.OpRange:       "..",
+
+	code.OpAddInt:         "+",
+	code.OpSubInt:         "-",
+	code.OpMulInt:         "*",
+	code.OpEqualInt:       "==",
+	code.OpNotEqualInt:    "!=",
+	code.OpGreaterThanInt: ">",
+	code.OpLessThanInt:    "<",
+}
+
+// 整数に特化した演算をする。スタックの上の2つが整数なら、その場で計算して置き換える
+// 整数でない場合や、結果がint64に収まらない場合は汎用の演算と同じく評価器で計算する
+func (vm *VM) executeIntOperation(op code.Opcode) *object.Error {
+	left, lok := vm.stack[vm.sp-2].(*object.Integer)
+	right, rok := vm.stack[vm.sp-1].(*object.Integer)
+	if lok && rok {
+		var result object.Object
+		switch op {
+		case code.OpEqualInt:
+			result = nativeBoolToBoolean(left.Value == right.Value)
+		case code.OpNotEqualInt:
+			result = nativeBoolToBoolean(left.Value != right.Value)
+		case code.OpGreaterThanInt:
+			result = nativeBoolToBoolean(left.Value > right.Value)
+		case code.OpLessThanInt:
+			result = nativeBoolToBoolean(left.Value < right.Value)
+		default:
+			if value, ok := evaluator.CheckedIntegerOp(infixOperators[op], left.Value, right.Value); ok {
+				result = evaluator.NewInteger(value)
+			}
+		}
+		if result != nil {
+			vm.sp--
+			vm.stack[vm.sp-1] = result
+			return nil
+		}
+	}
+
+	r := vm.pop()
+	l := vm.pop()
+	return vm.pushResult(evaluator.InfixOperation(infixOperators[op], l, r))
+}
+
+func nativeBoolToBoolean(b bool) *object.Boolean {
+	if b {
+		return TRUE
+	}
+	return FALSE
+}
+
+// 演算の結果を積む。エラーの場合は積まずに返す
+func (vm *VM) pushResult(result object.Object) *object.Error {
+	if err, ok := result.(*object.Error); ok {
+		return err
+	}
+	vm.push(result)
+	return nil
+}
+
+func (vm *VM) push(o object.Object) {
+	if vm.sp >= len(vm.stack) {
+		vm.growStack(vm.sp + 1)
+	}
+	vm.stack[vm.sp] = o
+	vm.sp++
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+// スタックの大きさを少なくともnにする
+func (vm *VM) growStack(n int) {
+	size := len(vm.stack) * 2
+	if size < n {
+		size = n
+	}
+	stack := make([]object.Object, size)
+	copy(stack, vm.stack[:vm.sp])
+	vm.stack = stack
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+	copy(elements, vm.stack[startIndex:endIndex])
+	return &object.Array{Elements: elements}
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, *object.Error) {
+	hash := object.NewHash()
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, evaluator.NewError(object.TYPE_ERROR, "unusable as hash key: %s", key.Type())
+		}
+		hash.Set(hashKey.HashKey(), object.HashPair{Key: key, Value: value})
+	}
+
+	return hash, nil
+}
+
+// 定数の関数と、スタックに積まれた自由変数のセルから関数の値を作る
+func (vm *VM) pushClosure(constIndex, numFree int) {
+	fn := vm.constants[constIndex].(*object.CompiledFunction)
+
+	var free []*object.Cell
+	if numFree > 0 {
+		free = make([]*object.Cell, numFree)
+		for i := 0; i < numFree; i++ {
+			free[i] = vm.stack[vm.sp-numFree+i].(*object.Cell)
+		}
+		vm.sp -= numFree
+	}
+
+	vm.push(&object.Closure{Fn: fn, Free: free})
+}
+
+// スタックに積まれた関数と引数で呼び出す。評価器と同じ順に深さと引数の数を確かめる
+// 組み込み関数はその場で呼び出して結果を積む。Monkeyの関数はフレームを積み、続きの命令で実行する
+func (vm *VM) callFunction(numArgs int) *object.Error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	cl, ok := callee.(*object.Closure)
+	if !ok {
+		builtin, ok := callee.(*object.Builtin)
+		if !ok {
+			return evaluator.NewError(object.TYPE_ERROR, "not a function: %s", callee.Type())
+		}
+		args := make([]object.Object, numArgs)
+		copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+		vm.sp -= numArgs + 1
+		return vm.pushResult(evaluator.CallBuiltin(builtin, args, vm.call, vm.builtinOptions()))
+	}
+
+	if evaluator.MaxCallDepth > 0 && len(vm.frames)-1 >= evaluator.MaxCallDepth {
+		return evaluator.NewError(object.LIMIT_ERROR, "maximum recursion depth exceeded")
+	}
+	if numArgs != cl.Fn.NumParameters {
+		return evaluator.NewError(object.ARITY_ERROR, "wrong number of arguments. got=%d, want=%d",
+			numArgs, cl.Fn.NumParameters)
+	}
+
+	frame := NewFrame(cl, vm.sp-numArgs)
+	vm.frames = append(vm.frames, frame)
+
+	// ローカル変数の分の場所を空けておく。前の呼び出しの値が残っていると、未定義の変数を読めてしまうので消す
+	sp := frame.basePointer + cl.Fn.NumLocals
+	if sp > len(vm.stack) {
+		vm.growStack(sp)
+	}
+	for i := vm.sp; i < sp; i++ {
+		vm.stack[i] = nil
+	}
+	vm.sp = sp
+
+	return nil
+}
+
+// 組み込み関数に渡す評価の設定
+func (vm *VM) builtinOptions() evaluator.Options {
+	return evaluator.Options{DisableFiles: vm.DisableFiles, Files: vm.Files, Stdout: vm.Stdout}
+}
+
+// 関数の結果をそのまま返す呼び出し。実行中のフレームを呼ぶ関数のものに置き換えて、再帰でもフレームが増えないようにする
+// deferで積んだ関数が残っている場合は戻るときに呼び出す必要があるので、組み込み関数と同じく通常の呼び出しにする
+// その場合も次の命令がOpReturnValueなので、結果は同じになる
+func (vm *VM) tailCall(numArgs int) *object.Error {
+	frame := vm.frames[len(vm.frames)-1]
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok || len(frame.defers) > 0 {
+		return vm.callFunction(numArgs)
+	}
+	if numArgs != cl.Fn.NumParameters {
+		return evaluator.NewError(object.ARITY_ERROR, "wrong number of arguments. got=%d, want=%d",
+			numArgs, cl.Fn.NumParameters)
+	}
+
+	// 呼ぶ関数と引数を、実行中の関数が置かれていた位置へ移してからフレームを取り除く
+	base := frame.basePointer - 1
+	copy(vm.stack[base:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	vm.sp = base + 1 + numArgs
+	vm.frames = vm.frames[:len(vm.frames)-1]
+
+	if err := vm.callFunction(numArgs); err != nil {
+		return err
+	}
+	vm.frames[len(vm.frames)-1].tailCalls = frame.tailCalls + 1
+	return nil
+}
+
+// 関数を呼び出して結果を返す。deferの式やrescueの引数の関数を、実行中の命令の途中で呼び出すのに使う
+func (vm *VM) call(fn object.Object, args []object.Object) object.Object {
+	sp := vm.sp
+	vm.push(fn)
+	for _, arg := range args {
+		vm.push(arg)
+	}
+
+	depth := len(vm.frames)
+	if err := vm.callFunction(len(args)); err != nil {
+		vm.sp = sp
+		return err
+	}
+	if len(vm.frames) == depth {
+		// 組み込み関数は結果を積み終えている
+		return vm.pop()
+	}
+	return vm.execute(depth)
+}
+
+// 実行中の関数から戻る。deferで積んだ関数を後に積んだものから呼び出してから、
+// 引数とローカル変数、呼ばれた関数をスタックから取り除く
+// deferの関数がエラーになった場合は、resultがエラーでなければそのエラーを返す。resultがエラーなら元のエラーを優先する
+func (vm *VM) returnFrom(result object.Object) object.Object {
+	frame := vm.frames[len(vm.frames)-1]
+	for len(frame.defers) > 0 {
+		d := frame.defers[len(frame.defers)-1]
+		frame.defers = frame.defers[:len(frame.defers)-1]
+		if evaluated := vm.call(d, nil); isError(evaluated) && !isError(result) {
+			result = evaluated
+		}
+	}
+
+	vm.frames = vm.frames[:len(vm.frames)-1]
+	vm.sp = frame.basePointer - 1
+	if len(vm.frames) == 0 {
+		// トップレベルには呼ばれた関数がない
+		vm.sp = 0
+	}
+	return result
+}
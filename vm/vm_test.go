@@ -0,0 +1,191 @@
+package vm
+
+import (
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/object"
+	"strconv"
+	"testing"
+)
+
+func run(t *testing.T, input string) object.Object {
+	t.Helper()
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return New(comp.Bytecode()).Run()
+}
+
+func TestResult(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1; 2", "2"},
+		// 式文の値は捨てられるので、スタックに残らない
+		{"1; [2]; 3", "3"},
+		{"fn() { 1 }(); 2", "2"},
+	}
+
+	for _, tt := range tests {
+		result := run(t, tt.input)
+		if result == nil || result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%v", tt.input, tt.expected, result)
+		}
+	}
+
+	// 何も残さないプログラムの結果はnil
+	if result := run(t, ""); result != nil {
+		t.Errorf("expected nil for an empty program. got=%s", result.Inspect())
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	// REPLの環境を使わずに実行しても、組み込み関数は表から呼び出せる
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"len([1, 2, 3])", "3"},
+		{"fn(xs) { push(xs, len(xs)) }([0])", "[0, 1]"},
+		{"let f = fn() { first }; f()([1, 2])", "1"},
+		{"let len = fn(x) { 0 }; len([1])", "0"},
+	}
+
+	for _, tt := range tests {
+		result := run(t, tt.input)
+		if result == nil || result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%v", tt.input, tt.expected, result)
+		}
+	}
+}
+
+func TestStackGrowth(t *testing.T) {
+	// 初期の大きさより多くの値を一度に積む
+	input := "["
+	for i := 0; i < StackSize+10; i++ {
+		input += "1, "
+	}
+	input += "2][" + strconv.Itoa(StackSize+10) + "]"
+
+	result := run(t, input)
+	integer, ok := result.(*object.Integer)
+	if !ok || integer.Value != 2 {
+		t.Errorf("wrong result. got=%v", result)
+	}
+}
+
+func TestCallDepthLimit(t *testing.T) {
+	defer func(n int) { evaluator.MaxCallDepth = n }(evaluator.MaxCallDepth)
+	evaluator.MaxCallDepth = 2
+
+	// 結果を使う呼び出しはフレームを積み重ねる
+	if result := run(t, "fn() { fn() { 1 }() + 0 }()"); result.Inspect() != "1" {
+		t.Errorf("wrong result within the limit. got=%s", result.Inspect())
+	}
+
+	result := run(t, "fn() { fn() { fn() { 1 }() + 0 }() + 0 }()")
+	errObj, ok := result.(*object.Error)
+	if !ok || errObj.Kind != object.LIMIT_ERROR {
+		t.Errorf("expected a limit error. got=%s", result.Inspect())
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	defer func(n int) { evaluator.MaxCallDepth = n }(evaluator.MaxCallDepth)
+	evaluator.MaxCallDepth = 10
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// 末尾の呼び出しはフレームを置き換えるので、呼び出しの深さの上限を超えない
+		{"let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + n) } }; loop(1000, 0)", "500500"},
+		{"let even = fn(n) { if (n == 0) { true } else { return odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; even(1001)", "false"},
+		{"let f = fn(n) { if (n == 0) { len([1]) } else { f(n - 1) } }; f(100)", "1"},
+		{"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1, 1) } }; f(5)", "wrong number of arguments. got=2, want=1"},
+		// deferが残っているフレームは置き換えない
+		{"let f = fn(n) { defer n; if (n == 0) { 0 } else { f(n - 1) } }; f(100)", "maximum recursion depth exceeded"},
+		{"let f = fn(n) { defer n; if (n == 0) { 0 } else { f(n - 1) } }; f(3)", "0"},
+	}
+
+	for _, tt := range tests {
+		result := run(t, tt.input)
+		got := ""
+		if result != nil {
+			got = result.Inspect()
+			if errObj, ok := result.(*object.Error); ok {
+				got = errObj.Message
+			}
+		}
+		if got != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestErrorLocations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 +\n true", "1:3: type mismatch: INTEGER + BOOLEAN"},
+		{"let f = fn(x) {\n  x[0]\n};\nf(1)", "2:3: index operator not supported: INTEGER\n    at f (4:1)"},
+		{"let g = fn() { len(1) };\nlet f = fn() { g() + 1 };\nf()", "1:16: argument to `len` not supported, got INTEGER\n    at len (1:16)\n    at g (2:16)\n    at f (3:1)"},
+		// 末尾呼び出しで置き換えたフレームは、数だけを示す
+		{"let g = fn() { -true };\nlet f = fn() { g() };\nf()", "1:16: unknown operator: -BOOLEAN\n    ... 1 tail calls\n    at f (3:1)"},
+	}
+
+	for _, tt := range tests {
+		result := run(t, tt.input)
+		errObj, ok := result.(*object.Error)
+		if !ok {
+			t.Errorf("expected an error for %q. got=%v", tt.input, result)
+			continue
+		}
+		if got := errObj.Located("") + errObj.StackTrace(); got != tt.expected {
+			t.Errorf("wrong error for %q.\nwant=%s\ngot=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestGlobalsAcrossRuns(t *testing.T) {
+	// REPLと同じく、記号表とグローバル変数を引き継いで1行ずつ実行する
+	symbolTable := compiler.NewSymbolTable()
+	constants := []object.Object{}
+	var globals []object.Object
+
+	inputs := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn() { g() + x }", ""},
+		{"let x = 1", ""},
+		{"let g = fn() { 41 }", ""},
+		{"f()", "42"},
+		{"let x = 2; f()", "43"},
+	}
+
+	for _, tt := range inputs {
+		comp := compiler.NewWithState(symbolTable, constants)
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+
+		machine := NewWithGlobals(bytecode, globals)
+		result := machine.Run()
+		globals = machine.Globals()
+
+		got := ""
+		if result != nil {
+			got = result.Inspect()
+		}
+		if got != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
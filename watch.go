@@ -0,0 +1,58 @@
+// ファイル監視モード。monkey run --watch script.mky
+// ファイルが変更されるたびに構文解析と評価をやり直す
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// 変更を確認する間隔
+const watchInterval = 500 * time.Millisecond
+
+// 変更の検出に使うファイルの状態
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// ファイルの変更を監視し、変更されるたびに実行する。中断されるまで戻らない
+func watchFile(filename string, opts runOptions, out, errOut io.Writer) int {
+	w := &watcher{filename: filename, opts: opts}
+	for {
+		w.poll(out, errOut)
+		time.Sleep(watchInterval)
+	}
+}
+
+// 監視しているファイルと、前回確認したときの状態
+type watcher struct {
+	filename string
+	opts     runOptions
+	last     fileStamp
+	lastErr  string
+}
+
+// ファイルの状態を確認し、前回から変わっていれば実行する
+func (w *watcher) poll(out, errOut io.Writer) {
+	info, err := os.Stat(w.filename)
+	if err != nil {
+		// 同じエラーを何度も表示しない
+		if err.Error() != w.lastErr {
+			fmt.Fprintln(errOut, err)
+			w.lastErr = err.Error()
+		}
+		return
+	}
+
+	w.lastErr = ""
+	stamp := fileStamp{modTime: info.ModTime(), size: info.Size()}
+	if stamp != w.last {
+		w.last = stamp
+		fmt.Fprintf(errOut, "--- %s %s\n", time.Now().Format("15:04:05"), w.filename)
+		runFile(w.filename, w.opts, out, errOut)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestWatcherPoll(t *testing.T) {
+	path := writeScript(t, "script.mky", "1 + true")
+	w := &watcher{filename: path, opts: runOptions{noColor: true, engine: "eval"}}
+
+	// 実行するたびに区切りの行を表示する。変更がなければ何もしない
+	var out, errOut bytes.Buffer
+	w.poll(&out, &errOut)
+	w.poll(&out, &errOut)
+	ran := regexp.MustCompile(`^--- \d\d:\d\d:\d\d ` + regexp.QuoteMeta(path) + `\nruntime error: ` + regexp.QuoteMeta(path) + `:1:3: type mismatch: INTEGER \+ BOOLEAN\n$`)
+	if !ran.MatchString(errOut.String()) {
+		t.Fatalf("wrong output of the first run. got=%q", errOut.String())
+	}
+
+	// 大きさが変われば実行し直す
+	if err := os.WriteFile(path, []byte("let a = 1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	errOut.Reset()
+	w.poll(&out, &errOut)
+	if !regexp.MustCompile(`^--- \d\d:\d\d:\d\d ` + regexp.QuoteMeta(path) + `\n$`).MatchString(errOut.String()) {
+		t.Errorf("wrong output after the change. got=%q", errOut.String())
+	}
+
+	// ファイルが消えたら、エラーを1回だけ表示する
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	errOut.Reset()
+	w.poll(&out, &errOut)
+	w.poll(&out, &errOut)
+	missing := regexp.MustCompile(`^stat ` + regexp.QuoteMeta(path) + `: no such file or directory\n$`)
+	if !missing.MatchString(errOut.String()) {
+		t.Errorf("wrong output for a missing file. got=%q", errOut.String())
+	}
+	if out.Len() != 0 {
+		t.Errorf("unexpected stdout. got=%q", out.String())
+	}
+}